@@ -4,7 +4,6 @@ import (
 	"crypto/tls"
 	"flag"
 	"fmt"
-	"net/url"
 	"strconv"
 	"strings"
 
@@ -24,44 +23,72 @@ var (
 	flagURI        = flag.String("H", "", "LDAP Uniform Resource Identifier(s)")
 )
 
+// URL returns the LDAP URL given with -H or nil if there is none. The
+// search parts of the URL (dn, attributes, scope, filter) can be used as
+// defaults by commands that search. flag.Parse must have been called first.
+func URL() (*ldap.LDAPURL, error) {
+	if *flagURI == "" {
+		return nil, nil
+	}
+	u, err := ldap.ParseLDAPURL(*flagURI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URI %s: %w", *flagURI, err)
+	}
+	if err := u.CheckCritical(ldap.URLExtensionBindName, ldap.URLExtensionStartTLS); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
 // Connect connects to the LDAP server. flag.Parse must
 // have been called first.
 func Connect() (*ldap.Client, error) {
+	u, err := URL()
+	if err != nil {
+		return nil, err
+	}
+	network := "tcp"
 	addr := *flagHost
 	enableTLS := false
-	if *flagURI != "" {
-		u, err := url.Parse(*flagURI)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse URI %s: %s", *flagURI, err.Error())
-		}
-		if u.Scheme == "ldaps" {
+	startTLS := *flagStartTLS
+	bindDN := *flagBindDN
+	if u != nil {
+		switch u.Scheme {
+		case "ldaps":
 			enableTLS = true
 			if *flagPort == 389 {
 				*flagPort = 636
 			}
-		} else if u.Scheme != "ldap" {
-			return nil, fmt.Errorf("URI scheme must be ldap or ldaps: %s", *flagURI)
+		case "ldapi":
+			network = "unix"
+		}
+		if u.Host != "" {
+			addr = u.Host
+		}
+		if ext := u.Extension(ldap.URLExtensionStartTLS); ext != nil {
+			startTLS = true
+		}
+		if ext := u.Extension(ldap.URLExtensionBindName); ext != nil && bindDN == "" {
+			bindDN = ext.Value
 		}
-		addr = u.Host
 	}
-	if strings.IndexByte(addr, ':') < 0 {
+	if network == "tcp" && strings.IndexByte(addr, ':') < 0 {
 		addr += ":" + strconv.Itoa(*flagPort)
 	}
-	var err error
 	var cli *ldap.Client
 	if enableTLS {
 		conf := &tls.Config{
 			InsecureSkipVerify: *flagInsecure,
 		}
-		cli, err = ldap.DialTLS("tcp", addr, conf)
+		cli, err = ldap.DialTLS(network, addr, conf)
 	} else {
-		cli, err = ldap.Dial("tcp", addr)
+		cli, err = ldap.Dial(network, addr)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to server: %w", err)
 	}
 
-	if !enableTLS && *flagStartTLS {
+	if !enableTLS && startTLS {
 		err := cli.StartTLS(&tls.Config{
 			InsecureSkipVerify: *flagInsecure,
 		})
@@ -81,7 +108,7 @@ func Connect() (*ldap.Client, error) {
 		} else {
 			pass = []byte(*flagBindPass)
 		}
-		if err := cli.Bind(*flagBindDN, pass); err != nil {
+		if err := cli.Bind(bindDN, pass); err != nil {
 			return nil, fmt.Errorf("bind failed: %w", err)
 		}
 	}
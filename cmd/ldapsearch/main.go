@@ -2,7 +2,6 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"os"
 	"strings"
@@ -30,6 +29,26 @@ func main() {
 	req := &ldap.SearchRequest{
 		BaseDN: *flagBaseDN,
 	}
+	scope := *flagScope
+
+	// A full LDAP URL given with -H describes the search unless overridden
+	// by flags or arguments.
+	u, err := ldapcmd.URL()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if u != nil && (u.DN != "" || len(u.Attributes) != 0 || u.Scope != ldap.ScopeBaseObject || u.Filter != nil) {
+		req = u.SearchRequest()
+		scope = ""
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "b":
+				req.BaseDN = *flagBaseDN
+			case "s":
+				scope = *flagScope
+			}
+		})
+	}
 
 	// Parse args either as "filter attribute,attribute,..." or either by itself. A filter
 	// string always start with a '('
@@ -53,10 +72,12 @@ func main() {
 		}
 	}
 
-	var ok bool
-	req.Scope, ok = scopes[*flagScope]
-	if !ok {
-		log.Fatalf("Unknown scope %s", *flagScope)
+	if scope != "" {
+		var ok bool
+		req.Scope, ok = scopes[scope]
+		if !ok {
+			log.Fatalf("Unknown scope %s", scope)
+		}
 	}
 
 	cli, err := ldapcmd.Connect()
@@ -68,10 +89,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("Search failed: %s", err.Error())
 	}
-	for i, r := range res {
-		if i != 0 {
-			fmt.Println()
+	w := ldap.NewLDIFWriter(os.Stdout)
+	for _, r := range res {
+		if err := w.WriteEntry(r); err != nil {
+			log.Fatal(err)
 		}
-		_ = r.ToLDIF(os.Stdout)
 	}
 }
@@ -2,7 +2,10 @@ module github.com/samuel/go-ldap
 
 go 1.22
 
-require github.com/howeyc/gopass v0.0.0-20210920133722-c8aef6fb66ef
+require (
+	github.com/howeyc/gopass v0.0.0-20210920133722-c8aef6fb66ef
+	golang.org/x/text v0.17.0
+)
 
 require (
 	golang.org/x/crypto v0.26.0 // indirect
@@ -5,6 +5,9 @@ import "io"
 type AddRequest struct {
 	DN         string
 	Attributes map[string][][]byte
+
+	// order is the order of the attributes as received.
+	order []string
 }
 
 type AddResponse struct {
@@ -38,11 +41,34 @@ func parseAddRequest(pkt *Packet) (*AddRequest, error) {
 			}
 			vals = append(vals, vb)
 		}
+		if _, ok := req.Attributes[attrName]; !ok {
+			req.order = append(req.order, attrName)
+		}
 		req.Attributes[attrName] = vals
 	}
 	return req, nil
 }
 
+func (r *AddRequest) WritePackets(w io.Writer, msgID int) error {
+	return r.writePackets(w, msgID, nil)
+}
+
+func (r *AddRequest) writePackets(w io.Writer, msgID int, controls []*Control) error {
+	req := NewRequestPacket(msgID)
+	pkt := req.AddItem(NewPacket(ClassApplication, false, ApplicationAddRequest, nil))
+	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.DN))
+	attrs := pkt.AddItem(NewPacket(ClassUniversal, false, TagSequence, nil))
+	for _, name := range orderedNames(r.Attributes, r.order) {
+		p := attrs.AddItem(NewPacket(ClassUniversal, false, TagSequence, nil))
+		p.AddItem(NewPacket(ClassUniversal, true, TagOctetString, name))
+		p = p.AddItem(NewPacket(ClassUniversal, false, TagSet, nil))
+		for _, v := range r.Attributes[name] {
+			p.AddItem(NewPacket(ClassUniversal, true, TagOctetString, v))
+		}
+	}
+	return writeRequest(w, req, controls)
+}
+
 func (r *AddResponse) WritePackets(w io.Writer, msgID int) error {
 	res := NewResponsePacket(msgID)
 	pkt := res.AddItem(r.BaseResponse.NewPacket())
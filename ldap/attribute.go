@@ -0,0 +1,199 @@
+package ldap
+
+// https://tools.ietf.org/html/rfc4512#section-2.5
+// https://tools.ietf.org/html/rfc3866
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AttributeDescription is an attribute type with options such as
+// "cn;lang-de" or "userCertificate;binary" (RFC 4512 section 2.5). An
+// attribute description with options is a subtype of the description
+// without them.
+type AttributeDescription struct {
+	Type    string
+	Options []string
+}
+
+// ParseAttributeDescription parses an attribute description. The type must
+// be a descriptor or numeric OID and options may only contain letters,
+// digits, and hyphens.
+func ParseAttributeDescription(s string) (AttributeDescription, error) {
+	parts := strings.Split(s, ";")
+	d := AttributeDescription{Type: parts[0]}
+	if !isKeyString(d.Type) && !isNumericOID(d.Type) {
+		return AttributeDescription{}, fmt.Errorf("ldap: invalid attribute type in attribute description %q", s)
+	}
+	for _, o := range parts[1:] {
+		if !isOption(o) {
+			return AttributeDescription{}, fmt.Errorf("ldap: invalid option %q in attribute description %q", o, s)
+		}
+		d.Options = append(d.Options, o)
+	}
+	return d, nil
+}
+
+func isOption(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func (d AttributeDescription) String() string {
+	if len(d.Options) == 0 {
+		return d.Type
+	}
+	return d.Type + ";" + strings.Join(d.Options, ";")
+}
+
+// Normalize returns the attribute description in canonical form. The type
+// is replaced by the lower case primary name from the schema (or just lower
+// cased if the schema is nil or doesn't define it) and the options are
+// lower cased, sorted, and deduplicated.
+func (d AttributeDescription) Normalize(s *Schema) AttributeDescription {
+	n := AttributeDescription{Type: strings.ToLower(d.Type)}
+	if s != nil {
+		if at := s.AttributeType(d.Type); at != nil {
+			n.Type = strings.ToLower(at.Name())
+		}
+	}
+	if len(d.Options) != 0 {
+		n.Options = make([]string, 0, len(d.Options))
+		for _, o := range d.Options {
+			n.Options = append(n.Options, strings.ToLower(o))
+		}
+		sort.Strings(n.Options)
+		j := 0
+		for i, o := range n.Options {
+			if i == 0 || o != n.Options[j-1] {
+				n.Options[j] = o
+				j++
+			}
+		}
+		n.Options = n.Options[:j]
+	}
+	return n
+}
+
+// HasOption returns true if the attribute description has the option
+// (compared case-insensitively).
+func (d AttributeDescription) HasOption(option string) bool {
+	for _, o := range d.Options {
+		if strings.EqualFold(o, option) {
+			return true
+		}
+	}
+	return false
+}
+
+// Language returns the language tag of the description without the "lang-"
+// prefix (e.g. "de" for "cn;lang-de"), or an empty string if it has no
+// language tag option.
+func (d AttributeDescription) Language() string {
+	for _, o := range d.Options {
+		if isLanguageTag(o) {
+			return o[len("lang-"):]
+		}
+	}
+	return ""
+}
+
+// Selects returns true if an attribute with the description attr is
+// selected by d. That is the case when attr has the same type as d, or is a
+// subtype of it if a schema is given, and attr has all of the options of d.
+// Options of d that are language ranges such as "lang-en-" match any
+// language tag option of attr in the range (RFC 3866 section 3.2) and the
+// range "lang-" matches any language tag.
+func (d AttributeDescription) Selects(attr AttributeDescription, s *Schema) bool {
+	if s != nil {
+		at := s.AttributeType(d.Type)
+		aat := s.AttributeType(attr.Type)
+		if at == nil || aat == nil {
+			if !strings.EqualFold(d.Type, attr.Type) {
+				return false
+			}
+		} else if at != aat && !s.isSubtype(aat, at) {
+			return false
+		}
+	} else if !strings.EqualFold(d.Type, attr.Type) {
+		return false
+	}
+	return optionsSelected(d.Options, strings.Join(attr.Options, ";"))
+}
+
+// optionsSelected returns true if the ';' separated options include all of
+// the required options or language ranges.
+func optionsSelected(required []string, options string) bool {
+	for _, r := range required {
+		found := false
+		for rest := options; rest != "" && !found; {
+			var o string
+			o, rest, _ = strings.Cut(rest, ";")
+			found = optionMatches(r, o)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// optionMatches returns true if the option matches the required option or
+// language range.
+func optionMatches(required, option string) bool {
+	if isLanguageRange(required) {
+		if !isLanguageTag(option) {
+			return false
+		}
+		// "lang-en-" matches "lang-en" and "lang-en-us"
+		prefix := required[:len(required)-1]
+		return len(required) == len("lang-") ||
+			strings.EqualFold(option, prefix) ||
+			len(option) > len(required) && strings.EqualFold(option[:len(required)], required)
+	}
+	return strings.EqualFold(required, option)
+}
+
+func isLanguageTag(o string) bool {
+	return len(o) > len("lang-") && strings.EqualFold(o[:len("lang-")], "lang-") && o[len(o)-1] != '-'
+}
+
+func isLanguageRange(o string) bool {
+	return len(o) >= len("lang-") && strings.EqualFold(o[:len("lang-")], "lang-") && o[len(o)-1] == '-'
+}
+
+// Values returns the values of all attributes selected by the attribute
+// description (see AttributeDescription.Selects) such that "cn" returns the
+// values of "cn" and "cn;lang-de" while "cn;lang-de" only returns the
+// values of the latter. Attribute types are compared case-insensitively
+// and subtypes are not included. It returns nil if the description is
+// invalid or no attributes are selected.
+func (r *SearchResult) Values(desc string) [][]byte {
+	d, err := ParseAttributeDescription(desc)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for name := range r.Attributes {
+		typ, opts, _ := strings.Cut(name, ";")
+		if strings.EqualFold(typ, d.Type) && optionsSelected(d.Options, opts) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var values [][]byte
+	for _, name := range names {
+		values = append(values, r.Attributes[name]...)
+	}
+	return values
+}
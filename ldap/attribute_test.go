@@ -0,0 +1,118 @@
+package ldap
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseAttributeDescription(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		desc string
+		want AttributeDescription
+		norm string
+	}{
+		{"cn", AttributeDescription{Type: "cn"}, "cn"},
+		{"commonName", AttributeDescription{Type: "commonName"}, "cn"},
+		{"2.5.4.3;Lang-DE", AttributeDescription{Type: "2.5.4.3", Options: []string{"Lang-DE"}}, "cn;lang-de"},
+		{"userCertificate;binary", AttributeDescription{Type: "userCertificate", Options: []string{"binary"}}, "usercertificate;binary"},
+		{"displayName;x-b;lang-en;X-B", AttributeDescription{Type: "displayName", Options: []string{"x-b", "lang-en", "X-B"}}, "displayname;lang-en;x-b"},
+	}
+	for _, c := range cases {
+		d, err := ParseAttributeDescription(c.desc)
+		if err != nil {
+			t.Errorf("ParseAttributeDescription(%q): %s", c.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(d, c.want) {
+			t.Errorf("ParseAttributeDescription(%q) = %#v, want %#v", c.desc, d, c.want)
+		}
+		if s := d.String(); s != c.desc {
+			t.Errorf("String() = %q, want %q", s, c.desc)
+		}
+		if n := d.Normalize(DefaultSchema).String(); n != c.norm {
+			t.Errorf("Normalize(%q) = %q, want %q", c.desc, n, c.norm)
+		}
+	}
+	for _, s := range []string{"", ";x", "cn;", "c n", "cn;a_b", "1cn", "2.5..4"} {
+		if _, err := ParseAttributeDescription(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+}
+
+func TestAttributeDescriptionSelects(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		desc, attr string
+		schema     bool
+		plain      bool
+	}{
+		{"cn", "cn", true, true},
+		{"cn", "CN;lang-de", true, true},
+		{"cn;lang-de", "cn", false, false},
+		{"cn;lang-de", "cn;LANG-DE;x-y", true, true},
+		{"cn;lang-de", "cn;lang-de-ch", false, false},
+		{"commonName", "cn", true, false},
+		{"name", "cn;lang-fr", true, false},
+		{"cn", "name", false, false},
+		{"cn;lang-", "cn;lang-fr", true, true},
+		{"cn;lang-", "cn", false, false},
+		{"cn;lang-en-", "cn;lang-en", true, true},
+		{"cn;lang-en-", "cn;lang-en-US", true, true},
+		{"cn;lang-en-", "cn;lang-eng", false, false},
+		{"cn;lang-en-", "cn;lang-es", false, false},
+		{"customAttr", "customattr;x-foo", true, true},
+	}
+	for _, c := range cases {
+		d, err := ParseAttributeDescription(c.desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := ParseAttributeDescription(c.attr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := d.Selects(a, DefaultSchema); r != c.schema {
+			t.Errorf("%s selects %s with schema = %t, want %t", c.desc, c.attr, r, c.schema)
+		}
+		if r := d.Selects(a, nil); r != c.plain {
+			t.Errorf("%s selects %s without schema = %t, want %t", c.desc, c.attr, r, c.plain)
+		}
+	}
+}
+
+func TestSearchResultValues(t *testing.T) {
+	t.Parallel()
+	r := &SearchResult{Attributes: map[string][][]byte{
+		"displayName":          {[]byte("Main Office")},
+		"displayName;lang-de":  {[]byte("Hauptsitz")},
+		"displayName;lang-fr":  {[]byte("Siège")},
+		"displayName;lang-en-": {[]byte("invalid")},
+	}}
+	values := func(desc string) []string {
+		var s []string
+		for _, v := range r.Values(desc) {
+			s = append(s, string(v))
+		}
+		sort.Strings(s)
+		return s
+	}
+	cases := []struct {
+		desc string
+		want []string
+	}{
+		{"displayname", []string{"Hauptsitz", "Main Office", "Siège", "invalid"}},
+		{"displayName;lang-de", []string{"Hauptsitz"}},
+		{"displayName;lang-", []string{"Hauptsitz", "Siège"}},
+		{"displayName;lang-it", nil},
+		{"cn", nil},
+		{"bad desc", nil},
+	}
+	for _, c := range cases {
+		if v := values(c.desc); !reflect.DeepEqual(v, c.want) {
+			t.Errorf("Values(%q) = %q, want %q", c.desc, v, c.want)
+		}
+	}
+}
@@ -25,6 +25,79 @@ type Backend interface {
 	Whoami(ctx context.Context, state State) (string, error)
 }
 
+// namingContexter is implemented by backends that know the suffixes of the
+// entries they hold. The server publishes them as the namingContexts of the
+// root DSE.
+type namingContexter interface {
+	NamingContexts() []string
+}
+
+// controlSupporter is implemented by backends that act on request controls
+// (see RequestControls). The server fails a request with a critical control
+// the backend doesn't support with ResultUnavailableCriticalExtension.
+type controlSupporter interface {
+	SupportsControl(oid string) bool
+}
+
+// comparer is implemented by backends that perform compare operations. The
+// server answers a compare request for other backends with a base object
+// search of the entry for an equality filter of the assertion.
+type comparer interface {
+	Compare(ctx context.Context, state State, req *CompareRequest) (*CompareResponse, error)
+}
+
+// searchStreamer is implemented by backends that can hand search results to
+// the server as they're found instead of collecting them. The server writes
+// each result passed to send to the client before send returns. The returned
+// response holds the references and the result of the search but no results.
+type searchStreamer interface {
+	SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error) (*SearchResponse, error)
+}
+
+// compare performs a compare with the backend's Compare or, for other
+// backends, with a base object search of the entry for an equality filter
+// of the assertion.
+func compare(ctx context.Context, be Backend, state State, req *CompareRequest) (*CompareResponse, error) {
+	if c, ok := be.(comparer); ok {
+		return c.Compare(ctx, state, req)
+	}
+	sr, err := be.Search(ctx, state, &SearchRequest{
+		BaseDN:     req.DN,
+		Scope:      ScopeBaseObject,
+		Filter:     &EqualityMatch{Attribute: req.Attribute, Value: req.Value},
+		Attributes: map[string]bool{"1.1": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &CompareResponse{BaseResponse: sr.BaseResponse}
+	if res.Code == ResultSuccess {
+		res.Code = ResultCompareFalse
+		if len(sr.Results) != 0 {
+			res.Code = ResultCompareTrue
+		}
+	}
+	return res, nil
+}
+
+// searchFunc performs a search with the backend's SearchFunc or, for other
+// backends, passes the results of Search to send.
+func searchFunc(ctx context.Context, be Backend, state State, req *SearchRequest, send func(*SearchResult) error) (*SearchResponse, error) {
+	if ss, ok := be.(searchStreamer); ok {
+		return ss.SearchFunc(ctx, state, req, send)
+	}
+	sr, err := be.Search(ctx, state, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range sr.Results {
+		if err := send(r); err != nil {
+			return nil, err
+		}
+	}
+	return &SearchResponse{BaseResponse: sr.BaseResponse, References: sr.References}, nil
+}
+
 type debugBackend struct{}
 
 // DebugBackend is an implementation of a server backend that prints out requests.
@@ -47,6 +47,10 @@ func (r *BindResponse) WritePackets(w io.Writer, msgID int) error {
 }
 
 func (r *BindRequest) WritePackets(w io.Writer, msgID int) error {
+	return r.writePackets(w, msgID, nil)
+}
+
+func (r *BindRequest) writePackets(w io.Writer, msgID int, controls []*Control) error {
 	pkt := NewPacket(ClassApplication, false, ApplicationBindRequest, nil)
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagInteger, protocolVersion))
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.DN))
@@ -54,5 +58,5 @@ func (r *BindRequest) WritePackets(w io.Writer, msgID int) error {
 
 	req := NewRequestPacket(msgID)
 	req.AddItem(pkt)
-	return req.Write(w)
+	return writeRequest(w, req, controls)
 }
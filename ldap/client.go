@@ -1,11 +1,10 @@
 package ldap
 
-// TODO: streaming search response
-
 import (
 	"bufio"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -48,6 +47,10 @@ type Client struct {
 	rmap           map[int]chan packetError
 	waitNextRecvCh chan chan struct{}
 	waitNextSendCh chan chan struct{}
+	// done is closed when the connection fails or is closed. err is the
+	// reason and is set before done is closed.
+	done chan struct{}
+	err  error
 }
 
 // NewClient returns a new initialized client using the provided existing connection.
@@ -63,6 +66,7 @@ func NewClient(cn net.Conn, isTLS bool) *Client {
 		isTLS:          isTLS,
 		waitNextRecvCh: make(chan chan struct{}, 1),
 		waitNextSendCh: make(chan chan struct{}, 1),
+		done:           make(chan struct{}),
 	}
 	c.start()
 	return c
@@ -89,10 +93,13 @@ func DialTLS(network, address string, config *tls.Config) (*Client, error) {
 func (c *Client) start() {
 	// Recv loop
 	go func() {
+		var e error
 		defer func() {
 			c.cn.Close()
+			// Fail the requests waiting for a response.
+			c.err = fmt.Errorf("ldap: connection closed: %w", e)
+			close(c.done)
 		}()
-		var e error
 		for {
 			pkt, _, err := ReadPacket(c.cn)
 			if err != nil {
@@ -124,7 +131,7 @@ func (c *Client) start() {
 			default:
 			}
 		}
-		if e != nil {
+		if e != nil && !errors.Is(e, io.EOF) && !errors.Is(e, net.ErrClosed) {
 			log.Printf("ldap: error on receive: %s", e)
 		}
 	}()
@@ -138,6 +145,12 @@ func (c *Client) start() {
 			if !ok {
 				break
 			}
+			// Register before sending since the response may be received
+			// before the send returns.
+			c.mu.Lock()
+			c.rmap[rq.i] = rq.c
+			c.mu.Unlock()
+
 			if err := rq.r.WritePackets(c.wr, rq.i); err != nil {
 				rq.c <- packetError{err: err}
 				break
@@ -147,10 +160,6 @@ func (c *Client) start() {
 				break
 			}
 
-			c.mu.Lock()
-			c.rmap[rq.i] = rq.c
-			c.mu.Unlock()
-
 			select {
 			case ch := <-c.waitNextSendCh:
 				<-ch
@@ -165,18 +174,55 @@ func (c *Client) newID() int {
 }
 
 func (c *Client) request(req Request) (*Packet, error) {
-	id := c.newID()
-	ch := make(chan packetError, 1)
-	c.rq <- cliReq{
-		i: id,
-		r: req,
-		c: ch,
+	id, ch, err := c.send(req)
+	if err != nil {
+		return nil, err
 	}
-	r := <-ch
+	r := c.wait(ch)
 	c.finishMessage(id)
 	return r.pkt, r.err
 }
 
+// send queues a request and returns its message ID and the channel for its
+// responses.
+func (c *Client) send(req Request) (int, chan packetError, error) {
+	id := c.newID()
+	ch := make(chan packetError, 1)
+	select {
+	case c.rq <- cliReq{i: id, r: req, c: ch}:
+		return id, ch, nil
+	case <-c.done:
+		return 0, nil, c.err
+	}
+}
+
+// wait returns the next response for a request or the error that ended
+// the connection.
+func (c *Client) wait(ch chan packetError) packetError {
+	select {
+	case r := <-ch:
+		return r
+	case <-c.done:
+		// A response may have been received before the connection ended.
+		select {
+		case r := <-ch:
+			return r
+		default:
+			return packetError{err: c.err}
+		}
+	}
+}
+
+// closed returns true if the connection has failed or been closed.
+func (c *Client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
 // Close closes the underlying connection to the server.
 func (c *Client) Close() error {
 	return c.cn.Close()
@@ -222,6 +268,7 @@ func (c *Client) StartTLS(config *tls.Config) error {
 	}
 	c.cn = tlsCn
 	c.wr.Reset(c.cn)
+	c.isTLS = true
 	return nil
 }
 
@@ -241,6 +288,19 @@ func (c *Client) Bind(dn string, pass []byte) error {
 	return res.BaseResponse.Err()
 }
 
+// Add an entry.
+func (c *Client) Add(req *AddRequest) error {
+	pkt, err := c.request(req)
+	if err != nil {
+		return err
+	}
+	var res AddResponse
+	if err := parseBaseResponse(pkt, &res.BaseResponse); err != nil {
+		return err
+	}
+	return res.BaseResponse.Err()
+}
+
 // Delete a node.
 func (c *Client) Delete(dn string) error {
 	pkt, err := c.request(&DeleteRequest{
@@ -256,45 +316,93 @@ func (c *Client) Delete(dn string) error {
 	return res.BaseResponse.Err()
 }
 
-// Search performs a search query against the LDAP database.
+// Search performs a search query against the LDAP database. Search result
+// references are ignored.
 func (c *Client) Search(req *SearchRequest) ([]*SearchResult, error) {
-	id := c.newID()
-	ch := make(chan packetError, 1)
-	c.rq <- cliReq{
-		i: id,
-		r: req,
-		c: ch,
+	var results []*SearchResult
+	err := c.SearchFunc(req, func(r *SearchResult) error {
+		results = append(results, r)
+		return nil
+	}, nil)
+	return results, err
+}
+
+// SearchFunc performs a search calling fn with each entry as it's received
+// and ref, if not nil, with the URIs of each search result reference. If
+// fn or ref returns an error then the remaining responses are discarded
+// and the error is returned once the search is done.
+func (c *Client) SearchFunc(req *SearchRequest, fn func(*SearchResult) error, ref func(uris []string) error) error {
+	return c.searchFunc(req, fn, ref)
+}
+
+func (c *Client) searchFunc(req Request, fn func(*SearchResult) error, ref func(uris []string) error) error {
+	id, ch, err := c.send(req)
+	if err != nil {
+		return err
 	}
 	defer c.finishMessage(id)
 
-	var results []*SearchResult
+	var fnErr error
 	for {
-		r := <-ch
+		r := c.wait(ch)
 		if r.err != nil {
-			return results, r.err
+			return r.err
 		}
 
 		switch r.pkt.Tag {
 		case ApplicationSearchResultEntry:
 			res, err := parseSearchResultResponse(r.pkt)
 			if err != nil {
-				return results, err
+				return err
+			}
+			if fnErr == nil {
+				fnErr = fn(res)
 			}
-			results = append(results, res)
 		case ApplicationSearchResultReference:
-			// TODO
+			uris := make([]string, len(r.pkt.Items))
+			for i, it := range r.pkt.Items {
+				var ok bool
+				if uris[i], ok = it.Str(); !ok {
+					return &ProtocolError{Reason: "invalid search result reference"}
+				}
+			}
+			if fnErr == nil && ref != nil {
+				fnErr = ref(uris)
+			}
 		case ApplicationSearchResultDone:
 			var res BaseResponse
 			if err := parseBaseResponse(r.pkt, &res); err != nil {
-				return results, err
+				return err
 			}
-			return results, res.Err()
+			if fnErr != nil {
+				return fnErr
+			}
+			return res.Err()
 		default:
-			return results, &ProtocolError{Reason: "unexpected tag for search response"}
+			return &ProtocolError{Reason: "unexpected tag for search response"}
 		}
 	}
 }
 
+// SearchURL performs the search described by an LDAP URL (RFC 4516). The
+// host of the URL is ignored since the search is sent to the server the
+// client is connected to. An error is returned if the URL has a critical
+// extension other than StartTLS on a TLS connection.
+func (c *Client) SearchURL(rawURL string) ([]*SearchResult, error) {
+	u, err := ParseLDAPURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var supported []string
+	if c.isTLS {
+		supported = append(supported, URLExtensionStartTLS)
+	}
+	if err := u.CheckCritical(supported...); err != nil {
+		return nil, err
+	}
+	return c.Search(u.SearchRequest())
+}
+
 // Modify operation allows a client to request that a modification
 // of an entry be performed on its behalf by a server.
 func (c *Client) Modify(dn string, mods []*Mod) error {
@@ -312,6 +420,67 @@ func (c *Client) Modify(dn string, mods []*Mod) error {
 	return res.BaseResponse.Err()
 }
 
+// ModifyDN renames an entry or moves it to a new superior.
+func (c *Client) ModifyDN(req *ModifyDNRequest) error {
+	pkt, err := c.request(req)
+	if err != nil {
+		return err
+	}
+	var res ModifyDNResponse
+	if err := parseBaseResponse(pkt, &res.BaseResponse); err != nil {
+		return err
+	}
+	return res.BaseResponse.Err()
+}
+
+// Compare returns true if the entry has the attribute with the value.
+func (c *Client) Compare(dn, attribute string, value []byte) (bool, error) {
+	pkt, err := c.request(&CompareRequest{
+		DN:        dn,
+		Attribute: attribute,
+		Value:     value,
+	})
+	if err != nil {
+		return false, err
+	}
+	var res CompareResponse
+	if err := parseBaseResponse(pkt, &res.BaseResponse); err != nil {
+		return false, err
+	}
+	switch res.Code {
+	case ResultCompareTrue:
+		return true, nil
+	case ResultCompareFalse:
+		return false, nil
+	}
+	if err := res.BaseResponse.Err(); err != nil {
+		return false, err
+	}
+	return false, &ProtocolError{Reason: "unexpected result code for compare response"}
+}
+
+// PasswordModify changes the password of a user (RFC 3062). The user
+// defaults to the one bound on the connection. If the new password is
+// empty then the server generates one which is returned.
+func (c *Client) PasswordModify(req *PasswordModifyRequest) ([]byte, error) {
+	ext, err := req.extendedRequest()
+	if err != nil {
+		return nil, err
+	}
+	pkt, err := c.request(ext)
+	if err != nil {
+		return nil, err
+	}
+	res, err := parseExtendedResponse(pkt)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.BaseResponse.Err(); err != nil {
+		return nil, err
+	}
+	return parseGenPassword(res.Value)
+}
+
 // WhoAmI returns the authzId for the authenticated user on the connection.
 // https://tools.ietf.org/html/rfc4532
 func (c *Client) WhoAmI() (string, error) {
@@ -1,7 +1,9 @@
 package ldap_test
 
 import (
+	"net"
 	"testing"
+	"time"
 
 	"github.com/samuel/go-ldap/ldap"
 )
@@ -48,3 +50,50 @@ func TestClientSearch(t *testing.T) {
 		}
 	}
 }
+
+// respondedConn holds up writes until the server has sent its response so
+// the response is received before the send returns.
+type respondedConn struct {
+	net.Conn
+	responded chan struct{}
+}
+
+func (c *respondedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err == nil {
+		<-c.responded
+	}
+	return n, err
+}
+
+func TestClientResponseBeforeSendReturns(t *testing.T) {
+	t.Parallel()
+	cn, srv := net.Pipe()
+	defer srv.Close()
+	responded := make(chan struct{})
+	c := ldap.NewClient(&respondedConn{Conn: cn, responded: responded}, false)
+	defer c.Close()
+	go func() {
+		pkt, _, err := ldap.ReadPacket(srv)
+		if err != nil {
+			return
+		}
+		msgID, _ := pkt.Items[0].Int()
+		res := &ldap.BindResponse{}
+		res.WritePackets(srv, msgID)
+		close(responded)
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.Bind("cn=test", []byte("verysecure"))
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("response received before the send returned was dropped")
+	}
+}
@@ -0,0 +1,59 @@
+package ldap
+
+import "io"
+
+// CompareRequest asks whether an entry has an attribute with a value
+// (RFC 4511 section 4.10).
+type CompareRequest struct {
+	DN        string
+	Attribute string
+	Value     []byte
+}
+
+// CompareResponse has the code ResultCompareTrue or ResultCompareFalse if
+// the comparison was performed.
+type CompareResponse struct {
+	BaseResponse
+}
+
+func parseCompareRequest(pkt *Packet) (*CompareRequest, error) {
+	if len(pkt.Items) != 2 || len(pkt.Items[1].Items) != 2 {
+		return nil, &ProtocolError{Reason: "compare request requires a dn and an attribute value assertion"}
+	}
+	var ok bool
+	req := &CompareRequest{}
+	req.DN, ok = pkt.Items[0].Str()
+	if !ok {
+		return nil, &ProtocolError{Reason: "invalid dn"}
+	}
+	req.Attribute, ok = pkt.Items[1].Items[0].Str()
+	if !ok {
+		return nil, &ProtocolError{Reason: "invalid attribute"}
+	}
+	req.Value, ok = pkt.Items[1].Items[1].Bytes()
+	if !ok {
+		return nil, &ProtocolError{Reason: "invalid assertion value"}
+	}
+	return req, nil
+}
+
+func (r *CompareRequest) WritePackets(w io.Writer, msgID int) error {
+	return r.writePackets(w, msgID, nil)
+}
+
+func (r *CompareRequest) writePackets(w io.Writer, msgID int, controls []*Control) error {
+	req := NewRequestPacket(msgID)
+	pkt := req.AddItem(NewPacket(ClassApplication, false, ApplicationCompareRequest, nil))
+	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.DN))
+	ava := pkt.AddItem(NewPacket(ClassUniversal, false, TagSequence, nil))
+	ava.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.Attribute))
+	ava.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.Value))
+	return writeRequest(w, req, controls)
+}
+
+func (r *CompareResponse) WritePackets(w io.Writer, msgID int) error {
+	res := NewResponsePacket(msgID)
+	pkt := res.AddItem(r.BaseResponse.NewPacket())
+	pkt.Tag = ApplicationCompareResponse
+	return res.Write(w)
+}
@@ -0,0 +1,73 @@
+package ldap
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCompareRequest(t *testing.T) {
+	t.Parallel()
+	req := &CompareRequest{DN: "cn=Alice,dc=example,dc=com", Attribute: "mail", Value: []byte("alice@example.com")}
+	var buf bytes.Buffer
+	if err := req.WritePackets(&buf, 7); err != nil {
+		t.Fatal(err)
+	}
+	msg, _, err := ParsePacket(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Items) != 2 || msg.Items[1].Tag != ApplicationCompareRequest {
+		t.Fatalf("Unexpected message %+v", msg)
+	}
+	req2, err := parseCompareRequest(msg.Items[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(req2, req) {
+		t.Errorf("Parsed %+v, want %+v", req2, req)
+	}
+	msg.Items[1].Items = msg.Items[1].Items[:1]
+	if _, err := parseCompareRequest(msg.Items[1]); err == nil {
+		t.Error("Expected error for compare request without an assertion")
+	}
+}
+
+type compareBackend struct {
+	Backend
+}
+
+func (compareBackend) Compare(ctx context.Context, state State, req *CompareRequest) (*CompareResponse, error) {
+	if req.Attribute == "answer" && string(req.Value) == "42" {
+		return &CompareResponse{BaseResponse: BaseResponse{Code: ResultCompareTrue}}, nil
+	}
+	return &CompareResponse{BaseResponse: BaseResponse{Code: ResultCompareFalse}}, nil
+}
+
+func TestServerCompare(t *testing.T) {
+	t.Parallel()
+	_, c := newTestServer(t, newTestMemoryBackend(t))
+	jdoe := "uid=jdoe,ou=People,dc=example,dc=com"
+	cases := []struct {
+		dn, attr, value string
+		want            bool
+		code            ResultCode
+	}{
+		{jdoe, "cn", "john doe", true, ResultSuccess},
+		{jdoe, "CN", "Alice Smith", false, ResultSuccess},
+		{jdoe, "mail", "jdoe@example.com", false, ResultSuccess},
+		{"uid=nobody,ou=People,dc=example,dc=com", "cn", "x", false, ResultNoSuchObject},
+	}
+	for _, tc := range cases {
+		got, err := c.Compare(tc.dn, tc.attr, []byte(tc.value))
+		if code := resultCode(err); code != tc.code || got != tc.want {
+			t.Errorf("%s %s=%s: expected %t %s got %t %v", tc.dn, tc.attr, tc.value, tc.want, tc.code, got, err)
+		}
+	}
+
+	_, c = newTestServer(t, compareBackend{newTestMemoryBackend(t)})
+	if ok, err := c.Compare(jdoe, "answer", []byte("42")); err != nil || !ok {
+		t.Errorf("expected backend compare to be true got %t %v", ok, err)
+	}
+}
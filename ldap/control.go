@@ -0,0 +1,114 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Control extends an operation (RFC 4511 section 4.1.11). A server that
+// doesn't support a critical control must fail the operation with
+// ResultUnavailableCriticalExtension.
+type Control struct {
+	Type        string // OID
+	Criticality bool
+	Value       []byte // nil if absent
+}
+
+func (c *Control) packet() *Packet {
+	pkt := NewPacket(ClassUniversal, false, TagSequence, nil)
+	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, c.Type))
+	if c.Criticality {
+		pkt.AddItem(NewPacket(ClassUniversal, true, TagBoolean, true))
+	}
+	if c.Value != nil {
+		pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, c.Value))
+	}
+	return pkt
+}
+
+// parseControls parses the controls of a message.
+func parseControls(pkt *Packet) ([]*Control, error) {
+	controls := make([]*Control, 0, len(pkt.Items))
+	for _, p := range pkt.Items {
+		if len(p.Items) == 0 || len(p.Items) > 3 {
+			return nil, &ProtocolError{Reason: "invalid control"}
+		}
+		c := &Control{}
+		var ok bool
+		if c.Type, ok = p.Items[0].Str(); !ok {
+			return nil, &ProtocolError{Reason: "invalid control type"}
+		}
+		for _, it := range p.Items[1:] {
+			switch {
+			case it.Tag == TagBoolean && !c.Criticality && c.Value == nil:
+				if c.Criticality, ok = it.Bool(); !ok {
+					return nil, &ProtocolError{Reason: "invalid control criticality"}
+				}
+			case it.Tag == TagOctetString && c.Value == nil:
+				if c.Value, ok = it.Bytes(); !ok {
+					return nil, &ProtocolError{Reason: "invalid control value"}
+				}
+				if c.Value == nil {
+					c.Value = []byte{}
+				}
+			default:
+				return nil, &ProtocolError{Reason: "invalid control"}
+			}
+		}
+		controls = append(controls, c)
+	}
+	return controls, nil
+}
+
+type requestControlsKey struct{}
+
+// RequestControls returns the controls sent with the request a backend is
+// processing. The server only passes on a critical control if the backend
+// has a SupportsControl method that returns true for its type. Non-critical
+// controls are passed on regardless and may be ignored.
+func RequestControls(ctx context.Context) []*Control {
+	controls, _ := ctx.Value(requestControlsKey{}).([]*Control)
+	return controls
+}
+
+func withRequestControls(ctx context.Context, controls []*Control) context.Context {
+	if len(controls) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, requestControlsKey{}, controls)
+}
+
+// controlledRequest sends a request with controls.
+type controlledRequest struct {
+	Request
+	controls []*Control
+}
+
+// controlWriter is implemented by the request types of this package to
+// write their message with controls.
+type controlWriter interface {
+	writePackets(w io.Writer, msgID int, controls []*Control) error
+}
+
+func (r *controlledRequest) WritePackets(w io.Writer, msgID int) error {
+	if len(r.controls) == 0 {
+		return r.Request.WritePackets(w, msgID)
+	}
+	cw, ok := r.Request.(controlWriter)
+	if !ok {
+		return fmt.Errorf("ldap: can't send controls with %T", r.Request)
+	}
+	return cw.writePackets(w, msgID, r.controls)
+}
+
+// writeRequest adds the controls to the request message and writes it.
+func writeRequest(w io.Writer, req *Packet, controls []*Control) error {
+	if len(controls) != 0 {
+		pkt := req.AddItem(NewPacket(ClassContext, false, 0, nil))
+		for _, c := range controls {
+			pkt.AddItem(c.packet())
+		}
+	}
+	return req.Write(w)
+}
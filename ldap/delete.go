@@ -34,7 +34,11 @@ func (r *DeleteResponse) WritePackets(w io.Writer, msgID int) error {
 }
 
 func (r *DeleteRequest) WritePackets(w io.Writer, msgID int) error {
+	return r.writePackets(w, msgID, nil)
+}
+
+func (r *DeleteRequest) writePackets(w io.Writer, msgID int, controls []*Control) error {
 	req := NewRequestPacket(msgID)
 	req.AddItem(NewPacket(ClassApplication, true, ApplicationDelRequest, r.DN))
-	return req.Write(w)
+	return writeRequest(w, req, controls)
 }
@@ -0,0 +1,179 @@
+package ldap
+
+import "strings"
+
+// DiffOptions control how DiffEntries compares entries.
+type DiffOptions struct {
+	// Schema is used to find the equality rule of attributes so values
+	// that are equal according to the rule (e.g. differ only in case for
+	// caseIgnoreMatch) are not considered changed, and to match attribute
+	// names that are aliases of each other. If nil then values are
+	// compared as octet strings and names case-insensitively.
+	Schema *Schema
+	// Replace uses a single Replace mod for every changed attribute rather
+	// than a Delete of the removed values and an Add of the added values.
+	Replace bool
+	// Ignore lists attributes that are left alone such as operational
+	// attributes returned by a search.
+	Ignore []string
+}
+
+// DiffEntries returns the modifications that turn the entry from into the
+// entry to, and the modifications that undo them. Attributes are in the
+// order of from followed by attributes only in to. The DNs of the entries
+// are not compared. If opts is nil the default options are used.
+func DiffEntries(from, to *Entry, opts *DiffOptions) (mods, undo []*Mod) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	d := &differ{opts: opts}
+	fromAttrs := d.group(from)
+	toAttrs := d.group(to)
+	var names []string
+	for _, e := range []*Entry{from, to} {
+		for _, a := range e.Attributes {
+			key := d.key(a.Name)
+			if !containsString(names, key) && !d.ignored(a.Name) {
+				names = append(names, key)
+			}
+		}
+	}
+	for _, key := range names {
+		m, u := d.diffAttribute(fromAttrs[key], toAttrs[key])
+		mods = append(mods, m...)
+		undo = append(undo, u...)
+	}
+	return mods, undo
+}
+
+type differ struct {
+	opts *DiffOptions
+}
+
+// diffAttribute returns the mods for a single attribute. The attributes are
+// nil when the entry doesn't have it.
+func (d *differ) diffAttribute(from, to *Attribute) (mods, undo []*Mod) {
+	switch {
+	case from == nil && to == nil:
+		return nil, nil
+	case from == nil:
+		if d.opts.Replace {
+			return []*Mod{{Type: Replace, Name: to.Name, Values: to.Values}},
+				[]*Mod{{Type: Replace, Name: to.Name}}
+		}
+		return []*Mod{{Type: Add, Name: to.Name, Values: to.Values}},
+			[]*Mod{{Type: Delete, Name: to.Name}}
+	case to == nil:
+		if d.opts.Replace {
+			return []*Mod{{Type: Replace, Name: from.Name}},
+				[]*Mod{{Type: Replace, Name: from.Name, Values: from.Values}}
+		}
+		return []*Mod{{Type: Delete, Name: from.Name}},
+			[]*Mod{{Type: Add, Name: from.Name, Values: from.Values}}
+	}
+	removed := d.missing(from, to)
+	added := d.missing(to, from)
+	if len(removed) == 0 && len(added) == 0 {
+		return nil, nil
+	}
+	if d.opts.Replace {
+		return []*Mod{{Type: Replace, Name: to.Name, Values: to.Values}},
+			[]*Mod{{Type: Replace, Name: from.Name, Values: from.Values}}
+	}
+	if len(removed) != 0 {
+		mods = append(mods, &Mod{Type: Delete, Name: from.Name, Values: removed})
+	}
+	if len(added) != 0 {
+		mods = append(mods, &Mod{Type: Add, Name: to.Name, Values: added})
+		undo = append(undo, &Mod{Type: Delete, Name: to.Name, Values: added})
+	}
+	if len(removed) != 0 {
+		undo = append(undo, &Mod{Type: Add, Name: from.Name, Values: removed})
+	}
+	return mods, undo
+}
+
+// missing returns the values of a that are not in b according to the
+// equality rule of the attribute.
+func (d *differ) missing(a, b *Attribute) [][]byte {
+	rule := d.rule(a.Name)
+	have := make(map[string]bool, len(b.Values))
+	for _, v := range b.Values {
+		have[normalizeValue(rule, v)] = true
+	}
+	var values [][]byte
+	for _, v := range a.Values {
+		if n := normalizeValue(rule, v); !have[n] {
+			have[n] = true
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (d *differ) rule(name string) *MatchingRule {
+	if d.opts.Schema == nil {
+		return nil
+	}
+	typ, _, _ := strings.Cut(name, ";")
+	return d.opts.Schema.schemaAwareRule(d.opts.Schema.EqualityRule(typ))
+}
+
+// normalizeValue returns the value normalized by the rule. Values that are
+// invalid for the rule are compared as octet strings.
+func normalizeValue(rule *MatchingRule, v []byte) string {
+	if rule != nil && rule.Normalize != nil {
+		if n, err := rule.Normalize(v); err == nil {
+			return string(n)
+		}
+	}
+	return string(v)
+}
+
+// key returns the normalized attribute description used to match
+// attributes between the entries.
+func (d *differ) key(name string) string {
+	desc, err := ParseAttributeDescription(name)
+	if err != nil {
+		return strings.ToLower(name)
+	}
+	return desc.Normalize(d.opts.Schema).String()
+}
+
+// group returns the attributes of the entry by key merging attributes that
+// are written differently but have the same description. Attributes without
+// values are treated as missing.
+func (d *differ) group(e *Entry) map[string]*Attribute {
+	attrs := make(map[string]*Attribute, len(e.Attributes))
+	for _, a := range e.Attributes {
+		if len(a.Values) == 0 {
+			continue
+		}
+		key := d.key(a.Name)
+		if g := attrs[key]; g != nil {
+			g.Values = append(g.Values[:len(g.Values):len(g.Values)], a.Values...)
+		} else {
+			attrs[key] = &Attribute{Name: a.Name, Values: a.Values}
+		}
+	}
+	return attrs
+}
+
+func (d *differ) ignored(name string) bool {
+	key := d.key(name)
+	for _, n := range d.opts.Ignore {
+		if d.key(n) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// applyMods applies modifications to an entry comparing values as octet
+// strings.
+func applyMods(e *Entry, mods []*Mod) *Entry {
+	e = e.Clone()
+	for _, m := range mods {
+		switch m.Type {
+		case Add:
+			e.AddValues(m.Name, m.Values...)
+		case Replace:
+			e.SetValues(m.Name, m.Values...)
+		case Delete:
+			if len(m.Values) == 0 {
+				e.DeleteAttribute(m.Name)
+				continue
+			}
+			var keep [][]byte
+			for _, v := range e.GetAllRaw(m.Name) {
+				found := false
+				for _, d := range m.Values {
+					found = found || bytes.EqualFold(v, d)
+				}
+				if !found {
+					keep = append(keep, v)
+				}
+			}
+			e.SetValues(m.Name, keep...)
+		}
+	}
+	return e
+}
+
+func formatMods(mods []*Mod) string {
+	s := ""
+	for _, m := range mods {
+		s += fmt.Sprintf("%s %s %q;", m.Type, m.Name, m.Values)
+	}
+	return s
+}
+
+func TestDiffEntries(t *testing.T) {
+	t.Parallel()
+	from := NewEntry("uid=jdoe,dc=example,dc=com", map[string][]string{
+		"cn":              {"John Doe", "Johnny"},
+		"mail":            {"jdoe@example.com"},
+		"description":     {"old"},
+		"modifyTimestamp": {"20240102150405Z"},
+	})
+	to := NewEntry("uid=jdoe,dc=example,dc=com", map[string][]string{
+		"CN":              {"john doe", "J"},
+		"mail":            {"jdoe@example.com"},
+		"telephoneNumber": {"+1 555 1234"},
+	})
+	cases := []struct {
+		opts *DiffOptions
+		mods string
+		undo string
+	}{
+		{
+			opts: &DiffOptions{Schema: DefaultSchema, Ignore: []string{"modifyTimestamp"}},
+			mods: `Delete cn ["Johnny"];Add CN ["J"];Delete description [];Add telephoneNumber ["+1 555 1234"];`,
+			undo: `Delete CN ["J"];Add cn ["Johnny"];Add description ["old"];Delete telephoneNumber [];`,
+		},
+		{
+			opts: nil,
+			mods: `Delete cn ["John Doe" "Johnny"];Add CN ["john doe" "J"];Delete description [];Delete modifyTimestamp [];Add telephoneNumber ["+1 555 1234"];`,
+			undo: `Delete CN ["john doe" "J"];Add cn ["John Doe" "Johnny"];Add description ["old"];Add modifyTimestamp ["20240102150405Z"];Delete telephoneNumber [];`,
+		},
+		{
+			opts: &DiffOptions{Schema: DefaultSchema, Replace: true, Ignore: []string{"modifyTimestamp"}},
+			mods: `Replace CN ["john doe" "J"];Replace description [];Replace telephoneNumber ["+1 555 1234"];`,
+			undo: `Replace cn ["John Doe" "Johnny"];Replace description ["old"];Replace telephoneNumber [];`,
+		},
+	}
+	for i, c := range cases {
+		mods, undo := DiffEntries(from, to, c.opts)
+		if s := formatMods(mods); s != c.mods {
+			t.Errorf("%d: mods = %s, want %s", i, s, c.mods)
+		}
+		if s := formatMods(undo); s != c.undo {
+			t.Errorf("%d: undo = %s, want %s", i, s, c.undo)
+		}
+		if c.opts == nil {
+			got := applyMods(from, mods)
+			if !reflect.DeepEqual(got.GetAll("cn"), to.GetAll("cn")) || got.Has("description") || got.Has("modifyTimestamp") {
+				t.Errorf("%d: applying mods = %+v", i, got)
+			}
+			if back := applyMods(got, undo); !reflect.DeepEqual(back.GetAll("cn"), from.GetAll("cn")) || back.Get("modifyTimestamp") == "" || back.Has("telephoneNumber") {
+				t.Errorf("%d: applying undo = %+v", i, back)
+			}
+		}
+	}
+	if mods, undo := DiffEntries(from, from.Clone(), nil); mods != nil || undo != nil {
+		t.Errorf("Diff of equal entries = %s, %s", formatMods(mods), formatMods(undo))
+	}
+	// commonName is an alias of cn and the values are equal ignoring case
+	alias := NewEntry("", map[string][]string{"commonName": {"JOHN DOE", "johnny"}})
+	if mods, _ := DiffEntries(NewEntry("", map[string][]string{"cn": from.GetAll("cn")}), alias, &DiffOptions{Schema: DefaultSchema}); mods != nil {
+		t.Errorf("Diff with alias = %s", formatMods(mods))
+	}
+}
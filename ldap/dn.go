@@ -0,0 +1,274 @@
+package ldap
+
+// https://tools.ietf.org/html/rfc4514
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type DNSyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *DNSyntaxError) Error() string {
+	return fmt.Sprintf("ldap: DN syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// AttributeTypeAndValue is a single assertion of a relative distinguished name.
+type AttributeTypeAndValue struct {
+	Type  string
+	Value string
+}
+
+func (atv AttributeTypeAndValue) String() string {
+	return atv.Type + "=" + escapeDNValue(atv.Value)
+}
+
+// RDN is a relative distinguished name. It has more than one value for multi-valued RDNs.
+type RDN []AttributeTypeAndValue
+
+func (r RDN) String() string {
+	s := make([]string, len(r))
+	for i, atv := range r {
+		s[i] = atv.String()
+	}
+	return strings.Join(s, "+")
+}
+
+// DN is a distinguished name. The first RDN is the name of the entry
+// relative to its immediate superior.
+type DN []RDN
+
+// ParseDN parses the string representation of a distinguished name (RFC 4514).
+// Spaces around separators are accepted as well as ';' as an RDN separator
+// for compatibility with RFC 1779.
+func ParseDN(s string) (DN, error) {
+	var dn DN
+	if strings.TrimSpace(s) == "" {
+		return dn, nil
+	}
+	var rdn RDN
+	i := 0
+	for {
+		// attribute type
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] != '=' {
+			c := s[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == ' ') {
+				return nil, &DNSyntaxError{Pos: i, Msg: fmt.Sprintf("invalid character %q in attribute type", c)}
+			}
+			i++
+		}
+		if i == len(s) {
+			return nil, &DNSyntaxError{Pos: i, Msg: "expected ="}
+		}
+		typ := strings.TrimRight(s[start:i], " ")
+		if typ == "" {
+			return nil, &DNSyntaxError{Pos: start, Msg: "empty attribute type"}
+		} else if strings.IndexByte(typ, ' ') >= 0 {
+			return nil, &DNSyntaxError{Pos: start, Msg: "space in attribute type"}
+		}
+		i++
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		// attribute value
+		var value []byte
+		if i < len(s) && s[i] == '#' {
+			start := i + 1
+			i++
+			for i < len(s) && isHexDigit(s[i]) {
+				i++
+			}
+			b, err := hex.DecodeString(s[start:i])
+			if err != nil || len(b) == 0 {
+				return nil, &DNSyntaxError{Pos: start, Msg: "invalid hex string value"}
+			}
+			// The value is the BER encoding of the value. Use the contents
+			// of the encoding when it's valid.
+			if pkt, n, err := ParsePacket(b); err == nil && n == len(b) && pkt.Primitive {
+				if v, ok := pkt.Value.([]byte); ok {
+					b = v
+				} else if v, ok := pkt.Value.(string); ok {
+					b = []byte(v)
+				}
+			}
+			value = b
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+		} else {
+			trailing := 0 // number of trailing unescaped spaces
+			for i < len(s) && s[i] != ',' && s[i] != '+' && s[i] != ';' {
+				c := s[i]
+				switch c {
+				case '\\':
+					if i+1 == len(s) {
+						return nil, &DNSyntaxError{Pos: i, Msg: "unexpected end of DN after \\"}
+					}
+					if i+2 < len(s) && isHexDigit(s[i+1]) && isHexDigit(s[i+2]) {
+						b, _ := hex.DecodeString(s[i+1 : i+3])
+						value = append(value, b[0])
+						i += 3
+					} else {
+						value = append(value, s[i+1])
+						i += 2
+					}
+					trailing = 0
+					continue
+				case '"', '<', '>':
+					return nil, &DNSyntaxError{Pos: i, Msg: fmt.Sprintf("unescaped %q in attribute value", c)}
+				case ' ':
+					trailing++
+				default:
+					trailing = 0
+				}
+				value = append(value, c)
+				i++
+			}
+			value = value[:len(value)-trailing]
+		}
+		rdn = append(rdn, AttributeTypeAndValue{Type: typ, Value: string(value)})
+		if i == len(s) {
+			dn = append(dn, rdn)
+			return dn, nil
+		}
+		if s[i] != '+' {
+			dn = append(dn, rdn)
+			rdn = nil
+		}
+		i++
+		if i == len(s) {
+			return nil, &DNSyntaxError{Pos: i, Msg: "unexpected end of DN"}
+		}
+	}
+}
+
+// MustParseDN is like ParseDN but panics if the DN cannot be parsed.
+func MustParseDN(s string) DN {
+	dn, err := ParseDN(s)
+	if err != nil {
+		panic(err)
+	}
+	return dn
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func escapeDNValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == ' ' && (i == 0 || i == len(v)-1),
+			c == '#' && i == 0,
+			c == '"', c == '+', c == ',', c == ';', c == '<', c == '>', c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (d DN) String() string {
+	s := make([]string, len(d))
+	for i, r := range d {
+		s[i] = r.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// Parent returns the DN of the immediate superior. It returns an empty DN
+// for an empty DN or a DN with a single RDN.
+func (d DN) Parent() DN {
+	if len(d) == 0 {
+		return nil
+	}
+	return d[1:]
+}
+
+// Normalize returns the DN in canonical form: attribute types are replaced
+// by the lower case primary name from DefaultSchema, values are normalized
+// using the equality matching rule of the attribute type (caseIgnoreMatch
+// for unknown types), and the values of multi-valued RDNs are sorted.
+func (d DN) Normalize() DN {
+	n := make(DN, len(d))
+	for i, r := range d {
+		nr := make(RDN, len(r))
+		for j, atv := range r {
+			nr[j] = normalizeATV(atv)
+		}
+		sort.Slice(nr, func(a, b int) bool {
+			if nr[a].Type != nr[b].Type {
+				return nr[a].Type < nr[b].Type
+			}
+			return nr[a].Value < nr[b].Value
+		})
+		n[i] = nr
+	}
+	return n
+}
+
+func normalizeATV(atv AttributeTypeAndValue) AttributeTypeAndValue {
+	typ := strings.ToLower(atv.Type)
+	rule := caseIgnoreMatch
+	if s := DefaultSchema; s != nil {
+		if at := s.AttributeType(atv.Type); at != nil {
+			typ = strings.ToLower(at.Name())
+			if r := s.EqualityRule(atv.Type); r != nil && r.Syntax != SyntaxDN {
+				rule = r
+			}
+		}
+	}
+	v, err := rule.normalize([]byte(atv.Value))
+	if err != nil {
+		if v, err = caseIgnoreMatch.normalize([]byte(atv.Value)); err != nil {
+			v = []byte(atv.Value)
+		}
+	}
+	return AttributeTypeAndValue{Type: typ, Value: string(v)}
+}
+
+// Equal returns true if the DNs match according to distinguishedNameMatch.
+func (d DN) Equal(o DN) bool {
+	if len(d) != len(o) {
+		return false
+	}
+	return d.Normalize().String() == o.Normalize().String()
+}
+
+// IsDescendantOf returns true if d is subordinate to (but not equal to) the ancestor DN.
+func (d DN) IsDescendantOf(ancestor DN) bool {
+	if len(d) <= len(ancestor) {
+		return false
+	}
+	return d[len(d)-len(ancestor):].Equal(ancestor)
+}
+
+// EqualDN returns true if two distinguished names in string form match
+// according to distinguishedNameMatch. Names that cannot be parsed are
+// compared case-insensitively.
+func EqualDN(a, b string) bool {
+	da, err := ParseDN(a)
+	if err != nil {
+		return strings.EqualFold(a, b)
+	}
+	db, err := ParseDN(b)
+	if err != nil {
+		return strings.EqualFold(a, b)
+	}
+	return da.Equal(db)
+}
@@ -0,0 +1,36 @@
+package ldap
+
+import "testing"
+
+func TestParseDN(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"cn=John Smith,dc=example,dc=com", "cn=John Smith,dc=example,dc=com"},
+		{"cn = John Smith , dc=example", "cn=John Smith,dc=example"},
+		{`cn=Smith\, John,dc=example`, `cn=Smith\, John,dc=example`},
+		{`cn=\23hash\20,dc=x`, `cn=\#hash\ ,dc=x`},
+		{`cn=\c3\a9,dc=x`, "cn=é,dc=x"},
+		{"ou=a+cn=b;dc=x", "ou=a+cn=b,dc=x"},
+		{"cn=#04024869,dc=x", "cn=Hi,dc=x"},
+	}
+	for _, c := range cases {
+		dn, err := ParseDN(c.in)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", c.in, err)
+		} else if s := dn.String(); s != c.out {
+			t.Errorf("ParseDN(%q) = %q, want %q", c.in, s, c.out)
+		}
+	}
+	for _, s := range []string{"cn", "=x", "cn=a,", `cn=a\`, "c n=a"} {
+		if _, err := ParseDN(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+	if !MustParseDN("cn=a,ou=People,dc=x").IsDescendantOf(MustParseDN("OU=people,DC=X")) {
+		t.Error("Expected cn=a,ou=People,dc=x to be a descendant of ou=people,dc=x")
+	}
+}
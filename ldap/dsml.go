@@ -0,0 +1,1104 @@
+package ldap
+
+// https://www.oasis-open.org/committees/dsml/docs/DSMLv2.doc
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DSMLNamespace is the XML namespace of DSMLv2 documents.
+const DSMLNamespace = "urn:oasis:names:tc:DSML:2:0:core"
+
+const (
+	xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+	xsdNamespace = "http://www.w3.org/2001/XMLSchema"
+)
+
+// DSMLBatchRequest is a DSMLv2 batchRequest document. It implements
+// xml.Marshaler and xml.Unmarshaler so it can be encoded on its own or as
+// part of a SOAP envelope.
+type DSMLBatchRequest struct {
+	RequestID string
+	// Processing is "sequential" (the default if empty) or "parallel".
+	Processing string
+	// ResponseOrder is "sequential" (the default if empty) or "unordered".
+	ResponseOrder string
+	// OnError is "exit" (the default if empty) or "resume".
+	OnError  string
+	Requests []*DSMLRequest
+}
+
+// DSMLRequest is an operation of a batch. Request is one of *SearchRequest,
+// *AddRequest, *ModifyRequest, *DeleteRequest, *ModifyDNRequest,
+// *CompareRequest, or *ExtendedRequest. Authentication and abandon
+// requests are not supported.
+type DSMLRequest struct {
+	RequestID string
+	Request   Request
+	Controls  []*Control
+}
+
+// DSMLBatchResponse is a DSMLv2 batchResponse document. It implements
+// xml.Marshaler and xml.Unmarshaler.
+type DSMLBatchResponse struct {
+	RequestID string
+	Responses []*DSMLResponse
+}
+
+// DSMLResponse is the response to an operation of a batch. Response is one
+// of *SearchResponse, *AddResponse, *ModifyResponse, *DeleteResponse,
+// *ModifyDNResponse, *CompareResponse, or *ExtendedResponse, or nil if
+// Error is set. The controls of a search response are those of the
+// searchResultDone element. Referrals and search result references are
+// ignored.
+type DSMLResponse struct {
+	RequestID string
+	Response  Response
+	Controls  []*Control
+	Error     *DSMLError
+}
+
+// DSMLError is an errorResponse which reports that a request could not be
+// processed rather than the result of an LDAP operation.
+type DSMLError struct {
+	// Type is one of "notAttempted", "couldNotConnect", "connectionClosed",
+	// "malformedRequest", "gatewayInternalError", "authenticationFailed",
+	// "unresolvableURI", or "other".
+	Type    string
+	Message string
+}
+
+func (e *DSMLError) Error() string {
+	return fmt.Sprintf("ldap: DSML %s: %s", e.Type, e.Message)
+}
+
+func dsmlErrorf(format string, args ...interface{}) error {
+	return fmt.Errorf("ldap: invalid DSML: "+format, args...)
+}
+
+var dsmlScopes = map[Scope]string{
+	ScopeBaseObject:   "baseObject",
+	ScopeSingleLevel:  "singleLevel",
+	ScopeWholeSubtree: "wholeSubtree",
+}
+
+var dsmlDerefAliases = map[DerefAliases]string{
+	NeverDerefAliases:   "neverDerefAliases",
+	DerefInSearching:    "derefInSearching",
+	DerefFindingBaseObj: "derefFindingBaseObj",
+	DerefAlways:         "derefAlways",
+}
+
+// dsmlResultCodes are the descriptions of result codes. The misspelling of
+// aliasDerefencingProblem is from the DSMLv2 schema.
+var dsmlResultCodes = map[ResultCode]string{
+	ResultSuccess:                      "success",
+	ResultOperationsError:              "operationsError",
+	ResultProtocolError:                "protocolError",
+	ResultTimeLimitExceeded:            "timeLimitExceeded",
+	ResultSizeLimitExceeded:            "sizeLimitExceeded",
+	ResultCompareFalse:                 "compareFalse",
+	ResultCompareTrue:                  "compareTrue",
+	ResultAuthMethodNotSupported:       "authMethodNotSupported",
+	ResultStrongAuthRequired:           "strongAuthRequired",
+	ResultReferral:                     "referral",
+	ResultAdminLimitExceeded:           "adminLimitExceeded",
+	ResultUnavailableCriticalExtension: "unavailableCriticalExtension",
+	ResultConfidentialityRequired:      "confidentialityRequired",
+	ResultSaslBindInProgress:           "saslBindInProgress",
+	ResultNoSuchAttribute:              "noSuchAttribute",
+	ResultUndefinedAttributeType:       "undefinedAttributeType",
+	ResultInappropriateMatching:        "inappropriateMatching",
+	ResultConstraintViolation:          "constraintViolation",
+	ResultAttributeOrValueExists:       "attributeOrValueExists",
+	ResultInvalidAttributeSyntax:       "invalidAttributeSyntax",
+	ResultNoSuchObject:                 "noSuchObject",
+	ResultAliasProblem:                 "aliasProblem",
+	ResultInvalidDNSyntax:              "invalidDNSyntax",
+	ResultAliasDereferencingProblem:    "aliasDerefencingProblem",
+	ResultInappropriateAuthentication:  "inappropriateAuthentication",
+	ResultInvalidCredentials:           "invalidCredentials",
+	ResultInsufficientAccessRights:     "insufficientAccessRights",
+	ResultBusy:                         "busy",
+	ResultUnavailable:                  "unavailable",
+	ResultUnwillingToPerform:           "unwillingToPerform",
+	ResultLoopDetect:                   "loopDetect",
+	ResultNamingViolation:              "namingViolation",
+	ResultObjectClassViolation:         "objectClassViolation",
+	ResultNotAllowedOnNonLeaf:          "notAllowedOnNonLeaf",
+	ResultNotAllowedOnRDN:              "notAllowedOnRDN",
+	ResultEntryAlreadyExists:           "entryAlreadyExists",
+	ResultObjectClassModsProhibited:    "objectClassModsProhibited",
+	ResultAffectsMultipleDSAs:          "affectsMultipleDSAs",
+	ResultOther:                        "other",
+}
+
+// MarshalXML encodes the batch as a batchRequest element.
+func (b *DSMLBatchRequest) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = dsmlRootElement("batchRequest", b.RequestID)
+	for _, a := range []struct{ name, value string }{
+		{"processing", b.Processing},
+		{"responseOrder", b.ResponseOrder},
+		{"onError", b.OnError},
+	} {
+		if a.value != "" {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: a.name}, Value: a.value})
+		}
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, r := range b.Requests {
+		name, el, err := newDSMLRequestElement(r)
+		if err != nil {
+			return err
+		}
+		if err := e.EncodeElement(el, xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// UnmarshalXML decodes a batchRequest element.
+func (b *DSMLBatchRequest) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if start.Name.Local != "batchRequest" {
+		return dsmlErrorf("expected batchRequest but found %s", start.Name.Local)
+	}
+	*b = DSMLBatchRequest{}
+	for _, a := range start.Attr {
+		if a.Name.Space != "" {
+			continue
+		}
+		switch a.Name.Local {
+		case "requestID":
+			b.RequestID = a.Value
+		case "processing":
+			b.Processing = a.Value
+		case "responseOrder":
+			b.ResponseOrder = a.Value
+		case "onError":
+			b.OnError = a.Value
+		}
+	}
+	return decodeDSMLElements(d, func(se xml.StartElement) error {
+		newElement, ok := dsmlRequestElements[se.Name.Local]
+		if !ok {
+			return dsmlErrorf("unsupported request %s", se.Name.Local)
+		}
+		el := newElement()
+		if err := d.DecodeElement(el, &se); err != nil {
+			return err
+		}
+		req, err := el.request()
+		if err != nil {
+			return err
+		}
+		m := el.message()
+		controls, err := m.controls()
+		if err != nil {
+			return err
+		}
+		b.Requests = append(b.Requests, &DSMLRequest{RequestID: m.RequestID, Request: req, Controls: controls})
+		return nil
+	})
+}
+
+// MarshalXML encodes the batch as a batchResponse element.
+func (b *DSMLBatchResponse) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = dsmlRootElement("batchResponse", b.RequestID)
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, r := range b.Responses {
+		name, el, err := newDSMLResponseElement(r)
+		if err != nil {
+			return err
+		}
+		if err := e.EncodeElement(el, xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// UnmarshalXML decodes a batchResponse element.
+func (b *DSMLBatchResponse) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if start.Name.Local != "batchResponse" {
+		return dsmlErrorf("expected batchResponse but found %s", start.Name.Local)
+	}
+	*b = DSMLBatchResponse{}
+	for _, a := range start.Attr {
+		if a.Name.Space == "" && a.Name.Local == "requestID" {
+			b.RequestID = a.Value
+		}
+	}
+	return decodeDSMLElements(d, func(se xml.StartElement) error {
+		res, err := decodeDSMLResponse(d, se)
+		if err != nil {
+			return err
+		}
+		b.Responses = append(b.Responses, res)
+		return nil
+	})
+}
+
+// dsmlRootElement returns the start of a batch element which declares the
+// namespaces used for the types of values.
+func dsmlRootElement(name, requestID string) xml.StartElement {
+	start := xml.StartElement{
+		Name: xml.Name{Space: DSMLNamespace, Local: name},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns:xsd"}, Value: xsdNamespace},
+			{Name: xml.Name{Local: "xmlns:xsi"}, Value: xsiNamespace},
+		},
+	}
+	if requestID != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "requestID"}, Value: requestID})
+	}
+	return start
+}
+
+// decodeDSMLElements calls fn for every child element up to the end of the
+// current element.
+func decodeDSMLElements(d *xml.Decoder, fn func(xml.StartElement) error) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if err := fn(t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// dsmlValue is a DsmlValue which is a string unless the xsi:type attribute
+// is xsd:base64Binary.
+type dsmlValue struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+	Text  string     `xml:",chardata"`
+}
+
+// newDSMLValue returns the value as a string if it can be represented in
+// XML as is, and base64 encoded otherwise.
+func newDSMLValue(v []byte) dsmlValue {
+	if isXMLText(v) {
+		return dsmlValue{Text: string(v)}
+	}
+	return newDSMLBase64Value(v)
+}
+
+func newDSMLBase64Value(v []byte) dsmlValue {
+	return dsmlValue{
+		Attrs: []xml.Attr{{Name: xml.Name{Local: "xsi:type"}, Value: "xsd:base64Binary"}},
+		Text:  base64.StdEncoding.EncodeToString(v),
+	}
+}
+
+func (v *dsmlValue) bytes() ([]byte, error) {
+	for _, a := range v.Attrs {
+		// The prefix is only resolved if the document declares it.
+		if a.Name.Local != "type" || a.Name.Space != xsiNamespace && a.Name.Space != "xsi" {
+			continue
+		}
+		typ := a.Value
+		if i := strings.LastIndexByte(typ, ':'); i >= 0 {
+			typ = typ[i+1:]
+		}
+		switch typ {
+		case "string":
+		case "base64Binary":
+			return decodeDSMLBase64(v.Text)
+		default:
+			return nil, dsmlErrorf("unsupported value type %q", a.Value)
+		}
+	}
+	return []byte(v.Text), nil
+}
+
+// decodeDSMLBase64 decodes base64 that may be broken into lines.
+func decodeDSMLBase64(s string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return nil, dsmlErrorf("invalid base64 value: %s", err)
+	}
+	return b, nil
+}
+
+// isXMLText returns true if the value is valid UTF-8 that only contains
+// characters allowed in XML. Carriage returns are excluded since XML
+// parsers normalize line endings.
+func isXMLText(v []byte) bool {
+	if !utf8.Valid(v) {
+		return false
+	}
+	for _, r := range string(v) {
+		if r < 0x20 && r != '\t' && r != '\n' || r == 0xfffe || r == 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+// dsmlMessage holds the parts common to all requests and responses.
+type dsmlMessage struct {
+	RequestID string        `xml:"requestID,attr,omitempty"`
+	Controls  []dsmlControl `xml:"control"`
+}
+
+func newDSMLMessage(requestID string, controls []*Control) dsmlMessage {
+	m := dsmlMessage{RequestID: requestID}
+	for _, c := range controls {
+		dc := dsmlControl{Type: c.Type, Criticality: c.Criticality}
+		if c.Value != nil {
+			v := newDSMLBase64Value(c.Value)
+			dc.Value = &v
+		}
+		m.Controls = append(m.Controls, dc)
+	}
+	return m
+}
+
+func (m *dsmlMessage) message() *dsmlMessage {
+	return m
+}
+
+func (m *dsmlMessage) controls() ([]*Control, error) {
+	var controls []*Control
+	for _, dc := range m.Controls {
+		if !isNumericOID(dc.Type) {
+			return nil, dsmlErrorf("invalid control type %q", dc.Type)
+		}
+		c := &Control{Type: dc.Type, Criticality: dc.Criticality}
+		if dc.Value != nil {
+			v, err := dc.Value.bytes()
+			if err != nil {
+				return nil, err
+			}
+			c.Value = v
+		}
+		controls = append(controls, c)
+	}
+	return controls, nil
+}
+
+type dsmlControl struct {
+	Type        string     `xml:"type,attr"`
+	Criticality bool       `xml:"criticality,attr,omitempty"`
+	Value       *dsmlValue `xml:"controlValue"`
+}
+
+type dsmlAttr struct {
+	Name   string      `xml:"name,attr"`
+	Values []dsmlValue `xml:"value"`
+}
+
+func newDSMLAttrs(attrs map[string][][]byte, order []string) []dsmlAttr {
+	var das []dsmlAttr
+	for _, name := range orderedNames(attrs, order) {
+		da := dsmlAttr{Name: name}
+		for _, v := range attrs[name] {
+			da.Values = append(da.Values, newDSMLValue(v))
+		}
+		das = append(das, da)
+	}
+	return das
+}
+
+// dsmlAttributes decodes attributes keeping their order. Attributes without
+// values (e.g. of search results with only types) are included with nil
+// values.
+func dsmlAttributes(das []dsmlAttr) (map[string][][]byte, []string, error) {
+	attrs := make(map[string][][]byte, len(das))
+	var order []string
+	for _, da := range das {
+		if da.Name == "" {
+			return nil, nil, dsmlErrorf("attribute without a name")
+		}
+		if _, ok := attrs[da.Name]; !ok {
+			attrs[da.Name] = nil
+			order = append(order, da.Name)
+		}
+		for _, dv := range da.Values {
+			v, err := dv.bytes()
+			if err != nil {
+				return nil, nil, err
+			}
+			attrs[da.Name] = append(attrs[da.Name], v)
+		}
+	}
+	return attrs, order, nil
+}
+
+// dsmlAssertion is an attribute value assertion used by filters and
+// compare requests.
+type dsmlAssertion struct {
+	Name  string    `xml:"name,attr"`
+	Value dsmlValue `xml:"value"`
+}
+
+func newDSMLAssertion(name string, value []byte) *dsmlAssertion {
+	return &dsmlAssertion{Name: name, Value: newDSMLValue(value)}
+}
+
+func (a *dsmlAssertion) assertion() (string, []byte, error) {
+	if a.Name == "" {
+		return "", nil, dsmlErrorf("assertion without a name")
+	}
+	v, err := a.Value.bytes()
+	return a.Name, v, err
+}
+
+type dsmlAttributeName struct {
+	Name string `xml:"name,attr"`
+}
+
+type dsmlSubstrings struct {
+	Name    string      `xml:"name,attr"`
+	Initial *dsmlValue  `xml:"initial"`
+	Any     []dsmlValue `xml:"any"`
+	Final   *dsmlValue  `xml:"final"`
+}
+
+type dsmlExtensibleMatch struct {
+	Name         string    `xml:"name,attr,omitempty"`
+	MatchingRule string    `xml:"matchingRule,attr,omitempty"`
+	DNAttributes bool      `xml:"dnAttributes,attr,omitempty"`
+	Value        dsmlValue `xml:"value"`
+}
+
+// dsmlFilter is the filter element of a search request.
+type dsmlFilter struct {
+	Filter Filter
+}
+
+func (f dsmlFilter) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := encodeDSMLFilter(e, f.Filter); err != nil {
+		return err
+	}
+	return e.EncodeToken(start.End())
+}
+
+func (f *dsmlFilter) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	filters, err := decodeDSMLFilters(d)
+	if err != nil {
+		return err
+	}
+	if len(filters) != 1 {
+		return dsmlErrorf("filter must have exactly one item but has %d", len(filters))
+	}
+	f.Filter = filters[0]
+	return nil
+}
+
+func encodeDSMLFilter(e *xml.Encoder, f Filter) error {
+	var name string
+	var v interface{}
+	switch f := f.(type) {
+	case *AND:
+		return encodeDSMLFilterSet(e, "and", f.Filters)
+	case *OR:
+		return encodeDSMLFilterSet(e, "or", f.Filters)
+	case *NOT:
+		return encodeDSMLFilterSet(e, "not", []Filter{f.Filter})
+	case *EqualityMatch:
+		name, v = "equalityMatch", newDSMLAssertion(f.Attribute, f.Value)
+	case *GreaterOrEqual:
+		name, v = "greaterOrEqual", newDSMLAssertion(f.Attribute, f.Value)
+	case *LessOrEqual:
+		name, v = "lessOrEqual", newDSMLAssertion(f.Attribute, f.Value)
+	case *ApproxMatch:
+		name, v = "approxMatch", newDSMLAssertion(f.Attribute, f.Value)
+	case *Present:
+		name, v = "present", &dsmlAttributeName{Name: f.Attribute}
+	case *Substrings:
+		s := &dsmlSubstrings{Name: f.Attribute}
+		if f.Initial != "" {
+			iv := newDSMLValue([]byte(f.Initial))
+			s.Initial = &iv
+		}
+		for _, a := range f.Any {
+			if a != "" {
+				s.Any = append(s.Any, newDSMLValue([]byte(a)))
+			}
+		}
+		if f.Final != "" {
+			fv := newDSMLValue([]byte(f.Final))
+			s.Final = &fv
+		}
+		name, v = "substrings", s
+	case *ExtensibleMatch:
+		name, v = "extensibleMatch", &dsmlExtensibleMatch{
+			Name:         f.Attribute,
+			MatchingRule: f.MatchingRule,
+			DNAttributes: f.DNAttributes,
+			Value:        newDSMLValue(f.Value),
+		}
+	default:
+		return fmt.Errorf("ldap: unsupported filter type %T for DSML", f)
+	}
+	return e.EncodeElement(v, xml.StartElement{Name: xml.Name{Local: name}})
+}
+
+func encodeDSMLFilterSet(e *xml.Encoder, name string, filters []Filter) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, f := range filters {
+		if err := encodeDSMLFilter(e, f); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// decodeDSMLFilters decodes the filters up to the end of the current
+// element.
+func decodeDSMLFilters(d *xml.Decoder) ([]Filter, error) {
+	var filters []Filter
+	err := decodeDSMLElements(d, func(se xml.StartElement) error {
+		f, err := decodeDSMLFilter(d, se)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, f)
+		return nil
+	})
+	return filters, err
+}
+
+func decodeDSMLFilter(d *xml.Decoder, start xml.StartElement) (Filter, error) {
+	switch start.Name.Local {
+	case "and", "or", "not":
+		filters, err := decodeDSMLFilters(d)
+		if err != nil {
+			return nil, err
+		}
+		switch start.Name.Local {
+		case "and":
+			return &AND{Filters: filters}, nil
+		case "or":
+			return &OR{Filters: filters}, nil
+		}
+		if len(filters) != 1 {
+			return nil, dsmlErrorf("not must have exactly one filter but has %d", len(filters))
+		}
+		return &NOT{Filter: filters[0]}, nil
+	case "equalityMatch", "greaterOrEqual", "lessOrEqual", "approxMatch":
+		var a dsmlAssertion
+		if err := d.DecodeElement(&a, &start); err != nil {
+			return nil, err
+		}
+		name, v, err := a.assertion()
+		if err != nil {
+			return nil, err
+		}
+		switch start.Name.Local {
+		case "equalityMatch":
+			return &EqualityMatch{Attribute: name, Value: v}, nil
+		case "greaterOrEqual":
+			return &GreaterOrEqual{Attribute: name, Value: v}, nil
+		case "lessOrEqual":
+			return &LessOrEqual{Attribute: name, Value: v}, nil
+		}
+		return &ApproxMatch{Attribute: name, Value: v}, nil
+	case "present":
+		var p dsmlAttributeName
+		if err := d.DecodeElement(&p, &start); err != nil {
+			return nil, err
+		}
+		if p.Name == "" {
+			return nil, dsmlErrorf("present filter without a name")
+		}
+		return &Present{Attribute: p.Name}, nil
+	case "substrings":
+		var s dsmlSubstrings
+		if err := d.DecodeElement(&s, &start); err != nil {
+			return nil, err
+		}
+		if s.Name == "" {
+			return nil, dsmlErrorf("substrings filter without a name")
+		}
+		f := &Substrings{Attribute: s.Name}
+		if s.Initial != nil {
+			v, err := s.Initial.bytes()
+			if err != nil {
+				return nil, err
+			}
+			f.Initial = string(v)
+		}
+		for _, a := range s.Any {
+			v, err := a.bytes()
+			if err != nil {
+				return nil, err
+			}
+			f.Any = append(f.Any, string(v))
+		}
+		if s.Final != nil {
+			v, err := s.Final.bytes()
+			if err != nil {
+				return nil, err
+			}
+			f.Final = string(v)
+		}
+		return f, nil
+	case "extensibleMatch":
+		var m dsmlExtensibleMatch
+		if err := d.DecodeElement(&m, &start); err != nil {
+			return nil, err
+		}
+		if m.Name == "" && m.MatchingRule == "" {
+			return nil, dsmlErrorf("extensibleMatch requires a name or matchingRule")
+		}
+		v, err := m.Value.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return &ExtensibleMatch{Attribute: m.Name, MatchingRule: m.MatchingRule, DNAttributes: m.DNAttributes, Value: v}, nil
+	}
+	return nil, dsmlErrorf("unknown filter %s", start.Name.Local)
+}
+
+// dsmlRequestElement is implemented by the request elements of a batch.
+type dsmlRequestElement interface {
+	message() *dsmlMessage
+	request() (Request, error)
+}
+
+var dsmlRequestElements = map[string]func() dsmlRequestElement{
+	"searchRequest":   func() dsmlRequestElement { return &dsmlSearchRequest{} },
+	"addRequest":      func() dsmlRequestElement { return &dsmlAddRequest{} },
+	"modifyRequest":   func() dsmlRequestElement { return &dsmlModifyRequest{} },
+	"delRequest":      func() dsmlRequestElement { return &dsmlDelRequest{} },
+	"modDNRequest":    func() dsmlRequestElement { return &dsmlModDNRequest{} },
+	"compareRequest":  func() dsmlRequestElement { return &dsmlCompareRequest{} },
+	"extendedRequest": func() dsmlRequestElement { return &dsmlExtendedRequest{} },
+}
+
+type dsmlSearchRequest struct {
+	dsmlMessage
+	DN           string             `xml:"dn,attr"`
+	Scope        string             `xml:"scope,attr"`
+	DerefAliases string             `xml:"derefAliases,attr"`
+	SizeLimit    int                `xml:"sizeLimit,attr,omitempty"`
+	TimeLimit    int                `xml:"timeLimit,attr,omitempty"`
+	TypesOnly    bool               `xml:"typesOnly,attr,omitempty"`
+	Filter       dsmlFilter         `xml:"filter"`
+	Attributes   *dsmlAttributeList `xml:"attributes"`
+}
+
+type dsmlAttributeList struct {
+	Attributes []dsmlAttributeName `xml:"attribute"`
+}
+
+func (r *dsmlSearchRequest) request() (Request, error) {
+	req := &SearchRequest{
+		BaseDN:    r.DN,
+		SizeLimit: r.SizeLimit,
+		TimeLimit: r.TimeLimit,
+		TypesOnly: r.TypesOnly,
+		Filter:    r.Filter.Filter,
+	}
+	found := false
+	for sc, name := range dsmlScopes {
+		if r.Scope == name {
+			req.Scope = sc
+			found = true
+		}
+	}
+	if !found {
+		return nil, dsmlErrorf("unknown scope %q", r.Scope)
+	}
+	found = false
+	for da, name := range dsmlDerefAliases {
+		if r.DerefAliases == name {
+			req.DerefAliases = da
+			found = true
+		}
+	}
+	if !found {
+		return nil, dsmlErrorf("unknown derefAliases %q", r.DerefAliases)
+	}
+	if req.Filter == nil {
+		return nil, dsmlErrorf("searchRequest without a filter")
+	}
+	if r.Attributes != nil && len(r.Attributes.Attributes) != 0 {
+		req.Attributes = make(map[string]bool, len(r.Attributes.Attributes))
+		for _, a := range r.Attributes.Attributes {
+			req.Attributes[a.Name] = true
+		}
+	}
+	return req, nil
+}
+
+type dsmlAddRequest struct {
+	dsmlMessage
+	DN    string     `xml:"dn,attr"`
+	Attrs []dsmlAttr `xml:"attr"`
+}
+
+func (r *dsmlAddRequest) request() (Request, error) {
+	attrs, order, err := dsmlAttributes(r.Attrs)
+	if err != nil {
+		return nil, err
+	}
+	return &AddRequest{DN: r.DN, Attributes: attrs, order: order}, nil
+}
+
+type dsmlModifyRequest struct {
+	dsmlMessage
+	DN   string             `xml:"dn,attr"`
+	Mods []dsmlModification `xml:"modification"`
+}
+
+type dsmlModification struct {
+	Name      string      `xml:"name,attr"`
+	Operation string      `xml:"operation,attr"`
+	Values    []dsmlValue `xml:"value"`
+}
+
+func (r *dsmlModifyRequest) request() (Request, error) {
+	req := &ModifyRequest{DN: r.DN}
+	for _, dm := range r.Mods {
+		// The modification types have the same names as in LDIF.
+		typ, ok := ldifModTypes[dm.Operation]
+		if !ok {
+			return nil, dsmlErrorf("unknown modification operation %q", dm.Operation)
+		}
+		if dm.Name == "" {
+			return nil, dsmlErrorf("modification without a name")
+		}
+		m := &Mod{Type: typ, Name: dm.Name}
+		for _, dv := range dm.Values {
+			v, err := dv.bytes()
+			if err != nil {
+				return nil, err
+			}
+			m.Values = append(m.Values, v)
+		}
+		req.Mods = append(req.Mods, m)
+	}
+	return req, nil
+}
+
+type dsmlDelRequest struct {
+	dsmlMessage
+	DN string `xml:"dn,attr"`
+}
+
+func (r *dsmlDelRequest) request() (Request, error) {
+	return &DeleteRequest{DN: r.DN}, nil
+}
+
+type dsmlModDNRequest struct {
+	dsmlMessage
+	DN           string `xml:"dn,attr"`
+	NewRDN       string `xml:"newrdn,attr"`
+	DeleteOldRDN string `xml:"deleteoldrdn,attr,omitempty"`
+	NewSuperior  string `xml:"newSuperior,attr,omitempty"`
+}
+
+func (r *dsmlModDNRequest) request() (Request, error) {
+	req := &ModifyDNRequest{DN: r.DN, NewRDN: r.NewRDN, NewSuperior: r.NewSuperior}
+	// deleteoldrdn defaults to true
+	req.DeleteOldRDN = true
+	if r.DeleteOldRDN != "" {
+		var err error
+		req.DeleteOldRDN, err = strconv.ParseBool(strings.TrimSpace(r.DeleteOldRDN))
+		if err != nil {
+			return nil, dsmlErrorf("invalid deleteoldrdn %q", r.DeleteOldRDN)
+		}
+	}
+	return req, nil
+}
+
+type dsmlCompareRequest struct {
+	dsmlMessage
+	DN        string        `xml:"dn,attr"`
+	Assertion dsmlAssertion `xml:"assertion"`
+}
+
+func (r *dsmlCompareRequest) request() (Request, error) {
+	name, v, err := r.Assertion.assertion()
+	if err != nil {
+		return nil, err
+	}
+	return &CompareRequest{DN: r.DN, Attribute: name, Value: v}, nil
+}
+
+type dsmlExtendedRequest struct {
+	dsmlMessage
+	Name  string  `xml:"requestName"`
+	Value *string `xml:"requestValue"`
+}
+
+func (r *dsmlExtendedRequest) request() (Request, error) {
+	req := &ExtendedRequest{Name: strings.TrimSpace(r.Name)}
+	if r.Value != nil {
+		v, err := decodeDSMLBase64(*r.Value)
+		if err != nil {
+			return nil, err
+		}
+		req.Value = v
+	}
+	return req, nil
+}
+
+func newDSMLRequestElement(r *DSMLRequest) (string, interface{}, error) {
+	m := newDSMLMessage(r.RequestID, r.Controls)
+	switch req := r.Request.(type) {
+	case *SearchRequest:
+		el := &dsmlSearchRequest{
+			dsmlMessage:  m,
+			DN:           req.BaseDN,
+			Scope:        dsmlScopes[req.Scope],
+			DerefAliases: dsmlDerefAliases[req.DerefAliases],
+			SizeLimit:    req.SizeLimit,
+			TimeLimit:    req.TimeLimit,
+			TypesOnly:    req.TypesOnly,
+			Filter:       dsmlFilter{Filter: req.Filter},
+		}
+		if el.Scope == "" {
+			return "", nil, fmt.Errorf("ldap: scope %s is not supported by DSML", req.Scope)
+		}
+		if el.DerefAliases == "" {
+			return "", nil, fmt.Errorf("ldap: unknown deref aliases %s", req.DerefAliases)
+		}
+		if el.Filter.Filter == nil {
+			el.Filter.Filter = &Present{Attribute: "objectClass"}
+		}
+		if len(req.Attributes) != 0 {
+			el.Attributes = &dsmlAttributeList{}
+			names := make([]string, 0, len(req.Attributes))
+			for a := range req.Attributes {
+				names = append(names, a)
+			}
+			sort.Strings(names)
+			for _, a := range names {
+				el.Attributes.Attributes = append(el.Attributes.Attributes, dsmlAttributeName{Name: a})
+			}
+		}
+		return "searchRequest", el, nil
+	case *AddRequest:
+		return "addRequest", &dsmlAddRequest{dsmlMessage: m, DN: req.DN, Attrs: newDSMLAttrs(req.Attributes, req.order)}, nil
+	case *ModifyRequest:
+		el := &dsmlModifyRequest{dsmlMessage: m, DN: req.DN}
+		for _, mod := range req.Mods {
+			dm := dsmlModification{Name: mod.Name}
+			for name, typ := range ldifModTypes {
+				if typ == mod.Type {
+					dm.Operation = name
+				}
+			}
+			if dm.Operation == "" {
+				return "", nil, fmt.Errorf("ldap: unsupported modification type %s", mod.Type)
+			}
+			for _, v := range mod.Values {
+				dm.Values = append(dm.Values, newDSMLValue(v))
+			}
+			el.Mods = append(el.Mods, dm)
+		}
+		return "modifyRequest", el, nil
+	case *DeleteRequest:
+		return "delRequest", &dsmlDelRequest{dsmlMessage: m, DN: req.DN}, nil
+	case *ModifyDNRequest:
+		return "modDNRequest", &dsmlModDNRequest{
+			dsmlMessage:  m,
+			DN:           req.DN,
+			NewRDN:       req.NewRDN,
+			DeleteOldRDN: strconv.FormatBool(req.DeleteOldRDN),
+			NewSuperior:  req.NewSuperior,
+		}, nil
+	case *CompareRequest:
+		return "compareRequest", &dsmlCompareRequest{
+			dsmlMessage: m,
+			DN:          req.DN,
+			Assertion:   *newDSMLAssertion(req.Attribute, req.Value),
+		}, nil
+	case *ExtendedRequest:
+		el := &dsmlExtendedRequest{dsmlMessage: m, Name: req.Name}
+		if req.Value != nil {
+			v := base64.StdEncoding.EncodeToString(req.Value)
+			el.Value = &v
+		}
+		return "extendedRequest", el, nil
+	}
+	return "", nil, fmt.Errorf("ldap: unsupported request type %T for DSML", r.Request)
+}
+
+// dsmlResult is an LDAPResult.
+type dsmlResult struct {
+	dsmlMessage
+	MatchedDN    string         `xml:"matchedDN,attr,omitempty"`
+	ResultCode   dsmlResultCode `xml:"resultCode"`
+	ErrorMessage string         `xml:"errorMessage,omitempty"`
+	Referrals    []string       `xml:"referral"`
+}
+
+type dsmlResultCode struct {
+	Code  int    `xml:"code,attr"`
+	Descr string `xml:"descr,attr,omitempty"`
+}
+
+func newDSMLResult(m dsmlMessage, res *BaseResponse) *dsmlResult {
+	return &dsmlResult{
+		dsmlMessage:  m,
+		MatchedDN:    res.MatchedDN,
+		ResultCode:   dsmlResultCode{Code: int(res.Code), Descr: dsmlResultCodes[res.Code]},
+		ErrorMessage: res.Message,
+	}
+}
+
+func (r *dsmlResult) baseResponse() BaseResponse {
+	return BaseResponse{
+		Code:      ResultCode(r.ResultCode.Code),
+		MatchedDN: r.MatchedDN,
+		Message:   r.ErrorMessage,
+	}
+}
+
+// dsmlResults are the responses that only consist of an LDAPResult.
+var dsmlResults = map[string]func(BaseResponse) Response{
+	"addResponse":     func(r BaseResponse) Response { return &AddResponse{BaseResponse: r} },
+	"modifyResponse":  func(r BaseResponse) Response { return &ModifyResponse{BaseResponse: r} },
+	"delResponse":     func(r BaseResponse) Response { return &DeleteResponse{BaseResponse: r} },
+	"modDNResponse":   func(r BaseResponse) Response { return &ModifyDNResponse{BaseResponse: r} },
+	"compareResponse": func(r BaseResponse) Response { return &CompareResponse{BaseResponse: r} },
+}
+
+type dsmlExtendedResponse struct {
+	dsmlResult
+	Name  string  `xml:"responseName,omitempty"`
+	Value *string `xml:"response"`
+}
+
+type dsmlSearchResponse struct {
+	RequestID string                  `xml:"requestID,attr,omitempty"`
+	Entries   []dsmlSearchResultEntry `xml:"searchResultEntry"`
+	Done      dsmlResult              `xml:"searchResultDone"`
+}
+
+type dsmlSearchResultEntry struct {
+	dsmlMessage
+	DN    string     `xml:"dn,attr"`
+	Attrs []dsmlAttr `xml:"attr"`
+}
+
+type dsmlErrorResponse struct {
+	RequestID string `xml:"requestID,attr,omitempty"`
+	Type      string `xml:"type,attr"`
+	Message   string `xml:"message,omitempty"`
+}
+
+func decodeDSMLResponse(d *xml.Decoder, se xml.StartElement) (*DSMLResponse, error) {
+	var res *DSMLResponse
+	var m *dsmlMessage
+	switch se.Name.Local {
+	case "searchResponse":
+		var el dsmlSearchResponse
+		if err := d.DecodeElement(&el, &se); err != nil {
+			return nil, err
+		}
+		sr := &SearchResponse{BaseResponse: el.Done.baseResponse()}
+		for _, e := range el.Entries {
+			attrs, order, err := dsmlAttributes(e.Attrs)
+			if err != nil {
+				return nil, err
+			}
+			sr.Results = append(sr.Results, &SearchResult{DN: e.DN, Attributes: attrs, order: order})
+		}
+		m = &el.Done.dsmlMessage
+		res = &DSMLResponse{RequestID: el.RequestID, Response: sr}
+		if res.RequestID == "" {
+			res.RequestID = m.RequestID
+		}
+	case "extendedResponse":
+		var el dsmlExtendedResponse
+		if err := d.DecodeElement(&el, &se); err != nil {
+			return nil, err
+		}
+		er := &ExtendedResponse{BaseResponse: el.baseResponse(), Name: strings.TrimSpace(el.Name)}
+		if el.Value != nil {
+			v, err := decodeDSMLBase64(*el.Value)
+			if err != nil {
+				return nil, err
+			}
+			er.Value = v
+		}
+		m = &el.dsmlMessage
+		res = &DSMLResponse{RequestID: m.RequestID, Response: er}
+	case "errorResponse":
+		var el dsmlErrorResponse
+		if err := d.DecodeElement(&el, &se); err != nil {
+			return nil, err
+		}
+		return &DSMLResponse{RequestID: el.RequestID, Error: &DSMLError{Type: el.Type, Message: el.Message}}, nil
+	default:
+		newResponse, ok := dsmlResults[se.Name.Local]
+		if !ok {
+			return nil, dsmlErrorf("unsupported response %s", se.Name.Local)
+		}
+		var el dsmlResult
+		if err := d.DecodeElement(&el, &se); err != nil {
+			return nil, err
+		}
+		m = &el.dsmlMessage
+		res = &DSMLResponse{RequestID: m.RequestID, Response: newResponse(el.baseResponse())}
+	}
+	var err error
+	res.Controls, err = m.controls()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func newDSMLResponseElement(r *DSMLResponse) (string, interface{}, error) {
+	if r.Error != nil {
+		return "errorResponse", &dsmlErrorResponse{RequestID: r.RequestID, Type: r.Error.Type, Message: r.Error.Message}, nil
+	}
+	m := newDSMLMessage(r.RequestID, r.Controls)
+	switch res := r.Response.(type) {
+	case *SearchResponse:
+		m.RequestID = ""
+		el := &dsmlSearchResponse{RequestID: r.RequestID, Done: *newDSMLResult(m, &res.BaseResponse)}
+		for _, sr := range res.Results {
+			el.Entries = append(el.Entries, dsmlSearchResultEntry{DN: sr.DN, Attrs: newDSMLAttrs(sr.Attributes, sr.order)})
+		}
+		return "searchResponse", el, nil
+	case *AddResponse:
+		return "addResponse", newDSMLResult(m, &res.BaseResponse), nil
+	case *ModifyResponse:
+		return "modifyResponse", newDSMLResult(m, &res.BaseResponse), nil
+	case *DeleteResponse:
+		return "delResponse", newDSMLResult(m, &res.BaseResponse), nil
+	case *ModifyDNResponse:
+		return "modDNResponse", newDSMLResult(m, &res.BaseResponse), nil
+	case *CompareResponse:
+		return "compareResponse", newDSMLResult(m, &res.BaseResponse), nil
+	case *ExtendedResponse:
+		el := &dsmlExtendedResponse{dsmlResult: *newDSMLResult(m, &res.BaseResponse), Name: res.Name}
+		if res.Value != nil {
+			v := base64.StdEncoding.EncodeToString(res.Value)
+			el.Value = &v
+		}
+		return "extendedResponse", el, nil
+	}
+	return "", nil, fmt.Errorf("ldap: unsupported response type %T for DSML", r.Response)
+}
@@ -0,0 +1,291 @@
+package ldap
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDSMLBatchRequest = `<?xml version="1.0" encoding="UTF-8"?>
+<dsml:batchRequest xmlns:dsml="urn:oasis:names:tc:DSML:2:0:core"
+		xmlns:xsd="http://www.w3.org/2001/XMLSchema"
+		xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+		requestID="batch" onError="resume">
+	<dsml:searchRequest requestID="1" dn="ou=Marketing,dc=example,dc=com" scope="singleLevel" derefAliases="derefAlways" sizeLimit="10" typesOnly="true">
+		<dsml:control type="1.2.840.113556.1.4.319" criticality="true">
+			<dsml:controlValue xsi:type="xsd:base64Binary">MAUCAQoEAA==</dsml:controlValue>
+		</dsml:control>
+		<dsml:filter>
+			<dsml:and>
+				<dsml:or>
+					<dsml:equalityMatch name="sn"><dsml:value>Jensen</dsml:value></dsml:equalityMatch>
+					<dsml:approxMatch name="sn"><dsml:value>Jenson</dsml:value></dsml:approxMatch>
+				</dsml:or>
+				<dsml:not><dsml:present name="telephoneNumber"/></dsml:not>
+				<dsml:substrings name="cn">
+					<dsml:initial>Ba</dsml:initial>
+					<dsml:any>ba</dsml:any>
+					<dsml:final>ra</dsml:final>
+				</dsml:substrings>
+				<dsml:greaterOrEqual name="uidNumber"><dsml:value>100</dsml:value></dsml:greaterOrEqual>
+				<dsml:lessOrEqual name="uidNumber"><dsml:value xsi:type="xsd:base64Binary">MjAw</dsml:value></dsml:lessOrEqual>
+				<dsml:extensibleMatch name="ou" matchingRule="caseExactMatch" dnAttributes="true"><dsml:value>Sales</dsml:value></dsml:extensibleMatch>
+			</dsml:and>
+		</dsml:filter>
+		<dsml:attributes>
+			<dsml:attribute name="cn"/>
+			<dsml:attribute name="mail"/>
+		</dsml:attributes>
+	</dsml:searchRequest>
+	<dsml:addRequest requestID="2" dn="cn=Alice,dc=example,dc=com">
+		<dsml:attr name="objectClass"><dsml:value>top</dsml:value><dsml:value>person</dsml:value></dsml:attr>
+		<dsml:attr name="cn"><dsml:value>Alice</dsml:value></dsml:attr>
+		<dsml:attr name="jpegPhoto"><dsml:value xsi:type="xsd:base64Binary">/9j/
+			4AAQ</dsml:value></dsml:attr>
+	</dsml:addRequest>
+	<dsml:modifyRequest requestID="3" dn="cn=Alice,dc=example,dc=com">
+		<dsml:modification name="mail" operation="replace"><dsml:value>alice@example.com</dsml:value></dsml:modification>
+		<dsml:modification name="description" operation="delete"/>
+		<dsml:modification name="seeAlso" operation="add"><dsml:value>cn=Bob,dc=example,dc=com</dsml:value></dsml:modification>
+	</dsml:modifyRequest>
+	<dsml:delRequest requestID="4" dn="cn=Bob,dc=example,dc=com"/>
+	<dsml:modDNRequest requestID="5" dn="cn=Alice,dc=example,dc=com" newrdn="cn=Alicia"/>
+	<dsml:modDNRequest requestID="6" dn="cn=Alicia,dc=example,dc=com" newrdn="cn=Alicia" deleteoldrdn="false" newSuperior="ou=People,dc=example,dc=com"/>
+	<dsml:compareRequest requestID="7" dn="cn=Alicia,ou=People,dc=example,dc=com">
+		<dsml:assertion name="mail"><dsml:value>alice@example.com</dsml:value></dsml:assertion>
+	</dsml:compareRequest>
+	<dsml:extendedRequest requestID="8">
+		<dsml:requestName>1.3.6.1.4.1.4203.1.11.3</dsml:requestName>
+	</dsml:extendedRequest>
+</dsml:batchRequest>
+`
+
+func TestDSMLBatchRequestDecode(t *testing.T) {
+	t.Parallel()
+	var b DSMLBatchRequest
+	if err := xml.Unmarshal([]byte(testDSMLBatchRequest), &b); err != nil {
+		t.Fatal(err)
+	}
+	want := &DSMLBatchRequest{
+		RequestID: "batch",
+		OnError:   "resume",
+		Requests: []*DSMLRequest{
+			{
+				RequestID: "1",
+				Request: &SearchRequest{
+					BaseDN:       "ou=Marketing,dc=example,dc=com",
+					Scope:        ScopeSingleLevel,
+					DerefAliases: DerefAlways,
+					SizeLimit:    10,
+					TypesOnly:    true,
+					Filter: &AND{Filters: []Filter{
+						&OR{Filters: []Filter{
+							&EqualityMatch{Attribute: "sn", Value: []byte("Jensen")},
+							&ApproxMatch{Attribute: "sn", Value: []byte("Jenson")},
+						}},
+						&NOT{Filter: &Present{Attribute: "telephoneNumber"}},
+						&Substrings{Attribute: "cn", Initial: "Ba", Any: []string{"ba"}, Final: "ra"},
+						&GreaterOrEqual{Attribute: "uidNumber", Value: []byte("100")},
+						&LessOrEqual{Attribute: "uidNumber", Value: []byte("200")},
+						&ExtensibleMatch{Attribute: "ou", MatchingRule: "caseExactMatch", DNAttributes: true, Value: []byte("Sales")},
+					}},
+					Attributes: map[string]bool{"cn": true, "mail": true},
+				},
+				Controls: []*Control{{Type: "1.2.840.113556.1.4.319", Criticality: true, Value: []byte{0x30, 5, 2, 1, 10, 4, 0}}},
+			},
+			{
+				RequestID: "2",
+				Request: &AddRequest{
+					DN: "cn=Alice,dc=example,dc=com",
+					Attributes: map[string][][]byte{
+						"objectClass": {[]byte("top"), []byte("person")},
+						"cn":          {[]byte("Alice")},
+						"jpegPhoto":   {{0xff, 0xd8, 0xff, 0xe0, 0, 0x10}},
+					},
+					order: []string{"objectClass", "cn", "jpegPhoto"},
+				},
+			},
+			{
+				RequestID: "3",
+				Request: &ModifyRequest{
+					DN: "cn=Alice,dc=example,dc=com",
+					Mods: []*Mod{
+						{Type: Replace, Name: "mail", Values: [][]byte{[]byte("alice@example.com")}},
+						{Type: Delete, Name: "description"},
+						{Type: Add, Name: "seeAlso", Values: [][]byte{[]byte("cn=Bob,dc=example,dc=com")}},
+					},
+				},
+			},
+			{RequestID: "4", Request: &DeleteRequest{DN: "cn=Bob,dc=example,dc=com"}},
+			{RequestID: "5", Request: &ModifyDNRequest{DN: "cn=Alice,dc=example,dc=com", NewRDN: "cn=Alicia", DeleteOldRDN: true}},
+			{RequestID: "6", Request: &ModifyDNRequest{DN: "cn=Alicia,dc=example,dc=com", NewRDN: "cn=Alicia", NewSuperior: "ou=People,dc=example,dc=com"}},
+			{RequestID: "7", Request: &CompareRequest{DN: "cn=Alicia,ou=People,dc=example,dc=com", Attribute: "mail", Value: []byte("alice@example.com")}},
+			{RequestID: "8", Request: &ExtendedRequest{Name: OIDWhoAmI}},
+		},
+	}
+	if len(b.Requests) != len(want.Requests) {
+		t.Fatalf("Decoded %d requests, want %d", len(b.Requests), len(want.Requests))
+	}
+	for i, r := range b.Requests {
+		if !reflect.DeepEqual(r, want.Requests[i]) {
+			t.Errorf("Request %d = %+v, want %+v", i, r.Request, want.Requests[i].Request)
+		}
+	}
+	b.Requests, want.Requests = nil, nil
+	if !reflect.DeepEqual(&b, want) {
+		t.Errorf("Batch = %+v, want %+v", b, want)
+	}
+
+	// Encoding and decoding again must give the same batch.
+	b2 := &DSMLBatchRequest{}
+	if err := xml.Unmarshal([]byte(testDSMLBatchRequest), b2); err != nil {
+		t.Fatal(err)
+	}
+	out, err := xml.Marshal(b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b3 DSMLBatchRequest
+	if err := xml.Unmarshal(out, &b3); err != nil {
+		t.Fatalf("Failed to decode %s: %s", out, err)
+	}
+	if !reflect.DeepEqual(&b3, b2) {
+		t.Errorf("Round trip of\n%s\ngave %+v", out, b3)
+	}
+}
+
+func TestDSMLBatchRequestEncode(t *testing.T) {
+	t.Parallel()
+	b := &DSMLBatchRequest{
+		Requests: []*DSMLRequest{
+			{Request: &SearchRequest{BaseDN: "dc=example", Scope: ScopeBaseObject}},
+			{Request: &AddRequest{
+				DN: "cn=a",
+				Attributes: map[string][][]byte{
+					"cn":          {[]byte("a\x00b"), []byte("line\r\n")},
+					"description": {[]byte("ünïcödé & <xml>")},
+				},
+			}},
+			{Request: &ExtendedRequest{Name: OIDPasswordModify, Value: []byte{0x30, 0}}},
+		},
+	}
+	out, err := xml.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<batchRequest xmlns="urn:oasis:names:tc:DSML:2:0:core" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<searchRequest dn="dc=example" scope="baseObject" derefAliases="neverDerefAliases"><filter><present name="objectClass"></present></filter></searchRequest>` +
+		`<addRequest dn="cn=a">` +
+		`<attr name="cn"><value xsi:type="xsd:base64Binary">YQBi</value><value xsi:type="xsd:base64Binary">bGluZQ0K</value></attr>` +
+		`<attr name="description"><value>ünïcödé &amp; &lt;xml&gt;</value></attr>` +
+		`</addRequest>` +
+		`<extendedRequest><requestName>1.3.6.1.4.1.4203.1.11.1</requestName><requestValue>MAA=</requestValue></extendedRequest>` +
+		`</batchRequest>`
+	if string(out) != want {
+		t.Errorf("Encoded\n%s\nwant\n%s", out, want)
+	}
+
+	for _, req := range []Request{
+		&SearchRequest{Scope: ScopeChildren},
+		&BindRequest{},
+		&ModifyRequest{Mods: []*Mod{{Type: ModType(9), Name: "cn"}}},
+	} {
+		if _, err := xml.Marshal(&DSMLBatchRequest{Requests: []*DSMLRequest{{Request: req}}}); err == nil {
+			t.Errorf("Expected error encoding %T", req)
+		}
+	}
+}
+
+func TestDSMLBatchResponse(t *testing.T) {
+	t.Parallel()
+	b := &DSMLBatchResponse{
+		RequestID: "batch",
+		Responses: []*DSMLResponse{
+			{
+				RequestID: "1",
+				Response: &SearchResponse{
+					Results: []*SearchResult{
+						{
+							DN: "cn=Alice,dc=example,dc=com",
+							Attributes: map[string][][]byte{
+								"cn":        {[]byte("Alice")},
+								"jpegPhoto": {{0xff, 0xd8}},
+								"mail":      nil,
+							},
+							order: []string{"mail", "cn", "jpegPhoto"},
+						},
+					},
+				},
+				Controls: []*Control{{Type: "1.2.840.113556.1.4.319", Value: []byte{0x30, 0}}},
+			},
+			{RequestID: "2", Response: &AddResponse{BaseResponse: BaseResponse{Code: ResultEntryAlreadyExists, MatchedDN: "dc=example,dc=com", Message: "exists"}}},
+			{RequestID: "3", Response: &ModifyResponse{}},
+			{RequestID: "4", Response: &DeleteResponse{BaseResponse: BaseResponse{Code: ResultNoSuchObject}}},
+			{RequestID: "5", Response: &ModifyDNResponse{}},
+			{RequestID: "6", Response: &CompareResponse{BaseResponse: BaseResponse{Code: ResultCompareTrue}}},
+			{RequestID: "7", Response: &ExtendedResponse{Name: OIDWhoAmI, Value: []byte("dn:cn=Alice")}},
+			{RequestID: "8", Error: &DSMLError{Type: "malformedRequest", Message: "bad"}},
+		},
+	}
+	out, err := xml.MarshalIndent(b, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<searchResponse requestID="1">`,
+		`<attr name="mail"></attr>`,
+		`<resultCode code="68" descr="entryAlreadyExists"></resultCode>`,
+		`<resultCode code="6" descr="compareTrue"></resultCode>`,
+		`<addResponse requestID="2" matchedDN="dc=example,dc=com">`,
+		`<errorResponse requestID="8" type="malformedRequest">`,
+	} {
+		if !strings.Contains(string(out), s) {
+			t.Errorf("Encoded response does not contain %s:\n%s", s, out)
+		}
+	}
+	var b2 DSMLBatchResponse
+	if err := xml.Unmarshal(out, &b2); err != nil {
+		t.Fatal(err)
+	}
+	if len(b2.Responses) != len(b.Responses) {
+		t.Fatalf("Decoded %d responses, want %d", len(b2.Responses), len(b.Responses))
+	}
+	for i, r := range b2.Responses {
+		if !reflect.DeepEqual(r, b.Responses[i]) {
+			t.Errorf("Response %d = %+v, want %+v", i, r.Response, b.Responses[i].Response)
+		}
+	}
+	if b2.RequestID != b.RequestID {
+		t.Errorf("RequestID = %q, want %q", b2.RequestID, b.RequestID)
+	}
+}
+
+func TestDSMLDecodeErrors(t *testing.T) {
+	t.Parallel()
+	const prefix = `<batchRequest xmlns="urn:oasis:names:tc:DSML:2:0:core">`
+	cases := []string{
+		`<batchResponse/>`,
+		prefix + `<authRequest principal="cn=a"/></batchRequest>`,
+		prefix + `<searchRequest dn="" scope="children" derefAliases="neverDerefAliases"><filter><present name="cn"/></filter></searchRequest></batchRequest>`,
+		prefix + `<searchRequest dn="" scope="baseObject" derefAliases="sometimes"><filter><present name="cn"/></filter></searchRequest></batchRequest>`,
+		prefix + `<searchRequest dn="" scope="baseObject" derefAliases="neverDerefAliases"/></batchRequest>`,
+		prefix + `<searchRequest dn="" scope="baseObject" derefAliases="neverDerefAliases"><filter><present name="cn"/><present name="sn"/></filter></searchRequest></batchRequest>`,
+		prefix + `<searchRequest dn="" scope="baseObject" derefAliases="neverDerefAliases"><filter><not/></filter></searchRequest></batchRequest>`,
+		prefix + `<searchRequest dn="" scope="baseObject" derefAliases="neverDerefAliases"><filter><present/></filter></searchRequest></batchRequest>`,
+		prefix + `<searchRequest dn="" scope="baseObject" derefAliases="neverDerefAliases"><filter><like name="cn"/></filter></searchRequest></batchRequest>`,
+		prefix + `<searchRequest dn="" scope="baseObject" derefAliases="neverDerefAliases"><filter><extensibleMatch><value>x</value></extensibleMatch></filter></searchRequest></batchRequest>`,
+		prefix + `<addRequest dn="cn=a"><attr name="cn"><value xsi:type="xsd:base64Binary">!!</value></attr></addRequest></batchRequest>`,
+		prefix + `<addRequest dn="cn=a"><attr name="cn"><value xsi:type="xsd:anyURI">file:///etc/passwd</value></attr></addRequest></batchRequest>`,
+		prefix + `<modifyRequest dn="cn=a"><modification name="cn" operation="merge"/></modifyRequest></batchRequest>`,
+		prefix + `<modDNRequest dn="cn=a" newrdn="cn=b" deleteoldrdn="maybe"/></batchRequest>`,
+		prefix + `<delRequest dn="cn=a"><control type="paged"/></delRequest></batchRequest>`,
+		prefix + `<delRequest dn="cn=a">`,
+	}
+	for _, c := range cases {
+		var b DSMLBatchRequest
+		if err := xml.Unmarshal([]byte(c), &b); err == nil {
+			t.Errorf("Expected error for %s", c)
+		}
+	}
+}
@@ -0,0 +1,663 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy controls when the write-ahead log of a DurableBackend is
+// flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log before an update completes so no
+	// acknowledged update is lost on a crash.
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodically flushes the log every SyncInterval. Updates
+	// acknowledged since the last flush may be lost on a crash.
+	SyncPeriodically
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// SnapshotFormat is the file format of the snapshots of a DurableBackend.
+type SnapshotFormat int
+
+const (
+	// SnapshotLDIF writes snapshots as LDIF content records.
+	SnapshotLDIF SnapshotFormat = iota
+	// SnapshotBinary writes snapshots as checksummed BER encoded add
+	// requests which is faster to load than LDIF.
+	SnapshotBinary
+)
+
+// DurableOptions are the options of a DurableBackend.
+type DurableOptions struct {
+	Sync SyncPolicy
+	// SyncInterval is the interval for SyncPeriodically. It defaults to
+	// one second.
+	SyncInterval time.Duration
+	// SnapshotEvery is the number of updates after which a snapshot is
+	// taken in the background. If 0 snapshots are only taken by calling
+	// Snapshot.
+	SnapshotEvery int
+	// SnapshotFormat is the format of new snapshots. Snapshots of either
+	// format are recovered.
+	SnapshotFormat SnapshotFormat
+}
+
+const (
+	walFileName        = "wal.log"
+	snapshotPrefix     = "snapshot-"
+	snapshotTmpPattern = "snapshot-*.tmp"
+	snapshotBinaryExt  = ".bin"
+	snapshotLDIFExt    = ".ldif"
+	snapshotMagic      = "LDAPSNP1"
+	walHeaderSize      = 8
+	maxWALRecordLength = 64 << 20
+)
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrBackendClosed is returned for updates to a DurableBackend after it
+// has been closed.
+var ErrBackendClosed = errors.New("ldap: backend closed")
+
+// DurableBackend is a MemoryBackend that persists the directory to files in
+// a directory.
+//
+// Every update is appended to a write-ahead log before it's applied. Each
+// record of the log holds the update as an LDAP message whose ID is the
+// sequence number of the update, framed by its length and CRC-32C. A
+// snapshot of the directory replaces the log from time to time. On open the
+// newest snapshot is loaded and the records of the log that follow it are
+// replayed. An incomplete or corrupt record at the end of the log, as left
+// by a crash during a write, is truncated. A corrupt record followed by
+// others fails the open.
+type DurableBackend struct {
+	*MemoryBackend
+
+	dir  string
+	opts DurableOptions
+
+	// snapshotMu serializes snapshots. It's taken before the lock of the
+	// MemoryBackend.
+	snapshotMu sync.Mutex
+
+	// walMu guards the log. Updates also hold the write lock of the
+	// MemoryBackend which orders them and excludes snapshots.
+	walMu      sync.Mutex
+	wal        *os.File
+	w          io.Writer // writes to wal, replaced by tests
+	off        int64     // size of the log after the last complete record
+	seq        int64     // sequence number of the last update
+	pending    int       // records since the last snapshot
+	dirty      bool      // records since the last sync
+	closed     bool
+	snapshotCh chan struct{}
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// OpenDurableBackend opens the directory stored in dir, creating dir if it
+// doesn't exist. The suffixes are those of NewMemoryBackend.
+func OpenDurableBackend(dir string, opts *DurableOptions, suffixes ...string) (*DurableBackend, error) {
+	mem, err := NewMemoryBackend(suffixes...)
+	if err != nil {
+		return nil, err
+	}
+	d := &DurableBackend{
+		MemoryBackend: mem,
+		dir:           dir,
+		snapshotCh:    make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.SyncInterval <= 0 {
+		d.opts.SyncInterval = time.Second
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := d.recover(); err != nil {
+		if d.wal != nil {
+			d.wal.Close()
+		}
+		return nil, err
+	}
+	mem.journal = d.append
+	d.wg.Add(1)
+	go d.run()
+	return d, nil
+}
+
+// Close flushes and closes the log. Updates fail after Close.
+func (d *DurableBackend) Close() error {
+	d.walMu.Lock()
+	if d.closed {
+		d.walMu.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.walMu.Unlock()
+	close(d.done)
+	d.wg.Wait()
+
+	d.walMu.Lock()
+	defer d.walMu.Unlock()
+	err := d.wal.Sync()
+	if err2 := d.wal.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Snapshot writes the directory to a new snapshot and empties the log.
+// Updates wait until it completes.
+func (d *DurableBackend) Snapshot() error {
+	d.snapshotMu.Lock()
+	defer d.snapshotMu.Unlock()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.snapshot()
+}
+
+// Backup writes a consistent copy of the directory as LDIF.
+func (d *DurableBackend) Backup(w io.Writer) error {
+	return d.WriteLDIF(w)
+}
+
+// Restore replaces the directory with the entries of an LDIF file such as
+// one written by Backup. The directory is left unchanged if the file can't
+// be loaded.
+func (d *DurableBackend) Restore(r io.Reader) error {
+	mem := &MemoryBackend{
+		Schema:   d.Schema,
+		suffixes: d.suffixes,
+		entries:  make(map[string]*memoryEntry),
+		byID:     make(map[uint64]*memoryEntry),
+	}
+	if err := mem.LoadLDIF(r); err != nil {
+		return err
+	}
+	d.snapshotMu.Lock()
+	defer d.snapshotMu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.walMu.Lock()
+	closed := d.closed
+	d.walMu.Unlock()
+	if closed {
+		return ErrBackendClosed
+	}
+	if d.indexes != nil {
+		x, err := mem.buildIndex(d.indexes)
+		if err != nil {
+			return err
+		}
+		mem.index = x
+	}
+	// Skip a sequence number so the restored snapshot supersedes any
+	// earlier state even if the log isn't emptied.
+	seq := d.seq + 1
+	name, err := d.writeSnapshot(mem, seq)
+	if err != nil {
+		return err
+	}
+	if d.indexes != nil {
+		d.index = mem.index
+	}
+	d.entries = mem.entries
+	d.roots = mem.roots
+	d.byID = mem.byID
+	d.nextID = mem.nextID
+	d.seq = seq
+	return d.compact(name)
+}
+
+func (d *DurableBackend) run() {
+	defer d.wg.Done()
+	var tick <-chan time.Time
+	if d.opts.Sync == SyncPeriodically {
+		t := time.NewTicker(d.opts.SyncInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-tick:
+			d.walMu.Lock()
+			if d.dirty && !d.closed {
+				if err := d.wal.Sync(); err != nil {
+					log.Printf("ldap: failed to sync log: %s", err)
+				}
+				d.dirty = false
+			}
+			d.walMu.Unlock()
+		case <-d.snapshotCh:
+			if err := d.Snapshot(); err != nil {
+				log.Printf("ldap: failed to take snapshot: %s", err)
+			}
+		}
+	}
+}
+
+// append writes an update to the log. It's called with the write lock of
+// the MemoryBackend held.
+func (d *DurableBackend) append(req Request) error {
+	d.walMu.Lock()
+	defer d.walMu.Unlock()
+	if d.closed {
+		return ErrBackendClosed
+	}
+	b, err := encodeWALRecord(req, d.seq+1)
+	if err != nil {
+		return err
+	}
+	if _, err := d.w.Write(b); err != nil {
+		// Remove what was written of the record so later records aren't
+		// lost behind it on recovery.
+		if err2 := d.resetWAL(d.off); err2 != nil {
+			d.closed = true
+			return fmt.Errorf("ldap: failed to write log: %w (and to truncate it: %s)", err, err2)
+		}
+		return fmt.Errorf("ldap: failed to write log: %w", err)
+	}
+	if d.opts.Sync == SyncAlways {
+		if err := d.wal.Sync(); err != nil {
+			// The record may or may not be stable so the state on
+			// recovery is unknown.
+			d.closed = true
+			return fmt.Errorf("ldap: failed to sync log: %w", err)
+		}
+	} else {
+		d.dirty = true
+	}
+	d.off += int64(len(b))
+	d.seq++
+	d.pending++
+	if d.opts.SnapshotEvery > 0 && d.pending >= d.opts.SnapshotEvery {
+		select {
+		case d.snapshotCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// resetWAL truncates the log to size and moves the write offset there.
+func (d *DurableBackend) resetWAL(size int64) error {
+	if err := d.wal.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := d.wal.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	d.off = size
+	return nil
+}
+
+// snapshot writes a snapshot of the directory and empties the log. It must
+// be called with snapshotMu held and the MemoryBackend locked against
+// updates.
+func (d *DurableBackend) snapshot() error {
+	name, err := d.writeSnapshot(d.MemoryBackend, d.seq)
+	if err != nil {
+		return err
+	}
+	return d.compact(name)
+}
+
+// writeSnapshot writes the entries of mem as the snapshot at sequence seq
+// and returns its name.
+func (d *DurableBackend) writeSnapshot(mem *MemoryBackend, seq int64) (string, error) {
+	ext := snapshotLDIFExt
+	if d.opts.SnapshotFormat == SnapshotBinary {
+		ext = snapshotBinaryExt
+	}
+	f, err := os.CreateTemp(d.dir, snapshotTmpPattern)
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	w := bufio.NewWriter(f)
+	if ext == snapshotBinaryExt {
+		err = writeBinarySnapshot(w, mem)
+	} else {
+		err = writeLDIFSnapshot(w, mem, seq)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s%016x%s", snapshotPrefix, seq, ext)
+	if err := os.Rename(tmp, filepath.Join(d.dir, name)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// compact empties the log and removes the snapshots other than name which
+// includes all records of the log, and any temporary files left by failed
+// snapshots.
+func (d *DurableBackend) compact(name string) error {
+	if err := syncDir(d.dir); err != nil {
+		return err
+	}
+
+	// Until the log is emptied recovery skips its records by their
+	// sequence numbers.
+	d.walMu.Lock()
+	defer d.walMu.Unlock()
+	if d.closed {
+		return ErrBackendClosed
+	}
+	if err := d.resetWAL(0); err != nil {
+		return err
+	}
+	if err := d.wal.Sync(); err != nil {
+		return err
+	}
+	d.pending = 0
+	d.dirty = false
+
+	snapshots, err := d.snapshots()
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if s != name {
+			if err := os.Remove(filepath.Join(d.dir, s)); err != nil {
+				return err
+			}
+		}
+	}
+	tmps, err := filepath.Glob(filepath.Join(d.dir, snapshotTmpPattern))
+	if err != nil {
+		return err
+	}
+	for _, tmp := range tmps {
+		if err := os.Remove(tmp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLDIFSnapshot writes the entries of mem as LDIF without taking its
+// lock which is already held.
+func writeLDIFSnapshot(w io.Writer, mem *MemoryBackend, seq int64) error {
+	lw := NewLDIFWriter(w)
+	if err := lw.WriteComment(fmt.Sprintf("sequence %d", seq)); err != nil {
+		return err
+	}
+	var err error
+	for _, me := range mem.roots {
+		me.walk(func(me *memoryEntry) bool {
+			err = lw.WriteEntry(me.entry.SearchResult())
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBinarySnapshot(w io.Writer, mem *MemoryBackend) error {
+	if _, err := io.WriteString(w, snapshotMagic); err != nil {
+		return err
+	}
+	var err error
+	for _, me := range mem.roots {
+		me.walk(func(me *memoryEntry) bool {
+			var b []byte
+			if b, err = encodeWALRecord(me.entry.AddRequest(), 0); err == nil {
+				_, err = w.Write(b)
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeWALRecord returns the request as an LDAP message with the sequence
+// number as the message ID, framed by its length and CRC-32C.
+func encodeWALRecord(req Request, seq int64) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, walHeaderSize))
+	if err := req.WritePackets(&buf, int(seq)); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(b)-walHeaderSize))
+	binary.BigEndian.PutUint32(b[4:8], crc32.Checksum(b[walHeaderSize:], walTable))
+	return b, nil
+}
+
+// readWALRecord reads a record written by encodeWALRecord. It returns
+// io.EOF at the end of the input and io.ErrUnexpectedEOF or a
+// *ProtocolError for an incomplete or corrupt record.
+func readWALRecord(r io.Reader) (int64, Request, int, error) {
+	var hdr [walHeaderSize]byte
+	if n, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, n, io.EOF
+		}
+		return 0, nil, n, io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(hdr[0:4])
+	if length > maxWALRecordLength {
+		return 0, nil, walHeaderSize, &ProtocolError{Reason: "log record too long"}
+	}
+	b := make([]byte, length)
+	if n, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, walHeaderSize + n, io.ErrUnexpectedEOF
+	}
+	n := walHeaderSize + int(length)
+	if crc32.Checksum(b, walTable) != binary.BigEndian.Uint32(hdr[4:8]) {
+		return 0, nil, n, &ProtocolError{Reason: "log record checksum mismatch"}
+	}
+	pkt, _, err := ParsePacket(b)
+	if err != nil {
+		return 0, nil, n, err
+	}
+	if len(pkt.Items) < 2 {
+		return 0, nil, n, &ProtocolError{Reason: "invalid log record"}
+	}
+	seq, ok := pkt.Items[0].Int()
+	if !ok {
+		return 0, nil, n, &ProtocolError{Reason: "invalid log record sequence"}
+	}
+	var req Request
+	switch p := pkt.Items[1]; p.Tag {
+	case ApplicationAddRequest:
+		req, err = parseAddRequest(p)
+	case ApplicationDelRequest:
+		req, err = parseDeleteRequest(p)
+	case ApplicationModifyRequest:
+		req, err = parseModifyRequest(p)
+	case ApplicationModifyDNRequest:
+		req, err = parseModifyDNRequest(p)
+	default:
+		err = &ProtocolError{Reason: fmt.Sprintf("unexpected request tag %d in log", p.Tag)}
+	}
+	if err != nil {
+		return 0, nil, n, err
+	}
+	return int64(seq), req, n, nil
+}
+
+// snapshots returns the names of the snapshot files ordered by sequence.
+func (d *DurableBackend) snapshots() ([]string, error) {
+	des, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, de := range des {
+		if _, ok := snapshotSeq(de.Name()); ok {
+			names = append(names, de.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := snapshotSeq(names[i])
+		b, _ := snapshotSeq(names[j])
+		return a < b
+	})
+	return names, nil
+}
+
+func snapshotSeq(name string) (int64, bool) {
+	if !strings.HasPrefix(name, snapshotPrefix) {
+		return 0, false
+	}
+	s := strings.TrimPrefix(name, snapshotPrefix)
+	s = strings.TrimSuffix(s, filepath.Ext(s))
+	if ext := filepath.Ext(name); ext != snapshotLDIFExt && ext != snapshotBinaryExt {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(s, 16, 64)
+	return seq, err == nil
+}
+
+// recover loads the newest snapshot and replays the log.
+func (d *DurableBackend) recover() error {
+	snapshots, err := d.snapshots()
+	if err != nil {
+		return err
+	}
+	if len(snapshots) != 0 {
+		name := snapshots[len(snapshots)-1]
+		d.seq, _ = snapshotSeq(name)
+		if err := d.loadSnapshot(filepath.Join(d.dir, name)); err != nil {
+			return fmt.Errorf("ldap: failed to load snapshot %s: %w", name, err)
+		}
+	}
+
+	d.wal, err = os.OpenFile(filepath.Join(d.dir, walFileName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	d.w = d.wal
+	r := bufio.NewReader(d.wal)
+	var off int64
+	for {
+		seq, req, n, err := readWALRecord(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// A crash during a write leaves a partial record, possibly
+			// followed by zeros, at the end of the log. Anything else
+			// after the bad record means the log is corrupt.
+			torn, err2 := zeroTail(r)
+			if err2 != nil {
+				return err2
+			}
+			if !torn {
+				return fmt.Errorf("ldap: log %s is corrupt at offset %d: %w", d.wal.Name(), off, err)
+			}
+			log.Printf("ldap: truncating log %s at offset %d: %s", d.wal.Name(), off, err)
+			break
+		}
+		if seq > d.seq {
+			if seq != d.seq+1 {
+				return fmt.Errorf("ldap: log record at offset %d has sequence %d, expected %d", off, seq, d.seq+1)
+			}
+			if err := d.apply(req); err != nil {
+				return fmt.Errorf("ldap: failed to replay log record %d: %w", seq, err)
+			}
+			d.seq = seq
+			d.pending++
+		}
+		off += int64(n)
+	}
+	if err := d.resetWAL(off); err != nil {
+		return err
+	}
+	return d.wal.Sync()
+}
+
+// zeroTail reports whether the rest of r holds only zero bytes.
+func zeroTail(r io.Reader) (bool, error) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		for _, c := range buf[:n] {
+			if c != 0 {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+func (d *DurableBackend) loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if filepath.Ext(path) == snapshotLDIFExt {
+		return d.LoadLDIF(r)
+	}
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		return &ProtocolError{Reason: "invalid snapshot header"}
+	}
+	for {
+		_, req, _, err := readWALRecord(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := d.apply(req); err != nil {
+			return err
+		}
+	}
+}
+
+// syncDir flushes the directory so renames and new files in it are stable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,502 @@
+package ldap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDurableUpdates are applied in order after loading testMemoryLDIF.
+var testDurableUpdates = []Request{
+	&AddRequest{DN: "uid=bjones,ou=People,dc=example,dc=com", Attributes: map[string][][]byte{
+		"objectClass": {[]byte("top"), []byte("person")},
+		"uid":         {[]byte("bjones")},
+		"cn":          {[]byte("Bob Jones")},
+		"sn":          {[]byte("Jones")},
+	}},
+	&ModifyRequest{DN: "uid=jdoe,ou=People,dc=example,dc=com", Mods: []*Mod{
+		{Type: Add, Name: "mail", Values: [][]byte{[]byte("jdoe@example.com")}},
+		{Type: Increment, Name: "employeeNumber", Values: [][]byte{[]byte("2")}},
+	}},
+	&ModifyDNRequest{DN: "uid=asmith,ou=People,dc=example,dc=com", NewRDN: "uid=alice", DeleteOldRDN: true, NewSuperior: "ou=Groups,dc=example,dc=com"},
+	&DeleteRequest{DN: "uid=bjones,ou=People,dc=example,dc=com"},
+	&ModifyRequest{DN: "ou=Groups,dc=example,dc=com", Mods: []*Mod{
+		{Type: Replace, Name: "description", Values: [][]byte{[]byte("binary \x00\xff value")}},
+	}},
+}
+
+func backupString(t *testing.T, d *DurableBackend) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := d.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func openTestDurableBackend(t *testing.T, dir string, opts *DurableOptions) *DurableBackend {
+	t.Helper()
+	d, err := OpenDurableBackend(dir, opts, "dc=example,dc=com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// newTestDurableLog returns a closed backend directory with the test data
+// in the log and the state of the directory after each record.
+func newTestDurableLog(t *testing.T) (string, []string) {
+	t.Helper()
+	dir := t.TempDir()
+	d := openTestDurableBackend(t, dir, &DurableOptions{Sync: SyncNever})
+	states := []string{backupString(t, d)}
+	lr := NewLDIFReader(strings.NewReader(testMemoryLDIF))
+	for {
+		rec, err := lr.ReadRecord()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.apply(rec.Request); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, backupString(t, d))
+	}
+	for _, req := range testDurableUpdates {
+		if err := d.apply(req); err != nil {
+			t.Fatalf("%+v: %s", req, err)
+		}
+		states = append(states, backupString(t, d))
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir, states
+}
+
+// walOffsets returns the offsets of the end of each record in the log.
+func walOffsets(t *testing.T, b []byte) []int64 {
+	t.Helper()
+	r := bytes.NewReader(b)
+	var offsets []int64
+	var off int64
+	for {
+		_, _, n, err := readWALRecord(r)
+		if err == io.EOF {
+			return offsets
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		off += int64(n)
+		offsets = append(offsets, off)
+	}
+}
+
+func copyDir(t *testing.T, src, dst string) {
+	t.Helper()
+	des, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, de := range des {
+		b, err := os.ReadFile(filepath.Join(src, de.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, de.Name()), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDurableBackendRecovery(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		name string
+		opts DurableOptions
+	}{
+		{"always", DurableOptions{Sync: SyncAlways}},
+		{"periodically", DurableOptions{Sync: SyncPeriodically, SyncInterval: time.Millisecond}},
+		{"binary", DurableOptions{Sync: SyncNever, SnapshotFormat: SnapshotBinary}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			d := openTestDurableBackend(t, dir, &tc.opts)
+			d.RootDN = "cn=admin,dc=example,dc=com"
+			d.RootPassword = []byte("admin")
+			if err := d.LoadLDIF(strings.NewReader(testMemoryLDIF)); err != nil {
+				t.Fatal(err)
+			}
+			for _, req := range testDurableUpdates[:2] {
+				if err := d.apply(req); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := d.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+			for _, req := range testDurableUpdates[2:] {
+				if err := d.apply(req); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Password changes are logged as the hashed value.
+			_, c := newTestServer(t, d)
+			if err := c.Bind("cn=admin,dc=example,dc=com", []byte("admin")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.PasswordModify(&PasswordModifyRequest{
+				UserIdentity: "uid=jdoe,ou=People,dc=example,dc=com",
+				NewPassword:  []byte("changed"),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			want := backupString(t, d)
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.apply(testDurableUpdates[0]); resultCode(err) != ResultUnavailable {
+				t.Errorf("expected Unavailable after close got %v", err)
+			}
+
+			d = openTestDurableBackend(t, dir, &tc.opts)
+			if got := backupString(t, d); got != want {
+				t.Errorf("state differs after recovery:\n%s\nexpected:\n%s", got, want)
+			}
+			if res, err := d.Bind(context.Background(), nil, &BindRequest{DN: "uid=jdoe,ou=People,dc=example,dc=com", Password: []byte("changed")}); err != nil || res.Code != ResultSuccess {
+				t.Errorf("bind with changed password failed after recovery: %v %v", res, err)
+			}
+		})
+	}
+}
+
+func TestDurableBackendTornWrite(t *testing.T) {
+	t.Parallel()
+	src, states := newTestDurableLog(t)
+	wal, err := os.ReadFile(filepath.Join(src, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := append([]int64{0}, walOffsets(t, wal)...)
+	if len(offsets) != len(states) {
+		t.Fatalf("expected %d records got %d", len(states)-1, len(offsets)-1)
+	}
+
+	check := func(t *testing.T, b []byte, records int) {
+		t.Helper()
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, walFileName), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		d := openTestDurableBackend(t, dir, &DurableOptions{Sync: SyncNever})
+		if got := backupString(t, d); got != states[records] {
+			t.Fatalf("expected the state after %d records got:\n%s", records, got)
+		}
+		fi, err := os.Stat(filepath.Join(dir, walFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != offsets[records] {
+			t.Fatalf("expected log truncated to %d got %d", offsets[records], fi.Size())
+		}
+		if records == 0 {
+			return
+		}
+		// Appends after recovery must follow the last complete record.
+		if err := d.apply(&ModifyRequest{DN: "dc=example,dc=com", Mods: []*Mod{
+			{Type: Replace, Name: "description", Values: [][]byte{[]byte("appended")}},
+		}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		d = openTestDurableBackend(t, dir, &DurableOptions{Sync: SyncNever})
+		if e := d.Entry("dc=example,dc=com"); e.Get("description") != "appended" {
+			t.Fatalf("update after recovery from %d records lost", records)
+		}
+	}
+
+	records := 0
+	for cut := int64(0); cut <= int64(len(wal)); cut++ {
+		for records < len(offsets)-1 && offsets[records+1] <= cut {
+			records++
+		}
+		check(t, wal[:cut], records)
+	}
+	for i := 1; i < len(offsets); i++ {
+		// Corrupt the last byte of a record which fails its checksum. Only
+		// the last record can be torn so corruption before it fails the
+		// open.
+		b := append([]byte(nil), wal...)
+		b[offsets[i]-1] ^= 0xff
+		if i == len(offsets)-1 {
+			check(t, b, i-1)
+			continue
+		}
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, walFileName), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if d, err := OpenDurableBackend(dir, nil, "dc=example,dc=com"); err == nil {
+			d.Close()
+			t.Errorf("expected open to fail with record %d corrupt", i)
+		}
+	}
+	// A zeroed tail as left by some file systems after a crash.
+	check(t, append(append([]byte(nil), wal...), make([]byte, 100)...), len(states)-1)
+}
+
+// crashWriter writes the first n bytes it's given and then copies the
+// directory to image, as if the machine stopped at that point, before
+// failing.
+type crashWriter struct {
+	w     io.Writer
+	n     int
+	src   string
+	image string
+	t     *testing.T
+}
+
+func (w *crashWriter) Write(b []byte) (int, error) {
+	if len(b) <= w.n {
+		w.n -= len(b)
+		return w.w.Write(b)
+	}
+	n, err := w.w.Write(b[:w.n])
+	if err != nil {
+		return n, err
+	}
+	copyDir(w.t, w.src, w.image)
+	return n, errors.New("injected crash")
+}
+
+func TestDurableBackendCrashInjection(t *testing.T) {
+	t.Parallel()
+	update := &ModifyRequest{DN: "uid=jdoe,ou=People,dc=example,dc=com", Mods: []*Mod{
+		{Type: Replace, Name: "description", Values: [][]byte{[]byte("a value long enough to span a few writes")}},
+	}}
+	size := func() int {
+		b, err := encodeWALRecord(update, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(b)
+	}()
+
+	for _, n := range []int{0, 1, walHeaderSize - 1, walHeaderSize, walHeaderSize + 1, size / 2, size - 1} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			d := openTestDurableBackend(t, dir, nil)
+			if err := d.LoadLDIF(strings.NewReader(testMemoryLDIF)); err != nil {
+				t.Fatal(err)
+			}
+			before := backupString(t, d)
+			image := t.TempDir()
+			d.w = &crashWriter{w: d.wal, n: n, src: dir, image: image, t: t}
+
+			err := d.apply(update)
+			if e, ok := errorAsType[*BaseResponse](err); !ok || e.Code != ResultUnavailable {
+				t.Fatalf("expected Unavailable got %v", err)
+			}
+			if got := backupString(t, d); got != before {
+				t.Fatalf("failed update changed the directory:\n%s", got)
+			}
+
+			// Recovering the image of the crash drops the torn record.
+			crashed := openTestDurableBackend(t, image, nil)
+			if got := backupString(t, crashed); got != before {
+				t.Errorf("state differs after crash recovery:\n%s\nexpected:\n%s", got, before)
+			}
+			if err := crashed.apply(update); err != nil {
+				t.Fatal(err)
+			}
+
+			// Without a crash the torn record is removed and later updates
+			// are recovered.
+			d.w = d.wal
+			if err := d.apply(update); err != nil {
+				t.Fatal(err)
+			}
+			want := backupString(t, d)
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+			d = openTestDurableBackend(t, dir, nil)
+			if got := backupString(t, d); got != want {
+				t.Errorf("state differs after recovery:\n%s\nexpected:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDurableBackendSnapshot(t *testing.T) {
+	t.Parallel()
+	for _, format := range []SnapshotFormat{SnapshotLDIF, SnapshotBinary} {
+		dir := t.TempDir()
+		opts := &DurableOptions{Sync: SyncNever, SnapshotFormat: format}
+		d := openTestDurableBackend(t, dir, opts)
+		if err := d.LoadLDIF(strings.NewReader(testMemoryLDIF)); err != nil {
+			t.Fatal(err)
+		}
+		oldWAL, err := os.ReadFile(filepath.Join(dir, walFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := d.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		snapshots, err := d.snapshots()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) != 1 {
+			t.Errorf("expected one snapshot got %q", snapshots)
+		}
+		if fi, err := os.Stat(filepath.Join(dir, walFileName)); err != nil || fi.Size() != 0 {
+			t.Errorf("expected empty log after snapshot got %v, %v", fi.Size(), err)
+		}
+		if err := d.apply(testDurableUpdates[0]); err != nil {
+			t.Fatal(err)
+		}
+		want := backupString(t, d)
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// A crash between writing the snapshot and emptying the log leaves
+		// records the snapshot includes which must be skipped.
+		newWAL, err := os.ReadFile(filepath.Join(dir, walFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, walFileName), append(oldWAL, newWAL...), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		d = openTestDurableBackend(t, dir, opts)
+		if got := backupString(t, d); got != want {
+			t.Errorf("state differs after recovery:\n%s\nexpected:\n%s", got, want)
+		}
+	}
+
+	// Snapshots are taken in the background after SnapshotEvery updates.
+	dir := t.TempDir()
+	d := openTestDurableBackend(t, dir, &DurableOptions{Sync: SyncNever, SnapshotEvery: 3})
+	if err := d.LoadLDIF(strings.NewReader(testMemoryLDIF)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshots, err := d.snapshots()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) != 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot taken")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDurableBackendConcurrentSnapshots(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	opts := &DurableOptions{Sync: SyncNever, SnapshotEvery: 1}
+	d := openTestDurableBackend(t, dir, opts)
+	if err := d.LoadLDIF(strings.NewReader(testMemoryLDIF)); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8*20+len(testDurableUpdates))
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				errs <- d.Snapshot()
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, req := range testDurableUpdates {
+			errs <- d.apply(req)
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	want := backupString(t, d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tmps, err := filepath.Glob(filepath.Join(dir, snapshotTmpPattern)); err != nil || len(tmps) != 0 {
+		t.Errorf("expected no temporary snapshots got %q %v", tmps, err)
+	}
+	d = openTestDurableBackend(t, dir, opts)
+	if got := backupString(t, d); got != want {
+		t.Errorf("state differs after recovery:\n%s\nexpected:\n%s", got, want)
+	}
+}
+
+func TestDurableBackendBackupRestore(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	d := openTestDurableBackend(t, dir, nil)
+	if err := d.LoadLDIF(strings.NewReader(testMemoryLDIF)); err != nil {
+		t.Fatal(err)
+	}
+	backup := backupString(t, d)
+	for _, req := range testDurableUpdates {
+		if err := d.apply(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Restore(strings.NewReader("dn: dc=other\ndc: other\n")); err == nil {
+		t.Error("expected restore of an entry outside the suffix to fail")
+	}
+	if err := d.Restore(strings.NewReader(backup)); err != nil {
+		t.Fatal(err)
+	}
+	if got := backupString(t, d); got != backup {
+		t.Errorf("state differs after restore:\n%s\nexpected:\n%s", got, backup)
+	}
+	if err := d.apply(testDurableUpdates[0]); err != nil {
+		t.Fatal(err)
+	}
+	want := backupString(t, d)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d = openTestDurableBackend(t, dir, nil)
+	if got := backupString(t, d); got != want {
+		t.Errorf("state differs after recovery:\n%s\nexpected:\n%s", got, want)
+	}
+}
@@ -0,0 +1,274 @@
+package ldap
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoSuchAttribute is returned by the typed getters of Entry when the
+// entry doesn't have a value for the attribute.
+var ErrNoSuchAttribute = errors.New("ldap: no such attribute")
+
+// Attribute is an attribute of an entry with its values.
+type Attribute struct {
+	Name   string
+	Values [][]byte
+}
+
+// Entry is a directory entry. Unlike SearchResult the attributes are kept
+// in order and looked up case-insensitively.
+type Entry struct {
+	DN         string
+	Attributes []*Attribute
+}
+
+// NewEntry returns an entry with string attribute values. Attributes are
+// sorted by name since maps are unordered.
+func NewEntry(dn string, attrs map[string][]string) *Entry {
+	e := &Entry{DN: dn}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := make([][]byte, len(attrs[name]))
+		for i, v := range attrs[name] {
+			values[i] = []byte(v)
+		}
+		e.AddValues(name, values...)
+	}
+	return e
+}
+
+// Attribute returns the attribute with the given name (compared
+// case-insensitively including options) or nil if there is none.
+func (e *Entry) Attribute(name string) *Attribute {
+	for _, a := range e.Attributes {
+		if strings.EqualFold(a.Name, name) {
+			return a
+		}
+	}
+	return nil
+}
+
+// Has returns true if the entry has at least one value for the attribute.
+func (e *Entry) Has(name string) bool {
+	a := e.Attribute(name)
+	return a != nil && len(a.Values) != 0
+}
+
+// GetRaw returns the first value of the attribute or nil if there is none.
+func (e *Entry) GetRaw(name string) []byte {
+	if a := e.Attribute(name); a != nil && len(a.Values) != 0 {
+		return a.Values[0]
+	}
+	return nil
+}
+
+// GetAllRaw returns all values of the attribute.
+func (e *Entry) GetAllRaw(name string) [][]byte {
+	if a := e.Attribute(name); a != nil {
+		return a.Values
+	}
+	return nil
+}
+
+// Get returns the first value of the attribute or an empty string if there is none.
+func (e *Entry) Get(name string) string {
+	return string(e.GetRaw(name))
+}
+
+// GetAll returns all values of the attribute as strings.
+func (e *Entry) GetAll(name string) []string {
+	values := e.GetAllRaw(name)
+	if values == nil {
+		return nil
+	}
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = string(v)
+	}
+	return s
+}
+
+func (e *Entry) first(name string) ([]byte, error) {
+	if a := e.Attribute(name); a != nil && len(a.Values) != 0 {
+		return a.Values[0], nil
+	}
+	return nil, ErrNoSuchAttribute
+}
+
+// GetInt returns the first value of the attribute decoded using the INTEGER syntax.
+func (e *Entry) GetInt(name string) (int64, error) {
+	v, err := e.first(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := parseInteger(v)
+	if err != nil {
+		return 0, err
+	}
+	if !n.IsInt64() {
+		return 0, &strconv.NumError{Func: "GetInt", Num: string(v), Err: strconv.ErrRange}
+	}
+	return n.Int64(), nil
+}
+
+// GetBool returns the first value of the attribute decoded using the Boolean syntax.
+func (e *Entry) GetBool(name string) (bool, error) {
+	v, err := e.first(name)
+	if err != nil {
+		return false, err
+	}
+	return parseBoolean(v)
+}
+
+// GetTime returns the first value of the attribute decoded using the
+// Generalized Time syntax.
+func (e *Entry) GetTime(name string) (time.Time, error) {
+	v, err := e.first(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ParseGeneralizedTime(string(v))
+}
+
+// GetDN returns the first value of the attribute parsed as a distinguished name.
+func (e *Entry) GetDN(name string) (DN, error) {
+	v, err := e.first(name)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDN(string(v))
+}
+
+// SetValues replaces the values of an attribute. The attribute is added to
+// the end of the entry if it doesn't exist and removed if there are no values.
+func (e *Entry) SetValues(name string, values ...[]byte) {
+	if len(values) == 0 {
+		e.DeleteAttribute(name)
+		return
+	}
+	if a := e.Attribute(name); a != nil {
+		a.Values = values
+		return
+	}
+	e.Attributes = append(e.Attributes, &Attribute{Name: name, Values: values})
+}
+
+// AddValues appends values to an attribute. The attribute is added to the
+// end of the entry if it doesn't exist.
+func (e *Entry) AddValues(name string, values ...[]byte) {
+	if a := e.Attribute(name); a != nil {
+		a.Values = append(a.Values, values...)
+		return
+	}
+	e.Attributes = append(e.Attributes, &Attribute{Name: name, Values: values})
+}
+
+// DeleteAttribute removes an attribute from the entry.
+func (e *Entry) DeleteAttribute(name string) {
+	for i, a := range e.Attributes {
+		if strings.EqualFold(a.Name, name) {
+			e.Attributes = append(e.Attributes[:i], e.Attributes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Clone returns a deep copy of the entry.
+func (e *Entry) Clone() *Entry {
+	c := &Entry{DN: e.DN, Attributes: make([]*Attribute, len(e.Attributes))}
+	for i, a := range e.Attributes {
+		values := make([][]byte, len(a.Values))
+		for j, v := range a.Values {
+			values[j] = append([]byte(nil), v...)
+		}
+		c.Attributes[i] = &Attribute{Name: a.Name, Values: values}
+	}
+	return c
+}
+
+// EntryDN implements Matchable.
+func (e *Entry) EntryDN() string {
+	return e.DN
+}
+
+// EntryAttributes implements Matchable.
+func (e *Entry) EntryAttributes(fn func(name string, values [][]byte) bool) {
+	for _, a := range e.Attributes {
+		if !fn(a.Name, a.Values) {
+			return
+		}
+	}
+}
+
+// SearchResult returns the entry as a search result. The order of the
+// attributes is kept when the result is encoded.
+func (e *Entry) SearchResult() *SearchResult {
+	r := &SearchResult{DN: e.DN, Attributes: make(map[string][][]byte, len(e.Attributes))}
+	r.order = make([]string, 0, len(e.Attributes))
+	for _, a := range e.Attributes {
+		if _, ok := r.Attributes[a.Name]; !ok {
+			r.order = append(r.order, a.Name)
+		}
+		r.Attributes[a.Name] = append(r.Attributes[a.Name], a.Values...)
+	}
+	return r
+}
+
+// AddRequest returns a request to add the entry.
+func (e *Entry) AddRequest() *AddRequest {
+	r := e.SearchResult()
+	return &AddRequest{DN: r.DN, Attributes: r.Attributes, order: r.order}
+}
+
+// Entry returns the search result as an entry. Attributes are in the order
+// they were received from the server or otherwise sorted by name.
+func (r *SearchResult) Entry() *Entry {
+	return newEntryFromMap(r.DN, r.Attributes, r.order)
+}
+
+// Entry returns the entry to add. Attributes are in the order they were
+// received or otherwise sorted by name.
+func (r *AddRequest) Entry() *Entry {
+	return newEntryFromMap(r.DN, r.Attributes, r.order)
+}
+
+func newEntryFromMap(dn string, attrs map[string][][]byte, order []string) *Entry {
+	names := orderedNames(attrs, order)
+	e := &Entry{DN: dn, Attributes: make([]*Attribute, len(names))}
+	for i, name := range names {
+		e.Attributes[i] = &Attribute{Name: name, Values: attrs[name]}
+	}
+	return e
+}
+
+// orderedNames returns the names of the attributes in the given order
+// followed by any names not in the order sorted. Names in the order that are
+// no longer in the map are skipped so the order may be stale.
+func orderedNames(attrs map[string][][]byte, order []string) []string {
+	names := make([]string, 0, len(attrs))
+	seen := make(map[string]bool, len(attrs))
+	for _, name := range order {
+		if _, ok := attrs[name]; ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == len(attrs) {
+		return names
+	}
+	n := len(names)
+	for name := range attrs {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[n:])
+	return names
+}
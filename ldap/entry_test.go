@@ -0,0 +1,123 @@
+package ldap
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEntryGetters(t *testing.T) {
+	t.Parallel()
+	e := NewEntry("uid=jdoe,dc=example,dc=com", map[string][]string{
+		"cn":              {"John Doe", "Johnny"},
+		"uidNumber":       {"1000"},
+		"pwdLockout":      {"TRUE"},
+		"createTimestamp": {"20240102150405Z"},
+		"manager":         {"uid=boss,dc=example,dc=com"},
+		"bad":             {"x"},
+	})
+	if v := e.Get("CN"); v != "John Doe" {
+		t.Errorf("Get(CN) = %q", v)
+	}
+	if v := e.GetAll("cn"); !reflect.DeepEqual(v, []string{"John Doe", "Johnny"}) {
+		t.Errorf("GetAll(cn) = %q", v)
+	}
+	if v := e.Get("missing"); v != "" {
+		t.Errorf("Get(missing) = %q", v)
+	}
+	if v, err := e.GetInt("UIDNUMBER"); err != nil || v != 1000 {
+		t.Errorf("GetInt = %d, %v", v, err)
+	}
+	if _, err := e.GetInt("bad"); err == nil {
+		t.Error("GetInt(bad) should fail")
+	}
+	if _, err := e.GetInt("missing"); err != ErrNoSuchAttribute {
+		t.Errorf("GetInt(missing) error = %v", err)
+	}
+	if v, err := e.GetBool("pwdLockout"); err != nil || !v {
+		t.Errorf("GetBool = %t, %v", v, err)
+	}
+	if v, err := e.GetTime("createTimestamp"); err != nil || !v.Equal(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("GetTime = %s, %v", v, err)
+	}
+	if v, err := e.GetDN("manager"); err != nil || v.String() != "uid=boss,dc=example,dc=com" {
+		t.Errorf("GetDN = %s, %v", v, err)
+	}
+	e.AddValues("CN", []byte("J"))
+	e.SetValues("sn", []byte("Doe"))
+	e.SetValues("bad")
+	var names []string
+	for _, a := range e.Attributes {
+		names = append(names, a.Name)
+	}
+	want := []string{"cn", "createTimestamp", "manager", "pwdLockout", "uidNumber", "sn"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Attributes = %v, want %v", names, want)
+	}
+	if n := len(e.GetAll("cn")); n != 3 {
+		t.Errorf("Expected 3 values for cn, got %d", n)
+	}
+	if f, _ := ParseFilter("(&(sn=doe)(cn=j))"); MatchFilter(f, e, DefaultSchema) != FilterTrue {
+		t.Error("Filter didn't match entry")
+	}
+}
+
+func TestEntryOrder(t *testing.T) {
+	t.Parallel()
+	e := &Entry{DN: "cn=x", Attributes: []*Attribute{
+		{Name: "sn", Values: [][]byte{[]byte("a")}},
+		{Name: "cn", Values: [][]byte{[]byte("x")}},
+		{Name: "objectClass", Values: [][]byte{[]byte("person")}},
+	}}
+	var buf bytes.Buffer
+	if err := (&SearchResponse{Results: []*SearchResult{e.SearchResult()}}).WritePackets(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	pkt, _, err := ReadPacket(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := parseSearchResultResponse(pkt.Items[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Entry(); !reflect.DeepEqual(got, e) {
+		t.Errorf("Entry() = %+v, want %+v", got, e)
+	}
+	if got := e.AddRequest().Entry(); !reflect.DeepEqual(got, e) {
+		t.Errorf("AddRequest().Entry() = %+v, want %+v", got, e)
+	}
+
+	// Without a known order attributes are encoded sorted by name.
+	res := &SearchResponse{Results: []*SearchResult{{DN: "cn=x", Attributes: map[string][][]byte{
+		"sn": {[]byte("a")}, "cn": {[]byte("x")}, "objectClass": {[]byte("person")}, "mail": {[]byte("m")},
+	}}}}
+	var first []byte
+	for i := 0; i < 10; i++ {
+		buf.Reset()
+		if err := res.WritePackets(&buf, 1); err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = append([]byte(nil), buf.Bytes()...)
+		} else if !bytes.Equal(first, buf.Bytes()) {
+			t.Fatal("Encoding is not deterministic")
+		}
+	}
+	pkt, _, err = ReadPacket(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = parseSearchResultResponse(pkt.Items[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, a := range r.Entry().Attributes {
+		names = append(names, a.Name)
+	}
+	if want := []string{"cn", "mail", "objectClass", "sn"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Attributes = %v, want %v", names, want)
+	}
+}
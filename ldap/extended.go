@@ -27,6 +27,10 @@ func (r *ExtendedResponse) WritePackets(w io.Writer, msgID int) error {
 }
 
 func (r *ExtendedRequest) WritePackets(w io.Writer, msgID int) error {
+	return r.writePackets(w, msgID, nil)
+}
+
+func (r *ExtendedRequest) writePackets(w io.Writer, msgID int, controls []*Control) error {
 	pkt := NewPacket(ClassApplication, false, ApplicationExtendedRequest, nil)
 	if r.Name != "" {
 		pkt.AddItem(NewPacket(ClassContext, true, 0, r.Name))
@@ -36,7 +40,7 @@ func (r *ExtendedRequest) WritePackets(w io.Writer, msgID int) error {
 	}
 	req := NewRequestPacket(msgID)
 	req.AddItem(pkt)
-	return req.Write(w)
+	return writeRequest(w, req, controls)
 }
 
 func parseExtendedResponse(pkt *Packet) (*ExtendedResponse, error) {
@@ -47,6 +51,8 @@ func parseExtendedResponse(pkt *Packet) (*ExtendedResponse, error) {
 	var ok bool
 	for _, it := range pkt.Items[3:] {
 		switch it.Tag {
+		case 3:
+			// Referral parsed by parseBaseResponse
 		case 10:
 			res.Name, ok = it.Str()
 			if !ok {
@@ -64,6 +70,17 @@ func parseExtendedResponse(pkt *Packet) (*ExtendedResponse, error) {
 	return res, nil
 }
 
+// unsupportedExtended returns the response to an extended operation the
+// backend doesn't implement.
+func unsupportedExtended(req *ExtendedRequest) *ExtendedResponse {
+	return &ExtendedResponse{
+		BaseResponse: BaseResponse{
+			Code:    ResultProtocolError,
+			Message: "unsupported extended operation " + req.Name,
+		},
+	}
+}
+
 func parseExtendedRequest(pkt *Packet) (*ExtendedRequest, error) {
 	var ok bool
 	req := &ExtendedRequest{}
@@ -99,6 +116,48 @@ type PasswordModifyResponse struct {
 	GenPassword []byte // [0] OCTET STRING OPTIONAL
 }
 
+// extendedRequest returns the extended request for a password modify
+// operation (RFC 3062).
+func (r *PasswordModifyRequest) extendedRequest() (*ExtendedRequest, error) {
+	p := NewPacket(ClassUniversal, false, TagSequence, nil)
+	if r.UserIdentity != "" {
+		p.AddItem(NewPacket(ClassContext, true, 0, r.UserIdentity))
+	}
+	if r.OldPassword != nil {
+		p.AddItem(NewPacket(ClassContext, true, 1, r.OldPassword))
+	}
+	if r.NewPassword != nil {
+		p.AddItem(NewPacket(ClassContext, true, 2, r.NewPassword))
+	}
+	value, err := p.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return &ExtendedRequest{Name: OIDPasswordModify, Value: value}, nil
+}
+
+// parseGenPassword returns the generated password from the value of a
+// password modify response or nil if there is none.
+func parseGenPassword(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	p, _, err := ParsePacket(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range p.Items {
+		if it.Tag == 0 {
+			gen, ok := it.Bytes()
+			if !ok {
+				return nil, &ProtocolError{Reason: "invalid generated password"}
+			}
+			return gen, nil
+		}
+	}
+	return nil, nil
+}
+
 func parsePasswordModifyRequest(pkt *Packet) (*PasswordModifyRequest, error) {
 	var ok bool
 	req := &PasswordModifyRequest{}
@@ -1,10 +1,9 @@
 package ldap
 
-// TODO: better validation especially of attribute names
-
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"unicode/utf8"
 )
@@ -36,6 +35,31 @@ type Filter interface {
 	Encode() (*Packet, error)
 }
 
+// AbsoluteTrue returns the filter "(&)" which matches all entries. Servers
+// that support it advertise OIDTrueFalseFilters in supportedFeatures.
+func AbsoluteTrue() Filter {
+	return &AND{}
+}
+
+// AbsoluteFalse returns the filter "(|)" which matches no entries.
+func AbsoluteFalse() Filter {
+	return &OR{}
+}
+
+// IsAbsoluteTrue returns true if f is the absolute true filter "(&)".
+func IsAbsoluteTrue(f Filter) bool {
+	a, ok := f.(*AND)
+	return ok && len(a.Filters) == 0
+}
+
+// IsAbsoluteFalse returns true if f is the absolute false filter "(|)".
+func IsAbsoluteFalse(f Filter) bool {
+	o, ok := f.(*OR)
+	return ok && len(o.Filters) == 0
+}
+
+// AND matches if all of its filters match. An AND with no filters is the
+// absolute true filter "(&)" (RFC 4526).
 type AND struct {
 	Filters []Filter
 }
@@ -60,6 +84,8 @@ func (a *AND) Encode() (*Packet, error) {
 	return pkt, nil
 }
 
+// OR matches if any of its filters match. An OR with no filters is the
+// absolute false filter "(|)" (RFC 4526).
 type OR struct {
 	Filters []Filter
 }
@@ -117,7 +143,7 @@ func (f *EqualityMatch) Encode() (*Packet, error) {
 }
 
 func (f *EqualityMatch) String() string {
-	return fmt.Sprintf("(%s=%s)", filterEscape(f.Attribute), filterEscape(string(f.Value)))
+	return fmt.Sprintf("(%s=%s)", EscapeFilterValue(f.Attribute), EscapeFilterValue(string(f.Value)))
 }
 
 type GreaterOrEqual AttributeValueAssertion
@@ -130,7 +156,7 @@ func (f *GreaterOrEqual) Encode() (*Packet, error) {
 }
 
 func (f *GreaterOrEqual) String() string {
-	return fmt.Sprintf("(%s>=%s)", filterEscape(f.Attribute), filterEscape(string(f.Value)))
+	return fmt.Sprintf("(%s>=%s)", EscapeFilterValue(f.Attribute), EscapeFilterValue(string(f.Value)))
 }
 
 type LessOrEqual AttributeValueAssertion
@@ -143,7 +169,7 @@ func (f *LessOrEqual) Encode() (*Packet, error) {
 }
 
 func (f *LessOrEqual) String() string {
-	return fmt.Sprintf("(%s<=%s)", filterEscape(f.Attribute), filterEscape(string(f.Value)))
+	return fmt.Sprintf("(%s<=%s)", EscapeFilterValue(f.Attribute), EscapeFilterValue(string(f.Value)))
 }
 
 type ApproxMatch AttributeValueAssertion
@@ -156,7 +182,7 @@ func (f *ApproxMatch) Encode() (*Packet, error) {
 }
 
 func (f *ApproxMatch) String() string {
-	return fmt.Sprintf("(%s~=%s)", filterEscape(f.Attribute), filterEscape(string(f.Value)))
+	return fmt.Sprintf("(%s~=%s)", EscapeFilterValue(f.Attribute), EscapeFilterValue(string(f.Value)))
 }
 
 type Present struct {
@@ -168,7 +194,7 @@ func (f *Present) Encode() (*Packet, error) {
 }
 
 func (f *Present) String() string {
-	return fmt.Sprintf("(%s=*)", filterEscape(f.Attribute))
+	return fmt.Sprintf("(%s=*)", EscapeFilterValue(f.Attribute))
 }
 
 type Substrings struct {
@@ -199,201 +225,326 @@ func (s *Substrings) Encode() (*Packet, error) {
 func (s *Substrings) String() string {
 	n := len(s.Any) + 2
 	parts := make([]string, n)
-	parts[0] = filterEscape(s.Initial)
-	parts[len(parts)-1] = filterEscape(s.Final)
+	parts[0] = EscapeFilterValue(s.Initial)
+	parts[len(parts)-1] = EscapeFilterValue(s.Final)
 	for i, s := range s.Any {
-		parts[i+1] = filterEscape(s)
+		parts[i+1] = EscapeFilterValue(s)
 	}
-	return fmt.Sprintf("(%s=%s)", filterEscape(s.Attribute), strings.Join(parts, "*"))
+	return fmt.Sprintf("(%s=%s)", EscapeFilterValue(s.Attribute), strings.Join(parts, "*"))
 }
 
-type tokenizer struct {
-	s    string
-	pos  int // byte position
-	cpos int // character position
+// ExtensibleMatch is a filter item that uses an explicit matching rule
+// and optionally matches the attributes of the entry's DN (RFC 4511
+// section 4.5.1.7.7). At least one of MatchingRule and Attribute must be
+// set.
+type ExtensibleMatch struct {
+	MatchingRule string // optional
+	Attribute    string // optional
+	Value        []byte
+	DNAttributes bool
 }
 
-func (t *tokenizer) next() rune {
-	if t.pos == len(t.s) {
-		return 0
+func (f *ExtensibleMatch) Encode() (*Packet, error) {
+	if f.MatchingRule == "" && f.Attribute == "" {
+		return nil, errors.New("ldap: extensible match requires a matching rule or attribute")
 	}
-	r, size := utf8.DecodeRuneInString(t.s[t.pos:])
-	t.pos += size
-	t.cpos++
-	return r
-}
-
-var escapes = map[rune][]rune{
-	'(':  []rune(`\28`),
-	')':  []rune(`\29`),
-	'&':  []rune(`\26`),
-	'|':  []rune(`\3c`),
-	'=':  []rune(`\3d`),
-	'>':  []rune(`\3e`),
-	'<':  []rune(`\3c`),
-	'~':  []rune(`\7e`),
-	'*':  []rune(`\2a`),
-	'/':  []rune(`\2f`),
-	'\\': []rune(`\5c`),
+	pkt := NewPacket(ClassContext, false, filterTagExtensibleMatch, nil)
+	if f.MatchingRule != "" {
+		pkt.AddItem(NewPacket(ClassContext, true, 1, f.MatchingRule))
+	}
+	if f.Attribute != "" {
+		pkt.AddItem(NewPacket(ClassContext, true, 2, f.Attribute))
+	}
+	pkt.AddItem(NewPacket(ClassContext, true, 3, f.Value))
+	if f.DNAttributes {
+		pkt.AddItem(NewPacket(ClassContext, true, 4, true))
+	}
+	return pkt, nil
 }
 
-func filterEscape(s string) string {
-	out := make([]rune, 0, len(s))
-	for _, r := range s {
-		if e := escapes[r]; e != nil {
-			out = append(out, e...)
-		} else {
-			out = append(out, r)
+func (f *ExtensibleMatch) String() string {
+	s := "(" + EscapeFilterValue(f.Attribute)
+	if f.DNAttributes {
+		s += ":dn"
+	}
+	if f.MatchingRule != "" {
+		s += ":" + f.MatchingRule
+	}
+	return s + ":=" + EscapeFilterValue(string(f.Value)) + ")"
+}
+
+// parseExtensibleMatch parses the part of an extensible match before ":="
+// (e.g. "cn:dn:caseExactMatch").
+func parseExtensibleMatch(name string, value []byte) (*ExtensibleMatch, error) {
+	parts := strings.Split(name, ":")
+	f := &ExtensibleMatch{Attribute: parts[0], Value: value}
+	parts = parts[1:]
+	if len(parts) != 0 && strings.EqualFold(parts[0], "dn") {
+		f.DNAttributes = true
+		parts = parts[1:]
+	}
+	switch len(parts) {
+	case 0:
+	case 1:
+		if parts[0] == "" {
+			return nil, errors.New("empty matching rule")
 		}
+		f.MatchingRule = parts[0]
+	default:
+		return nil, errors.New("invalid extensible match")
+	}
+	if f.Attribute == "" && f.MatchingRule == "" {
+		return nil, errors.New("extensible match requires a matching rule or attribute")
 	}
-	return string(out)
+	return f, nil
+}
+
+// EscapeFilterValue escapes a value for use in the string representation
+// of a filter (RFC 4515). The characters '*', '(', ')', '\', and NUL are
+// escaped as well as bytes that are not part of valid UTF-8 sequences so
+// that any value, including binary values, can be safely included in a
+// filter.
+func EscapeFilterValue(v string) string {
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	b.Grow(len(v))
+	for i := 0; i < len(v); {
+		c := v[i]
+		switch {
+		case c == '*', c == '(', c == ')', c == '\\', c == 0:
+		case c < utf8.RuneSelf:
+			b.WriteByte(c)
+			i++
+			continue
+		default:
+			if r, size := utf8.DecodeRuneInString(v[i:]); r != utf8.RuneError || size > 1 {
+				b.WriteString(v[i : i+size])
+				i += size
+				continue
+			}
+		}
+		b.WriteByte('\\')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xf])
+		i++
+	}
+	return b.String()
 }
 
+// ParseFilter parses the string representation of a filter (RFC 4515).
+// Escaped values are decoded to octets so values do not need to be valid
+// UTF-8. The position in a returned syntax error is a byte offset.
 func ParseFilter(filter string) (Filter, error) {
 	if len(filter) == 0 {
 		return nil, &ErrFilterSyntaxError{Pos: 0, Msg: "empty filter"}
 	}
-	tok := &tokenizer{s: filter}
-	return parseFilter(tok, false)
+	p := &filterParser{s: filter}
+	f, err := p.parseFilter()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.s) {
+		return nil, p.errorf("unexpected %q after filter", p.s[p.pos])
+	}
+	return f, nil
 }
 
-func parseFilter(tok *tokenizer, checkClose bool) (Filter, error) {
-	r := tok.next()
-	if checkClose && r == ')' {
-		tok.pos--
-		return nil, nil
-	} else if r != '(' {
-		return nil, &ErrFilterSyntaxError{Pos: tok.cpos - 1, Msg: "expected ("}
+type filterParser struct {
+	s   string
+	pos int // byte position
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return &ErrFilterSyntaxError{Pos: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) peek() (byte, bool) {
+	if p.pos == len(p.s) {
+		return 0, false
+	}
+	return p.s[p.pos], true
+}
+
+func (p *filterParser) expect(c byte) error {
+	if b, ok := p.peek(); !ok {
+		return p.errorf("unexpected end of filter, expected %q", c)
+	} else if b != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) parseFilter() (Filter, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	c, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("unexpected end of filter")
 	}
 	var filter Filter
-	r = tok.next()
-	switch r {
-	case 0, utf8.RuneError:
-		return nil, &ErrFilterSyntaxError{Pos: tok.cpos, Msg: "unxpected end of filter"}
+	switch c {
 	case '&', '|':
+		p.pos++
 		var filters []Filter
 		for {
-			f, err := parseFilter(tok, true)
+			if c, ok := p.peek(); ok && c == ')' {
+				break
+			}
+			f, err := p.parseFilter()
 			if err != nil {
 				return nil, err
 			}
-			if f == nil {
-				break
-			}
 			filters = append(filters, f)
 		}
-		switch r {
-		case '&':
+		if c == '&' {
 			filter = &AND{Filters: filters}
-		case '|':
+		} else {
 			filter = &OR{Filters: filters}
 		}
 	case '!':
-		f, err := parseFilter(tok, false)
+		p.pos++
+		f, err := p.parseFilter()
 		if err != nil {
 			return nil, err
 		}
 		filter = &NOT{Filter: f}
 	default:
-		name := []rune{r}
-		var op string
-		for op == "" {
-			r = tok.next()
-			switch r {
-			case 0, utf8.RuneError:
-				return nil, &ErrFilterSyntaxError{Pos: tok.cpos, Msg: "unxpected end of filter"}
-			case '=':
-				op = "="
-			case '>', '<', '~':
-				op = string(r) + "="
-				if r2 := tok.next(); r2 != '=' {
-					return nil, &ErrFilterSyntaxError{Pos: tok.cpos - 1, Msg: "expected = after " + string(r)}
-				}
-			case '\\':
-				// hex code
-				r1 := tok.next()
-				r2 := tok.next()
-				if r1 == 0 || r2 == 0 || r1 == utf8.RuneError || r2 == utf8.RuneError {
-					return nil, &ErrFilterSyntaxError{Pos: tok.cpos, Msg: "unxpected end of filter"}
-				}
-				h := string(r1) + string(r2)
-				n, err := strconv.ParseInt(h, 16, 8)
-				if err != nil {
-					return nil, &ErrFilterSyntaxError{Pos: tok.cpos - 2, Msg: "unable to parse hex code: " + err.Error()}
-				}
-				name = append(name, rune(n))
-			default:
-				name = append(name, r)
-			}
+		var err error
+		if filter, err = p.parseItem(); err != nil {
+			return nil, err
 		}
-		var value []rune
-		hasStar := false
-	valueLoop:
-		for {
-			r = tok.next()
-			if r == '*' {
-				hasStar = true
-			}
-			switch r {
-			case 0, utf8.RuneError:
-				return nil, &ErrFilterSyntaxError{Pos: tok.cpos, Msg: "unxpected end of filter"}
-			case ')':
-				tok.pos--
-				break valueLoop
-			case '\\':
-				// hex code
-				r1 := tok.next()
-				r2 := tok.next()
-				if r1 == 0 || r2 == 0 || r1 == utf8.RuneError || r2 == utf8.RuneError {
-					return nil, &ErrFilterSyntaxError{Pos: tok.cpos, Msg: "unxpected end of filter"}
-				}
-				h := string(r1) + string(r2)
-				n, err := strconv.ParseInt(h, 16, 8)
-				if err != nil {
-					return nil, &ErrFilterSyntaxError{Pos: tok.cpos - 2, Msg: "unable to parse hex code: " + err.Error()}
-				}
-				value = append(value, rune(n))
-			default:
-				value = append(value, r)
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// parseItem parses a simple, present, substrings, or extensible filter
+// item without the enclosing parentheses.
+func (p *filterParser) parseItem() (Filter, error) {
+	start := p.pos
+	for p.pos < len(p.s) && isAttributeDescriptionChar(p.s[p.pos]) {
+		p.pos++
+	}
+	attr := p.s[start:p.pos]
+	c, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("unexpected end of filter")
+	}
+	switch c {
+	case ':':
+		// extensible match: attr [":dn"] [":" rule] ":="
+		for p.pos < len(p.s) && (p.s[p.pos] == ':' || isAttributeDescriptionChar(p.s[p.pos])) {
+			if p.s[p.pos] == ':' && p.pos+1 < len(p.s) && p.s[p.pos+1] == '=' {
+				break
 			}
+			p.pos++
 		}
-		nameS := string(name)
-		valueS := string(value)
-		switch {
-		case valueS == "*":
-			if op != "=" {
-				return nil, &ErrFilterSyntaxError{Pos: tok.cpos, Msg: "* value for non = op"}
-			}
-			filter = &Present{Attribute: nameS}
-		case hasStar:
-			if op != "=" {
-				return nil, &ErrFilterSyntaxError{Pos: tok.cpos, Msg: "non equality substring match not allowed"}
+		if !strings.HasPrefix(p.s[p.pos:], ":=") {
+			return nil, p.errorf("expected := in extensible match")
+		}
+		name := p.s[start:p.pos]
+		p.pos += 2
+		parts, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 1 {
+			return nil, &ErrFilterSyntaxError{Pos: start, Msg: "* not allowed in extensible match value"}
+		}
+		f, err := parseExtensibleMatch(name, parts[0])
+		if err != nil {
+			return nil, &ErrFilterSyntaxError{Pos: start, Msg: err.Error()}
+		}
+		return f, nil
+	case '=', '~', '>', '<':
+	default:
+		return nil, p.errorf("invalid character %q in attribute description", c)
+	}
+	if attr == "" {
+		return nil, p.errorf("missing attribute description")
+	}
+	op := c
+	p.pos++
+	if op != '=' {
+		if err := p.expect('='); err != nil {
+			return nil, err
+		}
+	}
+	valuePos := p.pos
+	parts, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) > 1 && op != '=' {
+		return nil, &ErrFilterSyntaxError{Pos: valuePos, Msg: "* not allowed in value for " + string(op) + "="}
+	}
+	switch {
+	case len(parts) == 2 && len(parts[0]) == 0 && len(parts[1]) == 0:
+		return &Present{Attribute: attr}, nil
+	case len(parts) > 1:
+		f := &Substrings{
+			Attribute: attr,
+			Initial:   string(parts[0]),
+			Final:     string(parts[len(parts)-1]),
+		}
+		for _, a := range parts[1 : len(parts)-1] {
+			f.Any = append(f.Any, string(a))
+		}
+		return f, nil
+	}
+	switch op {
+	case '~':
+		return &ApproxMatch{Attribute: attr, Value: parts[0]}, nil
+	case '>':
+		return &GreaterOrEqual{Attribute: attr, Value: parts[0]}, nil
+	case '<':
+		return &LessOrEqual{Attribute: attr, Value: parts[0]}, nil
+	}
+	return &EqualityMatch{Attribute: attr, Value: parts[0]}, nil
+}
+
+// parseValue parses an assertion value up to the closing parenthesis. The
+// value is split at unescaped '*' characters.
+func (p *filterParser) parseValue() ([][]byte, error) {
+	parts := [][]byte{{}}
+	for {
+		c, ok := p.peek()
+		if !ok {
+			return nil, p.errorf("unexpected end of filter")
+		}
+		switch c {
+		case ')':
+			return parts, nil
+		case '*':
+			parts = append(parts, []byte{})
+		case '(', 0:
+			return nil, p.errorf("unescaped %q in value", c)
+		case '\\':
+			if p.pos+2 >= len(p.s) {
+				return nil, p.errorf("unexpected end of filter in escape")
 			}
-			// substring match
-			parts := strings.Split(valueS, "*")
-			filter = &Substrings{
-				Attribute: nameS,
-				Initial:   parts[0],
-				Final:     parts[len(parts)-1],
-				Any:       parts[1 : len(parts)-1],
+			if !isHexDigit(p.s[p.pos+1]) || !isHexDigit(p.s[p.pos+2]) {
+				return nil, p.errorf("invalid escape %q", p.s[p.pos:p.pos+3])
 			}
+			b, _ := hex.DecodeString(p.s[p.pos+1 : p.pos+3])
+			parts[len(parts)-1] = append(parts[len(parts)-1], b[0])
+			p.pos += 3
+			continue
 		default:
-			switch op {
-			case "=":
-				filter = &EqualityMatch{Attribute: nameS, Value: []byte(valueS)}
-			case ">=":
-				filter = &GreaterOrEqual{Attribute: nameS, Value: []byte(valueS)}
-			case "<=":
-				filter = &LessOrEqual{Attribute: nameS, Value: []byte(valueS)}
-			case "~=":
-				filter = &ApproxMatch{Attribute: nameS, Value: []byte(valueS)}
-			}
+			parts[len(parts)-1] = append(parts[len(parts)-1], c)
 		}
+		p.pos++
 	}
-	if r := tok.next(); r != ')' {
-		return nil, &ErrFilterSyntaxError{Pos: tok.cpos - 1, Msg: "expected )"}
-	}
-	return filter, nil
+}
+
+// isAttributeDescriptionChar returns true for the characters allowed in an
+// attribute description including options (RFC 4512 section 2.5).
+func isAttributeDescriptionChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == ';'
 }
 
 func parseSearchFilter(pkt *Packet) (Filter, error) {
@@ -510,7 +661,48 @@ func parseSearchFilter(pkt *Packet) (Filter, error) {
 		}
 		return f, nil
 	case filterTagExtensibleMatch:
-		// TODO
+		f := &ExtensibleMatch{}
+		hasValue := false
+		for _, c := range pkt.Items {
+			switch c.Tag {
+			case 1:
+				s, ok := c.Str()
+				if !ok {
+					return nil, &ProtocolError{Reason: "failed to parse extensibleMatch.matchingRule in filter"}
+				}
+				f.MatchingRule = s
+			case 2:
+				s, ok := c.Str()
+				if !ok {
+					return nil, &ProtocolError{Reason: "failed to parse extensibleMatch.type in filter"}
+				}
+				f.Attribute = s
+			case 3:
+				b, ok := c.Bytes()
+				if !ok {
+					return nil, &ProtocolError{Reason: "failed to parse extensibleMatch.matchValue in filter"}
+				}
+				f.Value = b
+				hasValue = true
+			case 4:
+				if v, ok := c.Bool(); ok {
+					f.DNAttributes = v
+				} else if b, ok := c.Bytes(); ok && len(b) == 1 {
+					f.DNAttributes = b[0] != 0
+				} else {
+					return nil, &ProtocolError{Reason: "failed to parse extensibleMatch.dnAttributes in filter"}
+				}
+			default:
+				return nil, &ProtocolError{Reason: fmt.Sprintf("unknown extensibleMatch item %d in filter", c.Tag)}
+			}
+		}
+		if !hasValue {
+			return nil, &ProtocolError{Reason: "extensibleMatch.matchValue missing in filter"}
+		}
+		if f.MatchingRule == "" && f.Attribute == "" {
+			return nil, &ProtocolError{Reason: "extensibleMatch requires matchingRule or type in filter"}
+		}
+		return f, nil
 	}
 	return nil, &ProtocolError{Reason: fmt.Sprintf("unknown filter tag %d", pkt.Tag)}
 }
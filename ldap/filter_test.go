@@ -1,6 +1,10 @@
 package ldap
 
-import "testing"
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
 
 func TestParseFilter(t *testing.T) {
 	t.Parallel()
@@ -17,6 +21,10 @@ func TestParseFilter(t *testing.T) {
 		"(prefix=prefix*)",
 		"(suffix=*suffix)",
 		"(middle=*middle*)",
+		"(cn:caseExactMatch:=Fred)",
+		"(:dn:2.4.6.8.10:=Dino)",
+		"(o:dn:=Ace Industry)",
+		"(sn:=Barney)",
 	}
 	for _, c := range cases {
 		if f, err := ParseFilter(c); err != nil {
@@ -25,6 +33,90 @@ func TestParseFilter(t *testing.T) {
 			t.Errorf("Parse filter '%s' != '%s'", c, f.String())
 		}
 	}
+	invalid := []string{
+		"",
+		"cn=x",
+		"(cn=x",
+		"(cn=x))",
+		"(cn=\\2)",
+		"(cn=\\zz)",
+		"(cn=(x)",
+		"(c n=x)",
+		"(=x)",
+		"(cn>=a*b)",
+		"(:=x)",
+		"(:dn:=x)",
+		"(cn:dn:rule:extra:=x)",
+		"(cn:rule:=*x)",
+	}
+	for _, c := range invalid {
+		if _, err := ParseFilter(c); err == nil {
+			t.Errorf("Expected error parsing '%s'", c)
+		}
+	}
+}
+
+func TestFilterStringRoundTrip(t *testing.T) {
+	t.Parallel()
+	cases := []Filter{
+		&EqualityMatch{Attribute: "cn", Value: []byte("caf\xc3\xa9")},
+		&EqualityMatch{Attribute: "cn", Value: []byte("a*b(c)d\\e\x00")},
+		&EqualityMatch{Attribute: "objectGUID", Value: []byte{0xff, 0x00, 0x80, 0x2a, 0xc3}},
+		&EqualityMatch{Attribute: "cn", Value: []byte{}},
+		&EqualityMatch{Attribute: "cn;lang-en;binary", Value: []byte("x")},
+		&EqualityMatch{Attribute: "2.5.4.3", Value: []byte("=|&<>~/")},
+		&GreaterOrEqual{Attribute: "n", Value: []byte("*")},
+		&Substrings{Attribute: "cn", Initial: "a*", Any: []string{"", "\x00", ")"}, Final: "b"},
+		&Substrings{Attribute: "cn", Any: []string{""}},
+		&Substrings{Attribute: "cn", Final: "\xff"},
+		&ExtensibleMatch{Attribute: "cn", MatchingRule: "caseExactMatch", Value: []byte("a*b"), DNAttributes: true},
+		&NOT{Filter: &Present{Attribute: "cn;x-opt"}},
+	}
+	for _, c := range cases {
+		s := c.String()
+		f, err := ParseFilter(s)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %s", s, err)
+		} else if !reflect.DeepEqual(f, c) {
+			t.Errorf("ParseFilter(%q) = %#v, want %#v", s, f, c)
+		}
+	}
+}
+
+func TestParseFilterEscapes(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		filter string
+		value  string
+		str    string
+	}{
+		{`(cn=\c3\a9)`, "\u00e9", "(cn=\u00e9)"},
+		{`(cn=\C3\A9)`, "\u00e9", "(cn=\u00e9)"},
+		{`(cn=\2a)`, "*", `(cn=\2a)`},
+		{`(cn=a|b)`, "a|b", "(cn=a|b)"},
+		{`(cn=\7c)`, "|", "(cn=|)"},
+		{`(cn=\ff)`, "\xff", `(cn=\ff)`},
+	}
+	for _, c := range cases {
+		f, err := ParseFilter(c.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %s", c.filter, err)
+			continue
+		}
+		eq, ok := f.(*EqualityMatch)
+		if !ok {
+			t.Errorf("ParseFilter(%q) = %T, want *EqualityMatch", c.filter, f)
+		} else if string(eq.Value) != c.value {
+			t.Errorf("ParseFilter(%q) value = %q, want %q", c.filter, eq.Value, c.value)
+		}
+		if s := f.String(); s != c.str {
+			t.Errorf("ParseFilter(%q).String() = %q, want %q", c.filter, s, c.str)
+		}
+	}
+	_, err := ParseFilter("(cn=\u00e9(x)")
+	if e, ok := err.(*ErrFilterSyntaxError); !ok || e.Pos != 6 {
+		t.Errorf("Expected syntax error at byte 6, got %v", err)
+	}
 }
 
 func TestFilterEncoding(t *testing.T) {
@@ -67,6 +159,16 @@ func TestFilterEncoding(t *testing.T) {
 			Final:     "final",
 			Any:       []string{"one", "two"},
 		},
+		&ExtensibleMatch{
+			MatchingRule: "1.2.840.113556.1.4.803",
+			Attribute:    "userAccountControl",
+			Value:        []byte("2"),
+		},
+		&ExtensibleMatch{
+			MatchingRule: "caseIgnoreMatch",
+			Value:        []byte("x"),
+			DNAttributes: true,
+		},
 	}
 	for _, c := range cases {
 		pkt, err := c.Encode()
@@ -80,5 +182,196 @@ func TestFilterEncoding(t *testing.T) {
 		if c.String() != f.String() {
 			t.Errorf("'%s' != '%s'", f.String(), c.String())
 		}
+		b, err := pkt.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt, _, err = ParsePacket(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f, err = parseSearchFilter(pkt); err != nil {
+			t.Fatal(err)
+		} else if c.String() != f.String() {
+			t.Errorf("'%s' != '%s' after decoding", f.String(), c.String())
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	t.Parallel()
+	e := &SearchResult{
+		DN: "uid=jdoe,ou=People,dc=example,dc=com",
+		Attributes: map[string][][]byte{
+			"objectClass":     {[]byte("top"), []byte("person"), []byte("inetOrgPerson")},
+			"CN":              {[]byte("John  Doe"), []byte("Johnny")},
+			"cn;lang-fr":      {[]byte("Jean")},
+			"sn":              {[]byte("Doe")},
+			"uid":             {[]byte("jdoe")},
+			"uidNumber":       {[]byte("1000")},
+			"telephoneNumber": {[]byte("+1 555-0100")},
+		},
+	}
+	cases := []struct {
+		filter string
+		schema FilterResult
+		plain  FilterResult
+	}{
+		{"(cn=john doe)", FilterTrue, FilterTrue},
+		{"(CN=JOHNNY)", FilterTrue, FilterTrue},
+		{"(2.5.4.3=johnny)", FilterTrue, FilterFalse},
+		{"(cn=jane)", FilterFalse, FilterFalse},
+		{"(name=jean)", FilterTrue, FilterFalse},
+		{"(cn;lang-fr=jean)", FilterTrue, FilterTrue},
+		{"(cn;lang-de=jean)", FilterFalse, FilterFalse},
+		{"(cn;lang-=jean)", FilterTrue, FilterTrue},
+		{"(cn;lang-fr-=jean)", FilterTrue, FilterTrue},
+		{"(name;lang-f-=jean)", FilterFalse, FilterFalse},
+		{"(cn=*)", FilterTrue, FilterTrue},
+		{"(mail=*)", FilterFalse, FilterFalse},
+		{"(bogus=*)", FilterFalse, FilterFalse},
+		{"(bogus=x)", FilterUndefined, FilterFalse},
+		{"(!(bogus=x))", FilterUndefined, FilterTrue},
+		{"(|(bogus=x)(sn=doe))", FilterTrue, FilterTrue},
+		{"(|(bogus=x)(sn=smith))", FilterUndefined, FilterFalse},
+		{"(&(bogus=x)(sn=smith))", FilterFalse, FilterFalse},
+		{"(&(bogus=x)(sn=doe))", FilterUndefined, FilterFalse},
+		{"(objectClass=PERSON)", FilterTrue, FilterTrue},
+		{"(objectClass=2.5.6.6)", FilterTrue, FilterFalse},
+		{"(uidNumber>=999)", FilterTrue, FilterFalse},
+		{"(uidNumber>=1000)", FilterTrue, FilterTrue},
+		{"(uidNumber<=999)", FilterFalse, FilterTrue},
+		{"(uidNumber=01000)", FilterUndefined, FilterFalse},
+		{"(uidNumber=abc)", FilterUndefined, FilterFalse},
+		{"(cn=j*n*oe)", FilterTrue, FilterTrue},
+		{"(cn=*ohnn*)", FilterTrue, FilterTrue},
+		{"(cn=*doe*john)", FilterFalse, FilterFalse},
+		{"(telephoneNumber=+15550100)", FilterTrue, FilterFalse},
+		{"(telephoneNumber=*555*)", FilterTrue, FilterTrue},
+		{"(cn~=johnny)", FilterTrue, FilterTrue},
+		{"(sn<=doe)", FilterUndefined, FilterTrue},
+		{"(cn:caseExactMatch:=Johnny)", FilterTrue, FilterTrue},
+		{"(cn:caseExactMatch:=johnny)", FilterFalse, FilterFalse},
+		{"(sn:=DOE)", FilterTrue, FilterTrue},
+		{"(sn:caseIgnoreSubstringsMatch:=d\\2ae)", FilterTrue, FilterTrue},
+		{"(uidNumber:integerOrderingMatch:=1001)", FilterTrue, FilterTrue},
+		{"(uidNumber:integerOrderingMatch:=1000)", FilterFalse, FilterFalse},
+		{"(uidNumber:1.2.840.113556.1.4.803:=8)", FilterTrue, FilterTrue},
+		{"(uidNumber:1.2.840.113556.1.4.803:=9)", FilterFalse, FilterFalse},
+		{"(uidNumber:1.2.840.113556.1.4.804:=9)", FilterTrue, FilterTrue},
+		{"(cn:1.2.3.4:=x)", FilterUndefined, FilterUndefined},
+		{"(ou=people)", FilterFalse, FilterFalse},
+		{"(ou:dn:=people)", FilterTrue, FilterTrue},
+		{"(:dn:caseIgnoreMatch:=people)", FilterTrue, FilterTrue},
+		{"(:caseIgnoreMatch:=people)", FilterFalse, FilterFalse},
+		{"(:caseExactMatch:=Jean)", FilterTrue, FilterTrue},
+	}
+	for _, c := range cases {
+		f, err := ParseFilter(c.filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %s", c.filter, err)
+		}
+		if r := MatchFilter(f, e, DefaultSchema); r != c.schema {
+			t.Errorf("%s with schema = %s, want %s", c.filter, r, c.schema)
+		}
+		if r := CompileFilter(f, nil)(e); r != c.plain {
+			t.Errorf("%s without schema = %s, want %s", c.filter, r, c.plain)
+		}
+	}
+}
+
+func BenchmarkCompiledFilter(b *testing.B) {
+	e := &SearchResult{
+		DN: "uid=jdoe,ou=People,dc=example,dc=com",
+		Attributes: map[string][][]byte{
+			"objectClass": {[]byte("top"), []byte("person"), []byte("inetOrgPerson")},
+			"cn":          {[]byte("John Doe")},
+			"sn":          {[]byte("Doe")},
+			"uid":         {[]byte("jdoe")},
+		},
+	}
+	f, err := ParseFilter("(&(objectClass=person)(|(uid=jdoe)(cn=john*)))")
+	if err != nil {
+		b.Fatal(err)
+	}
+	match := CompileFilter(f, DefaultSchema)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if match(e) != FilterTrue {
+			b.Fatal("filter didn't match")
+		}
+	}
+}
+
+func TestAbsoluteFilters(t *testing.T) {
+	t.Parallel()
+	e := &SearchResult{DN: "cn=x", Attributes: map[string][][]byte{"cn": {[]byte("x")}}}
+	cases := []struct {
+		filter string
+		ber    []byte
+		result FilterResult
+	}{
+		{"(&)", []byte{0xa0, 0x00}, FilterTrue},
+		{"(|)", []byte{0xa1, 0x00}, FilterFalse},
+		{"(!(&))", []byte{0xa2, 0x02, 0xa0, 0x00}, FilterFalse},
+		{"(&(cn=x)(|))", nil, FilterFalse},
+		{"(|(cn=y)(&))", nil, FilterTrue},
+	}
+	for _, c := range cases {
+		f, err := ParseFilter(c.filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %s", c.filter, err)
+		}
+		if s := f.String(); s != c.filter {
+			t.Errorf("String() = %q, want %q", s, c.filter)
+		}
+		if r := MatchFilter(f, e, DefaultSchema); r != c.result {
+			t.Errorf("%s = %s, want %s", c.filter, r, c.result)
+		}
+		if c.ber == nil {
+			continue
+		}
+		pkt, err := f.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := pkt.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c.ber) {
+			t.Errorf("%s encoded as %x, want %x", c.filter, b, c.ber)
+		}
+		pkt, _, err = ParsePacket(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f, err = parseSearchFilter(pkt); err != nil {
+			t.Fatal(err)
+		} else if f.String() != c.filter {
+			t.Errorf("decoded %s as %s", c.filter, f)
+		}
+	}
+	if !IsAbsoluteTrue(AbsoluteTrue()) || IsAbsoluteFalse(AbsoluteTrue()) {
+		t.Error("AbsoluteTrue is not absolute true")
+	}
+	if !IsAbsoluteFalse(AbsoluteFalse()) || IsAbsoluteTrue(AbsoluteFalse()) {
+		t.Error("AbsoluteFalse is not absolute false")
+	}
+	if IsAbsoluteTrue(&AND{Filters: []Filter{AbsoluteTrue()}}) {
+		t.Error("(&(&)) is not absolute true")
+	}
+}
+
+func TestSearchRequestNilFilter(t *testing.T) {
+	t.Parallel()
+	req := &SearchRequest{}
+	var buf bytes.Buffer
+	if err := req.WritePackets(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if req.Filter != nil {
+		t.Errorf("WritePackets modified Filter to %s", req.Filter)
 	}
 }
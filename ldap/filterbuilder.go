@@ -0,0 +1,146 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FilterBuilder constructs filters from attribute descriptions and raw
+// values so values never need to be escaped by hand:
+//
+//	var b ldap.FilterBuilder
+//	f := b.And(b.Eq("uid", user), b.Or(b.Present("mail"), b.Prefix("cn", p)))
+//	if err := b.Err(); err != nil {
+//		...
+//	}
+//
+// Invalid attribute descriptions don't stop the construction of the filter
+// but the first one is reported by Err. The zero value is ready to use.
+type FilterBuilder struct {
+	err error
+}
+
+// Err returns the first error encountered while building filters.
+func (b *FilterBuilder) Err() error {
+	return b.err
+}
+
+func (b *FilterBuilder) attr(attr string) string {
+	if _, err := ParseAttributeDescription(attr); err != nil && b.err == nil {
+		b.err = err
+	}
+	return attr
+}
+
+// And returns a filter that matches if all of the filters match. With no
+// filters it's the absolute true filter.
+func (b *FilterBuilder) And(filters ...Filter) Filter {
+	return &AND{Filters: filters}
+}
+
+// Or returns a filter that matches if any of the filters match. With no
+// filters it's the absolute false filter.
+func (b *FilterBuilder) Or(filters ...Filter) Filter {
+	return &OR{Filters: filters}
+}
+
+// Not returns a filter that matches if the filter doesn't match.
+func (b *FilterBuilder) Not(filter Filter) Filter {
+	return &NOT{Filter: filter}
+}
+
+// Eq returns an equality filter "(attr=value)".
+func (b *FilterBuilder) Eq(attr, value string) Filter {
+	return &EqualityMatch{Attribute: b.attr(attr), Value: []byte(value)}
+}
+
+// Ge returns an ordering filter "(attr>=value)".
+func (b *FilterBuilder) Ge(attr, value string) Filter {
+	return &GreaterOrEqual{Attribute: b.attr(attr), Value: []byte(value)}
+}
+
+// Le returns an ordering filter "(attr<=value)".
+func (b *FilterBuilder) Le(attr, value string) Filter {
+	return &LessOrEqual{Attribute: b.attr(attr), Value: []byte(value)}
+}
+
+// Approx returns an approximate filter "(attr~=value)".
+func (b *FilterBuilder) Approx(attr, value string) Filter {
+	return &ApproxMatch{Attribute: b.attr(attr), Value: []byte(value)}
+}
+
+// Present returns a filter "(attr=*)" that matches if the entry has the
+// attribute.
+func (b *FilterBuilder) Present(attr string) Filter {
+	return &Present{Attribute: b.attr(attr)}
+}
+
+// Prefix returns a substrings filter "(attr=prefix*)". The prefix must not
+// be empty.
+func (b *FilterBuilder) Prefix(attr, prefix string) Filter {
+	return b.Substrings(attr, prefix, nil, "")
+}
+
+// Suffix returns a substrings filter "(attr=*suffix)". The suffix must not
+// be empty.
+func (b *FilterBuilder) Suffix(attr, suffix string) Filter {
+	return b.Substrings(attr, "", nil, suffix)
+}
+
+// Contains returns a substrings filter "(attr=*substr*)". The substring
+// must not be empty.
+func (b *FilterBuilder) Contains(attr, substr string) Filter {
+	return b.Substrings(attr, "", []string{substr}, "")
+}
+
+// Substrings returns a substrings filter "(attr=initial*any*...*final)".
+// Empty initial and final strings are omitted. A substrings filter needs
+// at least one component (RFC 4511 section 4.5.1) and the any components
+// must not be empty, otherwise an error is recorded. Use Present to match
+// any value.
+func (b *FilterBuilder) Substrings(attr, initial string, any []string, final string) Filter {
+	empty := initial == "" && len(any) == 0 && final == ""
+	for _, s := range any {
+		empty = empty || s == ""
+	}
+	if empty && b.err == nil {
+		b.err = errors.New("ldap: empty substring in substrings filter")
+	}
+	return &Substrings{Attribute: b.attr(attr), Initial: initial, Any: any, Final: final}
+}
+
+// Extensible returns an extensible match filter "(attr:rule:=value)". Either
+// the attribute or the matching rule may be empty but not both.
+func (b *FilterBuilder) Extensible(attr, rule, value string, dnAttributes bool) Filter {
+	if attr != "" {
+		b.attr(attr)
+	}
+	if attr == "" && rule == "" && b.err == nil {
+		b.err = errors.New("ldap: extensible match requires a matching rule or attribute")
+	}
+	return &ExtensibleMatch{Attribute: attr, MatchingRule: rule, Value: []byte(value), DNAttributes: dnAttributes}
+}
+
+// ParseFilterf formats the filter string using fmt.Sprintf and parses it.
+// Every argument is escaped after it has been formatted so values such as
+// user input can't change the structure of the filter:
+//
+//	ldap.ParseFilterf("(&(objectClass=person)(uid=%s))", user)
+func ParseFilterf(format string, args ...interface{}) (Filter, error) {
+	escaped := make([]interface{}, len(args))
+	for i, a := range args {
+		escaped[i] = filterArg{a}
+	}
+	return ParseFilter(fmt.Sprintf(format, escaped...))
+}
+
+// filterArg formats a value with the verb of the format string and escapes
+// the result.
+type filterArg struct {
+	v interface{}
+}
+
+func (a filterArg) Format(f fmt.State, verb rune) {
+	io.WriteString(f, EscapeFilterValue(fmt.Sprintf(fmt.FormatString(f, verb), a.v)))
+}
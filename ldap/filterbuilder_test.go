@@ -0,0 +1,96 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterBuilder(t *testing.T) {
+	t.Parallel()
+	var b FilterBuilder
+	cases := []struct {
+		f Filter
+		s string
+	}{
+		{b.Eq("uid", "*)(uid=*"), `(uid=\2a\29\28uid=\2a)`},
+		{b.And(b.Eq("uid", "jdoe"), b.Or(b.Present("mail"), b.Prefix("cn", "J*"))), `(&(uid=jdoe)(|(mail=*)(cn=J\2a*)))`},
+		{b.Not(b.Suffix("cn;lang-de", "\\")), `(!(cn;lang-de=*\5c))`},
+		{b.Contains("cn", "o"), `(cn=*o*)`},
+		{b.Substrings("cn", "a", []string{"b", "c"}, "d"), `(cn=a*b*c*d)`},
+		{b.Ge("uidNumber", "100"), `(uidNumber>=100)`},
+		{b.Le("uidNumber", "100"), `(uidNumber<=100)`},
+		{b.Approx("cn", "jon"), `(cn~=jon)`},
+		{b.Extensible("cn", "caseExactMatch", "J(", true), `(cn:dn:caseExactMatch:=J\28)`},
+		{b.And(), `(&)`},
+		{b.Or(), `(|)`},
+	}
+	for _, c := range cases {
+		if s := c.f.String(); s != c.s {
+			t.Errorf("String() = %s, want %s", s, c.s)
+		}
+		f, err := ParseFilter(c.f.String())
+		if err != nil {
+			t.Errorf("ParseFilter(%s) failed: %s", c.s, err)
+		} else if !reflect.DeepEqual(f, c.f) {
+			t.Errorf("ParseFilter(%s) = %#v, want %#v", c.s, f, c.f)
+		}
+	}
+	if err := b.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, attr := range []string{"", "uid)(cn", "cn;", "1cn"} {
+		var b FilterBuilder
+		b.And(b.Eq(attr, "x"), b.Present("cn"))
+		if b.Err() == nil {
+			t.Errorf("Expected error for attribute %q", attr)
+		}
+	}
+	var b2 FilterBuilder
+	b2.Extensible("", "", "x", false)
+	if b2.Err() == nil {
+		t.Error("Expected error for extensible match without rule or attribute")
+	}
+	for i, fn := range []func(b *FilterBuilder) Filter{
+		func(b *FilterBuilder) Filter { return b.Prefix("cn", "") },
+		func(b *FilterBuilder) Filter { return b.Suffix("cn", "") },
+		func(b *FilterBuilder) Filter { return b.Contains("cn", "") },
+		func(b *FilterBuilder) Filter { return b.Substrings("cn", "", nil, "") },
+		func(b *FilterBuilder) Filter { return b.Substrings("cn", "a", []string{""}, "") },
+	} {
+		var b FilterBuilder
+		fn(&b)
+		if b.Err() == nil {
+			t.Errorf("%d: Expected error for empty substrings", i)
+		}
+	}
+}
+
+func TestParseFilterf(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		format string
+		args   []interface{}
+		filter Filter
+	}{
+		{"(uid=%s)", []interface{}{"*"}, &EqualityMatch{Attribute: "uid", Value: []byte("*")}},
+		{"(&(uid=%s)(uidNumber>=%d))", []interface{}{"a)(b", 100}, &AND{Filters: []Filter{
+			&EqualityMatch{Attribute: "uid", Value: []byte("a)(b")},
+			&GreaterOrEqual{Attribute: "uidNumber", Value: []byte("100")},
+		}}},
+		{"(cn=%s*)", []interface{}{"J\\"}, &Substrings{Attribute: "cn", Initial: "J\\"}},
+		{"(objectGUID=%s)", []interface{}{[]byte{0, 0xff, '('}}, &EqualityMatch{Attribute: "objectGUID", Value: []byte{0, 0xff, '('}}},
+		{"(cn=%5s)", []interface{}{"*"}, &EqualityMatch{Attribute: "cn", Value: []byte("    *")}},
+	}
+	for _, c := range cases {
+		f, err := ParseFilterf(c.format, c.args...)
+		if err != nil {
+			t.Errorf("ParseFilterf(%q) failed: %s", c.format, err)
+		} else if !reflect.DeepEqual(f, c.filter) {
+			t.Errorf("ParseFilterf(%q) = %s, want %s", c.format, f, c.filter)
+		}
+	}
+	if _, err := ParseFilterf("(uid=%s", "x"); err == nil {
+		t.Error("Expected syntax error")
+	}
+}
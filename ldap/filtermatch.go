@@ -0,0 +1,480 @@
+package ldap
+
+// https://tools.ietf.org/html/rfc4511#section-4.5.1.7
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FilterResult is the result of evaluating a filter against an entry.
+// Filters use three-valued logic where a filter item that cannot be
+// evaluated (e.g. an unrecognized attribute type or an invalid assertion
+// value) is Undefined.
+type FilterResult int
+
+const (
+	FilterFalse     FilterResult = 0
+	FilterTrue      FilterResult = 1
+	FilterUndefined FilterResult = 2
+)
+
+var FilterResultMap = map[FilterResult]string{
+	FilterFalse:     "FALSE",
+	FilterTrue:      "TRUE",
+	FilterUndefined: "Undefined",
+}
+
+func (r FilterResult) String() string {
+	if s := FilterResultMap[r]; s != "" {
+		return s
+	}
+	return strconv.Itoa(int(r))
+}
+
+// Matchable is an entry that a filter can be evaluated against.
+type Matchable interface {
+	// EntryDN returns the distinguished name of the entry.
+	EntryDN() string
+	// EntryAttributes calls fn for each attribute of the entry until fn
+	// returns false.
+	EntryAttributes(fn func(name string, values [][]byte) bool)
+}
+
+// EntryDN implements Matchable.
+func (r *SearchResult) EntryDN() string {
+	return r.DN
+}
+
+// EntryAttributes implements Matchable.
+func (r *SearchResult) EntryAttributes(fn func(name string, values [][]byte) bool) {
+	for name, values := range r.Attributes {
+		if !fn(name, values) {
+			return
+		}
+	}
+}
+
+// FilterPredicate is a filter compiled by CompileFilter.
+type FilterPredicate func(e Matchable) FilterResult
+
+// MatchFilter evaluates a filter against an entry. See CompileFilter for
+// how the schema is used. To evaluate the same filter against many entries
+// use CompileFilter instead.
+func MatchFilter(f Filter, e Matchable, s *Schema) FilterResult {
+	return CompileFilter(f, s)(e)
+}
+
+// CompileFilter compiles a filter into a predicate that can be evaluated
+// against many entries. Attribute types, matching rules, and assertion
+// values are resolved once at compile time.
+//
+// If a schema is given then attribute types are matched by any of their
+// names or OID, subtypes are included, and values are compared using the
+// matching rules of the attribute type. Filter items for attribute types
+// that are not in the schema, or that have no suitable matching rule, are
+// Undefined. If the schema is nil then attribute names are compared
+// case-insensitively and values are compared using caseIgnoreMatch,
+// caseIgnoreOrderingMatch, and caseIgnoreSubstringsMatch.
+func CompileFilter(f Filter, s *Schema) FilterPredicate {
+	switch f := f.(type) {
+	case *AND:
+		preds := compileFilters(f.Filters, s)
+		return func(e Matchable) FilterResult {
+			res := FilterTrue
+			for _, p := range preds {
+				switch p(e) {
+				case FilterFalse:
+					return FilterFalse
+				case FilterUndefined:
+					res = FilterUndefined
+				}
+			}
+			return res
+		}
+	case *OR:
+		preds := compileFilters(f.Filters, s)
+		return func(e Matchable) FilterResult {
+			res := FilterFalse
+			for _, p := range preds {
+				switch p(e) {
+				case FilterTrue:
+					return FilterTrue
+				case FilterUndefined:
+					res = FilterUndefined
+				}
+			}
+			return res
+		}
+	case *NOT:
+		pred := CompileFilter(f.Filter, s)
+		return func(e Matchable) FilterResult {
+			switch pred(e) {
+			case FilterTrue:
+				return FilterFalse
+			case FilterFalse:
+				return FilterTrue
+			}
+			return FilterUndefined
+		}
+	case *Present:
+		sel, ok := newAttributeSelector(s, f.Attribute)
+		if !ok {
+			return constantPredicate(FilterFalse)
+		}
+		return func(e Matchable) FilterResult {
+			res := FilterFalse
+			e.EntryAttributes(func(name string, values [][]byte) bool {
+				if len(values) != 0 && sel.matches(name) {
+					res = FilterTrue
+					return false
+				}
+				return true
+			})
+			return res
+		}
+	case *EqualityMatch:
+		return compileEqualityFilter(s, f.Attribute, f.Value)
+	case *ApproxMatch:
+		// There are no approximate matching rules so use equality as
+		// allowed by RFC 4511 section 4.5.1.7.6.
+		return compileEqualityFilter(s, f.Attribute, f.Value)
+	case *GreaterOrEqual:
+		return compileOrderingFilter(s, f.Attribute, f.Value, func(c int) bool { return c >= 0 })
+	case *LessOrEqual:
+		return compileOrderingFilter(s, f.Attribute, f.Value, func(c int) bool { return c <= 0 })
+	case *Substrings:
+		rule := filterRule(s, f.Attribute, SubstringsMatchingRule)
+		if rule == nil {
+			return constantPredicate(FilterUndefined)
+		}
+		match, err := rule.compileSubstrings(f.Initial, f.Any, f.Final)
+		if err != nil {
+			return constantPredicate(FilterUndefined)
+		}
+		return compileValueFilter(s, f.Attribute, match)
+	case *ExtensibleMatch:
+		return compileExtensibleFilter(s, f)
+	}
+	return constantPredicate(FilterUndefined)
+}
+
+func compileFilters(filters []Filter, s *Schema) []FilterPredicate {
+	preds := make([]FilterPredicate, len(filters))
+	for i, f := range filters {
+		preds[i] = CompileFilter(f, s)
+	}
+	return preds
+}
+
+func constantPredicate(res FilterResult) FilterPredicate {
+	return func(Matchable) FilterResult {
+		return res
+	}
+}
+
+func compileEqualityFilter(s *Schema, attr string, value []byte) FilterPredicate {
+	rule := filterRule(s, attr, EqualityMatchingRule)
+	if rule == nil {
+		return constantPredicate(FilterUndefined)
+	}
+	match, err := rule.compileEquality(value)
+	if err != nil {
+		return constantPredicate(FilterUndefined)
+	}
+	return compileValueFilter(s, attr, match)
+}
+
+func compileOrderingFilter(s *Schema, attr string, value []byte, ok func(int) bool) FilterPredicate {
+	rule := filterRule(s, attr, OrderingMatchingRule)
+	if rule == nil {
+		return constantPredicate(FilterUndefined)
+	}
+	cmp, err := rule.compileOrdering(value)
+	if err != nil {
+		return constantPredicate(FilterUndefined)
+	}
+	return compileValueFilter(s, attr, func(v []byte) (bool, error) {
+		c, err := cmp(v)
+		return err == nil && ok(c), err
+	})
+}
+
+// compileValueFilter returns a predicate that is TRUE if any value of the
+// attribute matches, FALSE if no values match and no values are invalid,
+// and Undefined otherwise.
+func compileValueFilter(s *Schema, attr string, match func(value []byte) (bool, error)) FilterPredicate {
+	sel, ok := newAttributeSelector(s, attr)
+	if !ok {
+		return constantPredicate(FilterUndefined)
+	}
+	return valuePredicate(sel, match)
+}
+
+func valuePredicate(sel *attributeSelector, match func(value []byte) (bool, error)) FilterPredicate {
+	return func(e Matchable) FilterResult {
+		res := FilterFalse
+		e.EntryAttributes(func(name string, values [][]byte) bool {
+			if !sel.matches(name) {
+				return true
+			}
+			for _, v := range values {
+				ok, err := match(v)
+				if err != nil {
+					res = FilterUndefined
+				} else if ok {
+					res = FilterTrue
+					return false
+				}
+			}
+			return true
+		})
+		return res
+	}
+}
+
+// filterRule returns the matching rule of the given kind for an attribute
+// description or nil if there is none.
+func filterRule(s *Schema, attr string, kind MatchingRuleKind) *MatchingRule {
+	if s == nil {
+		switch kind {
+		case OrderingMatchingRule:
+			return caseIgnoreOrderingMatch
+		case SubstringsMatchingRule:
+			return caseIgnoreSubstringsMatch
+		}
+		return caseIgnoreMatch
+	}
+	typ, _, _ := strings.Cut(attr, ";")
+	var rule *MatchingRule
+	switch kind {
+	case EqualityMatchingRule:
+		rule = s.EqualityRule(typ)
+	case OrderingMatchingRule:
+		rule = s.OrderingRule(typ)
+	case SubstringsMatchingRule:
+		rule = s.SubstringsRule(typ)
+	}
+	return s.schemaAwareRule(rule)
+}
+
+// schemaAwareRule returns a variant of the rule that uses the schema when
+// normalizing values if there is one.
+func (s *Schema) schemaAwareRule(rule *MatchingRule) *MatchingRule {
+	if s != nil && rule != nil && rule.OID == objectIdentifierMatch.OID {
+		return s.objectIdentifierRule()
+	}
+	return rule
+}
+
+// compileExtensibleFilter compiles an extensible match. If no matching rule
+// is given then the equality rule of the attribute is used. If no attribute
+// is given then all attributes that support the rule are matched. For
+// ordering rules the value matches if it is less than the assertion value,
+// and for substrings rules the assertion value is a substring assertion
+// such as "a*b*c" (RFC 4517 section 3.3.30).
+func compileExtensibleFilter(s *Schema, f *ExtensibleMatch) FilterPredicate {
+	var rule *MatchingRule
+	if f.MatchingRule != "" {
+		rule = s.schemaAwareRule(s.resolveMatchingRule(f.MatchingRule))
+		if rule == nil || !rule.Implemented() {
+			return constantPredicate(FilterUndefined)
+		}
+	} else if rule = filterRule(s, f.Attribute, EqualityMatchingRule); rule == nil {
+		return constantPredicate(FilterUndefined)
+	}
+	var match func(value []byte) (bool, error)
+	var err error
+	switch rule.Kind {
+	case EqualityMatchingRule:
+		match, err = rule.compileEquality(f.Value)
+	case OrderingMatchingRule:
+		var cmp func(value []byte) (int, error)
+		if cmp, err = rule.compileOrdering(f.Value); err == nil {
+			match = func(v []byte) (bool, error) {
+				c, err := cmp(v)
+				return err == nil && c < 0, err
+			}
+		}
+	case SubstringsMatchingRule:
+		var initial, final string
+		var any []string
+		if initial, any, final, err = parseSubstringAssertion(f.Value); err == nil {
+			match, err = rule.compileSubstrings(initial, any, final)
+		}
+	default:
+		err = ErrInappropriateMatching
+	}
+	if err != nil {
+		return constantPredicate(FilterUndefined)
+	}
+	var sel *attributeSelector
+	if f.Attribute != "" {
+		var ok bool
+		if sel, ok = newAttributeSelector(s, f.Attribute); !ok {
+			return constantPredicate(FilterUndefined)
+		}
+	} else {
+		sel = s.ruleSelector(rule)
+	}
+	pred := valuePredicate(sel, match)
+	if !f.DNAttributes {
+		return pred
+	}
+	return func(e Matchable) FilterResult {
+		res := pred(e)
+		if res == FilterTrue {
+			return res
+		}
+		dn, err := ParseDN(e.EntryDN())
+		if err != nil {
+			return FilterUndefined
+		}
+		switch pred(dnAttributes(dn)) {
+		case FilterTrue:
+			return FilterTrue
+		case FilterUndefined:
+			return FilterUndefined
+		}
+		return res
+	}
+}
+
+// dnAttributes presents the attribute values of the RDNs of a DN as an
+// entry for matching extensible filters with the dnAttributes flag.
+type dnAttributes DN
+
+func (d dnAttributes) EntryDN() string {
+	return DN(d).String()
+}
+
+func (d dnAttributes) EntryAttributes(fn func(name string, values [][]byte) bool) {
+	for _, rdn := range d {
+		for _, atv := range rdn {
+			if !fn(atv.Type, [][]byte{[]byte(atv.Value)}) {
+				return
+			}
+		}
+	}
+}
+
+// ruleSelector returns a selector for all attributes that support the
+// matching rule which are those with the rule as one of their matching
+// rules or with the same syntax as the rule's assertion syntax. If there
+// is no schema then all attributes are selected.
+func (s *Schema) ruleSelector(rule *MatchingRule) *attributeSelector {
+	if s == nil {
+		return &attributeSelector{all: true}
+	}
+	sel := &attributeSelector{types: make(map[string]bool)}
+	for _, at := range s.AttributeTypes() {
+		ok := rule.Syntax != "" && s.attributeSyntax(at) == rule.Syntax
+		for _, r := range []*MatchingRule{s.EqualityRule(at.OID), s.OrderingRule(at.OID), s.SubstringsRule(at.OID)} {
+			ok = ok || (r != nil && r.OID == rule.OID)
+		}
+		if ok {
+			sel.addType(at)
+		}
+	}
+	return sel
+}
+
+// attributeSyntax returns the syntax of an attribute type following super types.
+func (s *Schema) attributeSyntax(at *AttributeType) string {
+	for depth := 0; at != nil && depth < 16; depth++ {
+		if at.Syntax != "" {
+			return at.Syntax
+		}
+		if at.SuperType == "" {
+			break
+		}
+		at = s.AttributeType(at.SuperType)
+	}
+	return ""
+}
+
+// objectIdentifierRule returns objectIdentifierMatch with descriptors of
+// object classes and attribute types in the schema resolved to their OID so
+// that e.g. (objectClass=2.5.6.6) matches an entry with objectClass person.
+func (s *Schema) objectIdentifierRule() *MatchingRule {
+	r := *objectIdentifierMatch
+	r.Normalize = func(v []byte) ([]byte, error) {
+		n, err := normalizeOID(v)
+		if err != nil || isNumericOID(string(n)) {
+			return n, err
+		}
+		if oc := s.ObjectClass(string(n)); oc != nil {
+			return []byte(oc.OID), nil
+		}
+		if at := s.AttributeType(string(n)); at != nil {
+			return []byte(at.OID), nil
+		}
+		return n, nil
+	}
+	r.NormalizeAssertion = nil
+	return &r
+}
+
+// attributeSelector matches the attribute descriptions of an entry against
+// the attribute description of a filter item.
+type attributeSelector struct {
+	all     bool            // match any attribute type
+	types   map[string]bool // names and OIDs of the type and its subtypes
+	options []string        // options or language ranges that must be present
+}
+
+// newAttributeSelector returns a selector for the attribute description.
+// It returns false if a schema is given and the attribute type is not in it.
+func newAttributeSelector(s *Schema, attr string) (*attributeSelector, bool) {
+	typ, opts, _ := strings.Cut(strings.ToLower(attr), ";")
+	sel := &attributeSelector{types: make(map[string]bool)}
+	if opts != "" {
+		sel.options = strings.Split(opts, ";")
+	}
+	if s == nil {
+		sel.types[typ] = true
+		return sel, true
+	}
+	at := s.AttributeType(typ)
+	if at == nil {
+		return nil, false
+	}
+	for _, t := range s.AttributeTypes() {
+		if t == at || s.isSubtype(t, at) {
+			sel.addType(t)
+		}
+	}
+	return sel, true
+}
+
+func (sel *attributeSelector) addType(at *AttributeType) {
+	sel.types[at.OID] = true
+	for _, n := range at.Names {
+		// Entries usually use the names as defined so keep them to avoid
+		// lower casing on every match.
+		sel.types[n] = true
+		sel.types[strings.ToLower(n)] = true
+	}
+}
+
+// isSubtype returns true if at is a direct or indirect subtype of super.
+func (s *Schema) isSubtype(at, super *AttributeType) bool {
+	for depth := 0; at.SuperType != "" && depth < 16; depth++ {
+		at = s.AttributeType(at.SuperType)
+		if at == nil {
+			return false
+		}
+		if at == super {
+			return true
+		}
+	}
+	return false
+}
+
+func (sel *attributeSelector) matches(name string) bool {
+	typ, opts, _ := strings.Cut(name, ";")
+	if !sel.all && !sel.types[typ] && !sel.types[strings.ToLower(typ)] {
+		return false
+	}
+	return optionsSelected(sel.options, opts)
+}
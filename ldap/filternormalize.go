@@ -0,0 +1,144 @@
+package ldap
+
+import (
+	"sort"
+	"strings"
+)
+
+// NormalizeFilter returns an equivalent filter in canonical form such that
+// filters that differ only in structure or in how attributes are written
+// have the same String, which makes it usable as a cache key. The filter
+// is not modified. The normalized filter:
+//
+//   - has nested AND and OR filters flattened and single element AND and
+//     OR filters replaced by the element
+//   - has duplicate elements of AND and OR filters removed and the rest
+//     sorted by their string representation
+//   - has absolute true and false filters (RFC 4526) folded into the
+//     surrounding AND and OR filters
+//   - has NOT pushed inward through AND and OR filters using De Morgan's
+//     laws and double negations removed, both of which hold under the three
+//     valued logic of filters (RFC 4511 section 4.5.1.7)
+//   - uses the lower case primary names from the schema for attribute
+//     types and matching rules, and lower cased sorted options
+//
+// Attribute values are not changed. If the schema is nil then names are
+// only lower cased.
+func NormalizeFilter(f Filter, s *Schema) Filter {
+	return normalizeFilter(f, s, false)
+}
+
+// normalizeFilter returns the normalized filter or its negation if negate
+// is true.
+func normalizeFilter(f Filter, s *Schema, negate bool) Filter {
+	switch f := f.(type) {
+	case *AND:
+		if negate {
+			return normalizeJunction(f.Filters, s, true, false)
+		}
+		return normalizeJunction(f.Filters, s, false, true)
+	case *OR:
+		if negate {
+			return normalizeJunction(f.Filters, s, true, true)
+		}
+		return normalizeJunction(f.Filters, s, false, false)
+	case *NOT:
+		return normalizeFilter(f.Filter, s, !negate)
+	}
+	n := normalizeItem(f, s)
+	if negate {
+		return &NOT{Filter: n}
+	}
+	return n
+}
+
+// normalizeJunction normalizes the filters (negating each if negate is
+// true) and combines them into an AND if and is true or an OR otherwise.
+func normalizeJunction(filters []Filter, s *Schema, negate, and bool) Filter {
+	seen := make(map[string]bool, len(filters))
+	var out []Filter
+	for _, f := range filters {
+		n := normalizeFilter(f, s, negate)
+		switch {
+		case and && IsAbsoluteFalse(n):
+			return AbsoluteFalse()
+		case !and && IsAbsoluteTrue(n):
+			return AbsoluteTrue()
+		}
+		// Normalized filters are already flat so only the top level needs
+		// to be merged. This also drops absolute true from AND and
+		// absolute false from OR.
+		items := []Filter{n}
+		if a, ok := n.(*AND); ok && and {
+			items = a.Filters
+		} else if o, ok := n.(*OR); ok && !and {
+			items = o.Filters
+		}
+		for _, it := range items {
+			if key := it.String(); !seen[key] {
+				seen[key] = true
+				out = append(out, it)
+			}
+		}
+	}
+	if len(out) == 0 {
+		if and {
+			return AbsoluteTrue()
+		}
+		return AbsoluteFalse()
+	}
+	if len(out) == 1 {
+		return out[0]
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].String() < out[j].String()
+	})
+	if and {
+		return &AND{Filters: out}
+	}
+	return &OR{Filters: out}
+}
+
+// normalizeItem returns a copy of a filter item with normalized names.
+// Unknown filter types are returned unchanged.
+func normalizeItem(f Filter, s *Schema) Filter {
+	switch f := f.(type) {
+	case *EqualityMatch:
+		return &EqualityMatch{Attribute: normalizeAttributeName(f.Attribute, s), Value: f.Value}
+	case *GreaterOrEqual:
+		return &GreaterOrEqual{Attribute: normalizeAttributeName(f.Attribute, s), Value: f.Value}
+	case *LessOrEqual:
+		return &LessOrEqual{Attribute: normalizeAttributeName(f.Attribute, s), Value: f.Value}
+	case *ApproxMatch:
+		return &ApproxMatch{Attribute: normalizeAttributeName(f.Attribute, s), Value: f.Value}
+	case *Present:
+		return &Present{Attribute: normalizeAttributeName(f.Attribute, s)}
+	case *Substrings:
+		return &Substrings{Attribute: normalizeAttributeName(f.Attribute, s), Initial: f.Initial, Any: f.Any, Final: f.Final}
+	case *ExtensibleMatch:
+		n := &ExtensibleMatch{Value: f.Value, DNAttributes: f.DNAttributes}
+		if f.Attribute != "" {
+			n.Attribute = normalizeAttributeName(f.Attribute, s)
+		}
+		if f.MatchingRule != "" {
+			n.MatchingRule = strings.ToLower(f.MatchingRule)
+			if s != nil {
+				if mr := s.MatchingRule(f.MatchingRule); mr != nil {
+					n.MatchingRule = strings.ToLower(mr.Name())
+				}
+			}
+		}
+		return n
+	}
+	return f
+}
+
+// normalizeAttributeName returns the normalized attribute description or
+// the lower cased name if it isn't a valid attribute description.
+func normalizeAttributeName(name string, s *Schema) string {
+	d, err := ParseAttributeDescription(name)
+	if err != nil {
+		return strings.ToLower(name)
+	}
+	return d.Normalize(s).String()
+}
@@ -0,0 +1,80 @@
+package ldap
+
+import "testing"
+
+func TestNormalizeFilter(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		filter string
+		schema *Schema
+		want   string
+	}{
+		{"(cn=Foo)", nil, "(cn=Foo)"},
+		{"(CN;Lang-DE;binary=Foo)", nil, "(cn;binary;lang-de=Foo)"},
+		{"(commonName=Foo)", DefaultSchema, "(cn=Foo)"},
+		{"(2.5.4.3=*)", DefaultSchema, "(cn=*)"},
+		{"(commonName=Foo)", nil, "(commonname=Foo)"},
+		{"(&(&(b=1)(a=1))(&(c=1)(a=1)))", nil, "(&(a=1)(b=1)(c=1))"},
+		{"(|(b=1)(|(a=1)(b=1)))", nil, "(|(a=1)(b=1))"},
+		{"(&(a=1))", nil, "(a=1)"},
+		{"(&(a=1)(&))", nil, "(a=1)"},
+		{"(&(a=1)(|))", nil, "(|)"},
+		{"(|(a=1)(&))", nil, "(&)"},
+		{"(|(a=1)(|))", nil, "(a=1)"},
+		{"(&(&)(&))", nil, "(&)"},
+		{"(!(!(a=1)))", nil, "(a=1)"},
+		{"(!(&(a=1)(b=1)))", nil, "(|(!(a=1))(!(b=1)))"},
+		{"(!(|(a=1)(!(b=1))))", nil, "(&(!(a=1))(b=1))"},
+		{"(!(&))", nil, "(|)"},
+		{"(!(|(a=1)(&)))", nil, "(|)"},
+		{"(&(a=1)(!(|(b=1)(c=1))))", nil, "(&(!(b=1))(!(c=1))(a=1))"},
+		{"(|(a=1)(!(&(b=1)(c=1))))", nil, "(|(!(b=1))(!(c=1))(a=1))"},
+		{"(CN:DN:CaseExactMatch:=Foo)", nil, "(cn:dn:caseexactmatch:=Foo)"},
+		{"(commonName:2.5.13.5:=Foo)", DefaultSchema, "(cn:caseexactmatch:=Foo)"},
+		{"(&(uidNumber>=1)(uidnumber<=2)(cn~=a)(cn=a*b*c)(cn=a*b*c))", nil, "(&(cn=a*b*c)(cn~=a)(uidnumber<=2)(uidnumber>=1))"},
+	}
+	for _, c := range cases {
+		f, err := ParseFilter(c.filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%s) failed: %s", c.filter, err)
+		}
+		orig := f.String()
+		n := NormalizeFilter(f, c.schema)
+		if s := n.String(); s != c.want {
+			t.Errorf("NormalizeFilter(%s) = %s, want %s", c.filter, s, c.want)
+		}
+		if f.String() != orig {
+			t.Errorf("NormalizeFilter modified %s to %s", orig, f)
+		}
+		if s := NormalizeFilter(n, c.schema).String(); s != c.want {
+			t.Errorf("NormalizeFilter(%s) is not stable: %s", c.want, s)
+		}
+	}
+}
+
+func TestNormalizeFilterEquivalent(t *testing.T) {
+	t.Parallel()
+	entries := []*SearchResult{
+		{DN: "cn=a", Attributes: map[string][][]byte{"cn": {[]byte("a")}, "sn": {[]byte("x")}}},
+		{DN: "cn=b", Attributes: map[string][][]byte{"cn": {[]byte("b")}}},
+		{DN: "cn=c", Attributes: map[string][][]byte{"cn": {[]byte("c")}, "uidNumber": {[]byte("nan")}}},
+	}
+	filters := []string{
+		"(!(&(cn=a)(sn=*)))",
+		"(!(|(cn=b)(!(uidNumber>=5))))",
+		"(&(!(!(cn=c)))(|(uidNumber=1)(&)))",
+		"(!(|(!(sn=x))(&(cn=a)(|))))",
+	}
+	for _, fs := range filters {
+		f, err := ParseFilter(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := NormalizeFilter(f, DefaultSchema)
+		for _, e := range entries {
+			if a, b := MatchFilter(f, e, DefaultSchema), MatchFilter(n, e, DefaultSchema); a != b {
+				t.Errorf("%s matches %s with %s but %s with %s", fs, e.DN, a, n, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,676 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IndexKind is a set of kinds of index kept for an attribute.
+type IndexKind int
+
+const (
+	// IndexPresence answers presence filters such as (mail=*).
+	IndexPresence IndexKind = 1 << iota
+	// IndexEquality answers equality and approximate filters using the
+	// equality rule of the attribute.
+	IndexEquality
+	// IndexSubstring answers substrings filters using trigrams of the
+	// values normalized by the substrings rule of the attribute.
+	IndexSubstring
+	// IndexOrdering answers greaterOrEqual and lessOrEqual filters using
+	// the values sorted by the ordering rule of the attribute.
+	IndexOrdering
+)
+
+// substringAnchor marks the start and end of values for substring trigrams
+// so that initial and final components only match at the ends.
+const substringAnchor = "\x00"
+
+// Index indexes the attribute values of entries and the hierarchy of the
+// entries for a backend. Entries are identified by non-zero IDs assigned by
+// the backend. The backend keeps the index current by calling Add, Remove,
+// Update, and Move as entries change and uses Plan to find the candidates
+// for a search.
+//
+// An index isn't safe for concurrent use but Plan may be called
+// concurrently while there are no updates.
+type Index struct {
+	schema   *Schema
+	attrs    map[string]*attributeIndex // by attribute type OID
+	parent   map[uint64]uint64
+	children map[uint64]map[uint64]struct{} // 0 for the suffixes
+}
+
+// attributeIndex holds the indexes of an attribute type including its
+// subtypes.
+type attributeIndex struct {
+	name       string
+	kinds      IndexKind
+	sel        *attributeSelector
+	equality   *MatchingRule
+	substrings *MatchingRule
+	ordering   *MatchingRule
+
+	present postings
+	values  map[string]postings // by normalized value
+	grams   map[string]postings
+	sorted  *orderedValues
+}
+
+// postings counts the values of each entry that have an index key.
+type postings map[uint64]int
+
+type orderedValue struct {
+	value []byte
+	id    uint64
+}
+
+// orderedBlockSize is the number of values a block of orderedValues is
+// split back to when it grows to twice the size.
+const orderedBlockSize = 256
+
+// orderedValues holds values sorted by an ordering rule and then by ID. The
+// values are kept in blocks so an insert or removal only moves the values
+// of one block.
+type orderedValues struct {
+	compare func(a, b []byte) int
+	blocks  [][]orderedValue
+}
+
+func (s *orderedValues) less(a, b orderedValue) bool {
+	c := s.compare(a.value, b.value)
+	return c < 0 || c == 0 && a.id < b.id
+}
+
+// search returns the block that would hold v and the position in it.
+func (s *orderedValues) search(v orderedValue) (int, int) {
+	i := sort.Search(len(s.blocks), func(i int) bool {
+		b := s.blocks[i]
+		return !s.less(b[len(b)-1], v)
+	})
+	if i == len(s.blocks) {
+		if i == 0 {
+			return 0, 0
+		}
+		i--
+		return i, len(s.blocks[i])
+	}
+	b := s.blocks[i]
+	return i, sort.Search(len(b), func(j int) bool { return !s.less(b[j], v) })
+}
+
+func (s *orderedValues) insert(v orderedValue) {
+	if len(s.blocks) == 0 {
+		s.blocks = [][]orderedValue{{v}}
+		return
+	}
+	i, j := s.search(v)
+	b := append(s.blocks[i], orderedValue{})
+	copy(b[j+1:], b[j:])
+	b[j] = v
+	if len(b) < 2*orderedBlockSize {
+		s.blocks[i] = b
+		return
+	}
+	s.blocks = append(s.blocks, nil)
+	copy(s.blocks[i+2:], s.blocks[i+1:])
+	s.blocks[i] = b[:orderedBlockSize:orderedBlockSize]
+	s.blocks[i+1] = append([]orderedValue(nil), b[orderedBlockSize:]...)
+}
+
+func (s *orderedValues) remove(v orderedValue) {
+	i, j := s.search(v)
+	if i == len(s.blocks) || j == len(s.blocks[i]) || s.less(v, s.blocks[i][j]) {
+		return
+	}
+	b := append(s.blocks[i][:j], s.blocks[i][j+1:]...)
+	if len(b) == 0 {
+		s.blocks = append(s.blocks[:i], s.blocks[i+1:]...)
+	} else {
+		s.blocks[i] = b
+	}
+}
+
+// ids returns the IDs of the values greater than or equal to a, or less
+// than or equal to a if greater is false.
+func (s *orderedValues) ids(a []byte, greater bool) postings {
+	p := make(postings)
+	for _, b := range s.blocks {
+		var i, j int
+		if greater {
+			i = sort.Search(len(b), func(i int) bool { return s.compare(b[i].value, a) >= 0 })
+			j = len(b)
+		} else {
+			j = sort.Search(len(b), func(i int) bool { return s.compare(b[i].value, a) > 0 })
+		}
+		for _, v := range b[i:j] {
+			p[v.id] = 1
+		}
+	}
+	return p
+}
+
+// NewIndex returns an empty index with the given kinds of index for each
+// attribute. An error is returned if an attribute isn't in the schema or
+// doesn't have a matching rule for a kind of index.
+func NewIndex(s *Schema, attrs map[string]IndexKind) (*Index, error) {
+	x := &Index{
+		schema:   s,
+		attrs:    make(map[string]*attributeIndex),
+		parent:   make(map[uint64]uint64),
+		children: make(map[uint64]map[uint64]struct{}),
+	}
+	for name, kinds := range attrs {
+		if strings.Contains(name, ";") {
+			return nil, fmt.Errorf("ldap: index attribute %q has options", name)
+		}
+		key, ok := x.key(name)
+		if !ok {
+			return nil, fmt.Errorf("ldap: index attribute %q not in schema", name)
+		}
+		if x.attrs[key] != nil {
+			return nil, fmt.Errorf("ldap: attribute %q indexed twice", name)
+		}
+		sel, _ := newAttributeSelector(s, name)
+		ai := &attributeIndex{
+			name:    name,
+			kinds:   kinds,
+			sel:     sel,
+			present: make(postings),
+		}
+		if kinds&IndexEquality != 0 {
+			ai.equality = filterRule(s, name, EqualityMatchingRule)
+			if ai.equality == nil || ai.equality.MatchFunc != nil {
+				return nil, fmt.Errorf("ldap: attribute %q has no equality rule that can be indexed", name)
+			}
+			ai.values = make(map[string]postings)
+		}
+		if kinds&IndexSubstring != 0 {
+			if ai.substrings = filterRule(s, name, SubstringsMatchingRule); ai.substrings == nil {
+				return nil, fmt.Errorf("ldap: attribute %q has no substrings rule", name)
+			}
+			ai.grams = make(map[string]postings)
+		}
+		if kinds&IndexOrdering != 0 {
+			if ai.ordering = filterRule(s, name, OrderingMatchingRule); ai.ordering == nil {
+				return nil, fmt.Errorf("ldap: attribute %q has no ordering rule", name)
+			}
+			ai.sorted = &orderedValues{compare: ai.ordering.CompareFunc}
+			if ai.sorted.compare == nil {
+				ai.sorted.compare = bytes.Compare
+			}
+		}
+		x.attrs[key] = ai
+	}
+	return x, nil
+}
+
+// key returns the key of the attribute type of an attribute description.
+func (x *Index) key(attr string) (string, bool) {
+	typ, _, _ := strings.Cut(strings.ToLower(attr), ";")
+	if x.schema == nil {
+		return typ, true
+	}
+	at := x.schema.AttributeType(typ)
+	if at == nil {
+		return "", false
+	}
+	return at.OID, true
+}
+
+// lookup returns the index of the attribute if it has the kind of index.
+func (x *Index) lookup(attr string, kind IndexKind) *attributeIndex {
+	key, ok := x.key(attr)
+	if !ok {
+		return nil
+	}
+	if ai := x.attrs[key]; ai != nil && ai.kinds&kind != 0 {
+		return ai
+	}
+	return nil
+}
+
+// Add indexes an entry below its parent which is 0 for a suffix.
+func (x *Index) Add(id, parent uint64, e Matchable) {
+	x.link(id, parent)
+	x.update(id, e, 1)
+}
+
+// Remove removes an entry which must not have subordinates.
+func (x *Index) Remove(id uint64, e Matchable) {
+	x.update(id, e, -1)
+	x.unlink(id)
+}
+
+// Update reindexes the attributes of an entry that changed from old to e.
+func (x *Index) Update(id uint64, old, e Matchable) {
+	x.update(id, old, -1)
+	x.update(id, e, 1)
+}
+
+// Move moves an entry and its subordinates below a new parent.
+func (x *Index) Move(id, parent uint64) {
+	x.unlink(id)
+	x.link(id, parent)
+}
+
+func (x *Index) link(id, parent uint64) {
+	x.parent[id] = parent
+	c := x.children[parent]
+	if c == nil {
+		c = make(map[uint64]struct{})
+		x.children[parent] = c
+	}
+	c[id] = struct{}{}
+}
+
+func (x *Index) unlink(id uint64) {
+	parent, ok := x.parent[id]
+	if !ok {
+		return
+	}
+	delete(x.parent, id)
+	if c := x.children[parent]; c != nil {
+		delete(c, id)
+		if len(c) == 0 {
+			delete(x.children, parent)
+		}
+	}
+}
+
+// update adds (delta 1) or removes (delta -1) the values of an entry.
+func (x *Index) update(id uint64, e Matchable, delta int) {
+	e.EntryAttributes(func(name string, values [][]byte) bool {
+		for _, ai := range x.attrs {
+			if !ai.sel.matches(name) {
+				continue
+			}
+			for _, v := range values {
+				ai.update(id, v, delta)
+			}
+		}
+		return true
+	})
+}
+
+func (ai *attributeIndex) update(id uint64, v []byte, delta int) {
+	ai.present.add(id, delta)
+	if ai.equality != nil {
+		if n, err := ai.equality.normalize(v); err == nil {
+			addPosting(ai.values, string(n), id, delta)
+		}
+	}
+	if ai.substrings != nil {
+		if n, err := ai.substrings.normalize(v); err == nil {
+			for g := range trigrams(substringAnchor + string(n) + substringAnchor) {
+				addPosting(ai.grams, g, id, delta)
+			}
+		}
+	}
+	if ai.ordering != nil {
+		if n, err := ai.ordering.normalize(v); err == nil {
+			if delta > 0 {
+				ai.sorted.insert(orderedValue{value: n, id: id})
+			} else {
+				ai.sorted.remove(orderedValue{value: n, id: id})
+			}
+		}
+	}
+}
+
+func (p postings) add(id uint64, delta int) {
+	if n := p[id] + delta; n > 0 {
+		p[id] = n
+	} else {
+		delete(p, id)
+	}
+}
+
+func addPosting(m map[string]postings, key string, id uint64, delta int) {
+	p := m[key]
+	if p == nil {
+		if delta < 0 {
+			return
+		}
+		p = make(postings)
+		m[key] = p
+	}
+	p.add(id, delta)
+	if len(p) == 0 {
+		delete(m, key)
+	}
+}
+
+func (p postings) ids() []uint64 {
+	ids := make([]uint64, 0, len(p))
+	for id := range p {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// trigrams returns the distinct substrings of three bytes of s.
+func trigrams(s string) map[string]struct{} {
+	grams := make(map[string]struct{})
+	for i := 0; i+3 <= len(s); i++ {
+		grams[s[i:i+3]] = struct{}{}
+	}
+	return grams
+}
+
+// Plan is the plan for a search found by Index.Plan. The candidates are a
+// superset of the entries in scope that match the filter so the filter
+// must still be evaluated against each of them.
+type Plan struct {
+	root  *planNode
+	scope string
+	scan  bool
+	ids   []uint64
+}
+
+// planNode is a step of a plan. Unless scan is set it restricts the
+// candidates to ids.
+type planNode struct {
+	desc     string
+	scan     bool
+	ids      []uint64
+	children []*planNode
+}
+
+// Plan returns the candidates for a search below the entry base, which is
+// 0 for the root DSE, using the indexes for the filter where possible. If
+// the filter can't be answered from the indexes every entry in scope is a
+// candidate.
+func (x *Index) Plan(base uint64, scope Scope, f Filter) *Plan {
+	p := &Plan{
+		root:  x.plan(f),
+		scope: fmt.Sprintf("%s of entry %d", scope, base),
+	}
+	if p.root.scan {
+		p.scan = true
+		p.ids = x.scopeIDs(base, scope)
+		return p
+	}
+	p.ids = make([]uint64, 0, len(p.root.ids))
+	for _, id := range p.root.ids {
+		if x.inScope(id, base, scope) {
+			p.ids = append(p.ids, id)
+		}
+	}
+	return p
+}
+
+// Candidates returns the IDs of the candidate entries in ascending order.
+func (p *Plan) Candidates() []uint64 {
+	return p.ids
+}
+
+// Explain describes the steps of the plan with the number of candidates
+// they found.
+func (p *Plan) Explain() string {
+	var b strings.Builder
+	if p.scan {
+		fmt.Fprintf(&b, "scan %s: %d candidates\n", p.scope, len(p.ids))
+	} else {
+		fmt.Fprintf(&b, "filter %s: %d candidates\n", p.scope, len(p.ids))
+	}
+	p.root.explain(&b, 1)
+	return b.String()
+}
+
+func (n *planNode) explain(b *strings.Builder, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(n.desc)
+	if n.scan {
+		b.WriteString(": scan\n")
+	} else {
+		fmt.Fprintf(b, ": %d candidates\n", len(n.ids))
+	}
+	for _, c := range n.children {
+		c.explain(b, depth+1)
+	}
+}
+
+func scanNode(desc string) *planNode {
+	return &planNode{desc: desc, scan: true}
+}
+
+func (x *Index) plan(f Filter) *planNode {
+	switch f := f.(type) {
+	case *AND:
+		n := &planNode{desc: "and"}
+		var indexed []*planNode
+		for _, sub := range f.Filters {
+			c := x.plan(sub)
+			n.children = append(n.children, c)
+			if !c.scan {
+				indexed = append(indexed, c)
+			}
+		}
+		if len(indexed) == 0 {
+			n.scan = true
+			return n
+		}
+		sort.SliceStable(indexed, func(i, j int) bool { return len(indexed[i].ids) < len(indexed[j].ids) })
+		n.ids = indexed[0].ids
+		for _, c := range indexed[1:] {
+			if len(n.ids) == 0 {
+				break
+			}
+			n.ids = intersectIDs(n.ids, c.ids)
+		}
+		return n
+	case *OR:
+		n := &planNode{desc: "or", ids: []uint64{}}
+		for _, sub := range f.Filters {
+			c := x.plan(sub)
+			n.children = append(n.children, c)
+			if c.scan {
+				n.scan = true
+			} else if !n.scan {
+				n.ids = unionIDs(n.ids, c.ids)
+			}
+		}
+		if n.scan {
+			n.ids = nil
+		}
+		return n
+	case *EqualityMatch:
+		return x.planEquality(f.String(), f.Attribute, f.Value)
+	case *ApproxMatch:
+		return x.planEquality(f.String(), f.Attribute, f.Value)
+	case *Present:
+		ai := x.lookup(f.Attribute, IndexPresence)
+		if ai == nil {
+			return scanNode(f.String() + " not indexed")
+		}
+		return &planNode{desc: f.String() + " presence index", ids: ai.present.ids()}
+	case *Substrings:
+		return x.planSubstrings(f)
+	case *GreaterOrEqual:
+		return x.planOrdering(f.String(), f.Attribute, f.Value, true)
+	case *LessOrEqual:
+		return x.planOrdering(f.String(), f.Attribute, f.Value, false)
+	case nil:
+		return scanNode("no filter")
+	}
+	return scanNode(f.String() + " not indexed")
+}
+
+func (x *Index) planEquality(desc, attr string, value []byte) *planNode {
+	ai := x.lookup(attr, IndexEquality)
+	if ai == nil {
+		return scanNode(desc + " not indexed")
+	}
+	a, err := ai.equality.normalizeAssertion(value)
+	if err != nil {
+		return &planNode{desc: desc + " invalid assertion", ids: []uint64{}}
+	}
+	return &planNode{desc: desc + " equality index", ids: ai.values[string(a)].ids()}
+}
+
+func (x *Index) planSubstrings(f *Substrings) *planNode {
+	desc := f.String()
+	ai := x.lookup(f.Attribute, IndexSubstring)
+	if ai == nil {
+		return scanNode(desc + " not indexed")
+	}
+	ini, anys, fin, err := ai.substrings.substringComponents(f.Initial, f.Any, f.Final)
+	if err != nil {
+		return &planNode{desc: desc + " invalid assertion", ids: []uint64{}}
+	}
+	grams := make(map[string]struct{})
+	add := func(s string) {
+		for g := range trigrams(s) {
+			grams[g] = struct{}{}
+		}
+	}
+	if f.Initial != "" {
+		add(substringAnchor + string(ini))
+	}
+	for _, a := range anys {
+		add(string(a))
+	}
+	if f.Final != "" {
+		add(string(fin) + substringAnchor)
+	}
+	if len(grams) == 0 {
+		// Components too short for trigrams only require a value.
+		return &planNode{desc: desc + " substring index (no trigrams)", ids: ai.present.ids()}
+	}
+	var ids []uint64
+	first := true
+	for g := range grams {
+		p := ai.grams[g]
+		if first {
+			ids = p.ids()
+			first = false
+		} else {
+			ids = intersectIDs(ids, p.ids())
+		}
+		if len(ids) == 0 {
+			break
+		}
+	}
+	return &planNode{desc: fmt.Sprintf("%s substring index (%d trigrams)", desc, len(grams)), ids: ids}
+}
+
+func (x *Index) planOrdering(desc, attr string, value []byte, greater bool) *planNode {
+	ai := x.lookup(attr, IndexOrdering)
+	if ai == nil {
+		return scanNode(desc + " not indexed")
+	}
+	a, err := ai.ordering.normalizeAssertion(value)
+	if err != nil {
+		return &planNode{desc: desc + " invalid assertion", ids: []uint64{}}
+	}
+	return &planNode{desc: desc + " ordering index", ids: ai.sorted.ids(a, greater).ids()}
+}
+
+// inScope returns true if the entry is in the scope of a search below base.
+func (x *Index) inScope(id, base uint64, scope Scope) bool {
+	parent, ok := x.parent[id]
+	if !ok {
+		return false
+	}
+	switch scope {
+	case ScopeBaseObject:
+		return base != 0 && id == base
+	case ScopeSingleLevel:
+		return parent == base
+	case ScopeWholeSubtree:
+		if id == base {
+			return true
+		}
+	case ScopeChildren:
+	default:
+		return false
+	}
+	if base == 0 {
+		return true
+	}
+	for p := parent; p != 0; p = x.parent[p] {
+		if p == base {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeIDs returns all entries in the scope of a search below base.
+func (x *Index) scopeIDs(base uint64, scope Scope) []uint64 {
+	var ids []uint64
+	if base != 0 {
+		if _, ok := x.parent[base]; !ok {
+			return ids
+		}
+	}
+	switch scope {
+	case ScopeBaseObject:
+		if base != 0 {
+			ids = append(ids, base)
+		}
+	case ScopeSingleLevel:
+		for id := range x.children[base] {
+			ids = append(ids, id)
+		}
+	case ScopeWholeSubtree, ScopeChildren:
+		if scope == ScopeWholeSubtree && base != 0 {
+			ids = append(ids, base)
+		}
+		stack := []uint64{base}
+		for len(stack) != 0 {
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for id := range x.children[p] {
+				ids = append(ids, id)
+				stack = append(stack, id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// intersectIDs returns the IDs in both sorted lists.
+func intersectIDs(a, b []uint64) []uint64 {
+	out := make([]uint64, 0, min(len(a), len(b)))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// unionIDs returns the IDs in either sorted list.
+func unionIDs(a, b []uint64) []uint64 {
+	out := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			out = append(out, a[i])
+			i++
+		case a[i] > b[j]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
@@ -0,0 +1,321 @@
+package ldap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var testIndexes = map[string]IndexKind{
+	"objectClass": IndexEquality | IndexPresence,
+	"uid":         IndexEquality,
+	"cn":          IndexEquality | IndexSubstring,
+	"mail":        IndexPresence | IndexSubstring,
+	"uidNumber":   IndexEquality | IndexOrdering,
+}
+
+var testIndexFirstNames = []string{"John", "Alice", "Bob", "Carol", "Dave", "Eve", "Mallory", "Trent"}
+var testIndexLastNames = []string{"Doe", "Smith", "Jones", "Brown", "Miller", "Davis", "García", "Wilson", "Taylor"}
+
+// newTestIndexBackend returns a directory with n people split between two
+// organizational units.
+func newTestIndexBackend(tb testing.TB, n int, indexes map[string]IndexKind) *MemoryBackend {
+	tb.Helper()
+	be, err := NewMemoryBackend("dc=example,dc=com")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if indexes != nil {
+		// Set before loading to test maintaining the index on updates.
+		if err := be.SetIndexes(indexes); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	add := func(dn string, attrs map[string][][]byte) {
+		if res := be.add(&Entry{DN: dn, Attributes: entryAttributes(attrs)}); res.Code != ResultSuccess {
+			tb.Fatalf("%s: %s", dn, res.Err())
+		}
+	}
+	add("dc=example,dc=com", map[string][][]byte{"objectClass": {[]byte("domain")}, "dc": {[]byte("example")}})
+	for _, ou := range []string{"People", "Staff"} {
+		add("ou="+ou+",dc=example,dc=com", map[string][][]byte{"objectClass": {[]byte("organizationalUnit")}, "ou": {[]byte(ou)}})
+	}
+	for i := 0; i < n; i++ {
+		first := testIndexFirstNames[i%len(testIndexFirstNames)]
+		last := testIndexLastNames[(i/len(testIndexFirstNames))%len(testIndexLastNames)]
+		uid := fmt.Sprintf("user%d", i)
+		// The uidNumbers are a permutation so they aren't indexed in order.
+		attrs := map[string][][]byte{
+			"objectClass": {[]byte("top"), []byte("person"), []byte("inetOrgPerson")},
+			"uid":         {[]byte(uid)},
+			"cn":          {[]byte(first + " " + last)},
+			"sn":          {[]byte(last)},
+			"uidNumber":   {[]byte(fmt.Sprint(1000 + i*7919%n))},
+			"description": {[]byte(fmt.Sprintf("employee %d", i%97))},
+		}
+		if i%3 != 0 {
+			attrs["mail"] = [][]byte{[]byte(strings.ToLower(first) + "." + uid + "@example.com")}
+		}
+		ou := "People"
+		if i%5 == 0 {
+			ou = "Staff"
+		}
+		add("uid="+uid+",ou="+ou+",dc=example,dc=com", attrs)
+	}
+	return be
+}
+
+func entryAttributes(attrs map[string][][]byte) []*Attribute {
+	var names []string
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out []*Attribute
+	for _, name := range names {
+		out = append(out, &Attribute{Name: name, Values: attrs[name]})
+	}
+	return out
+}
+
+var testIndexFilters = []string{
+	"(uid=user42)",
+	"(UID=USER42)",
+	"(objectClass=inetOrgPerson)",
+	"(objectClass=2.5.6.6)",
+	"(mail=*)",
+	"(!(mail=*))",
+	"(cn=*smith*)",
+	"(cn=ali*)",
+	"(cn=*doe)",
+	"(cn=a*e*h)",
+	"(cn=*o*)",
+	"(cn=*garcía*)",
+	"(mail=*user1*@example.com)",
+	"(uidNumber>=1090)",
+	"(uidNumber<=1010)",
+	"(uidNumber>=x)",
+	"(&(uidNumber>=1050)(uidNumber<=1060))",
+	"(&(cn=john*)(mail=*))",
+	"(&(cn=*jones*)(description=employee 5))",
+	"(&(description=employee 5)(sn=jones))",
+	"(|(uid=user1)(uid=user2)(cn=eve*))",
+	"(|(uid=user1)(description=employee 3))",
+	"(|)",
+	"(&)",
+	"(&(objectClass=person)(|(cn=*miller)(uidNumber<=1003))(!(uid=user1)))",
+	"(name=alice smith)",
+	"(cn~=bob jones)",
+	"(!(&(uid=user42)(uid:caseExactMatch:=USER42)))",
+	"(!(|(uidNumber>=x)(uidNumber<=1002)))",
+}
+
+func TestIndexPlan(t *testing.T) {
+	t.Parallel()
+	scan := newTestIndexBackend(t, 300, nil)
+	indexed := newTestIndexBackend(t, 300, testIndexes)
+	_, scanClient := newTestServer(t, scan)
+	_, indexedClient := newTestServer(t, indexed)
+
+	compare := func(t *testing.T) {
+		t.Helper()
+		for _, filter := range testIndexFilters {
+			for _, base := range []string{"", "dc=example,dc=com", "ou=People,dc=example,dc=com", "uid=user42,ou=People,dc=example,dc=com"} {
+				for _, scope := range []Scope{ScopeBaseObject, ScopeSingleLevel, ScopeWholeSubtree, ScopeChildren} {
+					want, wantErr := searchDNs(t, scanClient, base, scope, filter)
+					got, err := searchDNs(t, indexedClient, base, scope, filter)
+					if resultCode(err) != resultCode(wantErr) || !reflect.DeepEqual(got, want) {
+						t.Errorf("%s %q %s: expected %d entries (%v) got %d (%v)", filter, base, scope, len(want), wantErr, len(got), err)
+					}
+				}
+			}
+		}
+	}
+	compare(t)
+
+	// Updates must keep the index current.
+	for _, be := range []*MemoryBackend{scan, indexed} {
+		for _, req := range []Request{
+			&ModifyRequest{DN: "uid=user43,ou=People,dc=example,dc=com", Mods: []*Mod{
+				{Type: Replace, Name: "cn", Values: [][]byte{[]byte("Zed Smith")}},
+				{Type: Delete, Name: "mail"},
+				{Type: Increment, Name: "uidNumber", Values: [][]byte{[]byte("100")}},
+			}},
+			&ModifyDNRequest{DN: "ou=Staff,dc=example,dc=com", NewRDN: "ou=Staff", NewSuperior: "ou=People,dc=example,dc=com"},
+			&ModifyDNRequest{DN: "uid=user7,ou=People,dc=example,dc=com", NewRDN: "uid=renamed", DeleteOldRDN: true},
+			&DeleteRequest{DN: "uid=user1,ou=People,dc=example,dc=com"},
+			&DeleteRequest{DN: "uid=user10,ou=Staff,ou=People,dc=example,dc=com"},
+		} {
+			if err := be.apply(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	compare(t)
+}
+
+func TestIndexExplain(t *testing.T) {
+	t.Parallel()
+	be := newTestIndexBackend(t, 40, testIndexes)
+	f, err := ParseFilter("(&(cn=*smith*)(|(uid=user9)(uidNumber<=1001))(description=x))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := be.Explain(&SearchRequest{BaseDN: "ou=People,dc=example,dc=com", Scope: ScopeWholeSubtree, Filter: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `filter Whole Subtree of entry 2: 1 candidates
+  and: 1 candidates
+    (cn=*smith*) substring index (3 trigrams): 8 candidates
+    or: 3 candidates
+      (uid=user9) equality index: 1 candidates
+      (uidNumber<=1001) ordering index: 2 candidates
+    (description=x) not indexed: scan
+`
+	if got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	f, err = ParseFilter("(|(cn=a*)(description=x))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = be.Explain(&SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeSingleLevel, Filter: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `scan Single Level of entry 1: 2 candidates
+  or: scan
+    (cn=a*) substring index (no trigrams): 40 candidates
+    (description=x) not indexed: scan
+`
+	if got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestNewIndex(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		attrs map[string]IndexKind
+		err   string
+	}{
+		{map[string]IndexKind{"cn": IndexEquality | IndexSubstring}, ""},
+		{map[string]IndexKind{"nonexistent": IndexEquality}, `ldap: index attribute "nonexistent" not in schema`},
+		{map[string]IndexKind{"cn;lang-en": IndexEquality}, `ldap: index attribute "cn;lang-en" has options`},
+		{map[string]IndexKind{"cn": IndexOrdering}, `ldap: attribute "cn" has no ordering rule`},
+		{map[string]IndexKind{"uidNumber": IndexSubstring}, `ldap: attribute "uidNumber" has no substrings rule`},
+		{map[string]IndexKind{"cn": IndexEquality, "2.5.4.3": IndexPresence}, `indexed twice`},
+	}
+	for _, tc := range cases {
+		_, err := NewIndex(DefaultSchema, tc.attrs)
+		if tc.err == "" && err != nil {
+			t.Errorf("%v: unexpected error %s", tc.attrs, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%v: expected error %q got %v", tc.attrs, tc.err, err)
+		}
+	}
+}
+
+func TestOrderedValues(t *testing.T) {
+	t.Parallel()
+	s := &orderedValues{compare: bytes.Compare}
+	const n = 5 * orderedBlockSize
+	value := func(i int) orderedValue {
+		return orderedValue{value: []byte(fmt.Sprintf("%04d", i/2)), id: uint64(i)}
+	}
+	for i := 0; i < n; i++ {
+		s.insert(value(i * 7919 % n))
+	}
+	for i := 0; i < n; i += 3 {
+		s.remove(value(i))
+	}
+	s.remove(value(n))
+	var want, got []uint64
+	for i := 0; i < n; i++ {
+		if i%3 != 0 {
+			want = append(want, uint64(i))
+		}
+	}
+	for _, b := range s.blocks {
+		if len(b) == 0 || len(b) >= 2*orderedBlockSize {
+			t.Fatalf("block with %d values", len(b))
+		}
+		for _, v := range b {
+			got = append(got, v.id)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+	if got, want := s.ids([]byte("0010"), false).ids(), []uint64{1, 2, 4, 5, 7, 8, 10, 11, 13, 14, 16, 17, 19, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("less or equal: expected %v got %v", want, got)
+	}
+	if got := len(s.ids([]byte("0010"), true)); got != len(want)-13 {
+		t.Errorf("greater or equal: expected %d IDs got %d", len(want)-13, got)
+	}
+}
+
+func TestIndexIDs(t *testing.T) {
+	t.Parallel()
+	a := []uint64{1, 3, 5, 7}
+	b := []uint64{2, 3, 4, 7, 9}
+	if got := intersectIDs(a, b); !reflect.DeepEqual(got, []uint64{3, 7}) {
+		t.Errorf("intersect: got %v", got)
+	}
+	if got := unionIDs(a, b); !reflect.DeepEqual(got, []uint64{1, 2, 3, 4, 5, 7, 9}) {
+		t.Errorf("union: got %v", got)
+	}
+}
+
+const benchmarkIndexEntries = 100000
+
+var benchmarkIndexBackends struct {
+	once          sync.Once
+	scan, indexed *MemoryBackend
+}
+
+func benchmarkIndexSearch(b *testing.B, filter string) {
+	benchmarkIndexBackends.once.Do(func() {
+		benchmarkIndexBackends.scan = newTestIndexBackend(b, benchmarkIndexEntries, nil)
+		benchmarkIndexBackends.indexed = newTestIndexBackend(b, benchmarkIndexEntries, testIndexes)
+	})
+	f, err := ParseFilter(filter)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := &SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree, Filter: f}
+	for _, bc := range []struct {
+		name string
+		be   *MemoryBackend
+	}{
+		{"scan", benchmarkIndexBackends.scan},
+		{"index", benchmarkIndexBackends.indexed},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				res, err := bc.be.Search(context.Background(), nil, req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if res.Code != ResultSuccess {
+					b.Fatal(res.Err())
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndexAND(b *testing.B) {
+	benchmarkIndexSearch(b, "(&(objectClass=person)(cn=*smith*)(uidNumber>=90000))")
+}
+
+func BenchmarkIndexOR(b *testing.B) {
+	benchmarkIndexSearch(b, "(|(uid=user42)(uid=user4242)(mail=carol.user9*))")
+}
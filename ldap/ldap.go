@@ -1,6 +1,7 @@
 package ldap
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 )
@@ -41,6 +42,10 @@ var RootDSE = map[string][]string{
 	"supportedFeatures": {
 		OIDModifyIncrement,
 		OIDAllOperationalAttributes,
+		OIDAttributesByObjectClass,
+		OIDTrueFalseFilters,
+		OIDLanguageTagOptions,
+		OIDLanguageRangeOptions,
 	},
 	"supportedExtension": {
 		OIDWhoAmI,
@@ -204,3 +209,10 @@ type ProtocolError struct {
 func (e *ProtocolError) Error() string {
 	return "ldap: protocol error: " + e.Reason
 }
+
+// errorAsType is a generic form of errors.As.
+func errorAsType[E error](err error) (E, bool) {
+	var e E
+	ok := errors.As(err, &e)
+	return e, ok
+}
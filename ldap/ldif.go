@@ -0,0 +1,306 @@
+package ldap
+
+// https://tools.ietf.org/html/rfc2849
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LDIFSyntaxError is returned by LDIFReader for malformed input. Line is
+// the line number (starting at 1) of the offending line, or of its first
+// line if it was folded.
+type LDIFSyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *LDIFSyntaxError) Error() string {
+	return fmt.Sprintf("ldap: LDIF syntax error on line %d: %s", e.Line, e.Msg)
+}
+
+// LDIFReader reads records from an LDIF file (RFC 2849) one at a time so
+// large files don't have to be held in memory.
+type LDIFReader struct {
+	// OpenURL opens the value of an attribute given as a URL such as
+	// "jpegPhoto:< file:///tmp/photo.jpg". If nil then URL values are
+	// rejected so LDIF from an untrusted source can't read local files.
+	// Set it to OpenFileURL to allow file URLs.
+	OpenURL func(url string) (io.ReadCloser, error)
+
+	r       *bufio.Reader
+	lineNo  int // number of the last physical line read
+	pending *string
+	started bool
+	version int
+}
+
+// ldifLine is a logical (unfolded) line of an LDIF file.
+type ldifLine struct {
+	no   int
+	text string
+}
+
+// NewLDIFReader returns a reader for the LDIF in r.
+func NewLDIFReader(r io.Reader) *LDIFReader {
+	return &LDIFReader{r: bufio.NewReader(r)}
+}
+
+// Version returns the version given by the "version:" line or 0 if there
+// was none. It is only known once the first record has been read.
+func (r *LDIFReader) Version() int {
+	return r.version
+}
+
+// ReadEntry reads the next content record. It returns io.EOF when there
+// are no more records. Use ReadRecord for files with change records.
+func (r *LDIFReader) ReadEntry() (*SearchResult, error) {
+	lines, err := r.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	dn, err := r.parseDN(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	res := &SearchResult{DN: dn, Attributes: make(map[string][][]byte)}
+	for _, l := range lines[1:] {
+		name, value, err := r.parseAttrValue(l)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(name, "changetype") {
+			return nil, &LDIFSyntaxError{Line: l.no, Msg: "unexpected change record"}
+		}
+		res.addValue(name, value)
+	}
+	return res, nil
+}
+
+// ReadAddRequest reads the next content record as a request to add it.
+// It returns io.EOF when there are no more records.
+func (r *LDIFReader) ReadAddRequest() (*AddRequest, error) {
+	res, err := r.ReadEntry()
+	if err != nil {
+		return nil, err
+	}
+	return &AddRequest{DN: res.DN, Attributes: res.Attributes, order: res.order}, nil
+}
+
+// ReadLDIF reads all content records of an LDIF file.
+func ReadLDIF(rd io.Reader) ([]*SearchResult, error) {
+	r := NewLDIFReader(rd)
+	var results []*SearchResult
+	for {
+		res, err := r.ReadEntry()
+		if err == io.EOF {
+			return results, nil
+		} else if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+}
+
+// addValue adds a value to the attribute keeping the order of the
+// attributes. Names are compared case-insensitively so the first spelling
+// is used for the attribute.
+func (r *SearchResult) addValue(name string, value []byte) {
+	if _, ok := r.Attributes[name]; !ok {
+		for _, n := range r.order {
+			if strings.EqualFold(n, name) {
+				name = n
+				break
+			}
+		}
+	}
+	if _, ok := r.Attributes[name]; !ok {
+		r.order = append(r.order, name)
+	}
+	r.Attributes[name] = append(r.Attributes[name], value)
+}
+
+// readRecord returns the logical lines of the next record without comments.
+// The version line is consumed if it is the first line of the file.
+func (r *LDIFReader) readRecord() ([]ldifLine, error) {
+	var lines []ldifLine
+	for {
+		l, err := r.readLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(l.text, "#") {
+			continue
+		}
+		if l.text == "" {
+			if len(lines) != 0 {
+				break
+			}
+			continue
+		}
+		if !r.started {
+			r.started = true
+			if name, value, ok := strings.Cut(l.text, ":"); ok && strings.EqualFold(name, "version") {
+				v, err := strconv.Atoi(strings.TrimSpace(value))
+				if err != nil || v != 1 {
+					return nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("unsupported version %q", strings.TrimSpace(value))}
+				}
+				r.version = v
+				continue
+			}
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) == 0 {
+		return nil, io.EOF
+	}
+	return lines, nil
+}
+
+// readLine returns the next logical line by joining folded lines. Lines
+// that only contain whitespace are treated as empty since they separate
+// records.
+func (r *LDIFReader) readLine() (ldifLine, error) {
+	text, err := r.readPhysicalLine()
+	if err != nil {
+		return ldifLine{}, err
+	}
+	l := ldifLine{no: r.lineNo, text: text}
+	if strings.TrimSpace(text) == "" {
+		return ldifLine{no: l.no}, nil
+	}
+	if text[0] == ' ' {
+		return l, &LDIFSyntaxError{Line: l.no, Msg: "continuation line without a line to continue"}
+	}
+	var b *strings.Builder
+	for {
+		next, err := r.readPhysicalLine()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return l, err
+		}
+		if len(next) == 0 || next[0] != ' ' {
+			r.pending = &next
+			r.lineNo--
+			break
+		}
+		if b == nil {
+			b = &strings.Builder{}
+			b.WriteString(text)
+		}
+		b.WriteString(next[1:])
+	}
+	if b != nil {
+		l.text = b.String()
+	}
+	return l, nil
+}
+
+// readPhysicalLine returns the next line without the line ending.
+func (r *LDIFReader) readPhysicalLine() (string, error) {
+	if r.pending != nil {
+		s := *r.pending
+		r.pending = nil
+		r.lineNo++
+		return s, nil
+	}
+	s, err := r.r.ReadString('\n')
+	if err == io.EOF {
+		if s == "" {
+			return "", io.EOF
+		}
+	} else if err != nil {
+		return "", err
+	}
+	r.lineNo++
+	s = strings.TrimSuffix(s, "\n")
+	s = strings.TrimSuffix(s, "\r")
+	return s, nil
+}
+
+func (r *LDIFReader) parseDN(l ldifLine) (string, error) {
+	name, value, err := r.parseValue(l)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(name, "dn") {
+		return "", &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("expected dn but found %q", name)}
+	}
+	dn := string(value)
+	if _, err := ParseDN(dn); err != nil {
+		return "", &LDIFSyntaxError{Line: l.no, Msg: err.Error()}
+	}
+	return dn, nil
+}
+
+// parseAttrValue parses an attribute and value line and validates the
+// attribute description.
+func (r *LDIFReader) parseAttrValue(l ldifLine) (string, []byte, error) {
+	name, value, err := r.parseValue(l)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := ParseAttributeDescription(name); err != nil {
+		return "", nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("invalid attribute description %q", name)}
+	}
+	return name, value, nil
+}
+
+// parseValue parses a line of the form "name: value", "name:: base64", or
+// "name:< url".
+func (r *LDIFReader) parseValue(l ldifLine) (string, []byte, error) {
+	name, spec, ok := strings.Cut(l.text, ":")
+	if !ok {
+		return "", nil, &LDIFSyntaxError{Line: l.no, Msg: "missing ':'"}
+	}
+	switch {
+	case strings.HasPrefix(spec, ":"):
+		v, err := base64.StdEncoding.DecodeString(strings.TrimSpace(spec[1:]))
+		if err != nil {
+			return "", nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("invalid base64 value for %s: %s", name, err)}
+		}
+		return name, v, nil
+	case strings.HasPrefix(spec, "<"):
+		v, err := r.readURL(strings.TrimSpace(spec[1:]))
+		if err != nil {
+			return "", nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("failed to read value for %s: %s", name, err)}
+		}
+		return name, v, nil
+	}
+	return name, []byte(strings.TrimLeft(spec, " ")), nil
+}
+
+func (r *LDIFReader) readURL(rawURL string) ([]byte, error) {
+	if r.OpenURL == nil {
+		return nil, errors.New("URL values are not allowed")
+	}
+	rc, err := r.OpenURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// OpenFileURL opens file URLs. It can be used as the OpenURL function of
+// an LDIFReader for trusted LDIF.
+func OpenFileURL(rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "file" {
+		return nil, errors.New("only file URLs are supported")
+	}
+	return os.Open(u.Path)
+}
@@ -0,0 +1,159 @@
+package ldap
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLDIFReader(t *testing.T) {
+	t.Parallel()
+	photo := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(photo, []byte{0xff, 0xd8, 0xff}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ldif := "# leading comment\r\n" +
+		"version: 1\r\n" +
+		"dn: cn=Barbara Jensen, ou=Product Development, dc=airius, dc=com\r\n" +
+		"objectclass: top\r\n" +
+		"objectclass: person\r\n" +
+		"cn: Barbara Jensen\r\n" +
+		"cn;lang-de: Barbara\r\n" +
+		"description: Babs is a big sailing fan, and travels extensively in sea\r\n" +
+		" rch of perfect sailing conditions.\r\n" +
+		"# a comment\r\n" +
+		"#  that is folded\r\n" +
+		"ObjectClass: inetOrgPerson\r\n" +
+		"\r\n" +
+		"\r\n" +
+		"dn:: b3U95Za25qWt6YOoLG89QWlyaXVz\n" +
+		"ou:: 5Za25qWt6YOo\n" +
+		"jpegPhoto:< file://" + filepath.ToSlash(photo) + "\n" +
+		"description:\n" +
+		"telephoneNumber:   +1 408 555 1212\n" +
+		"\n" +
+		"dn: cn=folded\n" +
+		" ,dc=com\n" +
+		"cn: folded"
+	r := NewLDIFReader(strings.NewReader(ldif))
+	r.OpenURL = OpenFileURL
+	want := []*SearchResult{
+		{
+			DN: "cn=Barbara Jensen, ou=Product Development, dc=airius, dc=com",
+			Attributes: map[string][][]byte{
+				"objectclass": {[]byte("top"), []byte("person"), []byte("inetOrgPerson")},
+				"cn":          {[]byte("Barbara Jensen")},
+				"cn;lang-de":  {[]byte("Barbara")},
+				"description": {[]byte("Babs is a big sailing fan, and travels extensively in search of perfect sailing conditions.")},
+			},
+			order: []string{"objectclass", "cn", "cn;lang-de", "description"},
+		},
+		{
+			DN: "ou=営業部,o=Airius",
+			Attributes: map[string][][]byte{
+				"ou":              {[]byte("営業部")},
+				"jpegPhoto":       {{0xff, 0xd8, 0xff}},
+				"description":     {{}},
+				"telephoneNumber": {[]byte("+1 408 555 1212")},
+			},
+			order: []string{"ou", "jpegPhoto", "description", "telephoneNumber"},
+		},
+		{
+			DN:         "cn=folded,dc=com",
+			Attributes: map[string][][]byte{"cn": {[]byte("folded")}},
+			order:      []string{"cn"},
+		},
+	}
+	for i, w := range want {
+		res, err := r.ReadEntry()
+		if err != nil {
+			t.Fatalf("Record %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(res, w) {
+			t.Errorf("Record %d = %+v, want %+v", i, res, w)
+		}
+	}
+	if _, err := r.ReadEntry(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if r.Version() != 1 {
+		t.Errorf("Version() = %d", r.Version())
+	}
+
+	// File URLs must be allowed explicitly.
+	if _, err := ReadLDIF(strings.NewReader(ldif)); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected URL values to be rejected, got %v", err)
+	}
+}
+
+func TestLDIFReaderErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		ldif string
+		line int
+	}{
+		{"version: 2\ndn: cn=a\n", 1},
+		{"dn: cn=a\ncn: a\n\ncn: b\n", 4},
+		{"dn: cn=a\ncn a\n", 2},
+		{"dn: cn=a\n\n#\n\ndn: cn=b\ncn:: !!!\n", 6},
+		{"dn: cn=a\ncn;: a\n", 2},
+		{"dn: not a dn\n", 1},
+		{"dn: cn=a\n\n cn: a\n", 3},
+		{"dn: cn=a\njpegPhoto:< http://example.com/a.jpg\n", 2},
+		{"dn: cn=a\njpegPhoto:< file:///does/not/exist\n", 2},
+		{"dn: cn=a\njpegPhoto:< file:///etc/passwd\n", 2},
+		{"dn: cn=a\nchangetype: delete\n", 2},
+		{"dn: cn=a\n\n\n# comment\n continued\ndn: cn=b\nx y\n", 7},
+	}
+	for _, c := range cases {
+		_, err := ReadLDIF(strings.NewReader(c.ldif))
+		var serr *LDIFSyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("Expected LDIFSyntaxError for %q, got %v", c.ldif, err)
+		} else if serr.Line != c.line {
+			t.Errorf("Error for %q on line %d, want %d: %s", c.ldif, serr.Line, c.line, serr)
+		}
+	}
+}
+
+func TestLDIFReaderOpenURL(t *testing.T) {
+	t.Parallel()
+	r := NewLDIFReader(strings.NewReader("dn: cn=a\njpegPhoto:< http://example.com/a.jpg\n"))
+	r.OpenURL = func(url string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(url)), nil
+	}
+	req, err := r.ReadAddRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.DN != "cn=a" || string(req.Attributes["jpegPhoto"][0]) != "http://example.com/a.jpg" {
+		t.Errorf("ReadAddRequest() = %+v", req)
+	}
+}
+
+func TestLDIFRoundTrip(t *testing.T) {
+	t.Parallel()
+	res := &SearchResult{
+		DN: "cn=a,dc=example",
+		Attributes: map[string][][]byte{
+			"cn":        {[]byte("a"), []byte("b")},
+			"jpegPhoto": {{0, 1, 2}},
+		},
+		order: []string{"jpegPhoto", "cn"},
+	}
+	var b strings.Builder
+	if err := res.ToLDIF(&b); err != nil {
+		t.Fatal(err)
+	}
+	results, err := ReadLDIF(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !reflect.DeepEqual(results[0], res) {
+		t.Errorf("ReadLDIF(%q) = %+v", b.String(), results)
+	}
+}
@@ -0,0 +1,277 @@
+package ldap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// LDIFRecord is a record of an LDIF file as a request. Content records and
+// "changetype: add" records are *AddRequest, and the other change types are
+// *DeleteRequest, *ModifyRequest, and *ModifyDNRequest ("modrdn" and
+// "moddn").
+type LDIFRecord struct {
+	Line     int // line of the dn
+	Request  Request
+	Controls []*Control
+}
+
+// DN returns the DN of the entry the record applies to.
+func (rec *LDIFRecord) DN() string {
+	switch req := rec.Request.(type) {
+	case *AddRequest:
+		return req.DN
+	case *DeleteRequest:
+		return req.DN
+	case *ModifyRequest:
+		return req.DN
+	case *ModifyDNRequest:
+		return req.DN
+	}
+	return ""
+}
+
+// ReadRecord reads the next content or change record. It returns io.EOF
+// when there are no more records.
+func (r *LDIFReader) ReadRecord() (*LDIFRecord, error) {
+	lines, err := r.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	dn, err := r.parseDN(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	rec := &LDIFRecord{Line: lines[0].no}
+	lines = lines[1:]
+	for len(lines) != 0 && lineName(lines[0]) == "control" {
+		c, err := r.parseControl(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		rec.Controls = append(rec.Controls, c)
+		lines = lines[1:]
+	}
+	changeType, changeLine := "add", rec.Line
+	if len(lines) != 0 && lineName(lines[0]) == "changetype" {
+		changeLine = lines[0].no
+		_, v, err := r.parseValue(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		changeType = strings.ToLower(string(v))
+		lines = lines[1:]
+	} else if len(rec.Controls) != 0 {
+		return nil, &LDIFSyntaxError{Line: rec.Line, Msg: "controls are only allowed in change records"}
+	}
+	switch changeType {
+	case "add":
+		req := &AddRequest{DN: dn, Attributes: make(map[string][][]byte)}
+		res := &SearchResult{Attributes: req.Attributes}
+		for _, l := range lines {
+			name, value, err := r.parseAttrValue(l)
+			if err != nil {
+				return nil, err
+			}
+			res.addValue(name, value)
+		}
+		req.order = res.order
+		rec.Request = req
+	case "delete":
+		if len(lines) != 0 {
+			return nil, &LDIFSyntaxError{Line: lines[0].no, Msg: "unexpected line in delete record"}
+		}
+		rec.Request = &DeleteRequest{DN: dn}
+	case "modrdn", "moddn":
+		rec.Request, err = r.parseModifyDN(dn, rec.Line, lines)
+	case "modify":
+		rec.Request, err = r.parseModify(dn, lines)
+	default:
+		return nil, &LDIFSyntaxError{Line: changeLine, Msg: fmt.Sprintf("unknown changetype %q", changeType)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// lineName returns the lower cased name of a "name: value" line.
+func lineName(l ldifLine) string {
+	name, _, _ := strings.Cut(l.text, ":")
+	return strings.ToLower(name)
+}
+
+// parseControl parses a control line of the form
+// "control: oid [true|false][: value]".
+func (r *LDIFReader) parseControl(l ldifLine) (*Control, error) {
+	_, spec, _ := strings.Cut(l.text, ":")
+	spec = strings.TrimLeft(spec, " ")
+	c := &Control{}
+	end := strings.IndexAny(spec, " :")
+	if end < 0 {
+		end = len(spec)
+	}
+	c.Type, spec = spec[:end], spec[end:]
+	if !isNumericOID(c.Type) {
+		return nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("invalid control type %q", c.Type)}
+	}
+	if strings.HasPrefix(spec, " ") {
+		crit := strings.TrimLeft(spec, " ")
+		end := strings.IndexByte(crit, ':')
+		if end < 0 {
+			end = len(crit)
+		}
+		switch crit[:end] {
+		case "true":
+			c.Criticality = true
+		case "false", "":
+		default:
+			return nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("invalid control criticality %q", crit[:end])}
+		}
+		spec = crit[end:]
+	}
+	if spec != "" {
+		_, v, err := r.parseValue(ldifLine{no: l.no, text: "control" + spec})
+		if err != nil {
+			return nil, err
+		}
+		c.Value = v
+	}
+	return c, nil
+}
+
+func (r *LDIFReader) parseModifyDN(dn string, line int, lines []ldifLine) (*ModifyDNRequest, error) {
+	req := &ModifyDNRequest{DN: dn}
+	var hasRDN, hasDelete bool
+	for _, l := range lines {
+		name, v, err := r.parseValue(l)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(name) {
+		case "newrdn":
+			req.NewRDN = string(v)
+			hasRDN = true
+		case "deleteoldrdn":
+			switch string(v) {
+			case "0":
+			case "1":
+				req.DeleteOldRDN = true
+			default:
+				return nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("deleteoldrdn must be 0 or 1 but is %q", v)}
+			}
+			hasDelete = true
+		case "newsuperior":
+			req.NewSuperior = string(v)
+			if _, err := ParseDN(req.NewSuperior); err != nil {
+				return nil, &LDIFSyntaxError{Line: l.no, Msg: err.Error()}
+			}
+		default:
+			return nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("unexpected %q in modrdn record", name)}
+		}
+	}
+	if !hasRDN || !hasDelete {
+		return nil, &LDIFSyntaxError{Line: line, Msg: "modrdn record requires newrdn and deleteoldrdn"}
+	}
+	return req, nil
+}
+
+var ldifModTypes = map[string]ModType{
+	"add":       Add,
+	"delete":    Delete,
+	"replace":   Replace,
+	"increment": Increment,
+}
+
+func (r *LDIFReader) parseModify(dn string, lines []ldifLine) (*ModifyRequest, error) {
+	req := &ModifyRequest{DN: dn}
+	var mod *Mod
+	for _, l := range lines {
+		if strings.TrimRight(l.text, " ") == "-" {
+			if mod == nil {
+				return nil, &LDIFSyntaxError{Line: l.no, Msg: "unexpected '-'"}
+			}
+			mod = nil
+			continue
+		}
+		name, v, err := r.parseValue(l)
+		if err != nil {
+			return nil, err
+		}
+		if mod == nil {
+			typ, ok := ldifModTypes[strings.ToLower(name)]
+			if !ok {
+				return nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("unknown modification type %q", name)}
+			}
+			if _, err := ParseAttributeDescription(string(v)); err != nil {
+				return nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("invalid attribute description %q", v)}
+			}
+			mod = &Mod{Type: typ, Name: string(v)}
+			req.Mods = append(req.Mods, mod)
+			continue
+		}
+		if !strings.EqualFold(name, mod.Name) {
+			return nil, &LDIFSyntaxError{Line: l.no, Msg: fmt.Sprintf("expected a value for %s or '-' but found %q", mod.Name, name)}
+		}
+		mod.Values = append(mod.Values, v)
+	}
+	return req, nil
+}
+
+// ApplyOptions control how ApplyLDIF applies records.
+type ApplyOptions struct {
+	// ContinueOnError applies the remaining records after a record fails.
+	// Malformed records always stop processing.
+	ContinueOnError bool
+	// DryRun reads and validates the records without sending them to the
+	// server.
+	DryRun bool
+}
+
+// ApplyResult is the outcome of applying a record.
+type ApplyResult struct {
+	Record *LDIFRecord
+	Err    error // nil if the record was applied
+}
+
+// ApplyLDIF reads the records of an LDIF file and applies them in order,
+// sending any controls with the requests. It returns a result for every
+// record that was read. The returned error is set if reading the LDIF
+// failed, or if applying a record failed and ContinueOnError isn't set.
+// The client may be nil for a dry run. If opts is nil the default options
+// are used.
+func ApplyLDIF(c *Client, r *LDIFReader, opts *ApplyOptions) ([]*ApplyResult, error) {
+	if opts == nil {
+		opts = &ApplyOptions{}
+	}
+	var results []*ApplyResult
+	for {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			if err == io.EOF {
+				return results, nil
+			}
+			return results, err
+		}
+		res := &ApplyResult{Record: rec}
+		results = append(results, res)
+		if opts.DryRun {
+			continue
+		}
+		if res.Err = c.applyRecord(rec); res.Err != nil && !opts.ContinueOnError {
+			return results, fmt.Errorf("ldap: failed to apply record for %s on line %d: %w", rec.DN(), rec.Line, res.Err)
+		}
+	}
+}
+
+func (c *Client) applyRecord(rec *LDIFRecord) error {
+	pkt, err := c.request(&controlledRequest{Request: rec.Request, controls: rec.Controls})
+	if err != nil {
+		return err
+	}
+	var res BaseResponse
+	if err := parseBaseResponse(pkt, &res); err != nil {
+		return err
+	}
+	return res.Err()
+}
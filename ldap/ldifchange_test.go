@@ -0,0 +1,293 @@
+package ldap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testChangeLDIF = `version: 1
+# Add a new entry
+dn: cn=Fiona Jensen, ou=Marketing, dc=airius, dc=com
+changetype: add
+objectclass: top
+objectclass: person
+cn: Fiona Jensen
+sn: Jensen
+
+# Delete an existing entry
+dn: cn=Robert Jensen, ou=Marketing, dc=airius, dc=com
+control: 1.2.840.113556.1.4.805 true
+changetype: delete
+
+# Modify an entry's relative distinguished name
+dn: cn=Paul Jensen, ou=Product Development, dc=airius, dc=com
+changetype: modrdn
+newrdn: cn=Paula Jensen
+deleteoldrdn: 1
+
+# Rename an entry and move all of its children to a new location
+dn: ou=PD Accountants, ou=Product Development, dc=airius, dc=com
+changetype: moddn
+newrdn: ou=Product Development Accountants
+deleteoldrdn: 0
+newsuperior: ou=Accounting, dc=airius, dc=com
+
+# Modify an entry: add an additional value to the postaladdress
+# attribute, completely delete the description attribute, replace
+# the telephonenumber attribute with two values, and delete a specific
+# value from the facsimiletelephonenumber attribute
+dn: cn=Paula Jensen, ou=Product Development, dc=airius, dc=com
+control: 1.3.6.1.4.1.4203.1.10.1 false:: AAE=
+changetype: modify
+add: postaladdress
+postaladdress: 123 Anystreet $ Sunnyvale, CA $ 94086
+-
+delete: description
+-
+replace: telephonenumber
+telephonenumber: +1 408 555 1234
+telephonenumber: +1 408 555 5678
+-
+delete: facsimiletelephonenumber
+facsimiletelephonenumber: +1 408 555 9876
+-
+increment: uidNumber
+uidNumber: 1
+
+# A content record is an add
+dn: cn=content,dc=com
+cn: content
+`
+
+func TestLDIFReaderRecords(t *testing.T) {
+	t.Parallel()
+	r := NewLDIFReader(strings.NewReader(testChangeLDIF))
+	want := []*LDIFRecord{
+		{Line: 3, Request: &AddRequest{
+			DN: "cn=Fiona Jensen, ou=Marketing, dc=airius, dc=com",
+			Attributes: map[string][][]byte{
+				"objectclass": {[]byte("top"), []byte("person")},
+				"cn":          {[]byte("Fiona Jensen")},
+				"sn":          {[]byte("Jensen")},
+			},
+			order: []string{"objectclass", "cn", "sn"},
+		}},
+		{
+			Line:     11,
+			Request:  &DeleteRequest{DN: "cn=Robert Jensen, ou=Marketing, dc=airius, dc=com"},
+			Controls: []*Control{{Type: "1.2.840.113556.1.4.805", Criticality: true}},
+		},
+		{Line: 16, Request: &ModifyDNRequest{
+			DN:           "cn=Paul Jensen, ou=Product Development, dc=airius, dc=com",
+			NewRDN:       "cn=Paula Jensen",
+			DeleteOldRDN: true,
+		}},
+		{Line: 22, Request: &ModifyDNRequest{
+			DN:          "ou=PD Accountants, ou=Product Development, dc=airius, dc=com",
+			NewRDN:      "ou=Product Development Accountants",
+			NewSuperior: "ou=Accounting, dc=airius, dc=com",
+		}},
+		{
+			Line: 32,
+			Request: &ModifyRequest{DN: "cn=Paula Jensen, ou=Product Development, dc=airius, dc=com", Mods: []*Mod{
+				{Type: Add, Name: "postaladdress", Values: [][]byte{[]byte("123 Anystreet $ Sunnyvale, CA $ 94086")}},
+				{Type: Delete, Name: "description"},
+				{Type: Replace, Name: "telephonenumber", Values: [][]byte{[]byte("+1 408 555 1234"), []byte("+1 408 555 5678")}},
+				{Type: Delete, Name: "facsimiletelephonenumber", Values: [][]byte{[]byte("+1 408 555 9876")}},
+				{Type: Increment, Name: "uidNumber", Values: [][]byte{[]byte("1")}},
+			}},
+			Controls: []*Control{{Type: "1.3.6.1.4.1.4203.1.10.1", Value: []byte{0, 1}}},
+		},
+		{Line: 51, Request: &AddRequest{
+			DN:         "cn=content,dc=com",
+			Attributes: map[string][][]byte{"cn": {[]byte("content")}},
+			order:      []string{"cn"},
+		}},
+	}
+	for i, w := range want {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			t.Fatalf("Record %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(rec, w) {
+			t.Errorf("Record %d = %+v (%+v), want %+v (%+v)", i, rec, rec.Request, w, w.Request)
+		}
+	}
+	if _, err := NewLDIFReader(strings.NewReader(testChangeLDIF)).ReadEntry(); err == nil {
+		t.Error("ReadEntry should fail for a change record")
+	}
+}
+
+func TestLDIFReaderRecordErrors(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		ldif string
+		line int
+	}{
+		{"dn: cn=a\nchangetype: rename\n", 2},
+		{"dn: cn=a\ncontrol: 1.2.3\ncn: a\n", 1},
+		{"dn: cn=a\ncontrol: foo\nchangetype: delete\n", 2},
+		{"dn: cn=a\ncontrol: 1.2.3 maybe\nchangetype: delete\n", 2},
+		{"dn: cn=a\nchangetype: delete\ncn: a\n", 3},
+		{"dn: cn=a\nchangetype: modrdn\nnewrdn: cn=b\n", 1},
+		{"dn: cn=a\nchangetype: modrdn\nnewrdn: cn=b\ndeleteoldrdn: yes\n", 4},
+		{"dn: cn=a\nchangetype: modrdn\nnewrdn: cn=b\ndeleteoldrdn: 0\nnewsuperior: bad\n", 5},
+		{"dn: cn=a\nchangetype: modify\nadd: cn\nsn: a\n", 4},
+		{"dn: cn=a\nchangetype: modify\n-\n", 3},
+		{"dn: cn=a\nchangetype: modify\nmerge: cn\n", 3},
+		{"dn: cn=a\nchangetype: modify\nadd: c n\n", 3},
+		{"dn: cn=a\nchangetype: add\nc n: a\n", 3},
+	}
+	for _, c := range cases {
+		_, err := NewLDIFReader(strings.NewReader(c.ldif)).ReadRecord()
+		var serr *LDIFSyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("Expected LDIFSyntaxError for %q, got %v", c.ldif, err)
+		} else if serr.Line != c.line {
+			t.Errorf("Error for %q on line %d, want %d: %s", c.ldif, serr.Line, c.line, serr)
+		}
+	}
+}
+
+func TestControlledRequest(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	req := &controlledRequest{
+		Request: &DeleteRequest{DN: "cn=a"},
+		controls: []*Control{
+			{Type: "1.2.3", Criticality: true},
+			{Type: "1.2.4", Value: []byte("v")},
+		},
+	}
+	if err := req.WritePackets(&buf, 7); err != nil {
+		t.Fatal(err)
+	}
+	pkt, _, err := ParsePacket(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkt.Items) != 3 {
+		t.Fatalf("Expected 3 items in message, got %d", len(pkt.Items))
+	}
+	if id, _ := pkt.Items[0].Int(); id != 7 {
+		t.Errorf("msgID = %d", id)
+	}
+	if dn, _ := pkt.Items[1].Str(); dn != "cn=a" || pkt.Items[1].Tag != ApplicationDelRequest {
+		t.Errorf("Unexpected operation %+v", pkt.Items[1])
+	}
+	controls := pkt.Items[2]
+	if controls.Class != ClassContext || controls.Tag != 0 || len(controls.Items) != 2 {
+		t.Fatalf("Unexpected controls %+v", controls)
+	}
+	if typ, _ := controls.Items[0].Items[0].Str(); typ != "1.2.3" || len(controls.Items[0].Items) != 2 {
+		t.Errorf("Unexpected control %+v", controls.Items[0])
+	}
+	if crit, _ := controls.Items[0].Items[1].Bool(); !crit {
+		t.Error("Expected critical control")
+	}
+	if v, _ := controls.Items[1].Items[1].Bytes(); string(v) != "v" || len(controls.Items[1].Items) != 2 {
+		t.Errorf("Unexpected control %+v", controls.Items[1])
+	}
+}
+
+type changeBackend struct {
+	Backend
+	mu  sync.Mutex
+	ops []string
+}
+
+// SupportsControl accepts all controls so the records are applied with
+// theirs.
+func (be *changeBackend) SupportsControl(oid string) bool {
+	return true
+}
+
+func (be *changeBackend) record(op, dn string) error {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	be.ops = append(be.ops, op+" "+dn)
+	if strings.HasPrefix(dn, "cn=Robert") {
+		return &BaseResponse{Code: ResultNoSuchObject}
+	}
+	return nil
+}
+
+func (be *changeBackend) Add(ctx context.Context, state State, req *AddRequest) (*AddResponse, error) {
+	res := &AddResponse{}
+	if err := be.record("add", req.DN); err != nil {
+		res.BaseResponse = *err.(*BaseResponse)
+	}
+	return res, nil
+}
+
+func (be *changeBackend) Delete(ctx context.Context, state State, req *DeleteRequest) (*DeleteResponse, error) {
+	res := &DeleteResponse{}
+	if err := be.record("delete", req.DN); err != nil {
+		res.BaseResponse = *err.(*BaseResponse)
+	}
+	return res, nil
+}
+
+func (be *changeBackend) Modify(ctx context.Context, state State, req *ModifyRequest) (*ModifyResponse, error) {
+	be.record("modify", req.DN)
+	return &ModifyResponse{}, nil
+}
+
+func (be *changeBackend) ModifyDN(ctx context.Context, state State, req *ModifyDNRequest) (*ModifyDNResponse, error) {
+	be.record("moddn", req.DN+" "+req.NewRDN+" "+req.NewSuperior)
+	return &ModifyDNResponse{}, nil
+}
+
+func TestApplyLDIF(t *testing.T) {
+	t.Parallel()
+	be := &changeBackend{Backend: DebugBackend}
+	_, c := newTestServer(t, be)
+
+	results, err := ApplyLDIF(nil, NewLDIFReader(strings.NewReader(testChangeLDIF)), &ApplyOptions{DryRun: true})
+	if err != nil || len(results) != 6 || len(be.ops) != 0 {
+		t.Fatalf("Dry run = %d results, %v, ops %q", len(results), err, be.ops)
+	}
+
+	results, err = ApplyLDIF(c, NewLDIFReader(strings.NewReader(testChangeLDIF)), nil)
+	if err == nil || len(results) != 2 || results[0].Err != nil || results[1].Err == nil {
+		t.Fatalf("Expected failure on second record, got %d results, %v", len(results), err)
+	}
+	if !strings.Contains(err.Error(), "line 11") {
+		t.Errorf("Error doesn't mention the line: %s", err)
+	}
+
+	be.ops = nil
+	results, err = ApplyLDIF(c, NewLDIFReader(strings.NewReader(testChangeLDIF)), &ApplyOptions{ContinueOnError: true})
+	if err != nil || len(results) != 6 {
+		t.Fatalf("ApplyLDIF = %d results, %v", len(results), err)
+	}
+	var failed []int
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.Record.Line)
+		}
+	}
+	if !reflect.DeepEqual(failed, []int{11}) {
+		t.Errorf("Failed records = %v", failed)
+	}
+	want := []string{
+		"add cn=Fiona Jensen, ou=Marketing, dc=airius, dc=com",
+		"delete cn=Robert Jensen, ou=Marketing, dc=airius, dc=com",
+		"moddn cn=Paul Jensen, ou=Product Development, dc=airius, dc=com cn=Paula Jensen ",
+		"moddn ou=PD Accountants, ou=Product Development, dc=airius, dc=com ou=Product Development Accountants ou=Accounting, dc=airius, dc=com",
+		"modify cn=Paula Jensen, ou=Product Development, dc=airius, dc=com",
+		"add cn=content,dc=com",
+	}
+	if !reflect.DeepEqual(be.ops, want) {
+		t.Errorf("Operations = %q, want %q", be.ops, want)
+	}
+
+	if _, err := ApplyLDIF(c, NewLDIFReader(strings.NewReader("dn: cn=a\nchangetype: bogus\n")), &ApplyOptions{ContinueOnError: true}); err == nil {
+		t.Error("Expected syntax error")
+	}
+}
@@ -0,0 +1,243 @@
+package ldap
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultLDIFWrap is the column at which LDIFWriter folds lines by default.
+const DefaultLDIFWrap = 76
+
+// LDIFWriter writes content and change records as LDIF (RFC 2849). Values
+// that are not safe strings, including DNs, are base64 encoded.
+type LDIFWriter struct {
+	// Wrap is the maximum length of a line after which it is folded. If 0
+	// then DefaultLDIFWrap is used and if negative lines are not folded.
+	Wrap int
+	// Order lists attributes that are written after objectClass and before
+	// the other attributes which keep the order of the record. Names are
+	// compared case-insensitively and match attributes with options.
+	Order []string
+	// OmitVersion disables the "version: 1" line written before the first
+	// record.
+	OmitVersion bool
+
+	w       io.Writer
+	started bool
+	sep     bool // a record was written so the next needs a blank line
+}
+
+// NewLDIFWriter returns a writer that writes LDIF to w.
+func NewLDIFWriter(w io.Writer) *LDIFWriter {
+	return &LDIFWriter{w: w}
+}
+
+// WriteComment writes a comment. Comments written between records belong
+// to the following record.
+func (w *LDIFWriter) WriteComment(comment string) error {
+	var b strings.Builder
+	w.begin(&b)
+	for _, line := range strings.Split(comment, "\n") {
+		w.writeLine(&b, "# "+strings.TrimSuffix(line, "\r"))
+	}
+	return w.flush(&b)
+}
+
+// WriteEntry writes a content record.
+func (w *LDIFWriter) WriteEntry(r *SearchResult) error {
+	var b strings.Builder
+	w.beginRecord(&b)
+	w.writeValue(&b, "dn", []byte(r.DN))
+	w.writeAttributes(&b, r.Attributes, r.order)
+	return w.flush(&b)
+}
+
+// WriteRecord writes a change record for the request of the record.
+func (w *LDIFWriter) WriteRecord(rec *LDIFRecord) error {
+	var b strings.Builder
+	w.beginRecord(&b)
+	w.writeValue(&b, "dn", []byte(rec.DN()))
+	for _, c := range rec.Controls {
+		w.writeControl(&b, c)
+	}
+	switch req := rec.Request.(type) {
+	case *AddRequest:
+		w.writeLine(&b, "changetype: add")
+		w.writeAttributes(&b, req.Attributes, req.order)
+	case *DeleteRequest:
+		w.writeLine(&b, "changetype: delete")
+	case *ModifyRequest:
+		w.writeLine(&b, "changetype: modify")
+		for _, m := range req.Mods {
+			var op string
+			for name, typ := range ldifModTypes {
+				if typ == m.Type {
+					op = name
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("ldap: unsupported modification type %s", m.Type)
+			}
+			w.writeLine(&b, op+": "+m.Name)
+			for _, v := range m.Values {
+				w.writeValue(&b, m.Name, v)
+			}
+			w.writeLine(&b, "-")
+		}
+	case *ModifyDNRequest:
+		if req.NewSuperior != "" {
+			w.writeLine(&b, "changetype: moddn")
+		} else {
+			w.writeLine(&b, "changetype: modrdn")
+		}
+		w.writeValue(&b, "newrdn", []byte(req.NewRDN))
+		if req.DeleteOldRDN {
+			w.writeLine(&b, "deleteoldrdn: 1")
+		} else {
+			w.writeLine(&b, "deleteoldrdn: 0")
+		}
+		if req.NewSuperior != "" {
+			w.writeValue(&b, "newsuperior", []byte(req.NewSuperior))
+		}
+	default:
+		return fmt.Errorf("ldap: unsupported request type %T for LDIF change record", rec.Request)
+	}
+	return w.flush(&b)
+}
+
+// begin writes the version line before the first output and the blank line
+// that separates records.
+func (w *LDIFWriter) begin(b *strings.Builder) {
+	if !w.started {
+		w.started = true
+		if !w.OmitVersion {
+			b.WriteString("version: 1\n\n")
+		}
+	} else if w.sep {
+		b.WriteString("\n")
+		w.sep = false
+	}
+}
+
+func (w *LDIFWriter) beginRecord(b *strings.Builder) {
+	w.begin(b)
+	w.sep = true
+}
+
+func (w *LDIFWriter) flush(b *strings.Builder) error {
+	_, err := io.WriteString(w.w, b.String())
+	return err
+}
+
+// writeAttributes writes attributes with objectClass first followed by
+// the attributes in w.Order and then the rest.
+func (w *LDIFWriter) writeAttributes(b *strings.Builder, attrs map[string][][]byte, order []string) {
+	names := orderedNames(attrs, order)
+	rank := func(name string) int {
+		typ, _, _ := strings.Cut(name, ";")
+		if strings.EqualFold(typ, "objectClass") {
+			return 0
+		}
+		for i, o := range w.Order {
+			if strings.EqualFold(typ, o) || strings.EqualFold(name, o) {
+				return i + 1
+			}
+		}
+		return len(w.Order) + 1
+	}
+	ranked := make([]string, 0, len(names))
+	for r := 0; r <= len(w.Order)+1; r++ {
+		for _, name := range names {
+			if rank(name) == r {
+				ranked = append(ranked, name)
+			}
+		}
+	}
+	for _, name := range ranked {
+		for _, v := range attrs[name] {
+			w.writeValue(b, name, v)
+		}
+	}
+}
+
+func (w *LDIFWriter) writeControl(b *strings.Builder, c *Control) {
+	s := "control: " + c.Type
+	if c.Criticality {
+		s += " true"
+	} else {
+		s += " false"
+	}
+	if c.Value != nil {
+		if isSafeString(c.Value) {
+			s += ": " + string(c.Value)
+		} else {
+			s += ":: " + base64.StdEncoding.EncodeToString(c.Value)
+		}
+	}
+	w.writeLine(b, s)
+}
+
+// writeValue writes "name: value" or "name:: base64" if the value isn't a
+// safe string.
+func (w *LDIFWriter) writeValue(b *strings.Builder, name string, v []byte) {
+	if len(v) == 0 {
+		w.writeLine(b, name+":")
+	} else if isSafeString(v) {
+		w.writeLine(b, name+": "+string(v))
+	} else {
+		w.writeLine(b, name+":: "+base64.StdEncoding.EncodeToString(v))
+	}
+}
+
+// writeLine writes a line folding it if it's longer than the wrap column.
+// Lines are not folded inside of UTF-8 sequences.
+func (w *LDIFWriter) writeLine(b *strings.Builder, line string) {
+	wrap := w.Wrap
+	if wrap == 0 {
+		wrap = DefaultLDIFWrap
+	}
+	if wrap < 0 || len(line) <= wrap {
+		b.WriteString(line)
+		b.WriteByte('\n')
+		return
+	}
+	width := wrap
+	for len(line) > width {
+		n := width
+		for n > 1 && !utf8.RuneStart(line[n]) {
+			n--
+		}
+		b.WriteString(line[:n])
+		b.WriteString("\n ")
+		line = line[n:]
+		// continuation lines start with a space
+		width = wrap - 1
+		if width < 1 {
+			width = 1
+		}
+	}
+	b.WriteString(line)
+	b.WriteByte('\n')
+}
+
+// isSafeString returns true if the value can be written as is (RFC 2849
+// SAFE-STRING). It must only contain ASCII characters other than NUL, LF,
+// and CR, must not start with a space, ':' or '<', and must not end with a
+// space.
+func isSafeString(v []byte) bool {
+	if len(v) == 0 {
+		return true
+	}
+	if v[0] == ' ' || v[0] == ':' || v[0] == '<' || v[len(v)-1] == ' ' {
+		return false
+	}
+	for _, c := range v {
+		if c == 0 || c == '\n' || c == '\r' || c > 127 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package ldap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLDIFWriterEntry(t *testing.T) {
+	t.Parallel()
+	var b strings.Builder
+	w := NewLDIFWriter(&b)
+	w.Order = []string{"cn"}
+	if err := w.WriteComment("first\nsecond"); err != nil {
+		t.Fatal(err)
+	}
+	entries := []*SearchResult{
+		{
+			DN: "cn=Barbara Jensen,dc=example",
+			Attributes: map[string][][]byte{
+				"description": {[]byte("Babs is a big sailing fan, and travels extensively in search of perfect sailing conditions.")},
+				"cn;lang-de":  {[]byte("Barbara")},
+				"cn":          {[]byte("Barbara Jensen")},
+				"objectClass": {[]byte("person")},
+				"sn":          {[]byte(" leading"), []byte("trailing "), []byte(":colon"), []byte("<less"), []byte("a\nb"), []byte("ok: fine")},
+				"ou":          {[]byte("営業部"), {}},
+			},
+			order: []string{"sn", "description", "ou", "cn;lang-de", "objectClass", "cn"},
+		},
+		{
+			DN:         "ou=営業部,o=Airius",
+			Attributes: map[string][][]byte{"ou": {[]byte("x")}},
+		},
+	}
+	for _, e := range entries {
+		if err := w.WriteEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "version: 1\n" +
+		"\n" +
+		"# first\n" +
+		"# second\n" +
+		"dn: cn=Barbara Jensen,dc=example\n" +
+		"objectClass: person\n" +
+		"cn;lang-de: Barbara\n" +
+		"cn: Barbara Jensen\n" +
+		"sn:: IGxlYWRpbmc=\n" +
+		"sn:: dHJhaWxpbmcg\n" +
+		"sn:: OmNvbG9u\n" +
+		"sn:: PGxlc3M=\n" +
+		"sn:: YQpi\n" +
+		"sn: ok: fine\n" +
+		"description: Babs is a big sailing fan, and travels extensively in search of\n" +
+		"  perfect sailing conditions.\n" +
+		"ou:: 5Za25qWt6YOo\n" +
+		"ou:\n" +
+		"\n" +
+		"dn:: b3U95Za25qWt6YOoLG89QWlyaXVz\n" +
+		"ou: x\n"
+	if b.String() != want {
+		t.Errorf("Output:\n%s\nwant:\n%s", b.String(), want)
+	}
+	results, err := ReadLDIF(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if !reflect.DeepEqual(r.Attributes, entries[i].Attributes) || r.DN != entries[i].DN {
+			t.Errorf("Read %+v, want %+v", r, entries[i])
+		}
+	}
+}
+
+func TestLDIFWriterWrap(t *testing.T) {
+	t.Parallel()
+	long := strings.Repeat("é", 10) + strings.Repeat("x", 10)
+	cases := []struct {
+		wrap int
+		want string
+	}{
+		{-1, "# " + long + "\n"},
+		{10, "# éééé\n éééé\n ééxxxxx\n xxxxx\n"},
+	}
+	for _, c := range cases {
+		var b strings.Builder
+		w := NewLDIFWriter(&b)
+		w.OmitVersion = true
+		w.Wrap = c.wrap
+		w.WriteComment(long)
+		if b.String() != c.want {
+			t.Errorf("Wrap %d = %q, want %q", c.wrap, b.String(), c.want)
+		}
+	}
+}
+
+func TestLDIFWriterRecords(t *testing.T) {
+	t.Parallel()
+	r := NewLDIFReader(strings.NewReader(testChangeLDIF))
+	var records []*LDIFRecord
+	for {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			break
+		}
+		records = append(records, rec)
+	}
+	var b strings.Builder
+	w := NewLDIFWriter(&b)
+	for _, rec := range records {
+		if err := w.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r = NewLDIFReader(strings.NewReader(b.String()))
+	for i, want := range records {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			t.Fatalf("Record %d: %s\n%s", i, err, b.String())
+		}
+		rec.Line = want.Line
+		if !reflect.DeepEqual(rec, want) {
+			t.Errorf("Record %d = %+v, want %+v", i, rec.Request, want.Request)
+		}
+	}
+	if !strings.Contains(b.String(), "control: 1.3.6.1.4.1.4203.1.10.1 false:: AAE=\n") {
+		t.Errorf("Control not written as expected:\n%s", b.String())
+	}
+	if err := w.WriteRecord(&LDIFRecord{Request: &SearchRequest{}}); err == nil {
+		t.Error("Expected error for search request")
+	}
+}
@@ -0,0 +1,418 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Marshaler is implemented by types that can encode themselves as
+// attribute values.
+type Marshaler interface {
+	MarshalLDAP() ([][]byte, error)
+}
+
+// Unmarshaler is implemented by types that can decode themselves from
+// attribute values.
+type Unmarshaler interface {
+	UnmarshalLDAP(values [][]byte) error
+}
+
+// MissingAttributesError is returned by Unmarshal when an entry has no
+// values for attributes of fields that are not tagged omitempty.
+type MissingAttributesError struct {
+	DN         string
+	Attributes []string
+}
+
+func (e *MissingAttributesError) Error() string {
+	return fmt.Sprintf("ldap: entry %q is missing required attributes %s", e.DN, strings.Join(e.Attributes, ", "))
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	timeType        = reflect.TypeOf(time.Time{})
+	dnType          = reflect.TypeOf(DN(nil))
+	bytesType       = reflect.TypeOf([]byte(nil))
+)
+
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+type structFields struct {
+	dn     []int // index of the ",dn" field or nil
+	fields []structField
+}
+
+// typeFields returns the attribute fields of a struct type. Fields are
+// mapped using the "ldap" tag in the form `ldap:"name,omitempty"` where the
+// name defaults to the field name. A field tagged `ldap:",dn"` holds the
+// DN of the entry and fields tagged `ldap:"-"` are ignored. The fields of
+// embedded structs without a tag are included.
+func typeFields(t reflect.Type) (*structFields, error) {
+	sf := &structFields{}
+	var walk func(t reflect.Type, index []int) error
+	walk = func(t reflect.Type, index []int) error {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag, hasTag := f.Tag.Lookup("ldap")
+			if tag == "-" {
+				continue
+			}
+			idx := append(append([]int(nil), index...), i)
+			if f.Anonymous && !hasTag && f.Type.Kind() == reflect.Struct {
+				if err := walk(f.Type, idx); err != nil {
+					return err
+				}
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			field := structField{name: name, index: idx}
+			isDN := false
+			for _, o := range strings.Split(opts, ",") {
+				switch o {
+				case "":
+				case "omitempty":
+					field.omitEmpty = true
+				case "dn":
+					if f.Type.Kind() != reflect.String && f.Type != dnType {
+						return fmt.Errorf("ldap: dn field %s must be a string or DN", f.Name)
+					}
+					isDN = true
+				default:
+					return fmt.Errorf("ldap: unknown option %q in tag of field %s", o, f.Name)
+				}
+			}
+			if isDN {
+				sf.dn = idx
+				continue
+			}
+			if field.name == "" {
+				field.name = f.Name
+			}
+			sf.fields = append(sf.fields, field)
+		}
+		return nil
+	}
+	if err := walk(t, nil); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+func structValue(v interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.Value{}, errors.New("ldap: nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("ldap: cannot marshal %s, expected a struct", rv.Type())
+	}
+	return rv, nil
+}
+
+// Marshal returns an entry for a struct. See Unmarshal for how fields are
+// mapped to attributes. Fields tagged omitempty are skipped if they have the
+// zero value, and fields with empty strings, slices, zero times, or nil
+// pointers have no values and are left out of the entry.
+func Marshal(v interface{}) (*Entry, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	sf, err := typeFields(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if sf.dn != nil {
+		e.DN = fmt.Sprint(rv.FieldByIndex(sf.dn).Interface())
+	}
+	err = marshalFields(rv, sf, func(name string, values [][]byte) {
+		if len(values) != 0 {
+			e.AddValues(name, values...)
+		}
+	})
+	return e, err
+}
+
+// MarshalAdd returns a request to add the entry for a struct. The struct
+// must have a field tagged `ldap:",dn"` that is not empty.
+func MarshalAdd(v interface{}) (*AddRequest, error) {
+	e, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if e.DN == "" {
+		return nil, errors.New("ldap: cannot marshal add request without a DN")
+	}
+	return e.AddRequest(), nil
+}
+
+// MarshalMods returns modifications that replace the attributes of an
+// entry with the values of a struct's fields for use with Client.Modify.
+// Fields tagged omitempty with the zero value are left unchanged while
+// other fields without values delete the attribute.
+func MarshalMods(v interface{}) ([]*Mod, error) {
+	rv, err := structValue(v)
+	if err != nil {
+		return nil, err
+	}
+	sf, err := typeFields(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	var mods []*Mod
+	err = marshalFields(rv, sf, func(name string, values [][]byte) {
+		mods = append(mods, &Mod{Type: Replace, Name: name, Values: values})
+	})
+	return mods, err
+}
+
+func marshalFields(rv reflect.Value, sf *structFields, fn func(name string, values [][]byte)) error {
+	for _, f := range sf.fields {
+		fv := rv.FieldByIndex(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		values, err := marshalValue(fv)
+		if err != nil {
+			return fmt.Errorf("ldap: cannot marshal attribute %s: %w", f.name, err)
+		}
+		fn(f.name, values)
+	}
+	return nil
+}
+
+func marshalValue(v reflect.Value) ([][]byte, error) {
+	if v.Type().Implements(marshalerType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, nil
+		}
+		return v.Interface().(Marshaler).MarshalLDAP()
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(marshalerType) {
+		return v.Addr().Interface().(Marshaler).MarshalLDAP()
+	}
+	switch t := v.Type(); {
+	case t == timeType:
+		tm := v.Interface().(time.Time)
+		if tm.IsZero() {
+			return nil, nil
+		}
+		return [][]byte{[]byte(FormatGeneralizedTime(tm))}, nil
+	case t == dnType:
+		if v.Len() == 0 {
+			return nil, nil
+		}
+		return [][]byte{[]byte(v.Interface().(DN).String())}, nil
+	case t == bytesType || t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		if v.Len() == 0 {
+			return nil, nil
+		}
+		return [][]byte{v.Bytes()}, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return marshalValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		var values [][]byte
+		for i := 0; i < v.Len(); i++ {
+			vals, err := marshalValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, vals...)
+		}
+		return values, nil
+	case reflect.String:
+		if v.Len() == 0 {
+			return nil, nil
+		}
+		return [][]byte{[]byte(v.String())}, nil
+	case reflect.Bool:
+		if v.Bool() {
+			return [][]byte{[]byte("TRUE")}, nil
+		}
+		return [][]byte{[]byte("FALSE")}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return [][]byte{strconv.AppendInt(nil, v.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return [][]byte{strconv.AppendUint(nil, v.Uint(), 10)}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// Unmarshal decodes an entry into the struct pointed to by v. Attributes are
+// mapped to fields by the "ldap" struct tag in the form
+// `ldap:"name,omitempty"` where the name defaults to the field name and is
+// matched case-insensitively. A field tagged `ldap:",dn"` of type string or
+// DN is set to the DN of the entry and fields tagged `ldap:"-"` are ignored.
+//
+// Supported field types are string, []byte, bool (Boolean syntax), integer
+// types (INTEGER syntax), time.Time (Generalized Time syntax), DN, slices
+// and pointers of these, and types implementing Unmarshaler. Fields that
+// aren't slices use the first value. A *MissingAttributesError is returned
+// if the entry has no values for fields that are not tagged omitempty.
+func Unmarshal(e *Entry, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("ldap: Unmarshal requires a non-nil pointer")
+	}
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("ldap: cannot unmarshal into %s, expected a struct", rv.Type())
+	}
+	sf, err := typeFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	if sf.dn != nil {
+		fv := rv.FieldByIndex(sf.dn)
+		if fv.Type() == dnType {
+			dn, err := ParseDN(e.DN)
+			if err != nil {
+				return err
+			}
+			fv.Set(reflect.ValueOf(dn))
+		} else {
+			fv.SetString(e.DN)
+		}
+	}
+	var missing []string
+	for _, f := range sf.fields {
+		values := e.GetAllRaw(f.name)
+		if len(values) == 0 {
+			if !f.omitEmpty {
+				missing = append(missing, f.name)
+			}
+			continue
+		}
+		if err := unmarshalValue(rv.FieldByIndex(f.index), values); err != nil {
+			return fmt.Errorf("ldap: cannot unmarshal attribute %s of %q: %w", f.name, e.DN, err)
+		}
+	}
+	if len(missing) != 0 {
+		return &MissingAttributesError{DN: e.DN, Attributes: missing}
+	}
+	return nil
+}
+
+// UnmarshalAll decodes search results into the slice pointed to by v whose
+// elements are structs or pointers to structs. It's meant to be used with
+// the results of Client.Search.
+func UnmarshalAll(results []*SearchResult, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("ldap: UnmarshalAll requires a non-nil pointer to a slice")
+	}
+	sv := rv.Elem()
+	et := sv.Type().Elem()
+	isPtr := et.Kind() == reflect.Ptr
+	if isPtr {
+		et = et.Elem()
+	}
+	out := reflect.MakeSlice(sv.Type(), 0, len(results))
+	for _, r := range results {
+		ev := reflect.New(et)
+		if err := Unmarshal(r.Entry(), ev.Interface()); err != nil {
+			return err
+		}
+		if !isPtr {
+			ev = ev.Elem()
+		}
+		out = reflect.Append(out, ev)
+	}
+	sv.Set(out)
+	return nil
+}
+
+func unmarshalValue(v reflect.Value, values [][]byte) error {
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		return v.Addr().Interface().(Unmarshaler).UnmarshalLDAP(values)
+	}
+	switch t := v.Type(); {
+	case t == timeType:
+		tm, err := ParseGeneralizedTime(string(values[0]))
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(tm))
+		return nil
+	case t == dnType:
+		dn, err := ParseDN(string(values[0]))
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(dn))
+		return nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		v.SetBytes(append([]byte(nil), values[0]...))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(v.Type().Elem())
+		if err := unmarshalValue(p.Elem(), values); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, val := range values {
+			if err := unmarshalValue(s.Index(i), [][]byte{val}); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.String:
+		v.SetString(string(values[0]))
+		return nil
+	case reflect.Bool:
+		b, err := parseBoolean(values[0])
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := parseInteger(values[0])
+		if err != nil {
+			return err
+		}
+		if !n.IsInt64() || v.OverflowInt(n.Int64()) {
+			return fmt.Errorf("integer %s out of range for %s", n, v.Type())
+		}
+		v.SetInt(n.Int64())
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := parseInteger(values[0])
+		if err != nil {
+			return err
+		}
+		if !n.IsUint64() || v.OverflowUint(n.Uint64()) {
+			return fmt.Errorf("integer %s out of range for %s", n, v.Type())
+		}
+		v.SetUint(n.Uint64())
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", v.Type())
+}
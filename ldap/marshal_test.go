@@ -0,0 +1,222 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type upperString string
+
+func (s upperString) MarshalLDAP() ([][]byte, error) {
+	return [][]byte{[]byte(strings.ToUpper(string(s)))}, nil
+}
+
+func (s *upperString) UnmarshalLDAP(values [][]byte) error {
+	*s = upperString(strings.ToLower(string(values[0])))
+	return nil
+}
+
+type marshalBase struct {
+	ObjectClass []string `ldap:"objectClass"`
+}
+
+type marshalUser struct {
+	marshalBase
+	DN          string      `ldap:",dn"`
+	UID         string      `ldap:"uid"`
+	CN          []string    `ldap:"cn"`
+	Mail        string      `ldap:"mail,omitempty"`
+	UIDNumber   int         `ldap:"uidNumber"`
+	Locked      bool        `ldap:"pwdLockout,omitempty"`
+	Created     time.Time   `ldap:"createTimestamp,omitempty"`
+	Manager     DN          `ldap:"manager,omitempty"`
+	Photo       []byte      `ldap:"jpegPhoto,omitempty"`
+	Shell       *string     `ldap:"loginShell,omitempty"`
+	Code        upperString `ldap:"code,omitempty"`
+	Description string
+	Ignored     string `ldap:"-"`
+	unexported  string
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	t.Parallel()
+	shell := "/bin/sh"
+	u := &marshalUser{
+		marshalBase: marshalBase{ObjectClass: []string{"top", "person"}},
+		DN:          "uid=jdoe,dc=example,dc=com",
+		UID:         "jdoe",
+		CN:          []string{"John Doe", "Johnny"},
+		UIDNumber:   1000,
+		Locked:      true,
+		Created:     time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+		Manager:     MustParseDN("uid=boss,dc=example,dc=com"),
+		Photo:       []byte{0xff, 0xd8},
+		Shell:       &shell,
+		Code:        "abc",
+		Description: "desc",
+		Ignored:     "ignored",
+		unexported:  "x",
+	}
+	e, err := Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.DN != u.DN {
+		t.Errorf("DN = %q", e.DN)
+	}
+	checks := map[string]string{
+		"objectClass":     "top",
+		"pwdLockout":      "TRUE",
+		"uidNumber":       "1000",
+		"createTimestamp": "20240102150405Z",
+		"manager":         "uid=boss,dc=example,dc=com",
+		"code":            "ABC",
+		"Description":     "desc",
+		"loginShell":      "/bin/sh",
+	}
+	for name, want := range checks {
+		if v := e.Get(name); v != want {
+			t.Errorf("%s = %q, want %q", name, v, want)
+		}
+	}
+	if e.Has("mail") || e.Has("Ignored") || e.Has("unexported") {
+		t.Error("Unexpected attributes in entry")
+	}
+	var u2 marshalUser
+	if err := Unmarshal(e, &u2); err != nil {
+		t.Fatal(err)
+	}
+	u.Ignored = ""
+	u.unexported = ""
+	if !reflect.DeepEqual(u, &u2) {
+		t.Errorf("Unmarshal = %+v, want %+v", &u2, u)
+	}
+
+	req, err := MarshalAdd(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.DN != u.DN || string(req.Attributes["uid"][0]) != "jdoe" {
+		t.Errorf("MarshalAdd = %+v", req)
+	}
+	if _, err := MarshalAdd(&marshalUser{UID: "x"}); err == nil {
+		t.Error("MarshalAdd without DN should fail")
+	}
+}
+
+func TestMarshalMods(t *testing.T) {
+	t.Parallel()
+	mods, err := MarshalMods(marshalUser{UID: "jdoe", UIDNumber: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]int)
+	for _, m := range mods {
+		if m.Type != Replace {
+			t.Errorf("Mod %s has type %s", m.Name, m.Type)
+		}
+		got[m.Name] = len(m.Values)
+	}
+	want := map[string]int{"objectClass": 0, "uid": 1, "cn": 0, "uidNumber": 1, "Description": 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MarshalMods = %v, want %v", got, want)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	t.Parallel()
+	e := NewEntry("uid=jdoe,dc=example,dc=com", map[string][]string{
+		"UID":       {"jdoe"},
+		"uidNumber": {"1000"},
+	})
+	var u marshalUser
+	err := Unmarshal(e, &u)
+	var merr *MissingAttributesError
+	if !errors.As(err, &merr) {
+		t.Fatalf("Expected MissingAttributesError, got %v", err)
+	}
+	if want := []string{"objectClass", "cn", "Description"}; !reflect.DeepEqual(merr.Attributes, want) {
+		t.Errorf("Missing = %v, want %v", merr.Attributes, want)
+	}
+	if u.UID != "jdoe" || u.UIDNumber != 1000 {
+		t.Errorf("Fields not set: %+v", u)
+	}
+	for _, v := range []string{"abc", "+5", "007", "-0", "", "99999999999999999999"} {
+		e.SetValues("uidNumber", []byte(v))
+		if err := Unmarshal(e, &u); err == nil || errors.As(err, &merr) {
+			t.Errorf("%q: Expected decoding error, got %v", v, err)
+		}
+	}
+	var small struct {
+		N int8  `ldap:"n"`
+		U uint8 `ldap:"u"`
+	}
+	for _, tc := range []struct{ n, u string }{{"128", "1"}, {"1", "256"}, {"1", "-1"}} {
+		if err := Unmarshal(NewEntry("", map[string][]string{"n": {tc.n}, "u": {tc.u}}), &small); err == nil {
+			t.Errorf("%+v: Expected range error", tc)
+		}
+	}
+	if err := Unmarshal(NewEntry("", map[string][]string{"n": {"-128"}, "u": {"255"}}), &small); err != nil || small.N != -128 || small.U != 255 {
+		t.Errorf("Unexpected %+v %v", small, err)
+	}
+	if err := Unmarshal(e, u); err == nil {
+		t.Error("Expected error for non-pointer")
+	}
+}
+
+type addBackend struct {
+	Backend
+	req *AddRequest
+}
+
+func (be *addBackend) Add(ctx context.Context, state State, req *AddRequest) (*AddResponse, error) {
+	be.req = req
+	return &AddResponse{}, nil
+}
+
+func (be *addBackend) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	return &SearchResponse{Results: []*SearchResult{be.req.Entry().SearchResult()}}, nil
+}
+
+func TestClientMarshal(t *testing.T) {
+	t.Parallel()
+	be := &addBackend{Backend: DebugBackend}
+	_, c := newTestServer(t, be)
+	u := &marshalUser{
+		marshalBase: marshalBase{ObjectClass: []string{"top", "person"}},
+		DN:          "uid=jdoe,dc=example,dc=com",
+		UID:         "jdoe",
+		CN:          []string{"John Doe"},
+		Description: "x",
+	}
+	req, err := MarshalAdd(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(req); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"objectClass", "uid", "cn", "uidNumber", "Description"}
+	var names []string
+	for _, a := range be.req.Entry().Attributes {
+		names = append(names, a.Name)
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Added attributes %v, want %v", names, want)
+	}
+	res, err := c.Search(&SearchRequest{BaseDN: u.DN, Attributes: map[string]bool{"*": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users []*marshalUser
+	if err := UnmarshalAll(res, &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || !reflect.DeepEqual(users[0], u) {
+		t.Errorf("UnmarshalAll = %+v", users)
+	}
+}
@@ -0,0 +1,715 @@
+package ldap
+
+// https://tools.ietf.org/html/rfc4517#section-4
+// https://tools.ietf.org/html/rfc4518
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type MatchingRuleKind int
+
+const (
+	EqualityMatchingRule   MatchingRuleKind = 0
+	OrderingMatchingRule   MatchingRuleKind = 1
+	SubstringsMatchingRule MatchingRuleKind = 2
+)
+
+var MatchingRuleKindMap = map[MatchingRuleKind]string{
+	EqualityMatchingRule:   "Equality",
+	OrderingMatchingRule:   "Ordering",
+	SubstringsMatchingRule: "Substrings",
+}
+
+func (k MatchingRuleKind) String() string {
+	if s := MatchingRuleKindMap[k]; s != "" {
+		return s
+	}
+	return strconv.Itoa(int(k))
+}
+
+// SubstringPosition is the position of a component in a substrings assertion.
+type SubstringPosition int
+
+const (
+	SubstringInitial SubstringPosition = 0
+	SubstringAny     SubstringPosition = 1
+	SubstringFinal   SubstringPosition = 2
+)
+
+// ErrInappropriateMatching is returned when a matching rule is used for a
+// kind of assertion it does not support.
+var ErrInappropriateMatching = errors.New("ldap: inappropriate matching")
+
+// Implemented returns true if the matching rule has an implementation and
+// can be used to compare values rather than just being a description.
+func (mr *MatchingRule) Implemented() bool {
+	return mr.Normalize != nil || mr.MatchFunc != nil
+}
+
+func (mr *MatchingRule) normalize(v []byte) ([]byte, error) {
+	if mr.Normalize == nil {
+		return v, nil
+	}
+	return mr.Normalize(v)
+}
+
+func (mr *MatchingRule) normalizeAssertion(v []byte) ([]byte, error) {
+	if mr.NormalizeAssertion != nil {
+		return mr.NormalizeAssertion(v)
+	}
+	return mr.normalize(v)
+}
+
+// Equal returns true if the attribute value matches the assertion value.
+// An error is returned if either value is invalid for the rule in which
+// case the result of the comparison is Undefined.
+func (mr *MatchingRule) Equal(value, assertion []byte) (bool, error) {
+	match, err := mr.compileEquality(assertion)
+	if err != nil {
+		return false, err
+	}
+	return match(value)
+}
+
+// Compare returns -1, 0, or 1 if the attribute value is less than, equal
+// to, or greater than the assertion value under an ordering rule.
+func (mr *MatchingRule) Compare(value, assertion []byte) (int, error) {
+	cmp, err := mr.compileOrdering(assertion)
+	if err != nil {
+		return 0, err
+	}
+	return cmp(value)
+}
+
+// MatchSubstrings returns true if the attribute value matches a substrings
+// assertion. Empty components are ignored.
+func (mr *MatchingRule) MatchSubstrings(value []byte, initial string, any []string, final string) (bool, error) {
+	match, err := mr.compileSubstrings(initial, any, final)
+	if err != nil {
+		return false, err
+	}
+	return match(value)
+}
+
+// compileEquality prepares the assertion value once and returns a function
+// that matches attribute values against it.
+func (mr *MatchingRule) compileEquality(assertion []byte) (func(value []byte) (bool, error), error) {
+	if mr.MatchFunc != nil {
+		return func(value []byte) (bool, error) {
+			return mr.MatchFunc(value, assertion)
+		}, nil
+	}
+	if mr.Kind == SubstringsMatchingRule {
+		return nil, ErrInappropriateMatching
+	}
+	a, err := mr.normalizeAssertion(assertion)
+	if err != nil {
+		return nil, err
+	}
+	return func(value []byte) (bool, error) {
+		v, err := mr.normalize(value)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(v, a), nil
+	}, nil
+}
+
+// compileOrdering prepares the assertion value once and returns a function
+// that compares attribute values to it.
+func (mr *MatchingRule) compileOrdering(assertion []byte) (func(value []byte) (int, error), error) {
+	if mr.Kind != OrderingMatchingRule {
+		return nil, ErrInappropriateMatching
+	}
+	a, err := mr.normalizeAssertion(assertion)
+	if err != nil {
+		return nil, err
+	}
+	compare := mr.CompareFunc
+	if compare == nil {
+		compare = bytes.Compare
+	}
+	return func(value []byte) (int, error) {
+		v, err := mr.normalize(value)
+		if err != nil {
+			return 0, err
+		}
+		return compare(v, a), nil
+	}, nil
+}
+
+// compileSubstrings prepares the assertion components once and returns a
+// function that matches attribute values against them.
+func (mr *MatchingRule) compileSubstrings(initial string, any []string, final string) (func(value []byte) (bool, error), error) {
+	ini, anys, fin, err := mr.substringComponents(initial, any, final)
+	if err != nil {
+		return nil, err
+	}
+	return func(value []byte) (bool, error) {
+		v, err := mr.normalize(value)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.HasPrefix(v, ini) {
+			return false, nil
+		}
+		pos := len(ini)
+		end := len(v) - len(fin)
+		if end < pos || !bytes.HasSuffix(v, fin) {
+			return false, nil
+		}
+		for _, a := range anys {
+			i := bytes.Index(v[pos:end], a)
+			if i < 0 {
+				return false, nil
+			}
+			pos += i + len(a)
+		}
+		return true, nil
+	}, nil
+}
+
+// substringComponents normalizes the components of a substrings assertion.
+// Empty components are dropped.
+func (mr *MatchingRule) substringComponents(initial string, any []string, final string) (ini []byte, anys [][]byte, fin []byte, err error) {
+	if mr.Kind != SubstringsMatchingRule {
+		return nil, nil, nil, ErrInappropriateMatching
+	}
+	sub := func(s string, pos SubstringPosition) ([]byte, error) {
+		if mr.NormalizeSubstring != nil {
+			return mr.NormalizeSubstring([]byte(s), pos)
+		}
+		return mr.normalize([]byte(s))
+	}
+	if initial != "" {
+		if ini, err = sub(initial, SubstringInitial); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if final != "" {
+		if fin, err = sub(final, SubstringFinal); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	anys = make([][]byte, 0, len(any))
+	for _, a := range any {
+		if a == "" {
+			continue
+		}
+		na, err := sub(a, SubstringAny)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		anys = append(anys, na)
+	}
+	return ini, anys, fin, nil
+}
+
+var matchingRuleRegistry = struct {
+	sync.RWMutex
+	m map[string]*MatchingRule
+}{m: make(map[string]*MatchingRule)}
+
+// RegisterMatchingRule makes a matching rule implementation available by
+// its OID and names. Registering a rule with the same OID or name as an
+// existing rule replaces it.
+func RegisterMatchingRule(mr *MatchingRule) {
+	matchingRuleRegistry.Lock()
+	defer matchingRuleRegistry.Unlock()
+	matchingRuleRegistry.m[mr.OID] = mr
+	for _, n := range mr.Names {
+		matchingRuleRegistry.m[strings.ToLower(n)] = mr
+	}
+}
+
+// LookupMatchingRule returns the registered matching rule with the given
+// name or OID, or nil if there is none.
+func LookupMatchingRule(name string) *MatchingRule {
+	matchingRuleRegistry.RLock()
+	defer matchingRuleRegistry.RUnlock()
+	return matchingRuleRegistry.m[strings.ToLower(name)]
+}
+
+func init() {
+	for _, mr := range builtinMatchingRules {
+		RegisterMatchingRule(mr)
+	}
+	for _, mr := range activeDirectoryMatchingRules {
+		RegisterMatchingRule(mr)
+	}
+}
+
+// resolveMatchingRule returns an implementation of the named matching rule
+// from the schema, falling back to the registry for rules that are only
+// described by the schema.
+func (s *Schema) resolveMatchingRule(name string) *MatchingRule {
+	if name == "" {
+		return nil
+	}
+	if s != nil {
+		if mr := s.MatchingRule(name); mr != nil {
+			if mr.Implemented() {
+				return mr
+			}
+			return LookupMatchingRule(mr.OID)
+		}
+	}
+	return LookupMatchingRule(name)
+}
+
+func (s *Schema) attributeRule(attr string, rule func(*AttributeType) string) *MatchingRule {
+	at := s.AttributeType(attr)
+	for depth := 0; at != nil && depth < 16; depth++ {
+		if name := rule(at); name != "" {
+			return s.resolveMatchingRule(name)
+		}
+		if at.SuperType == "" {
+			break
+		}
+		at = s.AttributeType(at.SuperType)
+	}
+	return nil
+}
+
+// EqualityRule returns the equality matching rule for an attribute type
+// following super types. It returns nil if the attribute type is unknown or
+// has no implemented equality rule.
+func (s *Schema) EqualityRule(attr string) *MatchingRule {
+	return s.attributeRule(attr, func(at *AttributeType) string { return at.Equality })
+}
+
+// OrderingRule returns the ordering matching rule for an attribute type
+// following super types. It returns nil if there is none.
+func (s *Schema) OrderingRule(attr string) *MatchingRule {
+	return s.attributeRule(attr, func(at *AttributeType) string { return at.Ordering })
+}
+
+// SubstringsRule returns the substrings matching rule for an attribute
+// type following super types. It returns nil if there is none.
+func (s *Schema) SubstringsRule(attr string) *MatchingRule {
+	return s.attributeRule(attr, func(at *AttributeType) string { return at.Substr })
+}
+
+// String preparation (RFC 4518)
+
+var errProhibitedCharacter = errors.New("ldap: prohibited character in string")
+
+// mapRune implements the mapping step of RFC 4518 section 2.2. It returns
+// -1 for characters mapped to nothing.
+func mapRune(r rune) rune {
+	switch {
+	case r == 0x09, r == 0x0a, r == 0x0b, r == 0x0c, r == 0x0d, r == 0x85:
+		return ' '
+	case r <= 0x08, r >= 0x0e && r <= 0x1f, r >= 0x7f && r <= 0x84, r >= 0x86 && r <= 0x9f,
+		r == 0xad, r == 0x034f, r == 0x06dd, r == 0x070f, r == 0x1806, r >= 0x180b && r <= 0x180e,
+		r >= 0x200b && r <= 0x200f, r >= 0x202a && r <= 0x202e, r >= 0x2060 && r <= 0x2063,
+		r >= 0x206a && r <= 0x206f, r >= 0xfe00 && r <= 0xfe0f, r == 0xfeff,
+		r >= 0xfff9 && r <= 0xfffc, r >= 0x1d173 && r <= 0x1d17a, r == 0xe0001, r >= 0xe0020 && r <= 0xe007f:
+		return -1
+	case unicode.In(r, unicode.Zs, unicode.Zl, unicode.Zp):
+		return ' '
+	}
+	return r
+}
+
+// prohibited implements the prohibit step of RFC 4518 section 2.4.
+func prohibited(r rune) bool {
+	switch {
+	case r == utf8.RuneError,
+		unicode.Is(unicode.Co, r),
+		r >= 0xfdd0 && r <= 0xfdef,
+		r&0xfffe == 0xfffe:
+		return true
+	}
+	// unassigned code points
+	return !unicode.In(r, unicode.L, unicode.M, unicode.N, unicode.P, unicode.S, unicode.Z, unicode.C)
+}
+
+// prepareString applies the transcode, map, normalize, prohibit, and
+// insignificant space handling steps of RFC 4518 to a value. The result has
+// leading and trailing spaces removed and inner runs of spaces collapsed to
+// a single space which gives the same equivalence as the two space form
+// described in the RFC.
+func prepareString(v []byte, caseFold bool, pos SubstringPosition, substring bool) ([]byte, error) {
+	if !utf8.Valid(v) {
+		return nil, errProhibitedCharacter
+	}
+	mapped := make([]byte, 0, len(v))
+	for _, r := range string(v) {
+		if r = mapRune(r); r < 0 {
+			continue
+		}
+		if caseFold {
+			r = unicode.ToLower(unicode.ToUpper(r))
+		}
+		mapped = utf8.AppendRune(mapped, r)
+	}
+	out := make([]byte, 0, len(mapped))
+	space := false
+	for _, r := range string(norm.NFKC.Bytes(mapped)) {
+		if prohibited(r) {
+			return nil, errProhibitedCharacter
+		}
+		// Normalization may produce spaces, such as U+00A8 DIAERESIS
+		// which becomes a space and a combining diaeresis.
+		if r == ' ' {
+			space = true
+			continue
+		}
+		if space && (len(out) != 0 || (substring && pos != SubstringInitial)) {
+			out = append(out, ' ')
+		}
+		space = false
+		out = utf8.AppendRune(out, r)
+	}
+	if space && substring && pos != SubstringFinal && (len(out) != 0 || pos == SubstringAny) {
+		out = append(out, ' ')
+	}
+	return out, nil
+}
+
+func stringRule(caseFold bool) (func([]byte) ([]byte, error), func([]byte, SubstringPosition) ([]byte, error)) {
+	return func(v []byte) ([]byte, error) {
+			return prepareString(v, caseFold, 0, false)
+		}, func(v []byte, pos SubstringPosition) ([]byte, error) {
+			return prepareString(v, caseFold, pos, true)
+		}
+}
+
+func ia5Rule(caseFold bool) (func([]byte) ([]byte, error), func([]byte, SubstringPosition) ([]byte, error)) {
+	norm, sub := stringRule(caseFold)
+	check := func(v []byte) error {
+		for _, c := range v {
+			if c >= 0x80 {
+				return fmt.Errorf("ldap: invalid IA5 string %q", v)
+			}
+		}
+		return nil
+	}
+	return func(v []byte) ([]byte, error) {
+			if err := check(v); err != nil {
+				return nil, err
+			}
+			return norm(v)
+		}, func(v []byte, pos SubstringPosition) ([]byte, error) {
+			if err := check(v); err != nil {
+				return nil, err
+			}
+			return sub(v, pos)
+		}
+}
+
+func removeBytes(v []byte, drop func(r rune) bool) []byte {
+	out := make([]byte, 0, len(v))
+	for _, r := range string(v) {
+		if !drop(r) {
+			out = utf8.AppendRune(out, r)
+		}
+	}
+	return out
+}
+
+func normalizeNumericString(v []byte) ([]byte, error) {
+	for _, c := range v {
+		if c != ' ' && (c < '0' || c > '9') {
+			return nil, fmt.Errorf("ldap: invalid numeric string %q", v)
+		}
+	}
+	return removeBytes(v, func(r rune) bool { return r == ' ' }), nil
+}
+
+func isHyphen(r rune) bool {
+	switch r {
+	case '-', 0x058a, 0x2010, 0x2011, 0x2212, 0xfe63, 0xff0d:
+		return true
+	}
+	return false
+}
+
+func normalizeTelephoneNumber(v []byte) ([]byte, error) {
+	p, err := prepareString(v, true, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	return removeBytes(p, func(r rune) bool { return r == ' ' || isHyphen(r) }), nil
+}
+
+func normalizeInteger(v []byte) ([]byte, error) {
+	n, err := parseInteger(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(n.String()), nil
+}
+
+func compareIntegers(a, b []byte) int {
+	na, _ := parseInteger(a)
+	nb, _ := parseInteger(b)
+	return na.Cmp(nb)
+}
+
+func normalizeBoolean(v []byte) ([]byte, error) {
+	if _, err := parseBoolean(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func normalizeGeneralizedTime(v []byte) ([]byte, error) {
+	t, err := ParseGeneralizedTime(string(v))
+	if err != nil {
+		return nil, err
+	}
+	// Fixed width so the normalized forms sort chronologically.
+	return []byte(t.UTC().Format("20060102150405.000000000Z")), nil
+}
+
+func normalizeOID(v []byte) ([]byte, error) {
+	s := strings.TrimSpace(string(v))
+	if isNumericOID(s) {
+		return []byte(s), nil
+	}
+	if isKeyString(s) {
+		return []byte(strings.ToLower(s)), nil
+	}
+	return nil, fmt.Errorf("ldap: invalid object identifier %q", v)
+}
+
+// normalizeFirstComponent extracts the OID from a value such as an
+// attribute type description "( 2.5.4.3 NAME 'cn' ... )".
+func normalizeFirstComponent(v []byte) ([]byte, error) {
+	s := strings.TrimSpace(string(v))
+	if !strings.HasPrefix(s, "(") {
+		return nil, fmt.Errorf("ldap: invalid description %q", v)
+	}
+	f := strings.Fields(s[1:])
+	if len(f) == 0 {
+		return nil, fmt.Errorf("ldap: invalid description %q", v)
+	}
+	return normalizeOID([]byte(f[0]))
+}
+
+func normalizeDNValue(v []byte) ([]byte, error) {
+	dn, err := ParseDN(string(v))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(dn.Normalize().String()), nil
+}
+
+// normalizeNameAndOptionalUID normalizes a value of the Name and Optional
+// UID syntax: a DN optionally followed by "#" and a bit string.
+func normalizeNameAndOptionalUID(v []byte) ([]byte, error) {
+	s := string(v)
+	uid := ""
+	if i := strings.LastIndex(s, "#'"); i >= 0 && strings.HasSuffix(s, "'B") {
+		s, uid = s[:i], s[i:]
+		for _, c := range uid[2 : len(uid)-2] {
+			if c != '0' && c != '1' {
+				return nil, fmt.Errorf("ldap: invalid bit string %q", uid[1:])
+			}
+		}
+	}
+	dn, err := normalizeDNValue([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+	return append(dn, uid...), nil
+}
+
+func identity(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+var (
+	caseIgnoreNormalize, caseIgnoreSubstring = stringRule(true)
+	caseExactNormalize, caseExactSubstring   = stringRule(false)
+	ia5IgnoreNormalize, ia5IgnoreSubstring   = ia5Rule(true)
+	ia5ExactNormalize, ia5ExactSubstring     = ia5Rule(false)
+)
+
+var (
+	objectIdentifierMatch = &MatchingRule{
+		OID: "2.5.13.0", Names: []string{"objectIdentifierMatch"}, Syntax: SyntaxOID,
+		Kind: EqualityMatchingRule, Normalize: normalizeOID,
+	}
+	distinguishedNameMatch = &MatchingRule{
+		OID: "2.5.13.1", Names: []string{"distinguishedNameMatch"}, Syntax: SyntaxDN,
+		Kind: EqualityMatchingRule, Normalize: normalizeDNValue,
+	}
+	caseIgnoreMatch = &MatchingRule{
+		OID: "2.5.13.2", Names: []string{"caseIgnoreMatch"}, Syntax: SyntaxDirectoryString,
+		Kind: EqualityMatchingRule, Normalize: caseIgnoreNormalize,
+	}
+	caseIgnoreOrderingMatch = &MatchingRule{
+		OID: "2.5.13.3", Names: []string{"caseIgnoreOrderingMatch"}, Syntax: SyntaxDirectoryString,
+		Kind: OrderingMatchingRule, Normalize: caseIgnoreNormalize,
+	}
+	caseIgnoreSubstringsMatch = &MatchingRule{
+		OID: "2.5.13.4", Names: []string{"caseIgnoreSubstringsMatch"}, Syntax: SyntaxSubstringAssertion,
+		Kind: SubstringsMatchingRule, Normalize: caseIgnoreNormalize, NormalizeSubstring: caseIgnoreSubstring,
+	}
+	caseExactMatch = &MatchingRule{
+		OID: "2.5.13.5", Names: []string{"caseExactMatch"}, Syntax: SyntaxDirectoryString,
+		Kind: EqualityMatchingRule, Normalize: caseExactNormalize,
+	}
+	caseExactOrderingMatch = &MatchingRule{
+		OID: "2.5.13.6", Names: []string{"caseExactOrderingMatch"}, Syntax: SyntaxDirectoryString,
+		Kind: OrderingMatchingRule, Normalize: caseExactNormalize,
+	}
+	caseExactSubstringsMatch = &MatchingRule{
+		OID: "2.5.13.7", Names: []string{"caseExactSubstringsMatch"}, Syntax: SyntaxSubstringAssertion,
+		Kind: SubstringsMatchingRule, Normalize: caseExactNormalize, NormalizeSubstring: caseExactSubstring,
+	}
+	numericStringMatch = &MatchingRule{
+		OID: "2.5.13.8", Names: []string{"numericStringMatch"}, Syntax: SyntaxNumericString,
+		Kind: EqualityMatchingRule, Normalize: normalizeNumericString,
+	}
+	numericStringOrderingMatch = &MatchingRule{
+		OID: "2.5.13.9", Names: []string{"numericStringOrderingMatch"}, Syntax: SyntaxNumericString,
+		Kind: OrderingMatchingRule, Normalize: normalizeNumericString,
+	}
+	numericStringSubstringsMatch = &MatchingRule{
+		OID: "2.5.13.10", Names: []string{"numericStringSubstringsMatch"}, Syntax: SyntaxSubstringAssertion,
+		Kind: SubstringsMatchingRule, Normalize: normalizeNumericString,
+	}
+	booleanMatch = &MatchingRule{
+		OID: "2.5.13.13", Names: []string{"booleanMatch"}, Syntax: SyntaxBoolean,
+		Kind: EqualityMatchingRule, Normalize: normalizeBoolean,
+	}
+	integerMatch = &MatchingRule{
+		OID: "2.5.13.14", Names: []string{"integerMatch"}, Syntax: SyntaxInteger,
+		Kind: EqualityMatchingRule, Normalize: normalizeInteger,
+	}
+	integerOrderingMatch = &MatchingRule{
+		OID: "2.5.13.15", Names: []string{"integerOrderingMatch"}, Syntax: SyntaxInteger,
+		Kind: OrderingMatchingRule, Normalize: normalizeInteger, CompareFunc: compareIntegers,
+	}
+	octetStringMatch = &MatchingRule{
+		OID: "2.5.13.17", Names: []string{"octetStringMatch"}, Syntax: SyntaxOctetString,
+		Kind: EqualityMatchingRule, Normalize: identity,
+	}
+	octetStringOrderingMatch = &MatchingRule{
+		OID: "2.5.13.18", Names: []string{"octetStringOrderingMatch"}, Syntax: SyntaxOctetString,
+		Kind: OrderingMatchingRule, Normalize: identity,
+	}
+	telephoneNumberMatch = &MatchingRule{
+		OID: "2.5.13.20", Names: []string{"telephoneNumberMatch"}, Syntax: SyntaxTelephoneNumber,
+		Kind: EqualityMatchingRule, Normalize: normalizeTelephoneNumber,
+	}
+	telephoneNumberSubstringsMatch = &MatchingRule{
+		OID: "2.5.13.21", Names: []string{"telephoneNumberSubstringsMatch"}, Syntax: SyntaxSubstringAssertion,
+		Kind: SubstringsMatchingRule, Normalize: normalizeTelephoneNumber,
+	}
+	uniqueMemberMatch = &MatchingRule{
+		OID: "2.5.13.23", Names: []string{"uniqueMemberMatch"}, Syntax: SyntaxNameAndOptionalUID,
+		Kind: EqualityMatchingRule, Normalize: normalizeNameAndOptionalUID,
+	}
+	generalizedTimeMatch = &MatchingRule{
+		OID: "2.5.13.27", Names: []string{"generalizedTimeMatch"}, Syntax: SyntaxGeneralizedTime,
+		Kind: EqualityMatchingRule, Normalize: normalizeGeneralizedTime,
+	}
+	generalizedTimeOrderingMatch = &MatchingRule{
+		OID: "2.5.13.28", Names: []string{"generalizedTimeOrderingMatch"}, Syntax: SyntaxGeneralizedTime,
+		Kind: OrderingMatchingRule, Normalize: normalizeGeneralizedTime,
+	}
+	objectIdentifierFirstComponentMatch = &MatchingRule{
+		OID: "2.5.13.30", Names: []string{"objectIdentifierFirstComponentMatch"}, Syntax: SyntaxOID,
+		Kind: EqualityMatchingRule, Normalize: normalizeFirstComponent, NormalizeAssertion: normalizeOID,
+	}
+	caseExactIA5Match = &MatchingRule{
+		OID: "1.3.6.1.4.1.1466.109.114.1", Names: []string{"caseExactIA5Match"}, Syntax: SyntaxIA5String,
+		Kind: EqualityMatchingRule, Normalize: ia5ExactNormalize,
+	}
+	caseIgnoreIA5Match = &MatchingRule{
+		OID: "1.3.6.1.4.1.1466.109.114.2", Names: []string{"caseIgnoreIA5Match"}, Syntax: SyntaxIA5String,
+		Kind: EqualityMatchingRule, Normalize: ia5IgnoreNormalize,
+	}
+	caseIgnoreIA5SubstringsMatch = &MatchingRule{
+		OID: "1.3.6.1.4.1.1466.109.114.3", Names: []string{"caseIgnoreIA5SubstringsMatch"}, Syntax: SyntaxSubstringAssertion,
+		Kind: SubstringsMatchingRule, Normalize: ia5IgnoreNormalize, NormalizeSubstring: ia5IgnoreSubstring,
+	}
+	caseExactIA5SubstringsMatch = &MatchingRule{
+		OID: "1.3.6.1.4.1.4203.1.2.1", Names: []string{"caseExactIA5SubstringsMatch"}, Syntax: SyntaxSubstringAssertion,
+		Kind: SubstringsMatchingRule, Normalize: ia5ExactNormalize, NormalizeSubstring: ia5ExactSubstring,
+	}
+)
+
+var builtinMatchingRules = []*MatchingRule{
+	objectIdentifierMatch,
+	distinguishedNameMatch,
+	caseIgnoreMatch,
+	caseIgnoreOrderingMatch,
+	caseIgnoreSubstringsMatch,
+	caseExactMatch,
+	caseExactOrderingMatch,
+	caseExactSubstringsMatch,
+	numericStringMatch,
+	numericStringOrderingMatch,
+	numericStringSubstringsMatch,
+	booleanMatch,
+	integerMatch,
+	integerOrderingMatch,
+	octetStringMatch,
+	octetStringOrderingMatch,
+	telephoneNumberMatch,
+	telephoneNumberSubstringsMatch,
+	uniqueMemberMatch,
+	generalizedTimeMatch,
+	generalizedTimeOrderingMatch,
+	objectIdentifierFirstComponentMatch,
+	caseExactIA5Match,
+	caseIgnoreIA5Match,
+	caseIgnoreIA5SubstringsMatch,
+	caseExactIA5SubstringsMatch,
+}
+
+// Matching rules defined by Active Directory for use in extensible match
+// filters such as "(userAccountControl:1.2.840.113556.1.4.803:=2)".
+// OIDMatchingRuleInChain follows the chain of DN-valued attributes which
+// requires access to the directory so it's not registered and filters
+// using it are only evaluated by servers that support it.
+const (
+	OIDMatchingRuleBitAnd  = "1.2.840.113556.1.4.803"
+	OIDMatchingRuleBitOr   = "1.2.840.113556.1.4.804"
+	OIDMatchingRuleInChain = "1.2.840.113556.1.4.1941"
+)
+
+// bitwiseMatch returns a match function for integer values where op is
+// applied to the bits of the attribute and assertion values.
+func bitwiseMatch(op func(v, a uint64) bool) func(value, assertion []byte) (bool, error) {
+	return func(value, assertion []byte) (bool, error) {
+		v, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return false, errInvalidInteger
+		}
+		a, err := strconv.ParseInt(string(assertion), 10, 64)
+		if err != nil {
+			return false, errInvalidInteger
+		}
+		return op(uint64(v), uint64(a)), nil
+	}
+}
+
+// activeDirectoryMatchingRules are registered but not part of DefaultSchema
+// since their names are not valid descriptors.
+var activeDirectoryMatchingRules = []*MatchingRule{
+	{
+		OID: OIDMatchingRuleBitAnd, Names: []string{"LDAP_MATCHING_RULE_BIT_AND"}, Syntax: SyntaxInteger,
+		Kind: EqualityMatchingRule, MatchFunc: bitwiseMatch(func(v, a uint64) bool { return v&a == a }),
+	},
+	{
+		OID: OIDMatchingRuleBitOr, Names: []string{"LDAP_MATCHING_RULE_BIT_OR"}, Syntax: SyntaxInteger,
+		Kind: EqualityMatchingRule, MatchFunc: bitwiseMatch(func(v, a uint64) bool { return v&a != 0 }),
+	},
+}
@@ -0,0 +1,125 @@
+package ldap
+
+import "testing"
+
+func TestMatchingRuleEqual(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		rule      string
+		value     string
+		assertion string
+		match     bool
+		undefined bool
+	}{
+		{"caseIgnoreMatch", "  Hello   World ", "hello world", true, false},
+		{"caseIgnoreMatch", "Hello­World", "helloworld", true, false},
+		{"caseIgnoreMatch", "abc", "abd", false, false},
+		// NFKC: composed and decomposed forms, compatibility characters
+		{"caseIgnoreMatch", "Caf\u00e9", "cafe\u0301", true, false},
+		{"caseExactMatch", "\ufb01le", "file", true, false},
+		{"caseExactMatch", "\uff21BC", "ABC", true, false},
+		{"caseIgnoreMatch", "\u212b", "\u00e5", true, false},
+		{"caseIgnoreMatch", "\xff", "abc", false, true},
+		{"caseExactMatch", "Hello", "hello", false, false},
+		{"caseExactMatch", "Hello  there", "Hello there", true, false},
+		{"integerMatch", "42", "42", true, false},
+		{"integerMatch", "-7", "-7", true, false},
+		{"integerMatch", "042", "42", false, true},
+		{"booleanMatch", "TRUE", "TRUE", true, false},
+		{"booleanMatch", "true", "TRUE", false, true},
+		{"numericStringMatch", "123 456", "123456", true, false},
+		{"telephoneNumberMatch", "+1 555-123-4567", "+15551234567", true, false},
+		{"generalizedTimeMatch", "20240102030405Z", "20240101200405-0700", true, false},
+		{"generalizedTimeMatch", "2024010203Z", "20240102030000.0Z", true, false},
+		{"distinguishedNameMatch", "CN=John  Smith, DC=Example,dc=com", "cn=john smith,dc=example,dc=com", true, false},
+		{"distinguishedNameMatch", "commonName=a+sn=b,dc=x", "sn=B+cn=A,dc=X", true, false},
+		{"octetStringMatch", "abc", "ABC", false, false},
+		{"objectIdentifierMatch", "Person", "person", true, false},
+		{"objectIdentifierMatch", "2.5.6.6", "2.5.6.6", true, false},
+		{"objectIdentifierFirstComponentMatch", "( 2.5.4.3 NAME 'cn' SUP name )", "2.5.4.3", true, false},
+		{"caseIgnoreIA5Match", "Foo@Example.com", "foo@example.COM", true, false},
+		{"uniqueMemberMatch", "cn=A,dc=x#'0101'B", "CN=a,DC=X#'0101'B", true, false},
+	}
+	for _, c := range cases {
+		mr := LookupMatchingRule(c.rule)
+		if mr == nil {
+			t.Fatalf("Matching rule %s not registered", c.rule)
+		}
+		ok, err := mr.Equal([]byte(c.value), []byte(c.assertion))
+		if c.undefined {
+			if err == nil {
+				t.Errorf("%s(%q, %q) expected undefined", c.rule, c.value, c.assertion)
+			}
+		} else if err != nil {
+			t.Errorf("%s(%q, %q) failed: %s", c.rule, c.value, c.assertion, err)
+		} else if ok != c.match {
+			t.Errorf("%s(%q, %q) = %t, want %t", c.rule, c.value, c.assertion, ok, c.match)
+		}
+	}
+}
+
+func TestMatchingRuleOrdering(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		rule string
+		a, b string
+		cmp  int
+	}{
+		{"integerOrderingMatch", "9", "10", -1},
+		{"integerOrderingMatch", "-10", "-9", -1},
+		{"integerOrderingMatch", "100", "100", 0},
+		{"caseIgnoreOrderingMatch", "B", "a", 1},
+		{"generalizedTimeOrderingMatch", "20240101000000Z", "20231231235959.5Z", 1},
+		{"generalizedTimeOrderingMatch", "20240101000000+0100", "20240101000000Z", -1},
+	}
+	for _, c := range cases {
+		n, err := LookupMatchingRule(c.rule).Compare([]byte(c.a), []byte(c.b))
+		if err != nil {
+			t.Errorf("%s(%q, %q) failed: %s", c.rule, c.a, c.b, err)
+		} else if n != c.cmp {
+			t.Errorf("%s(%q, %q) = %d, want %d", c.rule, c.a, c.b, n, c.cmp)
+		}
+	}
+}
+
+func TestMatchingRuleSubstrings(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		rule    string
+		value   string
+		initial string
+		any     []string
+		final   string
+		match   bool
+	}{
+		{"caseIgnoreSubstringsMatch", "John Smith", "john", nil, "", true},
+		{"caseIgnoreSubstringsMatch", "John Smith", "", nil, "SMITH", true},
+		{"caseIgnoreSubstringsMatch", "John  Smith", "john ", nil, "", true},
+		{"caseIgnoreSubstringsMatch", "John Smith", "j", []string{"h", "m"}, "h", true},
+		{"caseIgnoreSubstringsMatch", "John Smith", "j", []string{"m", "h", "m"}, "", false},
+		{"caseIgnoreSubstringsMatch", "abc", "ab", nil, "bc", false},
+		{"caseExactSubstringsMatch", "John Smith", "john", nil, "", false},
+		{"telephoneNumberSubstringsMatch", "+1 555-123-4567", "+1555", nil, "", true},
+	}
+	for _, c := range cases {
+		ok, err := LookupMatchingRule(c.rule).MatchSubstrings([]byte(c.value), c.initial, c.any, c.final)
+		if err != nil {
+			t.Errorf("%s(%q) failed: %s", c.rule, c.value, err)
+		} else if ok != c.match {
+			t.Errorf("%s(%q, %q %q %q) = %t, want %t", c.rule, c.value, c.initial, c.any, c.final, ok, c.match)
+		}
+	}
+}
+
+func TestSchemaRules(t *testing.T) {
+	t.Parallel()
+	if mr := DefaultSchema.EqualityRule("cn"); mr == nil || mr.Name() != "caseIgnoreMatch" {
+		t.Errorf("Expected cn to inherit caseIgnoreMatch from name, got %+v", mr)
+	}
+	if mr := DefaultSchema.OrderingRule("uidNumber"); mr == nil || mr.Name() != "integerOrderingMatch" {
+		t.Errorf("Expected uidNumber ordering rule integerOrderingMatch, got %+v", mr)
+	}
+	if mr := DefaultSchema.EqualityRule("unknownAttribute"); mr != nil {
+		t.Errorf("Expected no rule for unknown attribute, got %+v", mr)
+	}
+}
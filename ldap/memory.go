@@ -0,0 +1,883 @@
+package ldap
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend is a Backend that keeps the directory in memory. It is safe
+// for use by concurrent connections.
+//
+// Simple binds are checked against the userPassword attribute of the entry
+// (see checkPassword for the supported schemes) and passwords set with the
+// PasswordModify operation are stored hashed. The backend performs no access
+// control and no schema checking other than requiring the values of an
+// entry's RDN to be present in the entry.
+type MemoryBackend struct {
+	// Schema is used to evaluate filters and to match attribute names and
+	// values. If nil then names are compared case-insensitively and values
+	// as octet strings.
+	Schema *Schema
+	// RootDN can bind with RootPassword without an entry and may change
+	// the password of any entry.
+	RootDN       string
+	RootPassword []byte
+	// SizeLimit is the maximum number of entries returned by a search. If 0
+	// only the limit of the request applies.
+	SizeLimit int
+
+	mu       sync.RWMutex
+	suffixes []DN
+	entries  map[string]*memoryEntry // by normalized DN
+	roots    []*memoryEntry
+	byID     map[uint64]*memoryEntry
+	nextID   uint64
+	index    *Index
+	indexes  map[string]IndexKind
+	// journal is called with each update before it's applied while the
+	// lock is held. The update fails if it returns an error.
+	journal func(Request) error
+}
+
+type memoryEntry struct {
+	id       uint64
+	dn       DN
+	entry    *Entry // replaced rather than modified since results share it
+	parent   *memoryEntry
+	children []*memoryEntry
+}
+
+// NewMemoryBackend returns an empty directory. Entries can be added below
+// existing entries or as one of the suffixes. If no suffixes are given then
+// any entry whose superior doesn't exist is added as a suffix.
+func NewMemoryBackend(suffixes ...string) (*MemoryBackend, error) {
+	b := &MemoryBackend{
+		Schema:  DefaultSchema,
+		entries: make(map[string]*memoryEntry),
+		byID:    make(map[uint64]*memoryEntry),
+	}
+	for _, s := range suffixes {
+		dn, err := ParseDN(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(dn) == 0 {
+			return nil, fmt.Errorf("ldap: empty suffix")
+		}
+		b.suffixes = append(b.suffixes, dn)
+	}
+	return b, nil
+}
+
+// NamingContexts returns the suffixes of the directory or, if none were
+// given, the DNs of the entries without a superior.
+func (b *MemoryBackend) NamingContexts() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var names []string
+	if len(b.suffixes) != 0 {
+		for _, dn := range b.suffixes {
+			names = append(names, dn.String())
+		}
+		return names
+	}
+	for _, me := range b.roots {
+		names = append(names, me.entry.DN)
+	}
+	return names
+}
+
+// Entry returns a copy of the entry with the DN or nil if it doesn't exist.
+func (b *MemoryBackend) Entry(dn string) *Entry {
+	d, err := ParseDN(dn)
+	if err != nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if me := b.entries[dnKey(d)]; me != nil {
+		return me.entry.Clone()
+	}
+	return nil
+}
+
+// SetIndexes indexes the attributes of the entries for searches (see
+// Index). Searches with a filter then only evaluate the filter against the
+// candidates found with the indexes.
+func (b *MemoryBackend) SetIndexes(attrs map[string]IndexKind) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	x, err := b.buildIndex(attrs)
+	if err != nil {
+		return err
+	}
+	b.index = x
+	b.indexes = attrs
+	return nil
+}
+
+// buildIndex returns an index of the entries. It must be called with the
+// lock held.
+func (b *MemoryBackend) buildIndex(attrs map[string]IndexKind) (*Index, error) {
+	x, err := NewIndex(b.Schema, attrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, me := range b.roots {
+		me.walk(func(me *memoryEntry) bool {
+			x.Add(me.id, me.parentID(), me.entry)
+			return true
+		})
+	}
+	return x, nil
+}
+
+// Explain returns the plan for a search as described by Plan.Explain. An
+// error is returned if the base of the search doesn't exist or there are
+// no indexes.
+func (b *MemoryBackend) Explain(req *SearchRequest) (string, error) {
+	base, err := ParseDN(req.BaseDN)
+	if err != nil {
+		return "", err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.index == nil {
+		return "", fmt.Errorf("ldap: no indexes")
+	}
+	var id uint64
+	if len(base) != 0 {
+		me := b.entries[dnKey(base)]
+		if me == nil {
+			return "", &BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(base)}
+		}
+		id = me.id
+	}
+	return b.index.Plan(id, req.Scope, req.Filter).Explain(), nil
+}
+
+// LoadLDIF adds the content records of an LDIF file and applies its change
+// records. It stops at the first record that fails.
+func (b *MemoryBackend) LoadLDIF(r io.Reader) error {
+	lr := NewLDIFReader(r)
+	for {
+		rec, err := lr.ReadRecord()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := b.apply(rec.Request); err != nil {
+			return fmt.Errorf("ldap: failed to apply record for %s on line %d: %w", rec.DN(), rec.Line, err)
+		}
+	}
+}
+
+// WriteLDIF writes all entries as LDIF with superiors before their
+// subordinates so the output can be loaded with LoadLDIF.
+func (b *MemoryBackend) WriteLDIF(w io.Writer) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	lw := NewLDIFWriter(w)
+	var err error
+	for _, me := range b.roots {
+		me.walk(func(me *memoryEntry) bool {
+			err = lw.WriteEntry(me.entry.SearchResult())
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply performs an update request.
+func (b *MemoryBackend) apply(req Request) error {
+	var res BaseResponse
+	switch req := req.(type) {
+	case *AddRequest:
+		res = b.add(req.Entry())
+	case *DeleteRequest:
+		res = b.delete(req.DN)
+	case *ModifyRequest:
+		res = b.modify(req.DN, req.Mods)
+	case *ModifyDNRequest:
+		res = b.modifyDN(req)
+	default:
+		return fmt.Errorf("ldap: unsupported request type %T", req)
+	}
+	return res.Err()
+}
+
+func (b *MemoryBackend) Connect(remoteAddr net.Addr) (State, error) {
+	return &bindState{}, nil
+}
+
+func (b *MemoryBackend) Disconnect(state State) {
+}
+
+func (b *MemoryBackend) Add(ctx context.Context, state State, req *AddRequest) (*AddResponse, error) {
+	return &AddResponse{BaseResponse: b.add(req.Entry())}, nil
+}
+
+func (b *MemoryBackend) Delete(ctx context.Context, state State, req *DeleteRequest) (*DeleteResponse, error) {
+	return &DeleteResponse{BaseResponse: b.delete(req.DN)}, nil
+}
+
+func (b *MemoryBackend) Modify(ctx context.Context, state State, req *ModifyRequest) (*ModifyResponse, error) {
+	return &ModifyResponse{BaseResponse: b.modify(req.DN, req.Mods)}, nil
+}
+
+func (b *MemoryBackend) ModifyDN(ctx context.Context, state State, req *ModifyDNRequest) (*ModifyDNResponse, error) {
+	return &ModifyDNResponse{BaseResponse: b.modifyDN(req)}, nil
+}
+
+func (b *MemoryBackend) ExtendedRequest(ctx context.Context, state State, req *ExtendedRequest) (*ExtendedResponse, error) {
+	return unsupportedExtended(req), nil
+}
+
+// Bind performs a simple bind. A failed bind leaves the connection
+// anonymous.
+func (b *MemoryBackend) Bind(ctx context.Context, state State, req *BindRequest) (*BindResponse, error) {
+	return simpleBind(state, req, b.authenticate)
+}
+
+// authenticate returns the DN of the RootDN or entry whose password it is.
+func (b *MemoryBackend) authenticate(dn DN, password []byte) (string, error) {
+	if b.isRoot(dn.String()) && len(b.RootPassword) != 0 &&
+		subtle.ConstantTimeCompare(password, b.RootPassword) == 1 {
+		return b.RootDN, nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if me := b.entries[dnKey(dn)]; me != nil {
+		for _, v := range b.editor().values(me.entry, "userPassword") {
+			if checkPassword(v, password) {
+				return me.entry.DN, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func (b *MemoryBackend) isRoot(dn string) bool {
+	return b.RootDN != "" && dn != "" && EqualDN(dn, b.RootDN)
+}
+
+// Whoami returns the authorization identity of the bound DN (RFC 4532).
+func (b *MemoryBackend) Whoami(ctx context.Context, state State) (string, error) {
+	return authzID(state), nil
+}
+
+// PasswordModify changes the password of the bound user, or of any user if
+// bound as the RootDN. The old password must match if given.
+func (b *MemoryBackend) PasswordModify(ctx context.Context, state State, req *PasswordModifyRequest) ([]byte, error) {
+	bound := boundDN(state)
+	target := strings.TrimPrefix(req.UserIdentity, "dn:")
+	if target == "" {
+		if bound == "" {
+			return nil, &BaseResponse{Code: ResultUnwillingToPerform, Message: "must be bound to change the password"}
+		}
+		target = bound
+	}
+	if !b.isRoot(bound) && !EqualDN(target, bound) {
+		return nil, &BaseResponse{Code: ResultInsufficientAccessRights, Message: "only the password of the bound user can be changed"}
+	}
+	dn, err := ParseDN(target)
+	if err != nil {
+		return nil, &BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}
+	}
+	var gen []byte
+	password := req.NewPassword
+	if len(password) == 0 {
+		if gen, err = generatePassword(); err != nil {
+			return nil, err
+		}
+		password = gen
+	}
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	me := b.entries[dnKey(dn)]
+	if me == nil {
+		return nil, &BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn)}
+	}
+	if req.OldPassword != nil {
+		ok := false
+		for _, v := range b.editor().values(me.entry, "userPassword") {
+			ok = ok || checkPassword(v, req.OldPassword)
+		}
+		if !ok {
+			return nil, &BaseResponse{Code: ResultInvalidCredentials, Message: "old password does not match"}
+		}
+	}
+	e := me.entry.Clone()
+	name := "userPassword"
+	if a := b.editor().attribute(e, name); a != nil {
+		name = a.Name
+	}
+	e.SetValues(name, hashed)
+	if res := b.record(&ModifyRequest{DN: target, Mods: []*Mod{{Type: Replace, Name: name, Values: [][]byte{hashed}}}}); res.Code != ResultSuccess {
+		return nil, &res
+	}
+	b.setEntry(me, e)
+	return gen, nil
+}
+
+// Search returns the entries in the scope of the search that match the
+// filter. The base entry "" is the superior of the suffixes.
+func (b *MemoryBackend) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	res := &SearchResponse{}
+	switch req.Scope {
+	case ScopeBaseObject, ScopeSingleLevel, ScopeWholeSubtree, ScopeChildren:
+	default:
+		res.Code = ResultProtocolError
+		res.Message = fmt.Sprintf("unknown scope %d", req.Scope)
+		return res, nil
+	}
+	base, err := ParseDN(req.BaseDN)
+	if err != nil {
+		res.Code = ResultInvalidDNSyntax
+		res.Message = err.Error()
+		return res, nil
+	}
+	var match FilterPredicate
+	if req.Filter != nil {
+		match = CompileFilter(req.Filter, b.Schema)
+	}
+	limit := req.SizeLimit
+	if b.SizeLimit > 0 && (limit <= 0 || b.SizeLimit < limit) {
+		limit = b.SizeLimit
+	}
+	var deadline time.Time
+	if req.TimeLimit > 0 {
+		deadline = time.Now().Add(time.Duration(req.TimeLimit) * time.Second)
+	}
+	visit := func(me *memoryEntry) bool {
+		if ctx.Err() != nil || !deadline.IsZero() && time.Now().After(deadline) {
+			res.Code = ResultTimeLimitExceeded
+			return false
+		}
+		if match != nil && match(me.entry) != FilterTrue {
+			return true
+		}
+		if limit > 0 && len(res.Results) == limit {
+			res.Code = ResultSizeLimitExceeded
+			return false
+		}
+		res.Results = append(res.Results, me.entry.SearchResult())
+		return true
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	children := b.roots
+	var baseID uint64
+	if len(base) != 0 {
+		me := b.entries[dnKey(base)]
+		if me == nil {
+			res.Code = ResultNoSuchObject
+			res.MatchedDN = b.matchedDN(base)
+			return res, nil
+		}
+		baseID = me.id
+		children = me.children
+	}
+	if b.index != nil && req.Filter != nil {
+		for _, id := range b.index.Plan(baseID, req.Scope, req.Filter).Candidates() {
+			if !visit(b.byID[id]) {
+				break
+			}
+		}
+		return res, nil
+	}
+	if len(base) != 0 {
+		me := b.byID[baseID]
+		if req.Scope == ScopeBaseObject || req.Scope == ScopeWholeSubtree {
+			if !visit(me) {
+				return res, nil
+			}
+		}
+	}
+	switch req.Scope {
+	case ScopeSingleLevel:
+		for _, c := range children {
+			if !visit(c) {
+				break
+			}
+		}
+	case ScopeWholeSubtree, ScopeChildren:
+		for _, c := range children {
+			if !c.walk(visit) {
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+func (b *MemoryBackend) add(e *Entry) BaseResponse {
+	dn, err := ParseDN(e.DN)
+	if err != nil {
+		return BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}
+	}
+	if len(dn) == 0 {
+		return BaseResponse{Code: ResultEntryAlreadyExists, Message: "the root DSE cannot be added"}
+	}
+	e = e.Clone()
+	for _, a := range e.Attributes {
+		if _, err := ParseAttributeDescription(a.Name); err != nil {
+			return BaseResponse{Code: ResultUndefinedAttributeType, Message: fmt.Sprintf("invalid attribute description %q", a.Name)}
+		}
+		if len(a.Values) == 0 {
+			return BaseResponse{Code: ResultProtocolError, Message: fmt.Sprintf("no values for attribute %s", a.Name)}
+		}
+		if v := b.editor().duplicate(a.Name, a.Values); v != nil {
+			return BaseResponse{Code: ResultAttributeOrValueExists, Message: fmt.Sprintf("duplicate value %q for attribute %s", v, a.Name)}
+		}
+	}
+	if res := b.editor().checkRDN(e, dn[0]); res.Code != ResultSuccess {
+		res.Code = ResultNamingViolation
+		return res
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := dnKey(dn)
+	if b.entries[key] != nil {
+		return BaseResponse{Code: ResultEntryAlreadyExists}
+	}
+	parent := b.entries[dnKey(dn.Parent())]
+	if parent == nil && !b.isSuffix(dn) {
+		return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn), Message: "superior entry does not exist"}
+	}
+	if res := b.record(e.AddRequest()); res.Code != ResultSuccess {
+		return res
+	}
+	b.nextID++
+	me := &memoryEntry{id: b.nextID, dn: dn, entry: e}
+	b.link(me, parent)
+	b.entries[key] = me
+	b.byID[me.id] = me
+	if b.index != nil {
+		b.index.Add(me.id, me.parentID(), e)
+	}
+	return BaseResponse{}
+}
+
+func (b *MemoryBackend) delete(dnStr string) BaseResponse {
+	dn, err := ParseDN(dnStr)
+	if err != nil {
+		return BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	me := b.entries[dnKey(dn)]
+	if me == nil {
+		return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn)}
+	}
+	if len(me.children) != 0 {
+		return BaseResponse{Code: ResultNotAllowedOnNonLeaf}
+	}
+	if res := b.record(&DeleteRequest{DN: dnStr}); res.Code != ResultSuccess {
+		return res
+	}
+	if b.index != nil {
+		b.index.Remove(me.id, me.entry)
+	}
+	b.unlink(me)
+	delete(b.entries, dnKey(dn))
+	delete(b.byID, me.id)
+	return BaseResponse{}
+}
+
+// modify applies the modifications to a copy of the entry so the entry is
+// left unchanged if any of them fails.
+func (b *MemoryBackend) modify(dnStr string, mods []*Mod) BaseResponse {
+	dn, err := ParseDN(dnStr)
+	if err != nil {
+		return BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	me := b.entries[dnKey(dn)]
+	if me == nil {
+		return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn)}
+	}
+	e := me.entry.Clone()
+	for _, m := range mods {
+		if res := b.editor().applyMod(e, m); res.Code != ResultSuccess {
+			return res
+		}
+	}
+	if res := b.editor().checkRDN(e, me.dn[0]); res.Code != ResultSuccess {
+		return res
+	}
+	if res := b.record(&ModifyRequest{DN: dnStr, Mods: mods}); res.Code != ResultSuccess {
+		return res
+	}
+	b.setEntry(me, e)
+	return BaseResponse{}
+}
+
+// applyMod applies a modification to the entry. The entry may be partly
+// modified if it fails.
+func (ed entryEditor) applyMod(e *Entry, m *Mod) BaseResponse {
+	if _, err := ParseAttributeDescription(m.Name); err != nil {
+		return BaseResponse{Code: ResultUndefinedAttributeType, Message: fmt.Sprintf("invalid attribute description %q", m.Name)}
+	}
+	if v := ed.duplicate(m.Name, m.Values); v != nil {
+		return BaseResponse{Code: ResultAttributeOrValueExists, Message: fmt.Sprintf("duplicate value %q for attribute %s", v, m.Name)}
+	}
+	a := ed.attribute(e, m.Name)
+	switch m.Type {
+	case Add:
+		if len(m.Values) == 0 {
+			return BaseResponse{Code: ResultProtocolError, Message: fmt.Sprintf("no values to add to %s", m.Name)}
+		}
+		if a == nil {
+			e.AddValues(m.Name, m.Values...)
+			return BaseResponse{}
+		}
+		for _, v := range m.Values {
+			if ed.indexOf(a, v) >= 0 {
+				return BaseResponse{Code: ResultAttributeOrValueExists, Message: fmt.Sprintf("value %q of %s exists", v, m.Name)}
+			}
+		}
+		a.Values = append(a.Values, m.Values...)
+	case Delete:
+		if a == nil {
+			return BaseResponse{Code: ResultNoSuchAttribute, Message: fmt.Sprintf("no attribute %s", m.Name)}
+		}
+		if len(m.Values) == 0 {
+			e.DeleteAttribute(a.Name)
+			return BaseResponse{}
+		}
+		for _, v := range m.Values {
+			i := ed.indexOf(a, v)
+			if i < 0 {
+				return BaseResponse{Code: ResultNoSuchAttribute, Message: fmt.Sprintf("no value %q of %s", v, m.Name)}
+			}
+			a.Values = append(a.Values[:i:i], a.Values[i+1:]...)
+		}
+		if len(a.Values) == 0 {
+			e.DeleteAttribute(a.Name)
+		}
+	case Replace:
+		if a != nil {
+			e.SetValues(a.Name, m.Values...)
+		} else if len(m.Values) != 0 {
+			e.AddValues(m.Name, m.Values...)
+		}
+	case Increment:
+		// RFC 4525
+		if a == nil {
+			return BaseResponse{Code: ResultNoSuchAttribute, Message: fmt.Sprintf("no attribute %s", m.Name)}
+		}
+		if len(m.Values) != 1 {
+			return BaseResponse{Code: ResultProtocolError, Message: "increment requires exactly one value"}
+		}
+		delta, err := parseInteger(m.Values[0])
+		if err != nil {
+			return BaseResponse{Code: ResultInvalidAttributeSyntax, Message: fmt.Sprintf("invalid increment %q", m.Values[0])}
+		}
+		values := make([][]byte, len(a.Values))
+		for i, v := range a.Values {
+			n, err := parseInteger(v)
+			if err != nil {
+				return BaseResponse{Code: ResultConstraintViolation, Message: fmt.Sprintf("value %q of %s is not an integer", v, a.Name)}
+			}
+			values[i] = []byte(n.Add(n, delta).String())
+		}
+		a.Values = values
+	default:
+		return BaseResponse{Code: ResultProtocolError, Message: fmt.Sprintf("unknown modification type %s", m.Type)}
+	}
+	return BaseResponse{}
+}
+
+func (b *MemoryBackend) modifyDN(req *ModifyDNRequest) BaseResponse {
+	dn, err := ParseDN(req.DN)
+	if err != nil {
+		return BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}
+	}
+	newRDN, err := ParseDN(req.NewRDN)
+	if err != nil || len(newRDN) != 1 {
+		return BaseResponse{Code: ResultInvalidDNSyntax, Message: fmt.Sprintf("invalid new RDN %q", req.NewRDN)}
+	}
+	superior := dn.Parent()
+	if req.NewSuperior != "" {
+		if superior, err = ParseDN(req.NewSuperior); err != nil {
+			return BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}
+		}
+	}
+	newDN := append(DN{newRDN[0]}, superior...)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	me := b.entries[dnKey(dn)]
+	if me == nil {
+		return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn)}
+	}
+	parent := me.parent
+	if req.NewSuperior != "" {
+		parent = b.entries[dnKey(superior)]
+		if parent == nil {
+			return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(superior), Message: "new superior does not exist"}
+		}
+		for p := parent; p != nil; p = p.parent {
+			if p == me {
+				return BaseResponse{Code: ResultUnwillingToPerform, Message: "cannot move an entry below itself"}
+			}
+		}
+	}
+	if parent == nil && !b.isSuffix(newDN) {
+		return BaseResponse{Code: ResultUnwillingToPerform, Message: "cannot rename a suffix"}
+	}
+	newKey := dnKey(newDN)
+	if other := b.entries[newKey]; other != nil && other != me {
+		return BaseResponse{Code: ResultEntryAlreadyExists}
+	}
+
+	ed := b.editor()
+	e := me.entry.Clone()
+	e.DN = newDN.String()
+	for _, atv := range newRDN[0] {
+		if a := ed.attribute(e, atv.Type); a == nil {
+			e.AddValues(atv.Type, []byte(atv.Value))
+		} else if ed.indexOf(a, []byte(atv.Value)) < 0 {
+			a.Values = append(a.Values, []byte(atv.Value))
+		}
+	}
+	if req.DeleteOldRDN {
+		for _, atv := range dn[0] {
+			if ed.rdnHas(newRDN[0], atv.Type, []byte(atv.Value)) {
+				continue
+			}
+			if a := ed.attribute(e, atv.Type); a != nil {
+				if i := ed.indexOf(a, []byte(atv.Value)); i >= 0 {
+					a.Values = append(a.Values[:i:i], a.Values[i+1:]...)
+				}
+				if len(a.Values) == 0 {
+					e.DeleteAttribute(a.Name)
+				}
+			}
+		}
+	}
+
+	if res := b.record(req); res.Code != ResultSuccess {
+		return res
+	}
+	oldLen := len(me.dn)
+	me.walk(func(d *memoryEntry) bool {
+		delete(b.entries, dnKey(d.dn))
+		return true
+	})
+	b.unlink(me)
+	b.setEntry(me, e)
+	me.walk(func(d *memoryEntry) bool {
+		if d != me {
+			d.dn = append(d.dn[:len(d.dn)-oldLen:len(d.dn)-oldLen], newDN...)
+			ne := *d.entry
+			ne.DN = d.dn.String()
+			d.entry = &ne
+		}
+		return true
+	})
+	me.dn = newDN
+	b.link(me, parent)
+	if b.index != nil {
+		b.index.Move(me.id, me.parentID())
+	}
+	me.walk(func(d *memoryEntry) bool {
+		b.entries[dnKey(d.dn)] = d
+		return true
+	})
+	return BaseResponse{}
+}
+
+// record passes an update to the journal.
+func (b *MemoryBackend) record(req Request) BaseResponse {
+	if b.journal == nil {
+		return BaseResponse{}
+	}
+	if err := b.journal(req); err != nil {
+		return BaseResponse{Code: ResultUnavailable, Message: err.Error()}
+	}
+	return BaseResponse{}
+}
+
+// link adds the entry to the children of the parent, or to the roots if
+// the parent is nil.
+func (b *MemoryBackend) link(me, parent *memoryEntry) {
+	me.parent = parent
+	if parent == nil {
+		b.roots = append(b.roots, me)
+	} else {
+		parent.children = append(parent.children, me)
+	}
+}
+
+func (b *MemoryBackend) unlink(me *memoryEntry) {
+	siblings := &b.roots
+	if me.parent != nil {
+		siblings = &me.parent.children
+	}
+	for i, c := range *siblings {
+		if c == me {
+			*siblings = append((*siblings)[:i:i], (*siblings)[i+1:]...)
+			break
+		}
+	}
+	me.parent = nil
+}
+
+// setEntry replaces the entry of me by e.
+func (b *MemoryBackend) setEntry(me *memoryEntry, e *Entry) {
+	if b.index != nil {
+		b.index.Update(me.id, me.entry, e)
+	}
+	me.entry = e
+}
+
+// parentID returns the ID of the parent of the entry or 0 for a suffix.
+func (me *memoryEntry) parentID() uint64 {
+	if me.parent == nil {
+		return 0
+	}
+	return me.parent.id
+}
+
+// walk calls fn for the entry and its subordinates until fn returns false.
+func (me *memoryEntry) walk(fn func(*memoryEntry) bool) bool {
+	if !fn(me) {
+		return false
+	}
+	for _, c := range me.children {
+		if !c.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *MemoryBackend) isSuffix(dn DN) bool {
+	if len(b.suffixes) == 0 {
+		return true
+	}
+	for _, s := range b.suffixes {
+		if s.Equal(dn) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchedDN returns the DN of the closest superior of dn that exists.
+func (b *MemoryBackend) matchedDN(dn DN) string {
+	for i := 1; i < len(dn); i++ {
+		if me := b.entries[dnKey(dn[i:])]; me != nil {
+			return me.entry.DN
+		}
+	}
+	return ""
+}
+
+func dnKey(dn DN) string {
+	return dn.Normalize().String()
+}
+
+// entryEditor applies modifications to entries and compares attribute
+// names and values using a schema.
+type entryEditor struct {
+	schema *Schema
+}
+
+func (b *MemoryBackend) editor() entryEditor {
+	return entryEditor{schema: b.Schema}
+}
+
+// checkRDN returns NotAllowedOnRDN if a value of the RDN is not in the entry.
+func (ed entryEditor) checkRDN(e *Entry, rdn RDN) BaseResponse {
+	for _, atv := range rdn {
+		if a := ed.attribute(e, atv.Type); a == nil || ed.indexOf(a, []byte(atv.Value)) < 0 {
+			return BaseResponse{Code: ResultNotAllowedOnRDN, Message: fmt.Sprintf("value %s of the RDN is not in the entry", atv)}
+		}
+	}
+	return BaseResponse{}
+}
+
+func (ed entryEditor) rdnHas(rdn RDN, typ string, value []byte) bool {
+	d := ed.differ()
+	for _, atv := range rdn {
+		if d.key(atv.Type) == d.key(typ) {
+			rule := d.rule(typ)
+			if normalizeValue(rule, []byte(atv.Value)) == normalizeValue(rule, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// differ is used for the attribute names and equality rules of the schema.
+func (ed entryEditor) differ() *differ {
+	return &differ{opts: &DiffOptions{Schema: ed.schema}}
+}
+
+// attribute returns the attribute of the entry with the same description
+// as name, allowing for aliases of the attribute type.
+func (ed entryEditor) attribute(e *Entry, name string) *Attribute {
+	d := ed.differ()
+	key := d.key(name)
+	for _, a := range e.Attributes {
+		if d.key(a.Name) == key {
+			return a
+		}
+	}
+	return nil
+}
+
+func (ed entryEditor) values(e *Entry, name string) [][]byte {
+	if a := ed.attribute(e, name); a != nil {
+		return a.Values
+	}
+	return nil
+}
+
+// indexOf returns the index of the value of the attribute that is equal to
+// v according to the equality rule or -1 if there is none.
+func (ed entryEditor) indexOf(a *Attribute, v []byte) int {
+	rule := ed.differ().rule(a.Name)
+	n := normalizeValue(rule, v)
+	for i, av := range a.Values {
+		if normalizeValue(rule, av) == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// duplicate returns a value that occurs more than once or nil.
+func (ed entryEditor) duplicate(name string, values [][]byte) []byte {
+	rule := ed.differ().rule(name)
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		n := normalizeValue(rule, v)
+		if seen[n] {
+			return v
+		}
+		seen[n] = true
+	}
+	return nil
+}
@@ -0,0 +1,602 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testMemoryLDIF = `version: 1
+
+dn: dc=example,dc=com
+objectClass: top
+objectClass: domain
+dc: example
+
+dn: ou=People,dc=example,dc=com
+objectClass: top
+objectClass: organizationalUnit
+ou: People
+
+dn: uid=jdoe,ou=People,dc=example,dc=com
+objectClass: top
+objectClass: person
+objectClass: inetOrgPerson
+uid: jdoe
+cn: John Doe
+sn: Doe
+userPassword: secret
+employeeNumber: 10
+
+dn: uid=asmith,ou=People,dc=example,dc=com
+objectClass: top
+objectClass: person
+objectClass: inetOrgPerson
+uid: asmith
+cn: Alice Smith
+sn: Smith
+userPassword: {SSHA}yrht1iYXEIkejLVu42JWkadd80RzYWx0c2FsdA==
+
+dn: ou=Groups,dc=example,dc=com
+objectClass: top
+objectClass: organizationalUnit
+ou: Groups
+`
+
+func newTestMemoryBackend(t *testing.T) *MemoryBackend {
+	t.Helper()
+	be, err := NewMemoryBackend("dc=example,dc=com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	be.RootDN = "cn=admin,dc=example,dc=com"
+	be.RootPassword = []byte("admin")
+	if err := be.LoadLDIF(strings.NewReader(testMemoryLDIF)); err != nil {
+		t.Fatal(err)
+	}
+	return be
+}
+
+// newTestDialer starts a server for the backend and returns a function that
+// opens a new connection to it.
+func newTestDialer(t *testing.T, be Backend) func() *Client {
+	t.Helper()
+	srv, err := NewServer(be, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(ln)
+	t.Cleanup(func() { ln.Close() })
+	return func() *Client {
+		t.Helper()
+		c, err := Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+}
+
+// searchDNs returns the sorted DNs found by a search and the error of the
+// search. An empty filter matches all entries.
+func searchDNs(t *testing.T, c *Client, base string, scope Scope, filter string) ([]string, error) {
+	t.Helper()
+	req := &SearchRequest{BaseDN: base, Scope: scope}
+	if filter != "" {
+		f, err := ParseFilter(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Filter = f
+	}
+	res, err := c.Search(req)
+	var dns []string
+	for _, r := range res {
+		dns = append(dns, r.DN)
+	}
+	sort.Strings(dns)
+	return dns, err
+}
+
+func resultCode(err error) ResultCode {
+	if e, ok := errorAsType[*BaseResponse](err); ok {
+		return e.Code
+	}
+	if err == nil {
+		return ResultSuccess
+	}
+	return ResultOther
+}
+
+func TestMemoryBackendSearch(t *testing.T) {
+	t.Parallel()
+	_, c := newTestServer(t, newTestMemoryBackend(t))
+
+	cases := []struct {
+		base   string
+		scope  Scope
+		filter string
+		limit  int
+		dns    []string
+		code   ResultCode
+	}{
+		{
+			base:  "dc=example,dc=com",
+			scope: ScopeBaseObject,
+			dns:   []string{"dc=example,dc=com"},
+		},
+		{
+			base:  "DC=Example, DC=Com",
+			scope: ScopeSingleLevel,
+			dns:   []string{"ou=Groups,dc=example,dc=com", "ou=People,dc=example,dc=com"},
+		},
+		{
+			base:  "dc=example,dc=com",
+			scope: ScopeWholeSubtree,
+			dns: []string{
+				"dc=example,dc=com",
+				"ou=Groups,dc=example,dc=com",
+				"ou=People,dc=example,dc=com",
+				"uid=asmith,ou=People,dc=example,dc=com",
+				"uid=jdoe,ou=People,dc=example,dc=com",
+			},
+		},
+		{
+			base:  "ou=People,dc=example,dc=com",
+			scope: ScopeChildren,
+			dns:   []string{"uid=asmith,ou=People,dc=example,dc=com", "uid=jdoe,ou=People,dc=example,dc=com"},
+		},
+		{
+			base:   "dc=example,dc=com",
+			scope:  ScopeWholeSubtree,
+			filter: "(&(objectClass=person)(cn=*doe))",
+			dns:    []string{"uid=jdoe,ou=People,dc=example,dc=com"},
+		},
+		{
+			base:   "dc=example,dc=com",
+			scope:  ScopeWholeSubtree,
+			filter: "(|(sn=doe)(uid=nobody))",
+			dns:    []string{"uid=jdoe,ou=People,dc=example,dc=com"},
+		},
+		{
+			base:   "dc=example,dc=com",
+			scope:  ScopeWholeSubtree,
+			filter: "(uid=nobody)",
+		},
+		{
+			base:   "dc=example,dc=com",
+			scope:  ScopeWholeSubtree,
+			filter: "(objectClass=organizationalUnit)",
+			limit:  2,
+			dns:    []string{"ou=Groups,dc=example,dc=com", "ou=People,dc=example,dc=com"},
+		},
+		{
+			base:   "dc=example,dc=com",
+			scope:  ScopeWholeSubtree,
+			filter: "(objectClass=person)",
+			limit:  1,
+			dns:    []string{"uid=jdoe,ou=People,dc=example,dc=com"},
+			code:   ResultSizeLimitExceeded,
+		},
+		{
+			base:  "uid=nobody,ou=People,dc=example,dc=com",
+			scope: ScopeBaseObject,
+			code:  ResultNoSuchObject,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s/%s/%s", tc.base, tc.scope, tc.filter), func(t *testing.T) {
+			req := &SearchRequest{BaseDN: tc.base, Scope: tc.scope, SizeLimit: tc.limit}
+			if tc.filter != "" {
+				f, err := ParseFilter(tc.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Filter = f
+			}
+			res, err := c.Search(req)
+			if code := resultCode(err); code != tc.code {
+				t.Fatalf("expected %s got %v", tc.code, err)
+			}
+			var dns []string
+			for _, r := range res {
+				dns = append(dns, r.DN)
+			}
+			sort.Strings(dns)
+			if !reflect.DeepEqual(dns, tc.dns) {
+				t.Errorf("expected %q got %q", tc.dns, dns)
+			}
+		})
+	}
+
+	_, err := c.Search(&SearchRequest{BaseDN: "uid=nobody,ou=People,dc=example,dc=com"})
+	if e, ok := errorAsType[*BaseResponse](err); !ok || e.MatchedDN != "ou=People,dc=example,dc=com" {
+		t.Errorf("expected matched DN ou=People,dc=example,dc=com got %v", err)
+	}
+}
+
+func TestMemoryBackendUpdate(t *testing.T) {
+	t.Parallel()
+	_, c := newTestServer(t, newTestMemoryBackend(t))
+	const jdoe = "uid=jdoe,ou=People,dc=example,dc=com"
+
+	cases := []struct {
+		name string
+		fn   func() error
+		code ResultCode
+	}{
+		{"add", func() error {
+			return c.Add(&AddRequest{DN: "cn=admins,ou=Groups,dc=example,dc=com", Attributes: map[string][][]byte{
+				"objectClass": {[]byte("groupOfNames")},
+				"cn":          {[]byte("admins")},
+				"member":      {[]byte(jdoe)},
+			}})
+		}, ResultSuccess},
+		{"add exists", func() error {
+			return c.Add(&AddRequest{DN: "UID=JDoe,ou=People,dc=example,dc=com", Attributes: map[string][][]byte{
+				"uid": {[]byte("JDoe")},
+			}})
+		}, ResultEntryAlreadyExists},
+		{"add no superior", func() error {
+			return c.Add(&AddRequest{DN: "uid=x,ou=Nobody,dc=example,dc=com", Attributes: map[string][][]byte{
+				"uid": {[]byte("x")},
+			}})
+		}, ResultNoSuchObject},
+		{"add outside suffix", func() error {
+			return c.Add(&AddRequest{DN: "dc=org", Attributes: map[string][][]byte{
+				"dc": {[]byte("org")},
+			}})
+		}, ResultNoSuchObject},
+		{"add missing rdn", func() error {
+			return c.Add(&AddRequest{DN: "uid=x,ou=People,dc=example,dc=com", Attributes: map[string][][]byte{
+				"cn": {[]byte("x")},
+			}})
+		}, ResultNamingViolation},
+		{"delete non-leaf", func() error {
+			return c.Delete("ou=People,dc=example,dc=com")
+		}, ResultNotAllowedOnNonLeaf},
+		{"delete missing", func() error {
+			return c.Delete("uid=nobody,ou=People,dc=example,dc=com")
+		}, ResultNoSuchObject},
+		{"delete", func() error {
+			return c.Delete("cn=admins,ou=Groups,dc=example,dc=com")
+		}, ResultSuccess},
+		{"modify", func() error {
+			return c.Modify(jdoe, []*Mod{
+				{Type: Add, Name: "mail", Values: [][]byte{[]byte("jdoe@example.com")}},
+				{Type: Replace, Name: "sn", Values: [][]byte{[]byte("Doe"), []byte("Doh")}},
+				{Type: Delete, Name: "CN", Values: [][]byte{[]byte("JOHN DOE")}},
+				{Type: Add, Name: "cn", Values: [][]byte{[]byte("Johnny")}},
+				{Type: Increment, Name: "employeeNumber", Values: [][]byte{[]byte("5")}},
+			})
+		}, ResultSuccess},
+		{"modify value exists", func() error {
+			return c.Modify(jdoe, []*Mod{{Type: Add, Name: "mail", Values: [][]byte{[]byte("JDOE@example.com")}}})
+		}, ResultAttributeOrValueExists},
+		{"modify delete duplicate values", func() error {
+			return c.Modify(jdoe, []*Mod{{Type: Delete, Name: "sn", Values: [][]byte{[]byte("Doe"), []byte("DOE")}}})
+		}, ResultAttributeOrValueExists},
+		{"modify no attribute", func() error {
+			return c.Modify(jdoe, []*Mod{{Type: Delete, Name: "description"}})
+		}, ResultNoSuchAttribute},
+		{"modify no value", func() error {
+			return c.Modify(jdoe, []*Mod{{Type: Delete, Name: "sn", Values: [][]byte{[]byte("Smith")}}})
+		}, ResultNoSuchAttribute},
+		{"modify rdn", func() error {
+			return c.Modify(jdoe, []*Mod{{Type: Delete, Name: "uid"}})
+		}, ResultNotAllowedOnRDN},
+		{"increment not integer", func() error {
+			return c.Modify(jdoe, []*Mod{{Type: Increment, Name: "sn", Values: [][]byte{[]byte("1")}}})
+		}, ResultConstraintViolation},
+		{"increment invalid", func() error {
+			return c.Modify(jdoe, []*Mod{{Type: Increment, Name: "employeeNumber", Values: [][]byte{[]byte("x")}}})
+		}, ResultInvalidAttributeSyntax},
+		{"modify missing", func() error {
+			return c.Modify("uid=nobody,ou=People,dc=example,dc=com", []*Mod{{Type: Delete, Name: "sn"}})
+		}, ResultNoSuchObject},
+	}
+	for _, tc := range cases {
+		if code := resultCode(tc.fn()); code != tc.code {
+			t.Errorf("%s: expected %s got %s", tc.name, tc.code, code)
+		}
+	}
+
+	res, err := c.Search(&SearchRequest{BaseDN: jdoe, Scope: ScopeBaseObject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := res[0].Entry()
+	for name, want := range map[string][]string{
+		"cn":             {"Johnny"},
+		"sn":             {"Doe", "Doh"},
+		"mail":           {"jdoe@example.com"},
+		"employeeNumber": {"15"},
+	} {
+		var got []string
+		for _, v := range e.GetAllRaw(name) {
+			got = append(got, string(v))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %s %q got %q", name, want, got)
+		}
+	}
+}
+
+func TestMemoryBackendModifyDN(t *testing.T) {
+	t.Parallel()
+	be := newTestMemoryBackend(t)
+	_, c := newTestServer(t, be)
+
+	if err := c.ModifyDN(&ModifyDNRequest{
+		DN:           "uid=jdoe,ou=People,dc=example,dc=com",
+		NewRDN:       "uid=john",
+		DeleteOldRDN: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e := be.Entry("uid=john,ou=People,dc=example,dc=com")
+	if e == nil {
+		t.Fatal("renamed entry not found")
+	}
+	if v := e.GetAllRaw("uid"); len(v) != 1 || string(v[0]) != "john" {
+		t.Errorf("expected uid john got %q", v)
+	}
+	if be.Entry("uid=jdoe,ou=People,dc=example,dc=com") != nil {
+		t.Error("old entry still exists")
+	}
+
+	if err := c.ModifyDN(&ModifyDNRequest{
+		DN:          "ou=People,dc=example,dc=com",
+		NewRDN:      "ou=Staff",
+		NewSuperior: "ou=Groups,dc=example,dc=com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	dns, err := searchDNs(t, c, "ou=Groups,dc=example,dc=com", ScopeWholeSubtree, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"ou=Groups,dc=example,dc=com",
+		"ou=Staff,ou=Groups,dc=example,dc=com",
+		"uid=asmith,ou=Staff,ou=Groups,dc=example,dc=com",
+		"uid=john,ou=Staff,ou=Groups,dc=example,dc=com",
+	}
+	if !reflect.DeepEqual(dns, want) {
+		t.Errorf("expected %q got %q", want, dns)
+	}
+	if e := be.Entry("ou=Staff,ou=Groups,dc=example,dc=com"); e == nil || len(e.GetAllRaw("ou")) != 2 {
+		t.Errorf("expected old RDN value to be kept got %+v", e)
+	}
+
+	cases := []struct {
+		req  *ModifyDNRequest
+		code ResultCode
+	}{
+		{&ModifyDNRequest{DN: "ou=Groups,dc=example,dc=com", NewRDN: "ou=Groups", NewSuperior: "ou=Staff,ou=Groups,dc=example,dc=com"}, ResultUnwillingToPerform},
+		{&ModifyDNRequest{DN: "ou=Groups,dc=example,dc=com", NewRDN: "ou=Groups", NewSuperior: "ou=Nobody,dc=example,dc=com"}, ResultNoSuchObject},
+		{&ModifyDNRequest{DN: "uid=nobody,dc=example,dc=com", NewRDN: "uid=x"}, ResultNoSuchObject},
+		{&ModifyDNRequest{DN: "ou=Groups,dc=example,dc=com", NewRDN: "ou=Staff", NewSuperior: "ou=Groups,dc=example,dc=com"}, ResultUnwillingToPerform},
+		{&ModifyDNRequest{DN: "ou=Staff,ou=Groups,dc=example,dc=com", NewRDN: "ou=Groups", NewSuperior: "dc=example,dc=com"}, ResultEntryAlreadyExists},
+		{&ModifyDNRequest{DN: "dc=example,dc=com", NewRDN: "dc=other"}, ResultUnwillingToPerform},
+	}
+	for _, tc := range cases {
+		if code := resultCode(c.ModifyDN(tc.req)); code != tc.code {
+			t.Errorf("%+v: expected %s got %s", tc.req, tc.code, code)
+		}
+	}
+}
+
+func TestMemoryBackendBind(t *testing.T) {
+	t.Parallel()
+	be := newTestMemoryBackend(t)
+	dial := newTestDialer(t, be)
+
+	cases := []struct {
+		dn       string
+		password string
+		code     ResultCode
+		whoami   string
+	}{
+		{"", "", ResultSuccess, "anonymous"},
+		{"uid=jdoe,ou=People,dc=example,dc=com", "secret", ResultSuccess, "dn:uid=jdoe,ou=People,dc=example,dc=com"},
+		{"UID=jdoe, ou=people,dc=example,dc=com", "secret", ResultSuccess, "dn:uid=jdoe,ou=People,dc=example,dc=com"},
+		{"uid=asmith,ou=People,dc=example,dc=com", "password", ResultSuccess, "dn:uid=asmith,ou=People,dc=example,dc=com"},
+		{"cn=admin,dc=example,dc=com", "admin", ResultSuccess, "dn:cn=admin,dc=example,dc=com"},
+		{"uid=jdoe,ou=People,dc=example,dc=com", "wrong", ResultInvalidCredentials, "anonymous"},
+		{"uid=nobody,ou=People,dc=example,dc=com", "secret", ResultInvalidCredentials, "anonymous"},
+		{"uid=jdoe,ou=People,dc=example,dc=com", "", ResultUnwillingToPerform, "anonymous"},
+		{"", "secret", ResultInvalidCredentials, "anonymous"},
+	}
+	for _, tc := range cases {
+		c := dial()
+		if code := resultCode(c.Bind(tc.dn, []byte(tc.password))); code != tc.code {
+			t.Errorf("bind %q: expected %s got %s", tc.dn, tc.code, code)
+		}
+		who, err := c.WhoAmI()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if who != tc.whoami {
+			t.Errorf("bind %q: expected whoami %q got %q", tc.dn, tc.whoami, who)
+		}
+	}
+}
+
+func TestMemoryBackendPasswordModify(t *testing.T) {
+	t.Parallel()
+	be := newTestMemoryBackend(t)
+	dial := newTestDialer(t, be)
+	anon := dial()
+	const jdoe = "uid=jdoe,ou=People,dc=example,dc=com"
+	const asmith = "uid=asmith,ou=People,dc=example,dc=com"
+
+	if _, err := anon.PasswordModify(&PasswordModifyRequest{NewPassword: []byte("x")}); resultCode(err) != ResultUnwillingToPerform {
+		t.Errorf("expected UnwillingToPerform for anonymous got %v", err)
+	}
+
+	c := dial()
+	if err := c.Bind(jdoe, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PasswordModify(&PasswordModifyRequest{OldPassword: []byte("wrong"), NewPassword: []byte("x")}); resultCode(err) != ResultInvalidCredentials {
+		t.Errorf("expected InvalidCredentials for wrong old password got %v", err)
+	}
+	if _, err := c.PasswordModify(&PasswordModifyRequest{UserIdentity: "dn:" + asmith, NewPassword: []byte("x")}); resultCode(err) != ResultInsufficientAccessRights {
+		t.Errorf("expected InsufficientAccessRights got %v", err)
+	}
+	gen, err := c.PasswordModify(&PasswordModifyRequest{OldPassword: []byte("secret"), NewPassword: []byte("new secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen != nil {
+		t.Errorf("expected no generated password got %q", gen)
+	}
+	if v := be.Entry(jdoe).GetAllRaw("userPassword"); len(v) != 1 || !bytes.HasPrefix(v[0], []byte("{SSHA}")) {
+		t.Errorf("expected hashed password got %q", v)
+	}
+	if err := c.Bind(jdoe, []byte("secret")); resultCode(err) != ResultInvalidCredentials {
+		t.Errorf("expected old password to fail got %v", err)
+	}
+	if err := c.Bind(jdoe, []byte("new secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Bind("cn=admin,dc=example,dc=com", []byte("admin")); err != nil {
+		t.Fatal(err)
+	}
+	gen, err = c.PasswordModify(&PasswordModifyRequest{UserIdentity: asmith})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gen) == 0 {
+		t.Fatal("expected a generated password")
+	}
+	if err := c.Bind(asmith, gen); err != nil {
+		t.Errorf("bind with generated password failed: %s", err)
+	}
+}
+
+func TestMemoryBackendConcurrent(t *testing.T) {
+	t.Parallel()
+	be := newTestMemoryBackend(t)
+	dial := newTestDialer(t, be)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		c := dial()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				dn := fmt.Sprintf("uid=u%d-%d,ou=People,dc=example,dc=com", i, j)
+				if err := c.Add(&AddRequest{DN: dn, Attributes: map[string][][]byte{
+					"uid":     {[]byte(fmt.Sprintf("u%d-%d", i, j))},
+					"counter": {[]byte("0")},
+				}}); err != nil {
+					errs <- err
+					return
+				}
+				if err := c.Modify("uid=jdoe,ou=People,dc=example,dc=com", []*Mod{
+					{Type: Increment, Name: "employeeNumber", Values: [][]byte{[]byte("1")}},
+				}); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := c.Search(&SearchRequest{BaseDN: "ou=People,dc=example,dc=com", Scope: ScopeSingleLevel}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if v := be.Entry("uid=jdoe,ou=People,dc=example,dc=com").GetAllRaw("employeeNumber"); len(v) != 1 || string(v[0]) != "170" {
+		t.Errorf("expected employeeNumber 170 got %q", v)
+	}
+}
+
+func TestMemoryBackendLDIF(t *testing.T) {
+	t.Parallel()
+	be := newTestMemoryBackend(t)
+	if err := be.LoadLDIF(strings.NewReader(`
+dn: uid=jdoe,ou=People,dc=example,dc=com
+changetype: modify
+add: mail
+mail: jdoe@example.com
+-
+`)); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := be.WriteLDIF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	be2, err := NewMemoryBackend("dc=example,dc=com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := be2.LoadLDIF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, dn := range []string{"dc=example,dc=com", "ou=People,dc=example,dc=com", "uid=jdoe,ou=People,dc=example,dc=com"} {
+		if mods, _ := DiffEntries(be.Entry(dn), be2.Entry(dn), nil); len(mods) != 0 {
+			t.Errorf("%s differs after round trip: %+v", dn, mods)
+		}
+	}
+	if got := be2.NamingContexts(); !reflect.DeepEqual(got, []string{"dc=example,dc=com"}) {
+		t.Errorf("expected naming contexts [dc=example,dc=com] got %q", got)
+	}
+
+	err = be.LoadLDIF(strings.NewReader("dn: uid=jdoe,ou=People,dc=example,dc=com\nuid: jdoe\n"))
+	if resultCode(err) != ResultEntryAlreadyExists || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected EntryAlreadyExists on line 1 got %v", err)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	t.Parallel()
+	hashed, err := hashPassword([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		stored   string
+		password string
+		ok       bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "Secret", false},
+		{"{CLEARTEXT}secret", "secret", true},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret", true},
+		{"{sha}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret", true},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "wrong", false},
+		{"{SSHA}yrht1iYXEIkejLVu42JWkadd80RzYWx0c2FsdA==", "password", true},
+		{"{SHA256}K7gNU3sdo+OL0wNhqoVWhr3g6s1xYv72ol/pe/Unols=", "secret", true},
+		{"{MD5}Xr4ilOzQ4PCOq3aQ0qbuaQ==", "secret", false},
+		{"{SHA}invalid", "secret", false},
+		{"{SHA", "secret", false},
+		{string(hashed), "secret", true},
+		{string(hashed), "wrong", false},
+	}
+	for _, tc := range cases {
+		if ok := checkPassword([]byte(tc.stored), []byte(tc.password)); ok != tc.ok {
+			t.Errorf("checkPassword(%q, %q) = %t, expected %t", tc.stored, tc.password, ok, tc.ok)
+		}
+	}
+}
@@ -80,6 +80,10 @@ func parseModifyRequest(pkt *Packet) (*ModifyRequest, error) {
 }
 
 func (r *ModifyRequest) WritePackets(w io.Writer, msgID int) error {
+	return r.writePackets(w, msgID, nil)
+}
+
+func (r *ModifyRequest) writePackets(w io.Writer, msgID int, controls []*Control) error {
 	req := NewRequestPacket(msgID)
 	pkt := req.AddItem(NewPacket(ClassApplication, false, ApplicationModifyRequest, nil))
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.DN))
@@ -94,7 +98,7 @@ func (r *ModifyRequest) WritePackets(w io.Writer, msgID int) error {
 			p.AddItem(NewPacket(ClassUniversal, true, TagOctetString, v))
 		}
 	}
-	return req.Write(w)
+	return writeRequest(w, req, controls)
 }
 
 func (r *ModifyResponse) WritePackets(w io.Writer, msgID int) error {
@@ -40,6 +40,22 @@ func parseModifyDNRequest(pkt *Packet) (*ModifyDNRequest, error) {
 	return req, nil
 }
 
+func (r *ModifyDNRequest) WritePackets(w io.Writer, msgID int) error {
+	return r.writePackets(w, msgID, nil)
+}
+
+func (r *ModifyDNRequest) writePackets(w io.Writer, msgID int, controls []*Control) error {
+	req := NewRequestPacket(msgID)
+	pkt := req.AddItem(NewPacket(ClassApplication, false, ApplicationModifyDNRequest, nil))
+	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.DN))
+	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.NewRDN))
+	pkt.AddItem(NewPacket(ClassUniversal, true, TagBoolean, r.DeleteOldRDN))
+	if r.NewSuperior != "" {
+		pkt.AddItem(NewPacket(ClassContext, true, 0, r.NewSuperior))
+	}
+	return writeRequest(w, req, controls)
+}
+
 func (r *ModifyDNResponse) WritePackets(w io.Writer, msgID int) error {
 	res := NewResponsePacket(msgID)
 	pkt := res.AddItem(r.BaseResponse.NewPacket())
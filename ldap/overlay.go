@@ -0,0 +1,348 @@
+package ldap
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// Overlay intercepts the operations of a backend. Each method is given the
+// next layer of the chain, which is either the next overlay or the backend.
+// An overlay may change the request before passing it on, change the
+// response on the way back, or return its own result without calling next
+// at all.
+//
+// The State passed to an overlay is the state of the backend and it must be
+// passed to next unchanged. Connect must return the state returned by next
+// unless it fails. An overlay that needs the identity of a connection can
+// ask next with Whoami. A backend may not perform compares itself so
+// BaseOverlay's Compare passes one on to next.
+//
+// The entries of a search are collected for Search. An overlay that can
+// handle them as they're found may also implement
+//
+//	SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error, next Backend) (*SearchResponse, error)
+//
+// which is used instead of Search. It passes the entries to send and
+// returns the references and result of the search. The next overlay of a
+// chain has a SearchFunc method without the next argument, as may the
+// backend. BaseOverlay doesn't implement it since an overlay that embeds
+// BaseOverlay to intercept Search would be bypassed.
+//
+// Embed BaseOverlay to implement only the operations an overlay intercepts.
+type Overlay interface {
+	Add(ctx context.Context, state State, req *AddRequest, next Backend) (*AddResponse, error)
+	Bind(ctx context.Context, state State, req *BindRequest, next Backend) (*BindResponse, error)
+	Compare(ctx context.Context, state State, req *CompareRequest, next Backend) (*CompareResponse, error)
+	Connect(remoteAddr net.Addr, next Backend) (State, error)
+	Delete(ctx context.Context, state State, req *DeleteRequest, next Backend) (*DeleteResponse, error)
+	Disconnect(state State, next Backend)
+	ExtendedRequest(ctx context.Context, state State, req *ExtendedRequest, next Backend) (*ExtendedResponse, error)
+	Modify(ctx context.Context, state State, req *ModifyRequest, next Backend) (*ModifyResponse, error)
+	ModifyDN(ctx context.Context, state State, req *ModifyDNRequest, next Backend) (*ModifyDNResponse, error)
+	PasswordModify(ctx context.Context, state State, req *PasswordModifyRequest, next Backend) ([]byte, error)
+	Search(ctx context.Context, state State, req *SearchRequest, next Backend) (*SearchResponse, error)
+	Whoami(ctx context.Context, state State, next Backend) (string, error)
+}
+
+// BaseOverlay passes every operation to the next layer.
+type BaseOverlay struct{}
+
+func (BaseOverlay) Add(ctx context.Context, state State, req *AddRequest, next Backend) (*AddResponse, error) {
+	return next.Add(ctx, state, req)
+}
+
+func (BaseOverlay) Bind(ctx context.Context, state State, req *BindRequest, next Backend) (*BindResponse, error) {
+	return next.Bind(ctx, state, req)
+}
+
+func (BaseOverlay) Compare(ctx context.Context, state State, req *CompareRequest, next Backend) (*CompareResponse, error) {
+	return compare(ctx, next, state, req)
+}
+
+func (BaseOverlay) Connect(remoteAddr net.Addr, next Backend) (State, error) {
+	return next.Connect(remoteAddr)
+}
+
+func (BaseOverlay) Delete(ctx context.Context, state State, req *DeleteRequest, next Backend) (*DeleteResponse, error) {
+	return next.Delete(ctx, state, req)
+}
+
+func (BaseOverlay) Disconnect(state State, next Backend) {
+	next.Disconnect(state)
+}
+
+func (BaseOverlay) ExtendedRequest(ctx context.Context, state State, req *ExtendedRequest, next Backend) (*ExtendedResponse, error) {
+	return next.ExtendedRequest(ctx, state, req)
+}
+
+func (BaseOverlay) Modify(ctx context.Context, state State, req *ModifyRequest, next Backend) (*ModifyResponse, error) {
+	return next.Modify(ctx, state, req)
+}
+
+func (BaseOverlay) ModifyDN(ctx context.Context, state State, req *ModifyDNRequest, next Backend) (*ModifyDNResponse, error) {
+	return next.ModifyDN(ctx, state, req)
+}
+
+func (BaseOverlay) PasswordModify(ctx context.Context, state State, req *PasswordModifyRequest, next Backend) ([]byte, error) {
+	return next.PasswordModify(ctx, state, req)
+}
+
+func (BaseOverlay) Search(ctx context.Context, state State, req *SearchRequest, next Backend) (*SearchResponse, error) {
+	return next.Search(ctx, state, req)
+}
+
+func (BaseOverlay) Whoami(ctx context.Context, state State, next Backend) (string, error) {
+	return next.Whoami(ctx, state)
+}
+
+// NewOverlayChain returns a backend that performs operations through the
+// overlays and then the backend. The first overlay is the outermost so it
+// sees requests first and responses last. The naming contexts of the
+// backend are published by the chain.
+func NewOverlayChain(be Backend, overlays ...Overlay) Backend {
+	next := be
+	for i := len(overlays) - 1; i >= 0; i-- {
+		next = &overlayLink{overlay: overlays[i], next: next}
+	}
+	return next
+}
+
+// searchFuncOverlay is implemented by overlays that intercept the entries
+// of a search as they're found.
+type searchFuncOverlay interface {
+	SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error, next Backend) (*SearchResponse, error)
+}
+
+// overlayLink is a layer of an overlay chain.
+type overlayLink struct {
+	overlay Overlay
+	next    Backend
+}
+
+func (l *overlayLink) NamingContexts() []string {
+	if nc, ok := l.next.(namingContexter); ok {
+		return nc.NamingContexts()
+	}
+	return nil
+}
+
+// SupportsControl returns true if the overlay or the next layer supports
+// the control.
+func (l *overlayLink) SupportsControl(oid string) bool {
+	if cs, ok := l.overlay.(controlSupporter); ok && cs.SupportsControl(oid) {
+		return true
+	}
+	cs, ok := l.next.(controlSupporter)
+	return ok && cs.SupportsControl(oid)
+}
+
+func (l *overlayLink) Add(ctx context.Context, state State, req *AddRequest) (*AddResponse, error) {
+	return l.overlay.Add(ctx, state, req, l.next)
+}
+
+func (l *overlayLink) Bind(ctx context.Context, state State, req *BindRequest) (*BindResponse, error) {
+	return l.overlay.Bind(ctx, state, req, l.next)
+}
+
+func (l *overlayLink) Compare(ctx context.Context, state State, req *CompareRequest) (*CompareResponse, error) {
+	return l.overlay.Compare(ctx, state, req, l.next)
+}
+
+func (l *overlayLink) Connect(remoteAddr net.Addr) (State, error) {
+	return l.overlay.Connect(remoteAddr, l.next)
+}
+
+func (l *overlayLink) Delete(ctx context.Context, state State, req *DeleteRequest) (*DeleteResponse, error) {
+	return l.overlay.Delete(ctx, state, req, l.next)
+}
+
+func (l *overlayLink) Disconnect(state State) {
+	l.overlay.Disconnect(state, l.next)
+}
+
+func (l *overlayLink) ExtendedRequest(ctx context.Context, state State, req *ExtendedRequest) (*ExtendedResponse, error) {
+	return l.overlay.ExtendedRequest(ctx, state, req, l.next)
+}
+
+func (l *overlayLink) Modify(ctx context.Context, state State, req *ModifyRequest) (*ModifyResponse, error) {
+	return l.overlay.Modify(ctx, state, req, l.next)
+}
+
+func (l *overlayLink) ModifyDN(ctx context.Context, state State, req *ModifyDNRequest) (*ModifyDNResponse, error) {
+	return l.overlay.ModifyDN(ctx, state, req, l.next)
+}
+
+func (l *overlayLink) PasswordModify(ctx context.Context, state State, req *PasswordModifyRequest) ([]byte, error) {
+	return l.overlay.PasswordModify(ctx, state, req, l.next)
+}
+
+func (l *overlayLink) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	return l.overlay.Search(ctx, state, req, l.next)
+}
+
+// SearchFunc uses the overlay's SearchFunc or passes the entries found by
+// its Search to send.
+func (l *overlayLink) SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error) (*SearchResponse, error) {
+	return overlaySearchFunc(ctx, l.overlay, state, req, send, l.next)
+}
+
+func (l *overlayLink) Whoami(ctx context.Context, state State) (string, error) {
+	return l.overlay.Whoami(ctx, state, l.next)
+}
+
+// overlaySearchFunc performs a search with the overlay's SearchFunc or its
+// Search.
+func overlaySearchFunc(ctx context.Context, o Overlay, state State, req *SearchRequest, send func(*SearchResult) error, next Backend) (*SearchResponse, error) {
+	if so, ok := o.(searchFuncOverlay); ok {
+		return so.SearchFunc(ctx, state, req, send, next)
+	}
+	sr, err := o.Search(ctx, state, req, next)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range sr.Results {
+		if err := send(r); err != nil {
+			return nil, err
+		}
+	}
+	return &SearchResponse{BaseResponse: sr.BaseResponse, References: sr.References}, nil
+}
+
+// ScopeOverlay returns an overlay that only intercepts the operations on
+// entries at or below the suffix. Other operations are passed to the next
+// layer directly. An operation is in scope if:
+//
+//   - Add, Bind, Compare, Delete, Modify: the DN is at or below the suffix.
+//   - ModifyDN: the DN or the new superior is at or below the suffix, or
+//     the suffix is below the DN so the entries at and below it are moved.
+//   - Search: the base DN is at or below the suffix, or the suffix is
+//     within the scope of the search. All the results of the search are
+//     seen by the overlay.
+//   - PasswordModify: the user identity, or the bound DN if the identity
+//     is empty, is at or below the suffix.
+//
+// Connect, Disconnect, Whoami, and other extended requests are always
+// intercepted.
+func ScopeOverlay(suffix string, o Overlay) (Overlay, error) {
+	dn, err := ParseDN(suffix)
+	if err != nil {
+		return nil, err
+	}
+	return &scopedOverlay{Overlay: o, suffix: dn}, nil
+}
+
+type scopedOverlay struct {
+	Overlay
+	suffix DN
+}
+
+// contains returns true if the DN is at or below the suffix. Invalid DNs
+// are left for the backend to reject.
+func (o *scopedOverlay) contains(s string) bool {
+	dn, err := ParseDN(s)
+	return err == nil && (dn.Equal(o.suffix) || dn.IsDescendantOf(o.suffix))
+}
+
+// below returns true if the suffix is below the DN.
+func (o *scopedOverlay) below(s string) bool {
+	dn, err := ParseDN(s)
+	return err == nil && o.suffix.IsDescendantOf(dn)
+}
+
+// SupportsControl returns true if the overlay supports the control.
+func (o *scopedOverlay) SupportsControl(oid string) bool {
+	cs, ok := o.Overlay.(controlSupporter)
+	return ok && cs.SupportsControl(oid)
+}
+
+func (o *scopedOverlay) Add(ctx context.Context, state State, req *AddRequest, next Backend) (*AddResponse, error) {
+	if !o.contains(req.DN) {
+		return next.Add(ctx, state, req)
+	}
+	return o.Overlay.Add(ctx, state, req, next)
+}
+
+func (o *scopedOverlay) Bind(ctx context.Context, state State, req *BindRequest, next Backend) (*BindResponse, error) {
+	if !o.contains(req.DN) {
+		return next.Bind(ctx, state, req)
+	}
+	return o.Overlay.Bind(ctx, state, req, next)
+}
+
+func (o *scopedOverlay) Compare(ctx context.Context, state State, req *CompareRequest, next Backend) (*CompareResponse, error) {
+	if !o.contains(req.DN) {
+		return compare(ctx, next, state, req)
+	}
+	return o.Overlay.Compare(ctx, state, req, next)
+}
+
+func (o *scopedOverlay) Delete(ctx context.Context, state State, req *DeleteRequest, next Backend) (*DeleteResponse, error) {
+	if !o.contains(req.DN) {
+		return next.Delete(ctx, state, req)
+	}
+	return o.Overlay.Delete(ctx, state, req, next)
+}
+
+func (o *scopedOverlay) Modify(ctx context.Context, state State, req *ModifyRequest, next Backend) (*ModifyResponse, error) {
+	if !o.contains(req.DN) {
+		return next.Modify(ctx, state, req)
+	}
+	return o.Overlay.Modify(ctx, state, req, next)
+}
+
+func (o *scopedOverlay) ModifyDN(ctx context.Context, state State, req *ModifyDNRequest, next Backend) (*ModifyDNResponse, error) {
+	if !o.contains(req.DN) && !o.below(req.DN) && (req.NewSuperior == "" || !o.contains(req.NewSuperior)) {
+		return next.ModifyDN(ctx, state, req)
+	}
+	return o.Overlay.ModifyDN(ctx, state, req, next)
+}
+
+func (o *scopedOverlay) PasswordModify(ctx context.Context, state State, req *PasswordModifyRequest, next Backend) ([]byte, error) {
+	id := req.UserIdentity
+	if id == "" {
+		var err error
+		if id, err = next.Whoami(ctx, state); err != nil {
+			return nil, err
+		}
+	}
+	if !o.contains(strings.TrimPrefix(id, "dn:")) {
+		return next.PasswordModify(ctx, state, req)
+	}
+	return o.Overlay.PasswordModify(ctx, state, req, next)
+}
+
+func (o *scopedOverlay) Search(ctx context.Context, state State, req *SearchRequest, next Backend) (*SearchResponse, error) {
+	if !o.searchInScope(req) {
+		return next.Search(ctx, state, req)
+	}
+	return o.Overlay.Search(ctx, state, req, next)
+}
+
+// SearchFunc passes the entries of searches out of scope to send as the next
+// layer finds them.
+func (o *scopedOverlay) SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error, next Backend) (*SearchResponse, error) {
+	if !o.searchInScope(req) {
+		return searchFunc(ctx, next, state, req, send)
+	}
+	return overlaySearchFunc(ctx, o.Overlay, state, req, send, next)
+}
+
+func (o *scopedOverlay) searchInScope(req *SearchRequest) bool {
+	base, err := ParseDN(req.BaseDN)
+	if err != nil {
+		return false
+	}
+	if base.Equal(o.suffix) || base.IsDescendantOf(o.suffix) {
+		return true
+	}
+	if o.suffix.IsDescendantOf(base) {
+		switch req.Scope {
+		case ScopeWholeSubtree, ScopeChildren:
+			return true
+		case ScopeSingleLevel:
+			return len(o.suffix) == len(base)+1
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// traceOverlay records the operations it sees before and after the next
+// layer.
+type traceOverlay struct {
+	BaseOverlay
+	name string
+	mu   *sync.Mutex
+	log  *[]string
+}
+
+func (o *traceOverlay) trace(s string) {
+	o.mu.Lock()
+	*o.log = append(*o.log, o.name+s)
+	o.mu.Unlock()
+}
+
+func (o *traceOverlay) Connect(remoteAddr net.Addr, next Backend) (State, error) {
+	o.trace(">connect")
+	defer o.trace("<connect")
+	return next.Connect(remoteAddr)
+}
+
+func (o *traceOverlay) Add(ctx context.Context, state State, req *AddRequest, next Backend) (*AddResponse, error) {
+	o.trace(">add " + req.DN)
+	defer o.trace("<add")
+	return next.Add(ctx, state, req)
+}
+
+func (o *traceOverlay) Compare(ctx context.Context, state State, req *CompareRequest, next Backend) (*CompareResponse, error) {
+	o.trace(">compare " + req.DN)
+	defer o.trace("<compare")
+	return o.BaseOverlay.Compare(ctx, state, req, next)
+}
+
+func (o *traceOverlay) Search(ctx context.Context, state State, req *SearchRequest, next Backend) (*SearchResponse, error) {
+	o.trace(">search " + req.BaseDN)
+	defer o.trace("<search")
+	return next.Search(ctx, state, req)
+}
+
+// readOnlyOverlay rejects updates.
+type readOnlyOverlay struct {
+	BaseOverlay
+}
+
+var readOnlyResult = BaseResponse{Code: ResultUnwillingToPerform, Message: "read-only"}
+
+func (readOnlyOverlay) Add(ctx context.Context, state State, req *AddRequest, next Backend) (*AddResponse, error) {
+	return &AddResponse{BaseResponse: readOnlyResult}, nil
+}
+
+func (readOnlyOverlay) Delete(ctx context.Context, state State, req *DeleteRequest, next Backend) (*DeleteResponse, error) {
+	return &DeleteResponse{BaseResponse: readOnlyResult}, nil
+}
+
+func (readOnlyOverlay) Modify(ctx context.Context, state State, req *ModifyRequest, next Backend) (*ModifyResponse, error) {
+	return &ModifyResponse{BaseResponse: readOnlyResult}, nil
+}
+
+func (readOnlyOverlay) ModifyDN(ctx context.Context, state State, req *ModifyDNRequest, next Backend) (*ModifyDNResponse, error) {
+	return &ModifyDNResponse{BaseResponse: readOnlyResult}, nil
+}
+
+func (readOnlyOverlay) PasswordModify(ctx context.Context, state State, req *PasswordModifyRequest, next Backend) ([]byte, error) {
+	return nil, &readOnlyResult
+}
+
+// renameOverlay presents the entries below dc=example,dc=com as below
+// o=Example by rewriting requests and results.
+type renameOverlay struct {
+	BaseOverlay
+}
+
+func (renameOverlay) rewrite(dn, from, to string) string {
+	if strings.HasSuffix(strings.ToLower(dn), strings.ToLower(from)) {
+		return dn[:len(dn)-len(from)] + to
+	}
+	return dn
+}
+
+func (o renameOverlay) Search(ctx context.Context, state State, req *SearchRequest, next Backend) (*SearchResponse, error) {
+	r := *req
+	r.BaseDN = o.rewrite(req.BaseDN, "o=Example", "dc=example,dc=com")
+	res, err := next.Search(ctx, state, &r)
+	if err != nil {
+		return nil, err
+	}
+	out := *res
+	out.MatchedDN = o.rewrite(res.MatchedDN, "dc=example,dc=com", "o=Example")
+	out.Results = make([]*SearchResult, len(res.Results))
+	for i, e := range res.Results {
+		out.Results[i] = &SearchResult{DN: o.rewrite(e.DN, "dc=example,dc=com", "o=Example"), Attributes: e.Attributes}
+	}
+	return &out, nil
+}
+
+func TestOverlayChain(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var log []string
+	be := newTestMemoryBackend(t)
+	chain := NewOverlayChain(be,
+		&traceOverlay{name: "a", mu: &mu, log: &log},
+		renameOverlay{},
+		&traceOverlay{name: "b", mu: &mu, log: &log},
+	)
+	dial := newTestDialer(t, chain)
+	c := dial()
+
+	dns, err := searchDNs(t, c, "ou=People,o=Example", ScopeSingleLevel, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"uid=asmith,ou=People,o=Example", "uid=jdoe,ou=People,o=Example"}; !reflect.DeepEqual(dns, want) {
+		t.Errorf("got %v, want %v", dns, want)
+	}
+	_, err = c.Search(&SearchRequest{BaseDN: "ou=Nowhere,o=Example"})
+	if e, ok := errorAsType[*BaseResponse](err); !ok || e.Code != ResultNoSuchObject || e.MatchedDN != "o=Example" {
+		t.Errorf("expected no such object matching o=Example got %v", err)
+	}
+	if err := c.Add(&AddRequest{DN: "ou=Staff,dc=example,dc=com", Attributes: map[string][][]byte{"objectClass": {[]byte("organizationalUnit")}, "ou": {[]byte("Staff")}}}); err != nil {
+		t.Fatal(err)
+	}
+	if be.Entry("ou=Staff,dc=example,dc=com") == nil {
+		t.Error("entry not added")
+	}
+	if ok, err := c.Compare("ou=Staff,dc=example,dc=com", "ou", []byte("staff")); err != nil || !ok {
+		t.Errorf("expected compare to be true got %t %v", ok, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"a>connect", "b>connect", "b<connect", "a<connect",
+		"a>search ou=People,o=Example", "b>search ou=People,dc=example,dc=com", "b<search", "a<search",
+		"a>search ou=Nowhere,o=Example", "b>search ou=Nowhere,dc=example,dc=com", "b<search", "a<search",
+		"a>add ou=Staff,dc=example,dc=com", "b>add ou=Staff,dc=example,dc=com", "b<add", "a<add",
+		"a>compare ou=Staff,dc=example,dc=com", "b>compare ou=Staff,dc=example,dc=com", "b<compare", "a<compare",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("got trace\n%s\nwant\n%s", strings.Join(log, "\n"), strings.Join(want, "\n"))
+	}
+	if NewOverlayChain(be) != Backend(be) {
+		t.Error("expected the backend for an empty chain")
+	}
+}
+
+// countOverlay counts the entries of searches as they're found.
+type countOverlay struct {
+	BaseOverlay
+	n int
+}
+
+func (o *countOverlay) SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error, next Backend) (*SearchResponse, error) {
+	ss, ok := next.(searchStreamer)
+	if !ok {
+		return nil, errors.New("next layer doesn't stream")
+	}
+	return ss.SearchFunc(ctx, state, req, func(r *SearchResult) error {
+		o.n++
+		return send(r)
+	})
+}
+
+func TestOverlayStreamSearch(t *testing.T) {
+	t.Parallel()
+	be := &gatedBackend{MemoryBackend: newTestMemoryBackend(t), release: make(chan struct{})}
+	scoped, err := ScopeOverlay("ou=Groups,dc=example,dc=com", renameOverlay{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := &countOverlay{}
+	_, c := newTestServer(t, NewOverlayChain(be, count, scoped))
+
+	// The backend finishes its search once an entry it sent has been
+	// received, which needs every layer to stream.
+	n := 0
+	err = c.SearchFunc(&SearchRequest{BaseDN: "ou=People,dc=example,dc=com", Scope: ScopeSingleLevel}, func(*SearchResult) error {
+		if n == 0 {
+			close(be.release)
+		}
+		n++
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || count.n != 2 {
+		t.Errorf("expected 2 entries through the overlay got %d and %d", n, count.n)
+	}
+}
+
+func TestScopeOverlay(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var log []string
+	be := newTestMemoryBackend(t)
+	readOnly, err := ScopeOverlay("ou=People,dc=example,dc=com", readOnlyOverlay{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace, err := ScopeOverlay("OU=people,dc=example,dc=com", &traceOverlay{name: "t", mu: &mu, log: &log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ScopeOverlay("dc=example,", readOnlyOverlay{}); err == nil {
+		t.Error("expected an error for an invalid suffix")
+	}
+	chain := NewOverlayChain(be, readOnly, trace)
+	ctx := context.Background()
+	state, err := chain.Connect(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Disconnect(state)
+
+	ou := func(dn string) *AddRequest {
+		rdn, _, _ := strings.Cut(dn, ",")
+		return &AddRequest{DN: dn, Attributes: map[string][][]byte{"objectClass": {[]byte("organizationalUnit")}, "ou": {[]byte(strings.TrimPrefix(rdn, "ou="))}}}
+	}
+	for _, tc := range []struct {
+		dn   string
+		code ResultCode
+	}{
+		{"ou=Staff,dc=example,dc=com", ResultSuccess},
+		{"ou=Staff,ou=People,dc=example,dc=com", ResultUnwillingToPerform},
+		{"ou=People,dc=example,dc=com", ResultUnwillingToPerform},
+		{"ou=Staff,ou=People,dc=example,", ResultInvalidDNSyntax},
+	} {
+		res, err := chain.Add(ctx, state, ou(tc.dn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != tc.code {
+			t.Errorf("add %s: got %s, want %s", tc.dn, res.Code, tc.code)
+		}
+	}
+	for _, tc := range []struct {
+		req  *ModifyDNRequest
+		code ResultCode
+	}{
+		{&ModifyDNRequest{DN: "ou=Staff,dc=example,dc=com", NewRDN: "ou=Staff", NewSuperior: "ou=People,dc=example,dc=com"}, ResultUnwillingToPerform},
+		{&ModifyDNRequest{DN: "uid=jdoe,ou=People,dc=example,dc=com", NewRDN: "uid=jdoe", NewSuperior: "ou=Staff,dc=example,dc=com"}, ResultUnwillingToPerform},
+		{&ModifyDNRequest{DN: "ou=Staff,dc=example,dc=com", NewRDN: "ou=Crew", DeleteOldRDN: true}, ResultSuccess},
+	} {
+		res, err := chain.ModifyDN(ctx, state, tc.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != tc.code {
+			t.Errorf("%+v: got %s, want %s", tc.req, res.Code, tc.code)
+		}
+	}
+
+	// Renaming a superior of the suffix moves the entries below it.
+	leaf, err := ScopeOverlay("uid=jdoe,ou=People,dc=example,dc=com", readOnlyOverlay{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewOverlayChain(be, leaf).ModifyDN(ctx, state, &ModifyDNRequest{DN: "ou=People,dc=example,dc=com", NewRDN: "ou=Persons"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Message != readOnlyResult.Message {
+		t.Errorf("rename of a superior of the suffix: got %q, want %q", res.Message, readOnlyResult.Message)
+	}
+
+	for _, tc := range []struct {
+		base  string
+		scope Scope
+	}{
+		{"dc=example,dc=com", ScopeBaseObject},
+		{"dc=example,dc=com", ScopeSingleLevel},
+		{"dc=example,dc=com", ScopeWholeSubtree},
+		{"ou=Groups,dc=example,dc=com", ScopeWholeSubtree},
+		{"ou=People,dc=example,dc=com", ScopeBaseObject},
+		{"uid=jdoe,ou=People,dc=example,dc=com", ScopeSingleLevel},
+		{"", ScopeSingleLevel},
+		{"", ScopeChildren},
+	} {
+		if _, err := chain.Search(ctx, state, &SearchRequest{BaseDN: tc.base, Scope: tc.scope}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if res, err := chain.Bind(ctx, state, &BindRequest{DN: "uid=jdoe,ou=People,dc=example,dc=com", Password: []byte("secret")}); err != nil || res.Code != ResultSuccess {
+		t.Fatalf("bind: %v %v", res, err)
+	}
+	if _, err := chain.PasswordModify(ctx, state, &PasswordModifyRequest{NewPassword: []byte("changed")}); resultCode(err) != ResultUnwillingToPerform {
+		t.Errorf("expected the password of the bound user to be read-only got %v", err)
+	}
+	if res, err := chain.Bind(ctx, state, &BindRequest{DN: "cn=admin,dc=example,dc=com", Password: []byte("admin")}); err != nil || res.Code != ResultSuccess {
+		t.Fatalf("bind: %v %v", res, err)
+	}
+	if _, err := chain.PasswordModify(ctx, state, &PasswordModifyRequest{NewPassword: []byte("changed")}); err == nil || err.Error() == readOnlyResult.Error() {
+		t.Errorf("expected the password modify of the root DN to reach the backend got %v", err)
+	}
+	if _, err := chain.PasswordModify(ctx, state, &PasswordModifyRequest{UserIdentity: "dn:uid=asmith,ou=People,dc=example,dc=com", NewPassword: []byte("changed")}); resultCode(err) != ResultUnwillingToPerform {
+		t.Errorf("expected the password of asmith to be read-only got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Updates below ou=People are rejected by the outer overlay and other
+	// updates are out of scope for the inner one.
+	want := []string{
+		"t>connect", "t<connect",
+		"t>search dc=example,dc=com", "t<search",
+		"t>search dc=example,dc=com", "t<search",
+		"t>search ou=People,dc=example,dc=com", "t<search",
+		"t>search uid=jdoe,ou=People,dc=example,dc=com", "t<search",
+		"t>search ", "t<search",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("got trace\n%s\nwant\n%s", strings.Join(log, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// controlOverlay acts on a control.
+type controlOverlay struct {
+	BaseOverlay
+}
+
+func (controlOverlay) SupportsControl(oid string) bool {
+	return oid == "1.2.3.4"
+}
+
+func TestScopeOverlayControls(t *testing.T) {
+	t.Parallel()
+	scoped, err := ScopeOverlay("ou=People,dc=example,dc=com", controlOverlay{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, ok := NewOverlayChain(newTestMemoryBackend(t), scoped).(controlSupporter)
+	if !ok || !cs.SupportsControl("1.2.3.4") {
+		t.Error("expected the control of the scoped overlay to be supported")
+	}
+	if cs.SupportsControl("1.2.3.5") {
+		t.Error("expected other controls to be unsupported")
+	}
+}
+
+func TestOverlayNamingContexts(t *testing.T) {
+	t.Parallel()
+	_, c := newTestServer(t, NewOverlayChain(newTestMemoryBackend(t), readOnlyOverlay{}))
+	res, err := c.Search(&SearchRequest{Scope: ScopeBaseObject, Attributes: map[string]bool{"namingContexts": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := res[0].Attributes["namingContexts"]; len(v) != 1 || string(v[0]) != "dc=example,dc=com" {
+		t.Errorf("got naming contexts %q", v)
+	}
+	if err := c.Delete("uid=jdoe,ou=People,dc=example,dc=com"); resultCode(err) != ResultUnwillingToPerform {
+		t.Errorf("expected unwilling to perform got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package ldap
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strings"
+	"sync"
+)
+
+// bindState is the state of a connection to a backend that performs
+// simple binds with simpleBind.
+type bindState struct {
+	mu sync.Mutex
+	dn string // bound DN, empty if anonymous
+}
+
+// simpleBind performs a simple bind and records the bound DN in the
+// bindState. authenticate returns the DN of the entry the password of dn
+// belongs to, or an empty DN if the credentials are invalid. A failed bind
+// leaves the connection anonymous.
+func simpleBind(state State, req *BindRequest, authenticate func(dn DN, password []byte) (string, error)) (*BindResponse, error) {
+	res := &BindResponse{}
+	var bound string
+	switch {
+	case req.DN == "":
+		if len(req.Password) != 0 {
+			res.Code = ResultInvalidCredentials
+		}
+	case len(req.Password) == 0:
+		// RFC 4513 section 5.1.2
+		res.Code = ResultUnwillingToPerform
+		res.Message = "unauthenticated bind not allowed"
+	default:
+		dn, err := ParseDN(req.DN)
+		if err != nil {
+			res.Code = ResultInvalidDNSyntax
+			res.Message = err.Error()
+			break
+		}
+		if bound, err = authenticate(dn, req.Password); err != nil {
+			return nil, err
+		}
+		if bound == "" {
+			res.Code = ResultInvalidCredentials
+		}
+	}
+	if st, ok := state.(*bindState); ok {
+		st.mu.Lock()
+		st.dn = bound
+		st.mu.Unlock()
+	}
+	return res, nil
+}
+
+// boundDN returns the DN bound on a connection with a bindState.
+func boundDN(state State) string {
+	st, ok := state.(*bindState)
+	if !ok {
+		return ""
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.dn
+}
+
+// authzID returns the authorization identity of the DN bound on a
+// connection with a bindState (RFC 4532).
+func authzID(state State) string {
+	if dn := boundDN(state); dn != "" {
+		return "dn:" + dn
+	}
+	return ""
+}
+
+// passwordSchemes are the hash functions of the userPassword schemes that
+// are supported. The salted variants prefix the name with an 'S'.
+var passwordSchemes = map[string]func() hash.Hash{
+	"SHA":    sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// checkPassword returns true if the password matches a userPassword value.
+// The value is either in clear text or of the form "{scheme}base64" where
+// the scheme is CLEARTEXT, SHA, SSHA, SHA256, SSHA256, SHA512, or SSHA512.
+// The salt of the salted schemes follows the hash.
+func checkPassword(stored, password []byte) bool {
+	if len(stored) == 0 || stored[0] != '{' {
+		return subtle.ConstantTimeCompare(stored, password) == 1
+	}
+	end := bytes.IndexByte(stored, '}')
+	if end < 0 {
+		return false
+	}
+	scheme := strings.ToUpper(string(stored[1:end]))
+	value := stored[end+1:]
+	if scheme == "CLEARTEXT" {
+		return subtle.ConstantTimeCompare(value, password) == 1
+	}
+	salted := false
+	newHash := passwordSchemes[scheme]
+	if newHash == nil && strings.HasPrefix(scheme, "S") {
+		salted = true
+		newHash = passwordSchemes[scheme[1:]]
+	}
+	if newHash == nil {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(value))
+	if err != nil {
+		return false
+	}
+	h := newHash()
+	size := h.Size()
+	if len(decoded) < size || !salted && len(decoded) != size {
+		return false
+	}
+	h.Write(password)
+	h.Write(decoded[size:])
+	return subtle.ConstantTimeCompare(h.Sum(nil), decoded[:size]) == 1
+}
+
+// hashPassword returns a userPassword value for the password using the
+// {SSHA} scheme which is the default of most servers.
+func hashPassword(password []byte) ([]byte, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	h := sha1.New()
+	h.Write(password)
+	h.Write(salt)
+	return []byte("{SSHA}" + base64.StdEncoding.EncodeToString(append(h.Sum(nil), salt...))), nil
+}
+
+// generatePassword returns a random password for a PasswordModify request
+// that doesn't include a new password.
+func generatePassword() ([]byte, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return []byte(base64.RawURLEncoding.EncodeToString(b)), nil
+}
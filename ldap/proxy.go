@@ -0,0 +1,568 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyBackend is a Backend that forwards operations to upstream servers
+// through a pool of Clients. Results, including result codes, matched DNs,
+// referrals, and search result references, are relayed unchanged, and the
+// controls of requests are forwarded.
+//
+// The upstream servers are tried in order when connecting, starting with
+// the last one that could be reached. If a connection fails then searches,
+// compares, and binds are retried once on a new connection while other
+// operations fail with ResultUnavailable since they may have been performed.
+//
+// If ServiceDN is empty then binds are passed through: a bound connection
+// is given its own upstream connection until it binds anonymously or is
+// closed, and anonymous operations use the pool. Otherwise the pooled
+// connections are bound as the service account, binds are checked with an
+// upstream bind, and operations are performed with the proxied
+// authorization control (RFC 4370) for the bound user, or for anonymous,
+// so the upstream servers must allow the service account to proxy.
+type ProxyBackend struct {
+	// TLSConfig is used to connect to ldaps URLs.
+	TLSConfig *tls.Config
+	// DialTimeout limits the time to connect to an upstream server. If 0
+	// there's no timeout other than the operating system's.
+	DialTimeout time.Duration
+	// MaxIdle is the maximum number of idle upstream connections kept for
+	// reuse. If 0 then 4 are kept.
+	MaxIdle int
+	// ServiceDN and ServicePassword are used to bind pooled connections.
+	ServiceDN       string
+	ServicePassword []byte
+
+	urls    []*LDAPURL
+	mu      sync.Mutex
+	idle    []*Client
+	current int // index of the URL of the last connection
+	closed  bool
+}
+
+// proxyState is the state of a connection.
+type proxyState struct {
+	mu   sync.Mutex
+	dn   string  // bound DN, empty if anonymous
+	conn *Client // upstream connection with the passed through bind
+}
+
+// NewProxyBackend returns a backend for the upstream servers with the
+// ldap, ldaps, or ldapi URLs. Anything other than the scheme and host of
+// a URL is ignored.
+func NewProxyBackend(urls ...string) (*ProxyBackend, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("ldap: no upstream URLs")
+	}
+	b := &ProxyBackend{}
+	for _, raw := range urls {
+		u, err := ParseLDAPURL(raw)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("ldap: no host in upstream URL %q", raw)
+		}
+		b.urls = append(b.urls, u)
+	}
+	return b, nil
+}
+
+// Close closes the idle upstream connections. Connections in use are
+// closed when they're released.
+func (b *ProxyBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, c := range b.idle {
+		c.Close()
+	}
+	b.idle = nil
+	return nil
+}
+
+// dial connects to the first upstream server that can be reached and
+// binds as the service account.
+func (b *ProxyBackend) dial() (*Client, error) {
+	b.mu.Lock()
+	start := b.current
+	b.mu.Unlock()
+	var errs []error
+	for i := range b.urls {
+		n := (start + i) % len(b.urls)
+		c, err := b.dialURL(b.urls[n])
+		if err == nil && b.ServiceDN != "" {
+			if err = c.Bind(b.ServiceDN, b.ServicePassword); err != nil {
+				c.Close()
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s://%s: %w", b.urls[n].Scheme, b.urls[n].Host, err))
+			continue
+		}
+		b.mu.Lock()
+		b.current = n
+		b.mu.Unlock()
+		return c, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (b *ProxyBackend) dialURL(u *LDAPURL) (*Client, error) {
+	d := &net.Dialer{Timeout: b.DialTimeout}
+	switch u.Scheme {
+	case "ldapi":
+		cn, err := d.Dial("unix", u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return NewClient(cn, false), nil
+	case "ldaps":
+		config := b.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		cn, err := tls.DialWithDialer(d, "tcp", hostPort(u.Host, "636"), config)
+		if err != nil {
+			return nil, err
+		}
+		return NewClient(cn, true), nil
+	}
+	cn, err := d.Dial("tcp", hostPort(u.Host, "389"))
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(cn, false), nil
+}
+
+// hostPort adds the default port to a host without one.
+func hostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// acquire returns an idle upstream connection or a new one.
+func (b *ProxyBackend) acquire() (*Client, error) {
+	b.mu.Lock()
+	for len(b.idle) != 0 {
+		c := b.idle[len(b.idle)-1]
+		b.idle = b.idle[:len(b.idle)-1]
+		if !c.closed() {
+			b.mu.Unlock()
+			return c, nil
+		}
+	}
+	b.mu.Unlock()
+	return b.dial()
+}
+
+// release returns a connection to the pool. It must have the identity of
+// the pooled connections.
+func (b *ProxyBackend) release(c *Client) {
+	maxIdle := b.MaxIdle
+	if maxIdle <= 0 {
+		maxIdle = 4
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || c.closed() || len(b.idle) >= maxIdle {
+		c.Close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+// connError returns true if the error isn't a result so the connection
+// may be unusable.
+func connError(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := errorAsType[*BaseResponse](err)
+	return !ok
+}
+
+// do performs an operation on the connection's upstream connection or a
+// pooled one. If retry is true then the operation is retried once on a
+// new connection if the connection fails.
+func (b *ProxyBackend) do(ctx context.Context, state State, retry bool, op func(c *Client, controls []*Control) error) error {
+	st, _ := state.(*proxyState)
+	controls := b.controls(ctx, st)
+	for attempt := 0; ; attempt++ {
+		var c *Client
+		if st != nil {
+			st.mu.Lock()
+			c = st.conn
+			st.mu.Unlock()
+		}
+		pinned := c != nil
+		if !pinned {
+			var err error
+			if c, err = b.acquire(); err != nil {
+				return &BaseResponse{Code: ResultUnavailable, Message: err.Error()}
+			}
+		}
+		err := op(c, controls)
+		if !connError(err) && !c.closed() {
+			if !pinned {
+				b.release(c)
+			}
+			return err
+		}
+		c.Close()
+		if pinned {
+			// The bind is lost with the connection.
+			st.mu.Lock()
+			if st.conn == c {
+				st.conn = nil
+				st.dn = ""
+			}
+			st.mu.Unlock()
+			return &BaseResponse{Code: ResultUnavailable, Message: "upstream connection lost"}
+		}
+		if !retry || attempt > 0 {
+			return &BaseResponse{Code: ResultUnavailable, Message: "upstream connection lost"}
+		}
+	}
+}
+
+// controls returns the controls to send upstream with a request. A proxied
+// authorization control from the client is replaced when using the
+// service account since the service account may proxy for anyone.
+func (b *ProxyBackend) controls(ctx context.Context, st *proxyState) []*Control {
+	controls := RequestControls(ctx)
+	if b.ServiceDN == "" {
+		return controls
+	}
+	out := make([]*Control, 0, len(controls)+1)
+	for _, c := range controls {
+		if c.Type != OIDProxiedAuthControl {
+			out = append(out, c)
+		}
+	}
+	authz := ""
+	if st != nil {
+		st.mu.Lock()
+		if st.dn != "" {
+			authz = "dn:" + st.dn
+		}
+		st.mu.Unlock()
+	}
+	return append(out, &Control{Type: OIDProxiedAuthControl, Criticality: true, Value: []byte(authz)})
+}
+
+// call sends a request and parses the result into res.
+func call(c *Client, req Request, controls []*Control, res *BaseResponse) error {
+	pkt, err := c.request(&controlledRequest{Request: req, controls: controls})
+	if err != nil {
+		return err
+	}
+	return parseBaseResponse(pkt, res)
+}
+
+// relay sets the result of a response to a result returned as an error.
+func relay(res *BaseResponse, err error) error {
+	if e, ok := errorAsType[*BaseResponse](err); ok {
+		res.Code = e.Code
+		res.MatchedDN = e.MatchedDN
+		res.Message = e.Message
+		res.Referral = e.Referral
+		return nil
+	}
+	return err
+}
+
+// SupportsControl returns true for all controls since they're forwarded to
+// the upstream servers which check them.
+func (b *ProxyBackend) SupportsControl(oid string) bool {
+	return true
+}
+
+func (b *ProxyBackend) Connect(remoteAddr net.Addr) (State, error) {
+	return &proxyState{}, nil
+}
+
+// Disconnect returns the connection's upstream connection to the pool
+// after binding it anonymously.
+func (b *ProxyBackend) Disconnect(state State) {
+	st, ok := state.(*proxyState)
+	if !ok {
+		return
+	}
+	st.mu.Lock()
+	c := st.conn
+	st.conn = nil
+	st.mu.Unlock()
+	if c != nil {
+		if err := c.Bind("", nil); err != nil {
+			c.Close()
+			return
+		}
+		b.release(c)
+	}
+}
+
+func (b *ProxyBackend) Add(ctx context.Context, state State, req *AddRequest) (*AddResponse, error) {
+	res := &AddResponse{}
+	err := b.do(ctx, state, false, func(c *Client, controls []*Control) error {
+		return call(c, req, controls, &res.BaseResponse)
+	})
+	if err := relay(&res.BaseResponse, err); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *ProxyBackend) Delete(ctx context.Context, state State, req *DeleteRequest) (*DeleteResponse, error) {
+	res := &DeleteResponse{}
+	err := b.do(ctx, state, false, func(c *Client, controls []*Control) error {
+		return call(c, req, controls, &res.BaseResponse)
+	})
+	if err := relay(&res.BaseResponse, err); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *ProxyBackend) Modify(ctx context.Context, state State, req *ModifyRequest) (*ModifyResponse, error) {
+	res := &ModifyResponse{}
+	err := b.do(ctx, state, false, func(c *Client, controls []*Control) error {
+		return call(c, req, controls, &res.BaseResponse)
+	})
+	if err := relay(&res.BaseResponse, err); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *ProxyBackend) ModifyDN(ctx context.Context, state State, req *ModifyDNRequest) (*ModifyDNResponse, error) {
+	res := &ModifyDNResponse{}
+	err := b.do(ctx, state, false, func(c *Client, controls []*Control) error {
+		return call(c, req, controls, &res.BaseResponse)
+	})
+	if err := relay(&res.BaseResponse, err); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Compare forwards the compare so the upstream server's result, such as
+// compareTrue or noSuchAttribute, is returned.
+func (b *ProxyBackend) Compare(ctx context.Context, state State, req *CompareRequest) (*CompareResponse, error) {
+	res := &CompareResponse{}
+	err := b.do(ctx, state, true, func(c *Client, controls []*Control) error {
+		return call(c, req, controls, &res.BaseResponse)
+	})
+	if err := relay(&res.BaseResponse, err); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// extended performs an extended operation.
+func (b *ProxyBackend) extended(ctx context.Context, state State, req *ExtendedRequest) (*ExtendedResponse, error) {
+	var res *ExtendedResponse
+	err := b.do(ctx, state, false, func(c *Client, controls []*Control) error {
+		pkt, err := c.request(&controlledRequest{Request: req, controls: controls})
+		if err != nil {
+			return err
+		}
+		res, err = parseExtendedResponse(pkt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *ProxyBackend) ExtendedRequest(ctx context.Context, state State, req *ExtendedRequest) (*ExtendedResponse, error) {
+	res, err := b.extended(ctx, state, req)
+	if err != nil {
+		res = &ExtendedResponse{}
+		if err := relay(&res.BaseResponse, err); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// PasswordModify forwards the request. When using the service account the
+// user defaults to the bound user rather than the service account.
+func (b *ProxyBackend) PasswordModify(ctx context.Context, state State, req *PasswordModifyRequest) ([]byte, error) {
+	if b.ServiceDN != "" && req.UserIdentity == "" {
+		dn := ""
+		if st, ok := state.(*proxyState); ok {
+			st.mu.Lock()
+			dn = st.dn
+			st.mu.Unlock()
+		}
+		if dn == "" {
+			return nil, &BaseResponse{Code: ResultUnwillingToPerform, Message: "must be bound to change the password"}
+		}
+		r := *req
+		r.UserIdentity = "dn:" + dn
+		req = &r
+	}
+	ext, err := req.extendedRequest()
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.extended(ctx, state, ext)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+	return parseGenPassword(res.Value)
+}
+
+// Whoami returns the identity of the upstream connection or, when using
+// the service account, of the bound user.
+func (b *ProxyBackend) Whoami(ctx context.Context, state State) (string, error) {
+	if b.ServiceDN != "" {
+		if st, ok := state.(*proxyState); ok {
+			st.mu.Lock()
+			defer st.mu.Unlock()
+			if st.dn != "" {
+				return "dn:" + st.dn, nil
+			}
+		}
+		return "", nil
+	}
+	res, err := b.extended(ctx, state, &ExtendedRequest{Name: OIDWhoAmI})
+	if err != nil {
+		return "", err
+	}
+	if err := res.Err(); err != nil {
+		return "", err
+	}
+	return string(res.Value), nil
+}
+
+// Bind passes the bind through or, when using the service account, checks
+// it with an upstream bind.
+func (b *ProxyBackend) Bind(ctx context.Context, state State, req *BindRequest) (*BindResponse, error) {
+	st, _ := state.(*proxyState)
+	if st == nil {
+		st = &proxyState{}
+	}
+	res := &BindResponse{}
+	if b.ServiceDN != "" {
+		if req.DN != "" || len(req.Password) != 0 {
+			err := b.do(ctx, nil, true, func(c *Client, controls []*Control) error {
+				if err := call(c, req, RequestControls(ctx), &res.BaseResponse); err != nil {
+					return err
+				}
+				// Restore the identity of the pooled connection. The
+				// error isn't a result so the connection is discarded.
+				if err := c.Bind(b.ServiceDN, b.ServicePassword); err != nil {
+					return fmt.Errorf("ldap: failed to bind as the service account: %v", err)
+				}
+				return nil
+			})
+			if err := relay(&res.BaseResponse, err); err != nil {
+				return nil, err
+			}
+		}
+		st.mu.Lock()
+		st.dn = ""
+		if res.Code == ResultSuccess {
+			st.dn = req.DN
+		}
+		st.mu.Unlock()
+		return res, nil
+	}
+
+	st.mu.Lock()
+	c := st.conn
+	st.conn = nil
+	st.dn = ""
+	st.mu.Unlock()
+	if c == nil {
+		var err error
+		if c, err = b.acquire(); err != nil {
+			res.Code = ResultUnavailable
+			res.Message = err.Error()
+			return res, nil
+		}
+	}
+	if err := call(c, req, RequestControls(ctx), &res.BaseResponse); err != nil {
+		c.Close()
+		res.Code = ResultUnavailable
+		res.Message = "upstream connection lost"
+		return res, nil
+	}
+	if res.Code != ResultSuccess || req.DN == "" {
+		// A failed bind leaves the connection anonymous.
+		b.release(c)
+		return res, nil
+	}
+	st.mu.Lock()
+	st.conn = c
+	st.dn = req.DN
+	st.mu.Unlock()
+	return res, nil
+}
+
+// Search forwards the search and collects the results as they're
+// received.
+func (b *ProxyBackend) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	var results []*SearchResult
+	res, err := b.SearchFunc(ctx, state, req, func(r *SearchResult) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Results = results
+	return res, nil
+}
+
+// SearchFunc forwards the search and passes each result to send as it's
+// received. A search that fails after results have been sent isn't retried
+// since they can't be taken back.
+func (b *ProxyBackend) SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error) (*SearchResponse, error) {
+	res := &SearchResponse{}
+	sent := false
+	var sendErr error
+	err := b.do(ctx, state, true, func(c *Client, controls []*Control) error {
+		if sent {
+			return &BaseResponse{Code: ResultUnavailable, Message: "upstream connection lost"}
+		}
+		res.References = nil
+		err := c.searchFunc(&controlledRequest{Request: req, controls: controls}, func(r *SearchResult) error {
+			sent = true
+			sendErr = send(r)
+			return sendErr
+		}, func(uris []string) error {
+			res.References = append(res.References, uris)
+			return nil
+		})
+		if sendErr != nil {
+			// The upstream connection is still usable since the rest of
+			// the response was read.
+			return nil
+		}
+		return err
+	})
+	if sendErr != nil {
+		return nil, sendErr
+	}
+	if err := relay(&res.BaseResponse, err); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,414 @@
+package ldap
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// upstreamBackend records the controls of searches and returns referrals
+// for ou=Remote.
+type upstreamBackend struct {
+	*MemoryBackend
+	mu       sync.Mutex
+	controls []*Control
+}
+
+const testRemoteURL = "ldap://remote.example.com/ou=Remote,dc=example,dc=com"
+
+func (be *upstreamBackend) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	be.mu.Lock()
+	be.controls = RequestControls(ctx)
+	be.mu.Unlock()
+	if strings.HasSuffix(strings.ToLower(req.BaseDN), ",ou=remote,dc=example,dc=com") {
+		return &SearchResponse{BaseResponse: BaseResponse{Code: ResultReferral, Referral: []string{testRemoteURL}}}, nil
+	}
+	res, err := be.MemoryBackend.Search(ctx, state, req)
+	if err == nil && res.Code == ResultSuccess && req.Scope == ScopeWholeSubtree && EqualDN(req.BaseDN, "dc=example,dc=com") {
+		res.References = [][]string{{testRemoteURL}}
+	}
+	return res, err
+}
+
+func (be *upstreamBackend) SupportsControl(oid string) bool {
+	return oid == OIDProxiedAuthControl
+}
+
+func (be *upstreamBackend) lastControls() []*Control {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	return be.controls
+}
+
+// startTestUpstream serves the backend and returns its URL and a function
+// that stops the server and closes its connections.
+func startTestUpstream(t *testing.T, be Backend) (string, func()) {
+	t.Helper()
+	srv, err := NewServer(be, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl := &trackingListener{Listener: ln}
+	go srv.ServeListener(tl)
+	t.Cleanup(tl.kill)
+	return "ldap://" + ln.Addr().String(), tl.kill
+}
+
+type trackingListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	cn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, cn)
+		l.mu.Unlock()
+	}
+	return cn, err
+}
+
+func (l *trackingListener) kill() {
+	l.Listener.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, cn := range l.conns {
+		cn.Close()
+	}
+}
+
+func newTestProxy(t *testing.T, urls ...string) *ProxyBackend {
+	t.Helper()
+	proxy, err := NewProxyBackend(urls...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+func TestProxyPassThrough(t *testing.T) {
+	t.Parallel()
+	upstream := &upstreamBackend{MemoryBackend: newTestMemoryBackend(t)}
+	url, _ := startTestUpstream(t, upstream)
+	dial := newTestDialer(t, newTestProxy(t, url))
+	c := dial()
+
+	if dns, err := searchDNs(t, c, "dc=example,dc=com", ScopeWholeSubtree, "(objectClass=person)"); err != nil || !reflect.DeepEqual(dns, []string{"uid=asmith,ou=People,dc=example,dc=com", "uid=jdoe,ou=People,dc=example,dc=com"}) {
+		t.Errorf("unexpected search results %v: %v", dns, err)
+	}
+	_, err := c.Search(&SearchRequest{BaseDN: "ou=Nowhere,dc=example,dc=com"})
+	if e, ok := errorAsType[*BaseResponse](err); !ok || e.Code != ResultNoSuchObject || e.MatchedDN != "dc=example,dc=com" {
+		t.Errorf("expected no such object with matched DN got %v", err)
+	}
+	_, err = c.Search(&SearchRequest{BaseDN: "uid=x,ou=Remote,dc=example,dc=com"})
+	if e, ok := errorAsType[*BaseResponse](err); !ok || e.Code != ResultReferral || !reflect.DeepEqual(e.Referral, []string{testRemoteURL}) {
+		t.Errorf("expected referral got %v", err)
+	}
+	var refs [][]string
+	if err := c.SearchFunc(&SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree}, func(*SearchResult) error { return nil }, func(uris []string) error {
+		refs = append(refs, uris)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(refs, [][]string{{testRemoteURL}}) {
+		t.Errorf("expected search result reference got %v", refs)
+	}
+	control := &Control{Type: "1.2.3.4", Value: []byte("x")}
+	if err := c.searchFunc(&controlledRequest{Request: &SearchRequest{BaseDN: "dc=example,dc=com"}, controls: []*Control{control}}, func(*SearchResult) error { return nil }, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := upstream.lastControls(); !reflect.DeepEqual(got, []*Control{control}) {
+		t.Errorf("expected control to be forwarded got %v", got)
+	}
+
+	if err := c.Add(&AddRequest{DN: "ou=Staff,dc=example,dc=com", Attributes: map[string][][]byte{"objectClass": {[]byte("organizationalUnit")}, "ou": {[]byte("Staff")}}}); err != nil {
+		t.Fatal(err)
+	}
+	if upstream.Entry("ou=Staff,dc=example,dc=com") == nil {
+		t.Error("entry not added upstream")
+	}
+	if err := c.Modify("uid=nobody,dc=example,dc=com", []*Mod{{Type: Delete, Name: "cn"}}); resultCode(err) != ResultNoSuchObject {
+		t.Errorf("expected no such object got %v", err)
+	}
+	if err := c.ModifyDN(&ModifyDNRequest{DN: "ou=Staff,dc=example,dc=com", NewRDN: "ou=Crew", DeleteOldRDN: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("ou=Crew,dc=example,dc=com"); err != nil {
+		t.Fatal(err)
+	}
+
+	jdoe := "uid=jdoe,ou=People,dc=example,dc=com"
+	if err := c.Bind(jdoe, []byte("wrong")); resultCode(err) != ResultInvalidCredentials {
+		t.Errorf("expected invalid credentials got %v", err)
+	}
+	if err := c.Bind(jdoe, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "dn:"+jdoe {
+		t.Errorf("expected dn:%s got %q %v", jdoe, id, err)
+	}
+	// The bind must not leak to other connections.
+	c2 := dial()
+	if id, err := c2.WhoAmI(); err != nil || id != "anonymous" {
+		t.Errorf("expected anonymous got %q %v", id, err)
+	}
+	if _, err := c.PasswordModify(&PasswordModifyRequest{NewPassword: []byte("changed")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Bind(jdoe, []byte("changed")); err != nil {
+		t.Error(err)
+	}
+	if err := c.Bind("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "anonymous" {
+		t.Errorf("expected anonymous got %q %v", id, err)
+	}
+}
+
+// gatedBackend streams the results of a search and waits for release
+// before finishing it.
+type gatedBackend struct {
+	*MemoryBackend
+	release chan struct{}
+}
+
+func (be *gatedBackend) SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error) (*SearchResponse, error) {
+	res, err := be.MemoryBackend.Search(ctx, state, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range res.Results {
+		if err := send(r); err != nil {
+			return nil, err
+		}
+	}
+	select {
+	case <-be.release:
+	case <-time.After(5 * time.Second):
+		return nil, &BaseResponse{Code: ResultTimeLimitExceeded, Message: "results weren't streamed"}
+	}
+	return &SearchResponse{BaseResponse: res.BaseResponse}, nil
+}
+
+func (be *gatedBackend) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	var results []*SearchResult
+	res, err := be.SearchFunc(ctx, state, req, func(r *SearchResult) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Results = results
+	return res, nil
+}
+
+func TestProxyStreamSearch(t *testing.T) {
+	t.Parallel()
+	upstream := &gatedBackend{MemoryBackend: newTestMemoryBackend(t), release: make(chan struct{})}
+	url, _ := startTestUpstream(t, upstream)
+	c := newTestDialer(t, newTestProxy(t, url))()
+
+	var results []*SearchResult
+	err := c.SearchFunc(&SearchRequest{BaseDN: "ou=People,dc=example,dc=com", Scope: ScopeSingleLevel, Attributes: map[string]bool{"cn": true}}, func(r *SearchResult) error {
+		if len(results) == 0 {
+			close(upstream.release)
+		}
+		results = append(results, r)
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results got %d", len(results))
+	}
+	for _, r := range results {
+		if len(r.Attributes) != 1 || len(r.Attributes["cn"]) == 0 {
+			t.Errorf("expected only cn for %s got %v", r.DN, r.Attributes)
+		}
+	}
+}
+
+// compareUpstream compares passwords, which searches can't, and reports
+// the attributes it can't compare.
+type compareUpstream struct {
+	*MemoryBackend
+}
+
+func (be compareUpstream) Compare(ctx context.Context, state State, req *CompareRequest) (*CompareResponse, error) {
+	res := &CompareResponse{}
+	switch {
+	case DefaultSchema.AttributeType(req.Attribute) == nil:
+		res.Code = ResultUndefinedAttributeType
+	case !strings.EqualFold(req.Attribute, "userPassword"):
+		res.Code = ResultNoSuchAttribute
+	case string(req.Value) == "secret":
+		res.Code = ResultCompareTrue
+	default:
+		res.Code = ResultCompareFalse
+	}
+	return res, nil
+}
+
+func TestProxyCompare(t *testing.T) {
+	t.Parallel()
+	url, _ := startTestUpstream(t, compareUpstream{newTestMemoryBackend(t)})
+	_, c := newTestServer(t, newTestProxy(t, url))
+
+	jdoe := "uid=jdoe,ou=People,dc=example,dc=com"
+	cases := []struct {
+		attr, value string
+		want        bool
+		code        ResultCode
+	}{
+		{"userPassword", "secret", true, ResultSuccess},
+		{"userPassword", "wrong", false, ResultSuccess},
+		{"description", "x", false, ResultNoSuchAttribute},
+		{"noSuchType", "x", false, ResultUndefinedAttributeType},
+	}
+	for _, tc := range cases {
+		got, err := c.Compare(jdoe, tc.attr, []byte(tc.value))
+		if code := resultCode(err); code != tc.code || got != tc.want {
+			t.Errorf("%s=%s: expected %t %s got %t %v", tc.attr, tc.value, tc.want, tc.code, got, err)
+		}
+	}
+}
+
+func TestProxyServiceAccount(t *testing.T) {
+	t.Parallel()
+	upstream := &upstreamBackend{MemoryBackend: newTestMemoryBackend(t)}
+	url, _ := startTestUpstream(t, upstream)
+	proxy := newTestProxy(t, url)
+	proxy.ServiceDN = "cn=admin,dc=example,dc=com"
+	proxy.ServicePassword = []byte("admin")
+	dial := newTestDialer(t, proxy)
+	c := dial()
+
+	authz := func(t *testing.T, want string) {
+		t.Helper()
+		got := upstream.lastControls()
+		if len(got) != 1 || got[0].Type != OIDProxiedAuthControl || !got[0].Criticality || string(got[0].Value) != want {
+			t.Errorf("expected proxied authorization for %q got %+v", want, got)
+		}
+	}
+	if _, err := searchDNs(t, c, "dc=example,dc=com", ScopeBaseObject, ""); err != nil {
+		t.Fatal(err)
+	}
+	authz(t, "")
+
+	jdoe := "uid=jdoe,ou=People,dc=example,dc=com"
+	if err := c.Bind(jdoe, []byte("wrong")); resultCode(err) != ResultInvalidCredentials {
+		t.Errorf("expected invalid credentials got %v", err)
+	}
+	if err := c.Bind(jdoe, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := searchDNs(t, c, "dc=example,dc=com", ScopeBaseObject, ""); err != nil {
+		t.Fatal(err)
+	}
+	authz(t, "dn:"+jdoe)
+	if id, err := c.WhoAmI(); err != nil || id != "dn:"+jdoe {
+		t.Errorf("expected dn:%s got %q %v", jdoe, id, err)
+	}
+
+	// A client can't use the service account to proxy for someone else.
+	control := &Control{Type: OIDProxiedAuthControl, Criticality: true, Value: []byte("dn:cn=admin,dc=example,dc=com")}
+	if err := c.searchFunc(&controlledRequest{Request: &SearchRequest{BaseDN: "dc=example,dc=com"}, controls: []*Control{control}}, func(*SearchResult) error { return nil }, nil); err != nil {
+		t.Fatal(err)
+	}
+	authz(t, "dn:"+jdoe)
+
+	// The pooled connections are still bound as the service account so
+	// the password of the bound user is changed rather than theirs.
+	if _, err := c.PasswordModify(&PasswordModifyRequest{NewPassword: []byte("changed")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial().Bind(jdoe, []byte("changed")); err != nil {
+		t.Error(err)
+	}
+	if _, err := dial().PasswordModify(&PasswordModifyRequest{NewPassword: []byte("x")}); resultCode(err) != ResultUnwillingToPerform {
+		t.Errorf("expected unwilling to perform got %v", err)
+	}
+}
+
+func TestProxyFailover(t *testing.T) {
+	t.Parallel()
+	upstream := func(ou string) (*MemoryBackend, string, func()) {
+		be := newTestMemoryBackend(t)
+		if err := be.apply(&AddRequest{DN: "ou=" + ou + ",dc=example,dc=com", Attributes: map[string][][]byte{"objectClass": {[]byte("organizationalUnit")}, "ou": {[]byte(ou)}}}); err != nil {
+			t.Fatal(err)
+		}
+		url, kill := startTestUpstream(t, be)
+		return be, url, kill
+	}
+	_, deadURL, kill := upstream("Dead")
+	kill()
+	_, urlA, killA := upstream("A")
+	beB, urlB, _ := upstream("B")
+	dial := newTestDialer(t, newTestProxy(t, deadURL, urlA, urlB))
+	c := dial()
+
+	filter := "(|(ou=A)(ou=B)(ou=Dead))"
+	if dns, err := searchDNs(t, c, "dc=example,dc=com", ScopeSingleLevel, filter); err != nil || !reflect.DeepEqual(dns, []string{"ou=A,dc=example,dc=com"}) {
+		t.Fatalf("expected upstream A got %v: %v", dns, err)
+	}
+	jdoe := "uid=jdoe,ou=People,dc=example,dc=com"
+	if err := c.Bind(jdoe, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	killA()
+	// The bound connection loses its bind with the upstream connection.
+	if _, err := c.Search(&SearchRequest{BaseDN: "dc=example,dc=com"}); resultCode(err) != ResultUnavailable {
+		t.Errorf("expected unavailable got %v", err)
+	}
+	if dns, err := searchDNs(t, c, "dc=example,dc=com", ScopeSingleLevel, filter); err != nil || !reflect.DeepEqual(dns, []string{"ou=B,dc=example,dc=com"}) {
+		t.Fatalf("expected upstream B got %v: %v", dns, err)
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "anonymous" {
+		t.Errorf("expected anonymous got %q %v", id, err)
+	}
+	// Connections in the pool to A are replaced.
+	c2 := dial()
+	if dns, err := searchDNs(t, c2, "dc=example,dc=com", ScopeSingleLevel, filter); err != nil || !reflect.DeepEqual(dns, []string{"ou=B,dc=example,dc=com"}) {
+		t.Fatalf("expected upstream B got %v: %v", dns, err)
+	}
+	if err := c2.Delete("ou=B,dc=example,dc=com"); err != nil {
+		t.Fatal(err)
+	}
+	if beB.Entry("ou=B,dc=example,dc=com") != nil {
+		t.Error("entry not deleted from upstream B")
+	}
+}
+
+func TestNewProxyBackend(t *testing.T) {
+	t.Parallel()
+	for _, urls := range [][]string{nil, {"http://example.com"}, {"ldap:///dc=example,dc=com"}} {
+		if _, err := NewProxyBackend(urls...); err == nil {
+			t.Errorf("%v: expected an error", urls)
+		}
+	}
+	if got := hostPort("example.com", "389"); got != "example.com:389" {
+		t.Errorf("got %s", got)
+	}
+	if got := hostPort("[::1]", "636"); got != "[::1]:636" {
+		t.Errorf("got %s", got)
+	}
+	if got := hostPort("[::1]:1389", "636"); got != "[::1]:1389" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,459 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Router is a Backend that mounts backends at naming contexts and
+// dispatches operations to the backend with the longest suffix that
+// contains the DN of the operation. A backend mounted at the empty suffix
+// handles the DNs that aren't below any other suffix.
+//
+// Searches whose scope includes the suffixes of other backends are
+// performed by each of them and the results merged. Entries that a
+// backend holds below the suffix of another backend are not returned since
+// the other backend is authoritative for them. ModifyDN requests that would
+// move an entry between backends fail with ResultAffectsMultipleDSAs.
+//
+// Binds and compares are performed by the backend holding the DN. Whoami and
+// password modify requests without a user identity are handled by the
+// backend the connection is bound to. Other extended requests are handled
+// by the bound backend as well, or by the backend mounted at the empty
+// suffix for anonymous connections.
+type Router struct {
+	// routes are ordered by decreasing suffix length so the first match
+	// is the longest.
+	routes []*route
+}
+
+// errSizeLimitExceeded stops a backend's search once the router has sent
+// as many entries as the size limit allows.
+var errSizeLimitExceeded = errors.New("ldap: size limit exceeded")
+
+type route struct {
+	id     int
+	name   string
+	suffix DN // normalized
+	be     Backend
+}
+
+type routerState struct {
+	mu     sync.Mutex
+	states []State
+	bound  *route
+}
+
+// NewRouter returns a router without any mounted backends.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Mount adds a backend for the entries at and below the suffix. It must
+// not be called once the router is in use.
+func (r *Router) Mount(suffix string, be Backend) error {
+	dn, err := ParseDN(suffix)
+	if err != nil {
+		return err
+	}
+	dn = dn.Normalize()
+	for _, rt := range r.routes {
+		if rt.suffix.String() == dn.String() {
+			return fmt.Errorf("ldap: suffix %q is already mounted", suffix)
+		}
+	}
+	r.routes = append(r.routes, &route{id: len(r.routes), name: suffix, suffix: dn, be: be})
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return len(r.routes[i].suffix) > len(r.routes[j].suffix)
+	})
+	return nil
+}
+
+// SupportsControl returns true if any mounted backend supports the control.
+func (r *Router) SupportsControl(oid string) bool {
+	for _, rt := range r.routes {
+		if cs, ok := rt.be.(controlSupporter); ok && cs.SupportsControl(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// NamingContexts returns the suffixes of the mounted backends that aren't
+// below another suffix. For the backend mounted at the empty suffix the
+// naming contexts it reports are used.
+func (r *Router) NamingContexts() []string {
+	var names []string
+	var dns []DN
+	for _, rt := range r.routes {
+		candidates := []string{rt.name}
+		if len(rt.suffix) == 0 {
+			candidates = nil
+			if nc, ok := rt.be.(namingContexter); ok {
+				candidates = nc.NamingContexts()
+			}
+		}
+		for _, name := range candidates {
+			dn, err := ParseDN(name)
+			if err != nil || containsDN(names, name) {
+				continue
+			}
+			names = append(names, name)
+			dns = append(dns, dn)
+		}
+	}
+	var top []string
+	for i, dn := range dns {
+		below := false
+		for _, o := range dns {
+			below = below || dn.IsDescendantOf(o)
+		}
+		if !below {
+			top = append(top, names[i])
+		}
+	}
+	return top
+}
+
+// route returns the backend for a normalized DN or nil if none holds it.
+func (r *Router) route(dn DN) *route {
+	for _, rt := range r.routes {
+		if len(dn) >= len(rt.suffix) && dn[len(dn)-len(rt.suffix):].String() == rt.suffix.String() {
+			return rt
+		}
+	}
+	return nil
+}
+
+// routeDN parses a DN and returns the backend that holds it. The result is
+// set if the DN is invalid or no backend holds it.
+func (r *Router) routeDN(s string) (DN, *route, BaseResponse) {
+	dn, err := ParseDN(s)
+	if err != nil {
+		return nil, nil, BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}
+	}
+	dn = dn.Normalize()
+	rt := r.route(dn)
+	if rt == nil {
+		return dn, nil, BaseResponse{Code: ResultNoSuchObject}
+	}
+	return dn, rt, BaseResponse{}
+}
+
+// mountedBelow returns a backend other than rt that is mounted below dn.
+func (r *Router) mountedBelow(dn DN, rt *route) *route {
+	for _, o := range r.routes {
+		if o != rt && o.suffix.IsDescendantOf(dn) {
+			return o
+		}
+	}
+	return nil
+}
+
+func (r *Router) state(state State) *routerState {
+	st, _ := state.(*routerState)
+	if st == nil {
+		st = &routerState{states: make([]State, len(r.routes))}
+	}
+	return st
+}
+
+func (st *routerState) get(rt *route) State {
+	return st.states[rt.id]
+}
+
+// boundRoute returns the backend the connection is bound to or, if
+// anonymous, the backend mounted at the empty suffix.
+func (r *Router) boundRoute(st *routerState) *route {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.bound != nil {
+		return st.bound
+	}
+	return r.route(nil)
+}
+
+func (r *Router) Connect(remoteAddr net.Addr) (State, error) {
+	st := &routerState{states: make([]State, len(r.routes))}
+	for _, rt := range r.routes {
+		s, err := rt.be.Connect(remoteAddr)
+		if err != nil {
+			r.Disconnect(st)
+			return nil, err
+		}
+		st.states[rt.id] = s
+	}
+	return st, nil
+}
+
+func (r *Router) Disconnect(state State) {
+	st := r.state(state)
+	for _, rt := range r.routes {
+		if s := st.get(rt); s != nil {
+			rt.be.Disconnect(s)
+		}
+	}
+}
+
+func (r *Router) Add(ctx context.Context, state State, req *AddRequest) (*AddResponse, error) {
+	_, rt, res := r.routeDN(req.DN)
+	if rt == nil {
+		return &AddResponse{BaseResponse: res}, nil
+	}
+	return rt.be.Add(ctx, r.state(state).get(rt), req)
+}
+
+// Bind performs the bind with the backend holding the DN. The backend the
+// connection was previously bound to is bound anonymously so that only
+// one backend holds the identity of the connection.
+func (r *Router) Bind(ctx context.Context, state State, req *BindRequest) (*BindResponse, error) {
+	st := r.state(state)
+	var rt *route
+	res := &BindResponse{}
+	if req.DN == "" {
+		rt = r.route(nil)
+		if rt == nil && len(req.Password) != 0 {
+			res.Code = ResultInvalidCredentials
+		}
+	} else {
+		var br BaseResponse
+		_, rt, br = r.routeDN(req.DN)
+		if rt == nil {
+			if br.Code == ResultNoSuchObject {
+				br = BaseResponse{Code: ResultInvalidCredentials}
+			}
+			res.BaseResponse = br
+		}
+	}
+	if rt != nil {
+		var err error
+		if res, err = rt.be.Bind(ctx, st.get(rt), req); err != nil {
+			return nil, err
+		}
+	}
+	st.mu.Lock()
+	prev := st.bound
+	st.bound = nil
+	if req.DN != "" && res.Code == ResultSuccess {
+		st.bound = rt
+	}
+	st.mu.Unlock()
+	if prev != nil && prev != rt {
+		if _, err := prev.be.Bind(ctx, st.get(prev), &BindRequest{}); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Delete fails with ResultNotAllowedOnNonLeaf for an entry that has
+// another backend mounted below it.
+func (r *Router) Compare(ctx context.Context, state State, req *CompareRequest) (*CompareResponse, error) {
+	_, rt, res := r.routeDN(req.DN)
+	if rt == nil {
+		return &CompareResponse{BaseResponse: res}, nil
+	}
+	return compare(ctx, rt.be, r.state(state).get(rt), req)
+}
+
+func (r *Router) Delete(ctx context.Context, state State, req *DeleteRequest) (*DeleteResponse, error) {
+	dn, rt, res := r.routeDN(req.DN)
+	if rt == nil {
+		return &DeleteResponse{BaseResponse: res}, nil
+	}
+	if o := r.mountedBelow(dn, rt); o != nil {
+		return &DeleteResponse{BaseResponse: BaseResponse{Code: ResultNotAllowedOnNonLeaf, Message: "naming context " + o.name + " is mounted below the entry"}}, nil
+	}
+	return rt.be.Delete(ctx, r.state(state).get(rt), req)
+}
+
+func (r *Router) ExtendedRequest(ctx context.Context, state State, req *ExtendedRequest) (*ExtendedResponse, error) {
+	st := r.state(state)
+	rt := r.boundRoute(st)
+	if rt == nil {
+		return unsupportedExtended(req), nil
+	}
+	return rt.be.ExtendedRequest(ctx, st.get(rt), req)
+}
+
+func (r *Router) Modify(ctx context.Context, state State, req *ModifyRequest) (*ModifyResponse, error) {
+	_, rt, res := r.routeDN(req.DN)
+	if rt == nil {
+		return &ModifyResponse{BaseResponse: res}, nil
+	}
+	return rt.be.Modify(ctx, r.state(state).get(rt), req)
+}
+
+// ModifyDN fails with ResultAffectsMultipleDSAs if the new DN is held by
+// another backend or if another backend is mounted below the entry.
+func (r *Router) ModifyDN(ctx context.Context, state State, req *ModifyDNRequest) (*ModifyDNResponse, error) {
+	dn, rt, res := r.routeDN(req.DN)
+	if rt == nil {
+		return &ModifyDNResponse{BaseResponse: res}, nil
+	}
+	rdn, err := ParseDN(req.NewRDN)
+	if err != nil || len(rdn) != 1 {
+		return &ModifyDNResponse{BaseResponse: BaseResponse{Code: ResultInvalidDNSyntax, Message: "invalid new RDN"}}, nil
+	}
+	superior := dn.Parent()
+	if req.NewSuperior != "" {
+		if superior, err = ParseDN(req.NewSuperior); err != nil {
+			return &ModifyDNResponse{BaseResponse: BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}}, nil
+		}
+	}
+	newDN := append(DN{rdn[0]}, superior...).Normalize()
+	if r.route(newDN) != rt {
+		return &ModifyDNResponse{BaseResponse: BaseResponse{Code: ResultAffectsMultipleDSAs, Message: "the new DN is held by another backend"}}, nil
+	}
+	if o := r.mountedBelow(dn, rt); o != nil {
+		return &ModifyDNResponse{BaseResponse: BaseResponse{Code: ResultAffectsMultipleDSAs, Message: "naming context " + o.name + " is mounted below the entry"}}, nil
+	}
+	return rt.be.ModifyDN(ctx, r.state(state).get(rt), req)
+}
+
+// PasswordModify is performed by the backend holding the user identity
+// if it's a DN, optionally prefixed by "dn:", and otherwise by the
+// backend the connection is bound to.
+func (r *Router) PasswordModify(ctx context.Context, state State, req *PasswordModifyRequest) ([]byte, error) {
+	st := r.state(state)
+	var rt *route
+	if id := strings.TrimPrefix(req.UserIdentity, "dn:"); id != "" {
+		if dn, err := ParseDN(id); err == nil {
+			rt = r.route(dn.Normalize())
+		}
+	}
+	if rt == nil {
+		st.mu.Lock()
+		rt = st.bound
+		st.mu.Unlock()
+	}
+	if rt == nil {
+		if req.UserIdentity == "" {
+			return nil, &BaseResponse{Code: ResultUnwillingToPerform, Message: "must be bound to change the password"}
+		}
+		return nil, &BaseResponse{Code: ResultNoSuchObject}
+	}
+	return rt.be.PasswordModify(ctx, st.get(rt), req)
+}
+
+// Search merges the results of the backend holding the base DN and of the
+// backends mounted within the scope of the search. A search whose base is
+// only above mounted backends returns their entries.
+func (r *Router) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	var results []*SearchResult
+	res, err := r.SearchFunc(ctx, state, req, func(e *SearchResult) error {
+		results = append(results, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Results = results
+	return res, nil
+}
+
+// SearchFunc performs a search like Search but passes each entry to send
+// as the backends find it. Backends that don't stream their results are
+// searched with Search.
+func (r *Router) SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error) (*SearchResponse, error) {
+	st := r.state(state)
+	base, err := ParseDN(req.BaseDN)
+	if err != nil {
+		return &SearchResponse{BaseResponse: BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}}, nil
+	}
+	base = base.Normalize()
+	rt := r.route(base)
+	var subs []*route
+	if req.Scope != ScopeBaseObject {
+		for _, o := range r.routes {
+			if o != rt && o.suffix.IsDescendantOf(base) && (req.Scope != ScopeSingleLevel || len(o.suffix) == len(base)+1) {
+				subs = append(subs, o)
+			}
+		}
+	}
+	if len(subs) == 0 {
+		if rt == nil {
+			return &SearchResponse{BaseResponse: BaseResponse{Code: ResultNoSuchObject}}, nil
+		}
+		return searchFunc(ctx, rt.be, st.get(rt), req, send)
+	}
+
+	res := &SearchResponse{}
+	sent := 0
+	limited := false
+	merge := func(o *route, sub *SearchRequest) (bool, error) {
+		sr, err := searchFunc(ctx, o.be, st.get(o), sub, func(e *SearchResult) error {
+			// Skip the entries held by a backend mounted below this one.
+			if dn, err := ParseDN(e.DN); err == nil && r.route(dn.Normalize()) != o {
+				return nil
+			}
+			if req.SizeLimit > 0 && sent == req.SizeLimit {
+				limited = true
+				return errSizeLimitExceeded
+			}
+			sent++
+			return send(e)
+		})
+		if limited {
+			res.Code = ResultSizeLimitExceeded
+			return false, nil
+		}
+		if e, ok := errorAsType[*BaseResponse](err); ok {
+			sr, err = &SearchResponse{BaseResponse: *e}, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		res.References = append(res.References, sr.References...)
+		switch sr.Code {
+		case ResultSuccess:
+		case ResultSizeLimitExceeded:
+			res.Code = sr.Code
+		case ResultNoSuchObject:
+			// The base may only be a superior of the mounted backends and
+			// the suffix entry of a mounted backend may not exist yet.
+		default:
+			res.BaseResponse = sr.BaseResponse
+			return false, nil
+		}
+		return true, nil
+	}
+	if rt != nil {
+		subs = append([]*route{rt}, subs...)
+	}
+	for _, o := range subs {
+		sub := *req
+		if o != rt {
+			sub.BaseDN = o.name
+			sub.Scope = ScopeWholeSubtree
+			if req.Scope == ScopeSingleLevel {
+				sub.Scope = ScopeBaseObject
+			}
+		}
+		ok, err := merge(o, &sub)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return res, nil
+		}
+	}
+	return res, nil
+}
+
+// Whoami is answered by the backend the connection is bound to.
+func (r *Router) Whoami(ctx context.Context, state State) (string, error) {
+	st := r.state(state)
+	st.mu.Lock()
+	rt := st.bound
+	st.mu.Unlock()
+	if rt == nil {
+		return "", nil
+	}
+	return rt.be.Whoami(ctx, st.get(rt))
+}
@@ -0,0 +1,426 @@
+package ldap
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testPartnersLDIF = `version: 1
+
+dn: ou=Partners,dc=example,dc=com
+objectClass: organizationalUnit
+ou: Partners
+
+dn: uid=pjones,ou=Partners,dc=example,dc=com
+objectClass: person
+objectClass: inetOrgPerson
+uid: pjones
+cn: Pat Jones
+sn: Jones
+userPassword: partner
+`
+
+const testCorpLDIF = `version: 1
+
+dn: dc=corp
+objectClass: domain
+dc: corp
+
+dn: uid=bob,dc=corp
+objectClass: person
+objectClass: inetOrgPerson
+uid: bob
+cn: Bob
+sn: Builder
+`
+
+func newTestLDIFBackend(t *testing.T, suffix, ldif string) *MemoryBackend {
+	t.Helper()
+	be, err := NewMemoryBackend(suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := be.LoadLDIF(strings.NewReader(ldif)); err != nil {
+		t.Fatal(err)
+	}
+	return be
+}
+
+type testRouter struct {
+	*Router
+	example, partners, corp *MemoryBackend
+}
+
+// newTestRouter mounts the example directory as the default backend with
+// partners below it and corp beside it. The example directory also holds
+// entries below ou=Partners that are hidden by the partners backend.
+func newTestRouter(t *testing.T, withDefault bool) *testRouter {
+	t.Helper()
+	r := &testRouter{
+		Router:   NewRouter(),
+		example:  newTestMemoryBackend(t),
+		partners: newTestLDIFBackend(t, "ou=Partners,dc=example,dc=com", testPartnersLDIF),
+		corp:     newTestLDIFBackend(t, "dc=corp", testCorpLDIF),
+	}
+	r.corp.RootDN = "cn=admin,dc=corp"
+	r.corp.RootPassword = []byte("corp")
+	for _, dn := range []string{"ou=Partners,dc=example,dc=com", "uid=ghost,ou=Partners,dc=example,dc=com"} {
+		rdn, _, _ := strings.Cut(dn, ",")
+		typ, val, _ := strings.Cut(rdn, "=")
+		if err := r.example.apply(&AddRequest{DN: dn, Attributes: map[string][][]byte{"objectClass": {[]byte("extensibleObject")}, typ: {[]byte(val)}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mounts := map[string]Backend{"ou=partners,dc=example,dc=com": r.partners, "DC=Corp": r.corp}
+	if withDefault {
+		mounts[""] = r.example
+	}
+	for suffix, be := range mounts {
+		if err := r.Mount(suffix, be); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestRouterSearch(t *testing.T) {
+	t.Parallel()
+	r := newTestRouter(t, true)
+	partial := newTestRouter(t, false)
+	_, c := newTestServer(t, r)
+	_, partialClient := newTestServer(t, partial)
+	people := []string{"ou=People,dc=example,dc=com", "uid=asmith,ou=People,dc=example,dc=com", "uid=jdoe,ou=People,dc=example,dc=com"}
+	partners := []string{"ou=Partners,dc=example,dc=com", "uid=pjones,ou=Partners,dc=example,dc=com"}
+	example := append(append([]string{"dc=example,dc=com", "ou=Groups,dc=example,dc=com"}, partners...), people...)
+	sort.Strings(example)
+	cases := []struct {
+		c      *Client
+		base   string
+		scope  Scope
+		filter string
+		code   ResultCode
+		dns    []string
+	}{
+		{c, "dc=example,dc=com", ScopeWholeSubtree, "(objectClass=*)", ResultSuccess, example},
+		{c, "dc=example,dc=com", ScopeSingleLevel, "(objectClass=*)", ResultSuccess, []string{"ou=Groups,dc=example,dc=com", "ou=Partners,dc=example,dc=com", "ou=People,dc=example,dc=com"}},
+		{c, "dc=example,dc=com", ScopeChildren, "(|(uid=jdoe)(uid=pjones)(uid=ghost))", ResultSuccess, []string{"uid=jdoe,ou=People,dc=example,dc=com", "uid=pjones,ou=Partners,dc=example,dc=com"}},
+		{c, "OU=Partners,dc=example,dc=com", ScopeWholeSubtree, "(objectClass=*)", ResultSuccess, partners},
+		{c, "ou=Partners,dc=example,dc=com", ScopeBaseObject, "(objectClass=organizationalUnit)", ResultSuccess, partners[:1]},
+		{c, "dc=corp", ScopeWholeSubtree, "(objectClass=*)", ResultSuccess, []string{"dc=corp", "uid=bob,dc=corp"}},
+		{c, "", ScopeWholeSubtree, "(uid=*)", ResultSuccess, []string{"uid=asmith,ou=People,dc=example,dc=com", "uid=bob,dc=corp", "uid=jdoe,ou=People,dc=example,dc=com", "uid=pjones,ou=Partners,dc=example,dc=com"}},
+		{c, "", ScopeSingleLevel, "(objectClass=*)", ResultSuccess, []string{"dc=corp", "dc=example,dc=com"}},
+		{c, "dc=nowhere", ScopeWholeSubtree, "(objectClass=*)", ResultNoSuchObject, nil},
+		{c, "dc=example,dc=com,", ScopeWholeSubtree, "(objectClass=*)", ResultInvalidDNSyntax, nil},
+		{partialClient, "dc=example,dc=com", ScopeWholeSubtree, "(objectClass=*)", ResultSuccess, partners},
+		{partialClient, "dc=example,dc=com", ScopeBaseObject, "(objectClass=*)", ResultNoSuchObject, nil},
+		{partialClient, "uid=jdoe,ou=People,dc=example,dc=com", ScopeWholeSubtree, "(objectClass=*)", ResultNoSuchObject, nil},
+	}
+	for _, tc := range cases {
+		dns, err := searchDNs(t, tc.c, tc.base, tc.scope, tc.filter)
+		if code := resultCode(err); code != tc.code || !reflect.DeepEqual(dns, tc.dns) {
+			t.Errorf("%q %s %s: got %s %v, want %s %v", tc.base, tc.scope, tc.filter, code, dns, tc.code, tc.dns)
+		}
+	}
+
+	res, err := r.Search(context.Background(), nil, &SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree, SizeLimit: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != ResultSizeLimitExceeded || len(res.Results) != 6 {
+		t.Errorf("expected size limit exceeded with 6 results got %s %d", res.Code, len(res.Results))
+	}
+}
+
+func TestRouterStreamSearch(t *testing.T) {
+	t.Parallel()
+	r := NewRouter()
+	partners := &gatedBackend{MemoryBackend: newTestLDIFBackend(t, "ou=Partners,dc=example,dc=com", testPartnersLDIF), release: make(chan struct{})}
+	for suffix, be := range map[string]Backend{"": newTestMemoryBackend(t), "ou=Partners,dc=example,dc=com": partners} {
+		if err := r.Mount(suffix, be); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, c := newTestServer(t, r)
+
+	// The partners backend finishes its search once an entry it sent has
+	// been received.
+	var dns []string
+	err := c.SearchFunc(&SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree}, func(e *SearchResult) error {
+		if e.DN == "uid=pjones,ou=Partners,dc=example,dc=com" {
+			close(partners.release)
+		}
+		dns = append(dns, e.DN)
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dns) != 7 {
+		t.Errorf("expected 7 entries got %q", dns)
+	}
+
+	res, err := r.SearchFunc(context.Background(), nil, &SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree, SizeLimit: 6}, func(*SearchResult) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != ResultSizeLimitExceeded {
+		t.Errorf("expected size limit exceeded got %s", res.Code)
+	}
+}
+
+func TestRouterCompare(t *testing.T) {
+	t.Parallel()
+	r := newTestRouter(t, true)
+	if err := r.Mount("dc=answers", compareBackend{newTestMemoryBackend(t)}); err != nil {
+		t.Fatal(err)
+	}
+	_, c := newTestServer(t, r)
+	cases := []struct {
+		dn, attr, value string
+		want            bool
+		code            ResultCode
+	}{
+		{"uid=pjones,ou=Partners,dc=example,dc=com", "cn", "pat jones", true, ResultSuccess},
+		{"uid=ghost,ou=Partners,dc=example,dc=com", "uid", "ghost", false, ResultNoSuchObject},
+		{"uid=bob,dc=corp", "sn", "Jones", false, ResultSuccess},
+		{"cn=x,dc=answers", "answer", "42", true, ResultSuccess},
+	}
+	for _, tc := range cases {
+		got, err := c.Compare(tc.dn, tc.attr, []byte(tc.value))
+		if code := resultCode(err); code != tc.code || got != tc.want {
+			t.Errorf("%s %s=%s: expected %t %s got %t %v", tc.dn, tc.attr, tc.value, tc.want, tc.code, got, err)
+		}
+	}
+	res, err := newTestRouter(t, false).Compare(context.Background(), nil, &CompareRequest{DN: "dc=nowhere", Attribute: "dc", Value: []byte("nowhere")})
+	if err != nil || res.Code != ResultNoSuchObject {
+		t.Errorf("expected no such object got %v %v", res, err)
+	}
+}
+
+func TestRouterUpdate(t *testing.T) {
+	t.Parallel()
+	r := newTestRouter(t, true)
+	ctx := context.Background()
+	state, err := r.Connect(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Disconnect(state)
+
+	add := func(dn string) ResultCode {
+		rdn, _, _ := strings.Cut(dn, ",")
+		_, val, _ := strings.Cut(rdn, "=")
+		res, err := r.Add(ctx, state, &AddRequest{DN: dn, Attributes: map[string][][]byte{"objectClass": {[]byte("organizationalUnit")}, "ou": {[]byte(val)}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Code
+	}
+	modifyDN := func(req *ModifyDNRequest) ResultCode {
+		res, err := r.ModifyDN(ctx, state, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Code
+	}
+	if code := add("ou=Staff,dc=corp"); code != ResultSuccess {
+		t.Fatalf("add to corp: %s", code)
+	}
+	if code := add("ou=Vendors,ou=Partners,dc=example,dc=com"); code != ResultSuccess {
+		t.Fatalf("add to partners: %s", code)
+	}
+	if r.corp.Entry("ou=Staff,dc=corp") == nil || r.partners.Entry("ou=Vendors,ou=Partners,dc=example,dc=com") == nil || r.example.Entry("ou=Vendors,ou=Partners,dc=example,dc=com") != nil {
+		t.Error("entries not added to the backends holding them")
+	}
+	res, err := r.Modify(ctx, state, &ModifyRequest{DN: "uid=bob,dc=corp", Mods: []*Mod{{Type: Replace, Name: "sn", Values: [][]byte{[]byte("Smith")}}}})
+	if err != nil || res.Code != ResultSuccess {
+		t.Fatalf("modify: %v %v", res, err)
+	}
+	if e := r.corp.Entry("uid=bob,dc=corp"); e == nil || e.Get("sn") != "Smith" {
+		t.Error("entry not modified")
+	}
+
+	for _, tc := range []struct {
+		req  *ModifyDNRequest
+		code ResultCode
+	}{
+		{&ModifyDNRequest{DN: "ou=Staff,dc=corp", NewRDN: "ou=Crew", DeleteOldRDN: true}, ResultSuccess},
+		{&ModifyDNRequest{DN: "ou=Crew,dc=corp", NewRDN: "ou=Crew", NewSuperior: "ou=Partners,dc=example,dc=com"}, ResultAffectsMultipleDSAs},
+		{&ModifyDNRequest{DN: "ou=Crew,dc=corp", NewRDN: "ou=Crew", NewSuperior: "dc=example,dc=com"}, ResultAffectsMultipleDSAs},
+		{&ModifyDNRequest{DN: "ou=Vendors,ou=Partners,dc=example,dc=com", NewRDN: "ou=Vendors", NewSuperior: "dc=example,dc=com"}, ResultAffectsMultipleDSAs},
+		{&ModifyDNRequest{DN: "ou=Partners,dc=example,dc=com", NewRDN: "ou=Associates"}, ResultAffectsMultipleDSAs},
+		// The parent of a mounted backend can't be moved either.
+		{&ModifyDNRequest{DN: "dc=example,dc=com", NewRDN: "dc=sample"}, ResultAffectsMultipleDSAs},
+		{&ModifyDNRequest{DN: "ou=Groups,dc=example,dc=com", NewRDN: "ou=Teams", DeleteOldRDN: true}, ResultSuccess},
+		{&ModifyDNRequest{DN: "ou=Groups,dc=example,dc=com", NewRDN: "ou=Teams,ou=x"}, ResultInvalidDNSyntax},
+	} {
+		if code := modifyDN(tc.req); code != tc.code {
+			t.Errorf("%+v: got %s, want %s", tc.req, code, tc.code)
+		}
+	}
+	if r.corp.Entry("ou=Crew,dc=corp") == nil || r.example.Entry("ou=Teams,dc=example,dc=com") == nil {
+		t.Error("entries not renamed")
+	}
+
+	for _, tc := range []struct {
+		dn   string
+		code ResultCode
+	}{
+		{"ou=Partners,dc=example,dc=com", ResultNotAllowedOnNonLeaf},
+		{"ou=Crew,dc=corp", ResultSuccess},
+		{"ou=Vendors,ou=Partners,dc=example,dc=com", ResultSuccess},
+	} {
+		res, err := r.Delete(ctx, state, &DeleteRequest{DN: tc.dn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != tc.code {
+			t.Errorf("delete %s: got %s, want %s", tc.dn, res.Code, tc.code)
+		}
+	}
+
+	partial := newTestRouter(t, false)
+	if res, err := partial.Add(ctx, nil, &AddRequest{DN: "dc=other", Attributes: map[string][][]byte{"objectClass": {[]byte("domain")}, "dc": {[]byte("other")}}}); err != nil || res.Code != ResultNoSuchObject {
+		t.Errorf("expected no such object for an unmounted DN got %v %v", res, err)
+	}
+}
+
+func TestRouterBind(t *testing.T) {
+	t.Parallel()
+	r := newTestRouter(t, true)
+	dial := newTestDialer(t, r)
+	c := dial()
+
+	jdoe := "uid=jdoe,ou=People,dc=example,dc=com"
+	if err := c.Bind(jdoe, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "dn:"+jdoe {
+		t.Errorf("expected dn:%s got %q %v", jdoe, id, err)
+	}
+	if err := c.Bind("uid=pjones,ou=Partners,dc=example,dc=com", []byte("wrong")); resultCode(err) != ResultInvalidCredentials {
+		t.Errorf("expected invalid credentials got %v", err)
+	}
+	// A failed bind leaves the connection anonymous.
+	if id, err := c.WhoAmI(); err != nil || id != "anonymous" {
+		t.Errorf("expected anonymous got %q %v", id, err)
+	}
+	if err := c.Bind("cn=admin,dc=corp", []byte("corp")); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "dn:cn=admin,dc=corp" {
+		t.Errorf("expected dn:cn=admin,dc=corp got %q %v", id, err)
+	}
+	// Password changes are routed by the user identity.
+	if _, err := c.PasswordModify(&PasswordModifyRequest{UserIdentity: "dn:uid=bob,dc=corp", NewPassword: []byte("builder")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PasswordModify(&PasswordModifyRequest{UserIdentity: jdoe, NewPassword: []byte("x")}); resultCode(err) != ResultInsufficientAccessRights {
+		t.Errorf("expected insufficient access rights for a user of another backend got %v", err)
+	}
+	if err := c.Bind("uid=bob,dc=corp", []byte("builder")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PasswordModify(&PasswordModifyRequest{NewPassword: []byte("bob")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial().Bind("uid=bob,dc=corp", []byte("bob")); err != nil {
+		t.Error(err)
+	}
+	if err := c.Bind("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PasswordModify(&PasswordModifyRequest{NewPassword: []byte("x")}); resultCode(err) != ResultUnwillingToPerform {
+		t.Errorf("expected unwilling to perform got %v", err)
+	}
+
+	partial := newTestDialer(t, newTestRouter(t, false).Router)()
+	if err := partial.Bind(jdoe, []byte("secret")); resultCode(err) != ResultInvalidCredentials {
+		t.Errorf("expected invalid credentials got %v", err)
+	}
+	if err := partial.Bind("", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestRouterBindState checks that only the backend the connection is bound
+// to holds an identity.
+func TestRouterBindState(t *testing.T) {
+	t.Parallel()
+	r := newTestRouter(t, true)
+	ctx := context.Background()
+	state, err := r.Connect(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Disconnect(state)
+	bound := func() []string {
+		var dns []string
+		for _, s := range state.(*routerState).states {
+			dns = append(dns, boundDN(s))
+		}
+		sort.Strings(dns)
+		return dns
+	}
+	for _, tc := range []struct {
+		dn, password string
+		want         []string
+	}{
+		{"uid=jdoe,ou=People,dc=example,dc=com", "secret", []string{"", "", "uid=jdoe,ou=People,dc=example,dc=com"}},
+		{"uid=pjones,ou=Partners,dc=example,dc=com", "partner", []string{"", "", "uid=pjones,ou=Partners,dc=example,dc=com"}},
+		{"cn=admin,dc=corp", "wrong", []string{"", "", ""}},
+		{"cn=admin,dc=corp", "corp", []string{"", "", "cn=admin,dc=corp"}},
+		{"", "", []string{"", "", ""}},
+	} {
+		if _, err := r.Bind(ctx, state, &BindRequest{DN: tc.dn, Password: []byte(tc.password)}); err != nil {
+			t.Fatal(err)
+		}
+		if got := bound(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got bound DNs %q, want %q", tc.dn, got, tc.want)
+		}
+	}
+}
+
+func TestRouterNamingContexts(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		withDefault bool
+		want        []string
+	}{
+		{true, []string{"DC=Corp", "dc=example,dc=com"}},
+		{false, []string{"DC=Corp", "ou=partners,dc=example,dc=com"}},
+	} {
+		_, c := newTestServer(t, newTestRouter(t, tc.withDefault))
+		res, err := c.Search(&SearchRequest{Scope: ScopeBaseObject, Attributes: map[string]bool{"namingContexts": true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, v := range res[0].Attributes["namingContexts"] {
+			got = append(got, string(v))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("got naming contexts %q, want %q", got, tc.want)
+		}
+	}
+
+	r := NewRouter()
+	for _, tc := range []struct {
+		suffix string
+		ok     bool
+	}{
+		{"dc=corp", true},
+		{"DC=CORP", false},
+		{"dc=corp,", false},
+		{"", true},
+		{"", false},
+	} {
+		if err := r.Mount(tc.suffix, DebugBackend); (err == nil) != tc.ok {
+			t.Errorf("mount %q: got error %v", tc.suffix, err)
+		}
+	}
+}
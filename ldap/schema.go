@@ -0,0 +1,880 @@
+package ldap
+
+// https://tools.ietf.org/html/rfc4512#section-4
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Syntaxes (https://tools.ietf.org/html/rfc4517#section-3.3)
+const (
+	SyntaxAttributeTypeDescription = "1.3.6.1.4.1.1466.115.121.1.3"
+	SyntaxBitString                = "1.3.6.1.4.1.1466.115.121.1.6"
+	SyntaxBoolean                  = "1.3.6.1.4.1.1466.115.121.1.7"
+	SyntaxCountryString            = "1.3.6.1.4.1.1466.115.121.1.11"
+	SyntaxDN                       = "1.3.6.1.4.1.1466.115.121.1.12"
+	SyntaxDirectoryString          = "1.3.6.1.4.1.1466.115.121.1.15"
+	SyntaxFacsimileTelephoneNumber = "1.3.6.1.4.1.1466.115.121.1.22"
+	SyntaxGeneralizedTime          = "1.3.6.1.4.1.1466.115.121.1.24"
+	SyntaxIA5String                = "1.3.6.1.4.1.1466.115.121.1.26"
+	SyntaxInteger                  = "1.3.6.1.4.1.1466.115.121.1.27"
+	SyntaxJPEG                     = "1.3.6.1.4.1.1466.115.121.1.28"
+	SyntaxMatchingRuleDescription  = "1.3.6.1.4.1.1466.115.121.1.30"
+	SyntaxNameAndOptionalUID       = "1.3.6.1.4.1.1466.115.121.1.34"
+	SyntaxNumericString            = "1.3.6.1.4.1.1466.115.121.1.36"
+	SyntaxObjectClassDescription   = "1.3.6.1.4.1.1466.115.121.1.37"
+	SyntaxOID                      = "1.3.6.1.4.1.1466.115.121.1.38"
+	SyntaxOctetString              = "1.3.6.1.4.1.1466.115.121.1.40"
+	SyntaxPostalAddress            = "1.3.6.1.4.1.1466.115.121.1.41"
+	SyntaxPrintableString          = "1.3.6.1.4.1.1466.115.121.1.44"
+	SyntaxTelephoneNumber          = "1.3.6.1.4.1.1466.115.121.1.50"
+	SyntaxLDAPSyntaxDescription    = "1.3.6.1.4.1.1466.115.121.1.54"
+	SyntaxSubstringAssertion       = "1.3.6.1.4.1.1466.115.121.1.58"
+)
+
+// DefaultSubschemaDN is the name of the subschema subentry published by a Server.
+const DefaultSubschemaDN = "cn=Subschema"
+
+type SchemaSyntaxError struct {
+	Definition string
+	Msg        string
+}
+
+func (e *SchemaSyntaxError) Error() string {
+	return fmt.Sprintf("ldap: schema syntax error: %s in %q", e.Msg, e.Definition)
+}
+
+type AttributeUsage int
+
+const (
+	UserApplications     AttributeUsage = 0
+	DirectoryOperation   AttributeUsage = 1
+	DistributedOperation AttributeUsage = 2
+	DSAOperation         AttributeUsage = 3
+)
+
+var AttributeUsageMap = map[AttributeUsage]string{
+	UserApplications:     "userApplications",
+	DirectoryOperation:   "directoryOperation",
+	DistributedOperation: "distributedOperation",
+	DSAOperation:         "dSAOperation",
+}
+
+func (u AttributeUsage) String() string {
+	if s := AttributeUsageMap[u]; s != "" {
+		return s
+	}
+	return strconv.Itoa(int(u))
+}
+
+type ObjectClassKind int
+
+const (
+	Structural ObjectClassKind = 0
+	Abstract   ObjectClassKind = 1
+	Auxiliary  ObjectClassKind = 2
+)
+
+var ObjectClassKindMap = map[ObjectClassKind]string{
+	Structural: "STRUCTURAL",
+	Abstract:   "ABSTRACT",
+	Auxiliary:  "AUXILIARY",
+}
+
+func (k ObjectClassKind) String() string {
+	if s := ObjectClassKindMap[k]; s != "" {
+		return s
+	}
+	return strconv.Itoa(int(k))
+}
+
+// AttributeType is an attribute type description (RFC 4512 section 4.1.2).
+type AttributeType struct {
+	OID                string
+	Names              []string
+	Description        string
+	Obsolete           bool
+	SuperType          string
+	Equality           string
+	Ordering           string
+	Substr             string
+	Syntax             string
+	SyntaxLength       int
+	SingleValue        bool
+	Collective         bool
+	NoUserModification bool
+	Usage              AttributeUsage
+	Extensions         map[string][]string
+}
+
+// Name returns the primary name of the attribute type or its OID if it has no names.
+func (at *AttributeType) Name() string {
+	if len(at.Names) != 0 {
+		return at.Names[0]
+	}
+	return at.OID
+}
+
+// Operational returns true if the attribute type is not a user attribute.
+func (at *AttributeType) Operational() bool {
+	return at.Usage != UserApplications
+}
+
+func (at *AttributeType) String() string {
+	var b schemaBuilder
+	b.start(at.OID)
+	b.qdescrs("NAME", at.Names)
+	b.qdstring("DESC", at.Description)
+	b.flag("OBSOLETE", at.Obsolete)
+	b.word("SUP", at.SuperType)
+	b.word("EQUALITY", at.Equality)
+	b.word("ORDERING", at.Ordering)
+	b.word("SUBSTR", at.Substr)
+	if at.Syntax != "" {
+		if at.SyntaxLength > 0 {
+			b.word("SYNTAX", at.Syntax+"{"+strconv.Itoa(at.SyntaxLength)+"}")
+		} else {
+			b.word("SYNTAX", at.Syntax)
+		}
+	}
+	b.flag("SINGLE-VALUE", at.SingleValue)
+	b.flag("COLLECTIVE", at.Collective)
+	b.flag("NO-USER-MODIFICATION", at.NoUserModification)
+	if at.Usage != UserApplications {
+		b.word("USAGE", at.Usage.String())
+	}
+	b.extensions(at.Extensions)
+	return b.end()
+}
+
+// ObjectClass is an object class description (RFC 4512 section 4.1.1).
+type ObjectClass struct {
+	OID          string
+	Names        []string
+	Description  string
+	Obsolete     bool
+	SuperClasses []string
+	Kind         ObjectClassKind
+	Must         []string
+	May          []string
+	Extensions   map[string][]string
+}
+
+// Name returns the primary name of the object class or its OID if it has no names.
+func (oc *ObjectClass) Name() string {
+	if len(oc.Names) != 0 {
+		return oc.Names[0]
+	}
+	return oc.OID
+}
+
+func (oc *ObjectClass) String() string {
+	var b schemaBuilder
+	b.start(oc.OID)
+	b.qdescrs("NAME", oc.Names)
+	b.qdstring("DESC", oc.Description)
+	b.flag("OBSOLETE", oc.Obsolete)
+	b.oids("SUP", oc.SuperClasses)
+	b.flag(oc.Kind.String(), true)
+	b.oids("MUST", oc.Must)
+	b.oids("MAY", oc.May)
+	b.extensions(oc.Extensions)
+	return b.end()
+}
+
+// LDAPSyntax is an LDAP syntax description (RFC 4512 section 4.1.5).
+type LDAPSyntax struct {
+	OID         string
+	Description string
+	Extensions  map[string][]string
+}
+
+func (s *LDAPSyntax) String() string {
+	var b schemaBuilder
+	b.start(s.OID)
+	b.qdstring("DESC", s.Description)
+	b.extensions(s.Extensions)
+	return b.end()
+}
+
+// MatchingRule is a matching rule description (RFC 4512 section 4.1.3)
+// along with its implementation. A rule parsed from a description has no
+// implementation, in which case a Schema falls back to the rule registered
+// with RegisterMatchingRule using the same OID.
+type MatchingRule struct {
+	OID         string
+	Names       []string
+	Description string
+	Obsolete    bool
+	Syntax      string
+	Extensions  map[string][]string
+
+	// Kind is the kind of assertion the rule evaluates.
+	Kind MatchingRuleKind
+	// Normalize prepares an attribute value for comparison. Values match
+	// under an equality rule when their normalized forms are equal, ordering
+	// rules compare the normalized forms, and substrings rules look for the
+	// normalized components in the normalized value. An error means the value
+	// is not valid for the rule so the comparison is Undefined.
+	Normalize func(value []byte) ([]byte, error)
+	// NormalizeAssertion prepares an assertion value when its syntax differs
+	// from the attribute syntax. If nil then Normalize is used.
+	NormalizeAssertion func(value []byte) ([]byte, error)
+	// NormalizeSubstring prepares a component of a substrings assertion. If
+	// nil then Normalize is used.
+	NormalizeSubstring func(value []byte, pos SubstringPosition) ([]byte, error)
+	// CompareFunc orders normalized values for ordering rules. If nil then
+	// bytes.Compare is used.
+	CompareFunc func(a, b []byte) int
+	// MatchFunc replaces normalization for rules that are not based on
+	// equality of normalized values (e.g. bitwise rules).
+	MatchFunc func(value, assertion []byte) (bool, error)
+}
+
+// Name returns the primary name of the matching rule or its OID if it has no names.
+func (mr *MatchingRule) Name() string {
+	if len(mr.Names) != 0 {
+		return mr.Names[0]
+	}
+	return mr.OID
+}
+
+func (mr *MatchingRule) String() string {
+	var b schemaBuilder
+	b.start(mr.OID)
+	b.qdescrs("NAME", mr.Names)
+	b.qdstring("DESC", mr.Description)
+	b.flag("OBSOLETE", mr.Obsolete)
+	b.word("SYNTAX", mr.Syntax)
+	b.extensions(mr.Extensions)
+	return b.end()
+}
+
+// Schema is a set of schema definitions as published in a subschema subentry.
+// It is safe for concurrent use.
+type Schema struct {
+	// DN is the name of the subschema subentry.
+	DN string
+
+	mu             sync.RWMutex
+	attributeTypes []*AttributeType
+	objectClasses  []*ObjectClass
+	ldapSyntaxes   []*LDAPSyntax
+	matchingRules  []*MatchingRule
+	attrByName     map[string]*AttributeType
+	classByName    map[string]*ObjectClass
+	syntaxByOID    map[string]*LDAPSyntax
+	ruleByName     map[string]*MatchingRule
+}
+
+// NewSchema returns an empty schema published at the given DN.
+func NewSchema(dn string) *Schema {
+	return &Schema{
+		DN:          dn,
+		attrByName:  make(map[string]*AttributeType),
+		classByName: make(map[string]*ObjectClass),
+		syntaxByOID: make(map[string]*LDAPSyntax),
+		ruleByName:  make(map[string]*MatchingRule),
+	}
+}
+
+// Clone returns a copy of the schema that can be extended without changing the original.
+func (s *Schema) Clone() *Schema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := NewSchema(s.DN)
+	c.attributeTypes = append([]*AttributeType(nil), s.attributeTypes...)
+	c.objectClasses = append([]*ObjectClass(nil), s.objectClasses...)
+	c.ldapSyntaxes = append([]*LDAPSyntax(nil), s.ldapSyntaxes...)
+	c.matchingRules = append([]*MatchingRule(nil), s.matchingRules...)
+	for k, v := range s.attrByName {
+		c.attrByName[k] = v
+	}
+	for k, v := range s.classByName {
+		c.classByName[k] = v
+	}
+	for k, v := range s.syntaxByOID {
+		c.syntaxByOID[k] = v
+	}
+	for k, v := range s.ruleByName {
+		c.ruleByName[k] = v
+	}
+	return c
+}
+
+// AddAttributeType adds an attribute type to the schema. The super type,
+// if any, must already be part of the schema.
+func (s *Schema) AddAttributeType(at *AttributeType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at.SuperType != "" && s.attrByName[strings.ToLower(at.SuperType)] == nil {
+		return fmt.Errorf("ldap: attribute type %s has unknown super type %s", at.Name(), at.SuperType)
+	}
+	if at.SuperType == "" && at.Syntax == "" {
+		return fmt.Errorf("ldap: attribute type %s requires a syntax or super type", at.Name())
+	}
+	for _, n := range append([]string{at.OID}, at.Names...) {
+		if s.attrByName[strings.ToLower(n)] != nil {
+			return fmt.Errorf("ldap: duplicate attribute type %s", n)
+		}
+	}
+	for _, n := range append([]string{at.OID}, at.Names...) {
+		s.attrByName[strings.ToLower(n)] = at
+	}
+	s.attributeTypes = append(s.attributeTypes, at)
+	return nil
+}
+
+// AddObjectClass adds an object class to the schema. Super classes and
+// attributes referenced by the object class must already be part of the schema.
+func (s *Schema) AddObjectClass(oc *ObjectClass) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range oc.SuperClasses {
+		if s.classByName[strings.ToLower(n)] == nil {
+			return fmt.Errorf("ldap: object class %s has unknown super class %s", oc.Name(), n)
+		}
+	}
+	for _, n := range append(append([]string(nil), oc.Must...), oc.May...) {
+		if s.attrByName[strings.ToLower(n)] == nil {
+			return fmt.Errorf("ldap: object class %s references unknown attribute type %s", oc.Name(), n)
+		}
+	}
+	for _, n := range append([]string{oc.OID}, oc.Names...) {
+		if s.classByName[strings.ToLower(n)] != nil {
+			return fmt.Errorf("ldap: duplicate object class %s", n)
+		}
+	}
+	for _, n := range append([]string{oc.OID}, oc.Names...) {
+		s.classByName[strings.ToLower(n)] = oc
+	}
+	s.objectClasses = append(s.objectClasses, oc)
+	return nil
+}
+
+// classAttributes returns the required and allowed attributes of an object
+// class including those of its super classes.
+func (s *Schema) classAttributes(oc *ObjectClass) []string {
+	var attrs []string
+	seen := make(map[*ObjectClass]bool)
+	var walk func(oc *ObjectClass)
+	walk = func(oc *ObjectClass) {
+		if oc == nil || seen[oc] {
+			return
+		}
+		seen[oc] = true
+		attrs = append(attrs, oc.Must...)
+		attrs = append(attrs, oc.May...)
+		for _, sup := range oc.SuperClasses {
+			walk(s.ObjectClass(sup))
+		}
+	}
+	walk(oc)
+	return attrs
+}
+
+// AddLDAPSyntax adds a syntax to the schema.
+func (s *Schema) AddLDAPSyntax(syn *LDAPSyntax) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.syntaxByOID[syn.OID] != nil {
+		return fmt.Errorf("ldap: duplicate syntax %s", syn.OID)
+	}
+	s.syntaxByOID[syn.OID] = syn
+	s.ldapSyntaxes = append(s.ldapSyntaxes, syn)
+	return nil
+}
+
+// AddMatchingRule adds a matching rule to the schema.
+func (s *Schema) AddMatchingRule(mr *MatchingRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range append([]string{mr.OID}, mr.Names...) {
+		if s.ruleByName[strings.ToLower(n)] != nil {
+			return fmt.Errorf("ldap: duplicate matching rule %s", n)
+		}
+	}
+	for _, n := range append([]string{mr.OID}, mr.Names...) {
+		s.ruleByName[strings.ToLower(n)] = mr
+	}
+	s.matchingRules = append(s.matchingRules, mr)
+	return nil
+}
+
+// AttributeType returns the attribute type with the given name or OID, or nil if not found.
+func (s *Schema) AttributeType(name string) *AttributeType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.attrByName[strings.ToLower(name)]
+}
+
+// ObjectClass returns the object class with the given name or OID, or nil if not found.
+func (s *Schema) ObjectClass(name string) *ObjectClass {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.classByName[strings.ToLower(name)]
+}
+
+// LDAPSyntax returns the syntax with the given OID, or nil if not found.
+func (s *Schema) LDAPSyntax(oid string) *LDAPSyntax {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.syntaxByOID[oid]
+}
+
+// MatchingRule returns the matching rule with the given name or OID, or nil if not found.
+func (s *Schema) MatchingRule(name string) *MatchingRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ruleByName[strings.ToLower(name)]
+}
+
+// AttributeTypes returns all attribute types in the order they were added.
+func (s *Schema) AttributeTypes() []*AttributeType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*AttributeType(nil), s.attributeTypes...)
+}
+
+// ObjectClasses returns all object classes in the order they were added.
+func (s *Schema) ObjectClasses() []*ObjectClass {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*ObjectClass(nil), s.objectClasses...)
+}
+
+// LDAPSyntaxes returns all syntaxes in the order they were added.
+func (s *Schema) LDAPSyntaxes() []*LDAPSyntax {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*LDAPSyntax(nil), s.ldapSyntaxes...)
+}
+
+// MatchingRules returns all matching rules in the order they were added.
+func (s *Schema) MatchingRules() []*MatchingRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*MatchingRule(nil), s.matchingRules...)
+}
+
+// AddDefinitions parses and adds attribute types (as "attributeTypes") and
+// object classes (as "objectClasses") in RFC 4512 form in the order given.
+func (s *Schema) AddDefinitions(attributeTypes, objectClasses []string) error {
+	for _, d := range attributeTypes {
+		at, err := ParseAttributeType(d)
+		if err != nil {
+			return err
+		}
+		if err := s.AddAttributeType(at); err != nil {
+			return err
+		}
+	}
+	for _, d := range objectClasses {
+		oc, err := ParseObjectClass(d)
+		if err != nil {
+			return err
+		}
+		if err := s.AddObjectClass(oc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entry returns the subschema subentry holding the definitions in the schema.
+func (s *Schema) Entry() *SearchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := &SearchResult{
+		DN: s.DN,
+		Attributes: map[string][][]byte{
+			"objectClass":    {[]byte("top"), []byte("subschema")},
+			"attributeTypes": make([][]byte, len(s.attributeTypes)),
+			"objectClasses":  make([][]byte, len(s.objectClasses)),
+			"ldapSyntaxes":   make([][]byte, len(s.ldapSyntaxes)),
+			"matchingRules":  make([][]byte, len(s.matchingRules)),
+		},
+	}
+	if dn, err := ParseDN(s.DN); err == nil && len(dn) != 0 {
+		for _, atv := range dn[0] {
+			if strings.EqualFold(atv.Type, "cn") {
+				r.Attributes["cn"] = append(r.Attributes["cn"], []byte(atv.Value))
+			}
+		}
+	}
+	for i, at := range s.attributeTypes {
+		r.Attributes["attributeTypes"][i] = []byte(at.String())
+	}
+	for i, oc := range s.objectClasses {
+		r.Attributes["objectClasses"][i] = []byte(oc.String())
+	}
+	for i, syn := range s.ldapSyntaxes {
+		r.Attributes["ldapSyntaxes"][i] = []byte(syn.String())
+	}
+	for i, mr := range s.matchingRules {
+		r.Attributes["matchingRules"][i] = []byte(mr.String())
+	}
+	return r
+}
+
+// ParseAttributeType parses an attribute type description (RFC 4512 section 4.1.2).
+func ParseAttributeType(def string) (*AttributeType, error) {
+	d, err := parseSchemaDescription(def)
+	if err != nil {
+		return nil, err
+	}
+	at := &AttributeType{OID: d.oid}
+	for _, f := range d.fields {
+		switch f.name {
+		case "NAME":
+			at.Names = f.values
+		case "DESC":
+			at.Description, err = f.single(def)
+		case "OBSOLETE":
+			at.Obsolete = true
+		case "SUP":
+			at.SuperType, err = f.single(def)
+		case "EQUALITY":
+			at.Equality, err = f.single(def)
+		case "ORDERING":
+			at.Ordering, err = f.single(def)
+		case "SUBSTR":
+			at.Substr, err = f.single(def)
+		case "SYNTAX":
+			var syn string
+			syn, err = f.single(def)
+			if i := strings.IndexByte(syn, '{'); i > 0 && strings.HasSuffix(syn, "}") {
+				at.SyntaxLength, err = strconv.Atoi(syn[i+1 : len(syn)-1])
+				syn = syn[:i]
+			}
+			at.Syntax = syn
+		case "SINGLE-VALUE":
+			at.SingleValue = true
+		case "COLLECTIVE":
+			at.Collective = true
+		case "NO-USER-MODIFICATION":
+			at.NoUserModification = true
+		case "USAGE":
+			var usage string
+			usage, err = f.single(def)
+			found := false
+			for u, s := range AttributeUsageMap {
+				if strings.EqualFold(s, usage) {
+					at.Usage = u
+					found = true
+				}
+			}
+			if err == nil && !found {
+				err = &SchemaSyntaxError{Definition: def, Msg: "unknown usage " + usage}
+			}
+		default:
+			if !f.extension() {
+				err = &SchemaSyntaxError{Definition: def, Msg: "unexpected " + f.name}
+			} else {
+				at.Extensions = addSchemaExtension(at.Extensions, f)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return at, nil
+}
+
+// ParseObjectClass parses an object class description (RFC 4512 section 4.1.1).
+func ParseObjectClass(def string) (*ObjectClass, error) {
+	d, err := parseSchemaDescription(def)
+	if err != nil {
+		return nil, err
+	}
+	oc := &ObjectClass{OID: d.oid}
+	for _, f := range d.fields {
+		switch f.name {
+		case "NAME":
+			oc.Names = f.values
+		case "DESC":
+			oc.Description, err = f.single(def)
+		case "OBSOLETE":
+			oc.Obsolete = true
+		case "SUP":
+			oc.SuperClasses = f.values
+		case "ABSTRACT":
+			oc.Kind = Abstract
+		case "STRUCTURAL":
+			oc.Kind = Structural
+		case "AUXILIARY":
+			oc.Kind = Auxiliary
+		case "MUST":
+			oc.Must = f.values
+		case "MAY":
+			oc.May = f.values
+		default:
+			if !f.extension() {
+				err = &SchemaSyntaxError{Definition: def, Msg: "unexpected " + f.name}
+			} else {
+				oc.Extensions = addSchemaExtension(oc.Extensions, f)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return oc, nil
+}
+
+// ParseLDAPSyntax parses an LDAP syntax description (RFC 4512 section 4.1.5).
+func ParseLDAPSyntax(def string) (*LDAPSyntax, error) {
+	d, err := parseSchemaDescription(def)
+	if err != nil {
+		return nil, err
+	}
+	syn := &LDAPSyntax{OID: d.oid}
+	for _, f := range d.fields {
+		switch f.name {
+		case "DESC":
+			syn.Description, err = f.single(def)
+		default:
+			if !f.extension() {
+				err = &SchemaSyntaxError{Definition: def, Msg: "unexpected " + f.name}
+			} else {
+				syn.Extensions = addSchemaExtension(syn.Extensions, f)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return syn, nil
+}
+
+// ParseMatchingRule parses a matching rule description (RFC 4512 section 4.1.3).
+func ParseMatchingRule(def string) (*MatchingRule, error) {
+	d, err := parseSchemaDescription(def)
+	if err != nil {
+		return nil, err
+	}
+	mr := &MatchingRule{OID: d.oid}
+	for _, f := range d.fields {
+		switch f.name {
+		case "NAME":
+			mr.Names = f.values
+		case "DESC":
+			mr.Description, err = f.single(def)
+		case "OBSOLETE":
+			mr.Obsolete = true
+		case "SYNTAX":
+			mr.Syntax, err = f.single(def)
+		default:
+			if !f.extension() {
+				err = &SchemaSyntaxError{Definition: def, Msg: "unexpected " + f.name}
+			} else {
+				mr.Extensions = addSchemaExtension(mr.Extensions, f)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if mr.Syntax == "" {
+		return nil, &SchemaSyntaxError{Definition: def, Msg: "missing SYNTAX"}
+	}
+	return mr, nil
+}
+
+type schemaField struct {
+	name   string
+	values []string
+}
+
+func (f schemaField) single(def string) (string, error) {
+	if len(f.values) != 1 {
+		return "", &SchemaSyntaxError{Definition: def, Msg: "expected a single value for " + f.name}
+	}
+	return f.values[0], nil
+}
+
+func (f schemaField) extension() bool {
+	return strings.HasPrefix(f.name, "X-")
+}
+
+func addSchemaExtension(ext map[string][]string, f schemaField) map[string][]string {
+	if ext == nil {
+		ext = make(map[string][]string)
+	}
+	ext[f.name] = f.values
+	return ext
+}
+
+type schemaDescription struct {
+	oid    string
+	fields []schemaField
+}
+
+// Keywords that do not take a value.
+var schemaFlags = map[string]bool{
+	"OBSOLETE":             true,
+	"SINGLE-VALUE":         true,
+	"COLLECTIVE":           true,
+	"NO-USER-MODIFICATION": true,
+	"ABSTRACT":             true,
+	"STRUCTURAL":           true,
+	"AUXILIARY":            true,
+}
+
+type schemaToken struct {
+	s      string
+	quoted bool
+}
+
+func tokenizeSchema(def string) ([]schemaToken, error) {
+	var toks []schemaToken
+	for i := 0; i < len(def); {
+		switch c := def[i]; c {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '(', ')', '$':
+			toks = append(toks, schemaToken{s: def[i : i+1]})
+			i++
+		case '\'':
+			j := strings.IndexByte(def[i+1:], '\'')
+			if j < 0 {
+				return nil, &SchemaSyntaxError{Definition: def, Msg: "unterminated quoted string"}
+			}
+			s := def[i+1 : i+1+j]
+			s = strings.NewReplacer(`\27`, `'`, `\5C`, `\`, `\5c`, `\`).Replace(s)
+			toks = append(toks, schemaToken{s: s, quoted: true})
+			i += j + 2
+		default:
+			j := i
+			for j < len(def) && !strings.ContainsRune(" \t\n\r()$'", rune(def[j])) {
+				j++
+			}
+			toks = append(toks, schemaToken{s: def[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+func parseSchemaDescription(def string) (*schemaDescription, error) {
+	toks, err := tokenizeSchema(def)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) < 3 || toks[0].s != "(" || toks[len(toks)-1].s != ")" {
+		return nil, &SchemaSyntaxError{Definition: def, Msg: "description must be enclosed in parentheses"}
+	}
+	toks = toks[1 : len(toks)-1]
+	if toks[0].quoted || toks[0].s == "(" {
+		return nil, &SchemaSyntaxError{Definition: def, Msg: "missing numeric OID"}
+	}
+	d := &schemaDescription{oid: toks[0].s}
+	for i := 1; i < len(toks); {
+		t := toks[i]
+		if t.quoted || t.s == "(" || t.s == ")" || t.s == "$" {
+			return nil, &SchemaSyntaxError{Definition: def, Msg: "expected keyword"}
+		}
+		i++
+		f := schemaField{name: strings.ToUpper(t.s)}
+		if !schemaFlags[f.name] {
+			if i == len(toks) {
+				return nil, &SchemaSyntaxError{Definition: def, Msg: "missing value for " + f.name}
+			}
+			if toks[i].s == "(" && !toks[i].quoted {
+				i++
+				for ; i < len(toks) && (toks[i].quoted || toks[i].s != ")"); i++ {
+					if toks[i].s != "$" || toks[i].quoted {
+						f.values = append(f.values, toks[i].s)
+					}
+				}
+				if i == len(toks) {
+					return nil, &SchemaSyntaxError{Definition: def, Msg: "unterminated list for " + f.name}
+				}
+			} else {
+				f.values = []string{toks[i].s}
+			}
+			i++
+		}
+		d.fields = append(d.fields, f)
+	}
+	return d, nil
+}
+
+type schemaBuilder struct {
+	b strings.Builder
+}
+
+func (b *schemaBuilder) start(oid string) {
+	b.b.WriteString("( ")
+	b.b.WriteString(oid)
+}
+
+func (b *schemaBuilder) end() string {
+	b.b.WriteString(" )")
+	return b.b.String()
+}
+
+func (b *schemaBuilder) flag(name string, set bool) {
+	if set {
+		b.b.WriteByte(' ')
+		b.b.WriteString(name)
+	}
+}
+
+func (b *schemaBuilder) word(name, value string) {
+	if value != "" {
+		b.b.WriteByte(' ')
+		b.b.WriteString(name)
+		b.b.WriteByte(' ')
+		b.b.WriteString(value)
+	}
+}
+
+func quoteSchemaString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\5C`, `'`, `\27`).Replace(s) + "'"
+}
+
+func (b *schemaBuilder) qdstring(name, value string) {
+	if value != "" {
+		b.word(name, quoteSchemaString(value))
+	}
+}
+
+func (b *schemaBuilder) qdescrs(name string, values []string) {
+	switch len(values) {
+	case 0:
+	case 1:
+		b.word(name, quoteSchemaString(values[0]))
+	default:
+		q := make([]string, len(values))
+		for i, v := range values {
+			q[i] = quoteSchemaString(v)
+		}
+		b.word(name, "( "+strings.Join(q, " ")+" )")
+	}
+}
+
+func (b *schemaBuilder) oids(name string, values []string) {
+	switch len(values) {
+	case 0:
+	case 1:
+		b.word(name, values[0])
+	default:
+		b.word(name, "( "+strings.Join(values, " $ ")+" )")
+	}
+}
+
+func (b *schemaBuilder) extensions(ext map[string][]string) {
+	names := make([]string, 0, len(ext))
+	for n := range ext {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		b.qdescrs(n, ext[n])
+	}
+}
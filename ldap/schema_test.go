@@ -0,0 +1,83 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchemaDefinitionRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, d := range coreAttributeTypes {
+		at, err := ParseAttributeType(d)
+		if err != nil {
+			t.Fatalf("Failed to parse '%s': %s", d, err)
+		}
+		if s := at.String(); s != d {
+			t.Errorf("Parse attribute type '%s' != '%s'", d, s)
+		}
+	}
+	for _, d := range coreObjectClasses {
+		oc, err := ParseObjectClass(d)
+		if err != nil {
+			t.Fatalf("Failed to parse '%s': %s", d, err)
+		}
+		if s := oc.String(); s != d {
+			t.Errorf("Parse object class '%s' != '%s'", d, s)
+		}
+	}
+	for _, mr := range builtinMatchingRules {
+		d := mr.String()
+		mr2, err := ParseMatchingRule(d)
+		if err != nil {
+			t.Fatalf("Failed to parse '%s': %s", d, err)
+		}
+		if s := mr2.String(); s != d {
+			t.Errorf("Parse matching rule '%s' != '%s'", d, s)
+		}
+	}
+}
+
+func TestParseAttributeType(t *testing.T) {
+	t.Parallel()
+	at, err := ParseAttributeType("( 1.2.3 NAME ( 'foo' 'bar' ) DESC 'it\\27s quoted' SUP name SYNTAX 1.3.6.1.4.1.1466.115.121.1.15{32} X-ORIGIN 'test' )")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at.OID != "1.2.3" || len(at.Names) != 2 || at.Names[1] != "bar" || at.SuperType != "name" {
+		t.Errorf("Unexpected attribute type %+v", at)
+	}
+	if at.Description != "it's quoted" {
+		t.Errorf("Expected unescaped description, got %q", at.Description)
+	}
+	if at.SyntaxLength != 32 || at.Syntax != SyntaxDirectoryString {
+		t.Errorf("Expected syntax with length 32, got %s{%d}", at.Syntax, at.SyntaxLength)
+	}
+	if v := at.Extensions["X-ORIGIN"]; len(v) != 1 || v[0] != "test" {
+		t.Errorf("Expected X-ORIGIN extension, got %+v", at.Extensions)
+	}
+	if _, err := ParseAttributeType("( 1.2.3 NAME 'foo' BOGUS )"); err == nil {
+		t.Error("Expected error for unknown keyword")
+	}
+	if s := DefaultSchema.AttributeType("commonName"); s == nil || s.OID != "2.5.4.3" {
+		t.Errorf("Expected lookup by alias to return cn, got %+v", s)
+	}
+}
+
+func TestSchemaEntryCN(t *testing.T) {
+	t.Parallel()
+	for dn, want := range map[string][]string{
+		"cn=Subschema":                          {"Subschema"},
+		`cn=Sub\,schema,o=Example`:              {"Sub,schema"},
+		"cn=Subschema+cn=Schema,o=Example":      {"Subschema", "Schema"},
+		"ou=Subschema+cn=Schema,cn=x,o=Example": {"Schema"},
+		"ou=Subschema,cn=x":                     nil,
+	} {
+		var got []string
+		for _, v := range NewSchema(dn).Entry().Attributes["cn"] {
+			got = append(got, string(v))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected cn %q got %q", dn, want, got)
+		}
+	}
+}
@@ -1,9 +1,8 @@
 package ldap
 
 import (
-	"encoding/base64"
-	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"unicode/utf8"
 )
@@ -54,13 +53,6 @@ func (d DerefAliases) String() string {
 	return strconv.Itoa(int(d))
 }
 
-type ExtensibleMatch struct {
-	MatchingRule string // optional
-	Attribute    string
-	Value        string
-	DNAttributes bool
-}
-
 type SearchRequest struct {
 	BaseDN       string
 	Scope        Scope
@@ -68,13 +60,19 @@ type SearchRequest struct {
 	SizeLimit    int
 	TimeLimit    int
 	TypesOnly    bool
-	Filter       Filter
-	Attributes   map[string]bool
+	// Filter selects the entries to return. If nil then "(objectClass=*)"
+	// is sent since not all servers support the absolute true filter "(&)".
+	Filter     Filter
+	Attributes map[string]bool
 }
 
 type SearchResult struct {
 	DN         string
 	Attributes map[string][][]byte
+
+	// order is the order of the attributes as received. Attributes that
+	// aren't in it are encoded after the ones that are sorted by name.
+	order []string
 }
 
 func IsPrintable(v []byte) bool {
@@ -88,45 +86,35 @@ func IsPrintable(v []byte) bool {
 	return true
 }
 
+// ToLDIF writes the result as an LDIF content record without a version
+// line. Use LDIFWriter to write multiple records.
 func (r *SearchResult) ToLDIF(w io.Writer) error {
-	if _, err := fmt.Fprintf(w, "dn: %s\n", r.DN); err != nil {
-		return err
-	}
-	for name, values := range r.Attributes {
-		for _, v := range values {
-			if IsPrintable(v) {
-				if _, err := fmt.Fprintf(w, "%s: %s\n", name, string(v)); err != nil {
-					return err
-				}
-			} else {
-				if _, err := fmt.Fprintf(w, "%s:: %s\n", name, base64.StdEncoding.EncodeToString(v)); err != nil {
-					return err
-				}
-			}
-		}
-	}
-	return nil
+	lw := NewLDIFWriter(w)
+	lw.OmitVersion = true
+	return lw.WriteEntry(r)
 }
 
 type SearchResponse struct {
 	BaseResponse
 	Results []*SearchResult
+	// References are continuation references to other servers (RFC 4511
+	// section 4.5.3). Each is a list of URIs. They're written after the
+	// results.
+	References [][]string
 }
 
 func (r *SearchResponse) WritePackets(w io.Writer, msgID int) error {
 	top := NewResponsePacket(msgID)
 	for _, res := range r.Results {
+		if err := res.writePacket(w, top); err != nil {
+			return err
+		}
+	}
+	for _, ref := range r.References {
 		top.Items = top.Items[:1]
-		pkt := top.AddItem(NewPacket(ClassApplication, false, ApplicationSearchResultEntry, nil))
-		pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, res.DN))
-		attrPkt := pkt.AddItem(NewPacket(ClassUniversal, false, TagSequence, nil))
-		for name, vals := range res.Attributes {
-			p := attrPkt.AddItem(NewPacket(ClassUniversal, false, TagSequence, nil))
-			p.AddItem(NewPacket(ClassUniversal, true, TagOctetString, name))
-			valsPkt := p.AddItem(NewPacket(ClassUniversal, false, TagSet, nil))
-			for _, v := range vals {
-				valsPkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, v))
-			}
+		pkt := top.AddItem(NewPacket(ClassApplication, false, ApplicationSearchResultReference, nil))
+		for _, uri := range ref {
+			pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, uri))
 		}
 		if err := top.Write(w); err != nil {
 			return err
@@ -135,13 +123,32 @@ func (r *SearchResponse) WritePackets(w io.Writer, msgID int) error {
 	top.Items = top.Items[:1]
 	pkt := top.AddItem(r.BaseResponse.NewPacket())
 	pkt.Tag = ApplicationSearchResultDone
-	if len(r.Results) == 0 && r.BaseResponse.Code == ResultSuccess {
-		r.BaseResponse.Code = ResultNoSuchObject
+	return top.Write(w)
+}
+
+// writePacket writes the result as a search result entry in the response
+// packet top.
+func (r *SearchResult) writePacket(w io.Writer, top *Packet) error {
+	top.Items = top.Items[:1]
+	pkt := top.AddItem(NewPacket(ClassApplication, false, ApplicationSearchResultEntry, nil))
+	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.DN))
+	attrPkt := pkt.AddItem(NewPacket(ClassUniversal, false, TagSequence, nil))
+	for _, name := range orderedNames(r.Attributes, r.order) {
+		p := attrPkt.AddItem(NewPacket(ClassUniversal, false, TagSequence, nil))
+		p.AddItem(NewPacket(ClassUniversal, true, TagOctetString, name))
+		valsPkt := p.AddItem(NewPacket(ClassUniversal, false, TagSet, nil))
+		for _, v := range r.Attributes[name] {
+			valsPkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, v))
+		}
 	}
 	return top.Write(w)
 }
 
 func (r *SearchRequest) WritePackets(w io.Writer, msgID int) error {
+	return r.writePackets(w, msgID, nil)
+}
+
+func (r *SearchRequest) writePackets(w io.Writer, msgID int, controls []*Control) error {
 	pkt := NewPacket(ClassApplication, false, ApplicationSearchRequest, nil)
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.BaseDN))
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagEnumerated, int(r.Scope)))
@@ -149,22 +156,28 @@ func (r *SearchRequest) WritePackets(w io.Writer, msgID int) error {
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagInteger, r.SizeLimit))
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagInteger, r.TimeLimit))
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagBoolean, r.TypesOnly))
-	if r.Filter == nil {
-		r.Filter = &Present{Attribute: "objectClass"}
+	filter := r.Filter
+	if filter == nil {
+		filter = &Present{Attribute: "objectClass"}
 	}
-	p, err := r.Filter.Encode()
+	p, err := filter.Encode()
 	if err != nil {
 		return err
 	}
 	pkt.AddItem(p)
 	p = pkt.AddItem(NewPacket(ClassUniversal, false, TagSequence, nil))
+	attrs := make([]string, 0, len(r.Attributes))
 	for a := range r.Attributes {
+		attrs = append(attrs, a)
+	}
+	sort.Strings(attrs)
+	for _, a := range attrs {
 		p.AddItem(NewPacket(ClassUniversal, true, TagOctetString, a))
 	}
 
 	req := NewRequestPacket(msgID)
 	req.AddItem(pkt)
-	return req.Write(w)
+	return writeRequest(w, req, controls)
 }
 
 func parseSearchRequest(pkt *Packet) (*SearchRequest, error) {
@@ -206,7 +219,9 @@ func parseSearchRequest(pkt *Packet) (*SearchRequest, error) {
 		if !ok {
 			return nil, &ProtocolError{Reason: "can't parse attribute from list for search request"}
 		}
-		req.Attributes[s] = true // TODO: should we lower case these? [strings.ToLower(s)] = true
+		// Names are kept as sent. Server matches them case-insensitively
+		// when selecting the attributes of results.
+		req.Attributes[s] = true
 	}
 	return req, nil
 }
@@ -230,13 +245,19 @@ func parseSearchResultResponse(pkt *Packet) (*SearchResult, error) {
 		if !ok {
 			return nil, &ProtocolError{Reason: "failed to parse attribute name in search result response"}
 		}
+		// Attributes have no values when only types are requested.
+		values := make([][]byte, 0, len(p.Items[1].Items))
 		for _, p2 := range p.Items[1].Items {
 			value, ok := p2.Bytes()
 			if !ok {
 				return nil, &ProtocolError{Reason: "failed to parse attribute value in search result response"}
 			}
-			res.Attributes[name] = append(res.Attributes[name], value)
+			values = append(values, value)
+		}
+		if _, ok := res.Attributes[name]; !ok {
+			res.order = append(res.order, name)
 		}
+		res.Attributes[name] = append(res.Attributes[name], values...)
 	}
 	return res, nil
 }
@@ -28,7 +28,9 @@ type BaseResponse struct {
 	Code        ResultCode
 	MatchedDN   string
 	Message     string
-	// TODO Referral
+	// Referral is a list of URIs of servers to send the request to. It
+	// must be set if Code is ResultReferral.
+	Referral []string
 }
 
 func (r *BaseResponse) Error() string {
@@ -53,6 +55,12 @@ func (r *BaseResponse) NewPacket() *Packet {
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagEnumerated, int(r.Code)))
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.MatchedDN))
 	pkt.AddItem(NewPacket(ClassUniversal, true, TagOctetString, r.Message))
+	if len(r.Referral) != 0 {
+		p := pkt.AddItem(NewPacket(ClassContext, false, 3, nil))
+		for _, uri := range r.Referral {
+			p.AddItem(NewPacket(ClassUniversal, true, TagOctetString, uri))
+		}
+	}
 	return pkt
 }
 
@@ -73,12 +81,27 @@ func parseBaseResponse(pkt *Packet, res *BaseResponse) error {
 	if !ok {
 		return &ProtocolError{Reason: "invalid message in response"}
 	}
+	if len(pkt.Items) > 3 && pkt.Items[3].Class == ClassContext && pkt.Items[3].Tag == 3 {
+		res.Referral = nil
+		for _, it := range pkt.Items[3].Items {
+			uri, ok := it.Str()
+			if !ok {
+				return &ProtocolError{Reason: "invalid referral in response"}
+			}
+			res.Referral = append(res.Referral, uri)
+		}
+	}
 	return nil
 }
 
 type Server struct {
 	Backend Backend
 	RootDSE map[string][]string
+	// Schema is published as the subschema subentry. It is referenced by
+	// the subschemaSubentry attribute of the root DSE. If nil then no schema
+	// is published. It's also used to select the attributes of search
+	// results in which case DefaultSchema is used if nil.
+	Schema *Schema
 
 	tlsConfig *tls.Config
 	// processingTimeout is how long to allow for the execution of a request.
@@ -107,6 +130,7 @@ func NewServer(be Backend, tlsConfig *tls.Config) (*Server, error) {
 	return &Server{
 		Backend:           be,
 		RootDSE:           sf,
+		Schema:            DefaultSchema,
 		tlsConfig:         tlsConfig,
 		processingTimeout: time.Second * 10,
 		responseTimeout:   time.Second * 5,
@@ -135,10 +159,19 @@ func (srv *Server) Serve(network, addr string) error {
 	return srv.serve(ln)
 }
 
+// ServeListener accepts connections on an existing listener. It returns
+// when the listener is closed.
+func (srv *Server) ServeListener(ln net.Listener) error {
+	return srv.serve(ln)
+}
+
 func (srv *Server) serve(ln net.Listener) error {
 	for {
 		cn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
 			log.Printf("Accept failed: %+v", err)
 			continue
 		}
@@ -196,22 +229,42 @@ func (cli *srvClient) serve() {
 			return
 		}
 
-		// TODO: parse rest of packet: control
-		// https://ldapwiki.com/wiki/SupportedControl
-		// 1.2.840.113556.1.4.319
-		//   https://ldapwiki.com/wiki/Simple%20Paged%20Results%20Control
-		//   https://oidref.com/1.2.840.113556.1.4.319
+		reqCtx := ctx
+		err = nil
+		if len(pkt.Items) > 2 && pkt.Items[2].Class == ClassContext && pkt.Items[2].Tag == 0 {
+			var controls []*Control
+			if controls, err = parseControls(pkt.Items[2]); err == nil {
+				err = cli.checkControls(controls)
+				reqCtx = withRequestControls(ctx, controls)
+			}
+		}
+		if err == nil {
+			err = cli.processRequest(reqCtx, msgID, pkt.Items[1])
+		}
 
-		if err := cli.processRequest(ctx, msgID, pkt.Items[1]); err != nil {
+		if err != nil {
 			end := true
 			if !errors.Is(err, io.EOF) {
-				log.Printf("[%s] Processing of request failed: %s", cli.remoteAddr, err)
+				if _, ok := errorAsType[*BaseResponse](err); !ok {
+					log.Printf("[%s] Processing of request failed: %s", cli.remoteAddr, err)
+				}
 				res := &BaseResponse{
 					MessageType: pkt.Items[1].Tag + 1,
 					Code:        ResultOther,
 					Message:     "ERROR",
 				}
-				if e, ok := errorAsType[*ProtocolError](err); ok {
+				if pkt.Items[1].Tag == ApplicationSearchRequest {
+					res.MessageType = ApplicationSearchResultDone
+				}
+				if e, ok := errorAsType[*BaseResponse](err); ok {
+					// Backends return a result as an error for operations
+					// such as PasswordModify that only return a value.
+					res.Code = e.Code
+					res.MatchedDN = e.MatchedDN
+					res.Message = e.Message
+					res.Referral = e.Referral
+					end = false
+				} else if e, ok := errorAsType[*ProtocolError](err); ok {
 					res.Code = ResultProtocolError
 					res.Message = e.Reason
 					end = false
@@ -241,6 +294,23 @@ func (cli *srvClient) serve() {
 	}
 }
 
+// streamSearch performs a search on a backend that streams its results,
+// writing each entry as it's received. The returned response holds the
+// references and result that follow the entries.
+func (cli *srvClient) streamSearch(ctx context.Context, ss searchStreamer, msgID int, req *SearchRequest) (*SearchResponse, error) {
+	sel := newAttributeSelection(cli.srv.attributeSchema(), req.Attributes)
+	top := NewResponsePacket(msgID)
+	return ss.SearchFunc(ctx, cli.state, req, func(r *SearchResult) error {
+		if err := cli.cn.SetWriteDeadline(time.Now().Add(cli.srv.responseTimeout)); err != nil {
+			return fmt.Errorf("failed to set deadline for write: %w", err)
+		}
+		if err := sel.apply(r, req.TypesOnly).writePacket(cli.wr, top); err != nil {
+			return err
+		}
+		return cli.wr.Flush()
+	})
+}
+
 // return an error when the client connection should be closed
 func (cli *srvClient) processRequest(ctx context.Context, msgID int, pkt *Packet) error {
 	ctx, cancel := context.WithTimeout(ctx, cli.srv.processingTimeout)
@@ -269,14 +339,31 @@ func (cli *srvClient) processRequest(ctx context.Context, msgID int, pkt *Packet
 		if err != nil {
 			return err
 		}
-		if req.BaseDN == "" && req.Scope == ScopeBaseObject { // TODO check filter
+		switch {
+		case req.BaseDN == "" && req.Scope == ScopeBaseObject:
 			res, err = cli.rootDSE(req)
-		} else {
-			res, err = cli.srv.Backend.Search(ctx, cli.state, req)
+		case cli.srv.Schema != nil && req.Scope == ScopeBaseObject && EqualDN(req.BaseDN, cli.srv.Schema.DN):
+			res, err = cli.subschema(req)
+		default:
+			if ss, ok := cli.srv.Backend.(searchStreamer); ok {
+				res, err = cli.streamSearch(ctx, ss, msgID, req)
+			} else {
+				res, err = cli.srv.Backend.Search(ctx, cli.state, req)
+			}
 		}
 		if err != nil {
 			return err
 		}
+		if sr, ok := res.(*SearchResponse); ok && sr != nil {
+			// Build a new response since the backend may return results it
+			// keeps a reference to.
+			sel := newAttributeSelection(cli.srv.attributeSchema(), req.Attributes)
+			results := make([]*SearchResult, len(sr.Results))
+			for i, r := range sr.Results {
+				results[i] = sel.apply(r, req.TypesOnly)
+			}
+			res = &SearchResponse{BaseResponse: sr.BaseResponse, Results: results, References: sr.References}
+		}
 	case ApplicationAddRequest:
 		req, err := parseAddRequest(pkt)
 		if err != nil {
@@ -313,6 +400,15 @@ func (cli *srvClient) processRequest(ctx context.Context, msgID int, pkt *Packet
 		if err != nil {
 			return err
 		}
+	case ApplicationCompareRequest:
+		req, err := parseCompareRequest(pkt)
+		if err != nil {
+			return err
+		}
+		res, err = compare(ctx, cli.srv.Backend, cli.state, req)
+		if err != nil {
+			return err
+		}
 	case ApplicationExtendedRequest:
 		req, err := parseExtendedRequest(pkt)
 		if err != nil {
@@ -403,20 +499,140 @@ func (cli *srvClient) processRequest(ctx context.Context, msgID int, pkt *Packet
 	return cli.wr.Flush()
 }
 
-func (cli *srvClient) rootDSE(req *SearchRequest) (*SearchResponse, error) {
-	r := &SearchResult{DN: "", Attributes: make(map[string][][]byte)}
-	res := &SearchResponse{Results: []*SearchResult{r}}
-	if len(req.Attributes) == 0 {
-		r.Attributes["objectClass"] = [][]byte{[]byte("top")}
-		return res, nil
+// checkControls returns a result for the first critical control the
+// backend doesn't support.
+func (cli *srvClient) checkControls(controls []*Control) error {
+	cs, _ := cli.srv.Backend.(controlSupporter)
+	for _, c := range controls {
+		if c.Criticality && (cs == nil || !cs.SupportsControl(c.Type)) {
+			return &BaseResponse{Code: ResultUnavailableCriticalExtension, Message: "unsupported critical control " + c.Type}
+		}
 	}
+	return nil
+}
+
+func (cli *srvClient) rootDSE(req *SearchRequest) (*SearchResponse, error) {
+	e := &SearchResult{DN: "", Attributes: map[string][][]byte{"objectClass": {[]byte("top")}}}
 	for name, vals := range cli.srv.RootDSE {
-		if req.Attributes["+"] || req.Attributes[strings.ToLower(name)] {
-			r.Attributes[name] = make([][]byte, len(vals))
-			for i, v := range vals {
-				r.Attributes[name][i] = []byte(v)
+		e.Attributes[name] = make([][]byte, len(vals))
+		for i, v := range vals {
+			e.Attributes[name][i] = []byte(v)
+		}
+	}
+	if nc, ok := cli.srv.Backend.(namingContexter); ok && cli.srv.RootDSE["namingContexts"] == nil {
+		for _, name := range nc.NamingContexts() {
+			e.Attributes["namingContexts"] = append(e.Attributes["namingContexts"], []byte(name))
+		}
+	}
+	if s := cli.srv.Schema; s != nil && cli.srv.RootDSE["subschemaSubentry"] == nil {
+		e.Attributes["subschemaSubentry"] = [][]byte{[]byte(s.DN)}
+	}
+	if req.Filter != nil && MatchFilter(req.Filter, e, cli.srv.Schema) != FilterTrue {
+		return &SearchResponse{}, nil
+	}
+	return &SearchResponse{Results: []*SearchResult{e}}, nil
+}
+
+// subschema returns the subschema subentry. The schema attributes are
+// operational so they're only returned when requested by name or when all
+// operational attributes are requested with "+".
+func (cli *srvClient) subschema(req *SearchRequest) (*SearchResponse, error) {
+	e := cli.srv.Schema.Entry()
+	if req.Filter != nil && MatchFilter(req.Filter, e, cli.srv.Schema) != FilterTrue {
+		return &SearchResponse{}, nil
+	}
+	return &SearchResponse{Results: []*SearchResult{e}}, nil
+}
+
+// attributeSchema returns the schema used to select attributes.
+func (srv *Server) attributeSchema() *Schema {
+	if srv.Schema != nil {
+		return srv.Schema
+	}
+	return DefaultSchema
+}
+
+// attributeSelection implements the rules for which attributes of an entry
+// are returned by a search (RFC 4511 section 4.5.1.8). An empty list or "*"
+// selects all user attributes, "+" selects all operational attributes
+// (RFC 3673), "1.1" alone selects no attributes, and "@class" selects the
+// attributes allowed by an object class (RFC 4529). Requested attribute
+// types also select their subtypes and any of their names.
+type attributeSelection struct {
+	schema      *Schema
+	user        bool
+	operational bool
+	selectors   []*attributeSelector
+}
+
+func newAttributeSelection(s *Schema, attrs map[string]bool) *attributeSelection {
+	sel := &attributeSelection{schema: s, user: len(attrs) == 0}
+	add := func(name string) {
+		as, ok := newAttributeSelector(s, name)
+		if !ok {
+			// Attributes not in the schema can still be returned by the
+			// backend so match them by name.
+			as, _ = newAttributeSelector(nil, name)
+		}
+		sel.selectors = append(sel.selectors, as)
+	}
+	for a := range attrs {
+		switch {
+		case a == "*":
+			sel.user = true
+		case a == "+":
+			sel.operational = true
+		case a == "1.1":
+		case strings.HasPrefix(a, "@"):
+			if s == nil {
+				continue
 			}
+			if oc := s.ObjectClass(a[1:]); oc != nil {
+				for _, name := range s.classAttributes(oc) {
+					add(name)
+				}
+			}
+		default:
+			add(a)
+		}
+	}
+	return sel
+}
+
+// includes returns true if the named attribute is selected.
+func (sel *attributeSelection) includes(name string) bool {
+	operational := false
+	if sel.schema != nil {
+		typ, _, _ := strings.Cut(name, ";")
+		if at := sel.schema.AttributeType(typ); at != nil {
+			operational = at.Operational()
+		}
+	}
+	if operational && sel.operational || !operational && sel.user {
+		return true
+	}
+	for _, as := range sel.selectors {
+		if as.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns a copy of the result with only the selected attributes.
+// If typesOnly is true then the values of the attributes are omitted.
+func (sel *attributeSelection) apply(r *SearchResult, typesOnly bool) *SearchResult {
+	out := &SearchResult{DN: r.DN, Attributes: make(map[string][][]byte, len(r.Attributes))}
+	for _, name := range orderedNames(r.Attributes, r.order) {
+		if !sel.includes(name) {
+			continue
+		}
+		vals := r.Attributes[name]
+		if typesOnly {
+			vals = [][]byte{}
 		}
+		out.Attributes[name] = vals
+		out.order = append(out.order, name)
 	}
-	return res, nil
+	return out
 }
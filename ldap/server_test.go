@@ -0,0 +1,203 @@
+package ldap
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type searchBackend struct {
+	Backend
+	results []*SearchResult
+}
+
+func (be *searchBackend) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	return &SearchResponse{Results: be.results}, nil
+}
+
+// newTestServer starts a server for the backend and returns a connected client.
+func newTestServer(t *testing.T, be Backend) (*Server, *Client) {
+	t.Helper()
+	srv, err := NewServer(be, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeListener(ln)
+	c, err := Dial("tcp", ln.Addr().String())
+	if err != nil {
+		ln.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		ln.Close()
+	})
+	return srv, c
+}
+
+func TestServerAttributeSelection(t *testing.T) {
+	t.Parallel()
+	be := &searchBackend{
+		Backend: DebugBackend,
+		results: []*SearchResult{{
+			DN: "uid=jdoe,dc=example,dc=com",
+			Attributes: map[string][][]byte{
+				"objectClass":     {[]byte("top"), []byte("person")},
+				"cn":              {[]byte("John Doe")},
+				"cn;lang-fr":      {[]byte("Jean")},
+				"sn":              {[]byte("Doe")},
+				"description":     {[]byte("test")},
+				"mail":            {[]byte("jdoe@example.com")},
+				"createTimestamp": {[]byte("20240102150405Z")},
+				"entryDN":         {[]byte("uid=jdoe,dc=example,dc=com")},
+				"customAttr":      {[]byte("x")},
+			},
+		}},
+	}
+	_, c := newTestServer(t, be)
+	cases := []struct {
+		attrs     []string
+		typesOnly bool
+		want      []string
+	}{
+		{nil, false, []string{"cn", "cn;lang-fr", "customAttr", "description", "mail", "objectClass", "sn"}},
+		{[]string{"*"}, false, []string{"cn", "cn;lang-fr", "customAttr", "description", "mail", "objectClass", "sn"}},
+		{[]string{"+"}, false, []string{"createTimestamp", "entryDN"}},
+		{[]string{"*", "+"}, false, []string{"cn", "cn;lang-fr", "createTimestamp", "customAttr", "description", "entryDN", "mail", "objectClass", "sn"}},
+		{[]string{"1.1"}, false, nil},
+		{[]string{"1.1", "SN"}, false, []string{"sn"}},
+		{[]string{"commonName"}, false, []string{"cn", "cn;lang-fr"}},
+		{[]string{"2.5.4.4", "CREATETIMESTAMP"}, false, []string{"createTimestamp", "sn"}},
+		{[]string{"name"}, false, []string{"cn", "cn;lang-fr", "sn"}},
+		{[]string{"customattr"}, false, []string{"customAttr"}},
+		{[]string{"cn;lang-fr"}, false, []string{"cn;lang-fr"}},
+		{[]string{"name;lang-"}, false, []string{"cn;lang-fr"}},
+		{[]string{"@person"}, false, []string{"cn", "cn;lang-fr", "description", "objectClass", "sn"}},
+		{[]string{"@unknownClass"}, false, nil},
+		{[]string{"sn", "mail"}, true, []string{"mail", "sn"}},
+	}
+	for _, tc := range cases {
+		req := &SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree, TypesOnly: tc.typesOnly}
+		if tc.attrs != nil {
+			req.Attributes = make(map[string]bool)
+			for _, a := range tc.attrs {
+				req.Attributes[a] = true
+			}
+		}
+		res, err := c.Search(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 1 {
+			t.Fatalf("Expected 1 result, got %d", len(res))
+		}
+		var names []string
+		for name, vals := range res[0].Attributes {
+			names = append(names, name)
+			if tc.typesOnly && len(vals) != 0 {
+				t.Errorf("%v: typesOnly returned values for %s", tc.attrs, name)
+			} else if !tc.typesOnly && len(vals) == 0 {
+				t.Errorf("%v: no values returned for %s", tc.attrs, name)
+			}
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tc.want) {
+			t.Errorf("%v: got attributes %v, want %v", tc.attrs, names, tc.want)
+		}
+	}
+	// The backend's results must not be modified.
+	if len(be.results[0].Attributes) != 9 {
+		t.Error("Backend results were modified")
+	}
+}
+
+func TestServerRootDSE(t *testing.T) {
+	t.Parallel()
+	_, c := newTestServer(t, DebugBackend)
+	res, err := c.Search(&SearchRequest{Scope: ScopeBaseObject, Attributes: map[string]bool{"+": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(res))
+	}
+	features := make(map[string]bool)
+	for _, f := range res[0].Attributes["supportedFeatures"] {
+		features[string(f)] = true
+	}
+	for _, oid := range []string{OIDAllOperationalAttributes, OIDAttributesByObjectClass, OIDTrueFalseFilters} {
+		if !features[oid] {
+			t.Errorf("Feature %s not advertised", oid)
+		}
+	}
+	if res[0].Attributes["objectClass"] != nil {
+		t.Error("objectClass returned for +")
+	}
+	if v := res[0].Attributes["subschemaSubentry"]; len(v) != 1 || string(v[0]) != DefaultSubschemaDN {
+		t.Errorf("subschemaSubentry = %q", v)
+	}
+}
+
+// rawRequest writes a request message as given.
+type rawRequest func(w io.Writer, msgID int) error
+
+func (r rawRequest) WritePackets(w io.Writer, msgID int) error {
+	return r(w, msgID)
+}
+
+type controlBackend struct {
+	*MemoryBackend
+}
+
+func (controlBackend) SupportsControl(oid string) bool {
+	return oid == "1.2.3"
+}
+
+func TestServerControls(t *testing.T) {
+	t.Parallel()
+	_, c := newTestServer(t, controlBackend{newTestMemoryBackend(t)})
+	search := func(controls ...*Control) error {
+		return c.searchFunc(&controlledRequest{Request: &SearchRequest{BaseDN: "dc=example,dc=com"}, controls: controls}, func(*SearchResult) error { return nil }, nil)
+	}
+	cases := []struct {
+		control *Control
+		code    ResultCode
+	}{
+		{&Control{Type: "1.2.3", Criticality: true}, ResultSuccess},
+		{&Control{Type: "1.2.4"}, ResultSuccess},
+		{&Control{Type: "1.2.4", Criticality: true}, ResultUnavailableCriticalExtension},
+	}
+	for _, tc := range cases {
+		if err := search(tc.control); resultCode(err) != tc.code {
+			t.Errorf("%+v: expected %s got %v", tc.control, tc.code, err)
+		}
+	}
+
+	// A malformed control fails the request but not the connection.
+	pkt, err := c.request(rawRequest(func(w io.Writer, msgID int) error {
+		req := NewRequestPacket(msgID)
+		req.AddItem(NewPacket(ClassApplication, true, ApplicationDelRequest, "uid=jdoe,ou=People,dc=example,dc=com"))
+		req.AddItem(NewPacket(ClassContext, false, 0, nil)).AddItem(NewPacket(ClassUniversal, false, TagSequence, nil))
+		return req.Write(w)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := parseDeleteResponse(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != ResultProtocolError {
+		t.Errorf("expected protocol error for a malformed control got %s", res.Code)
+	}
+	if err := search(); err != nil {
+		t.Errorf("search after malformed control failed: %v", err)
+	}
+}
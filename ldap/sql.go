@@ -0,0 +1,970 @@
+package ldap
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLTable maps the rows of a table to the entries immediately below a
+// base DN. The RDN of each entry is the value of the column mapped to
+// RDNAttribute, so the row with "jdoe" in the uid column of a table with
+// the base "ou=People,dc=example,dc=com" is the entry
+// "uid=jdoe,ou=People,dc=example,dc=com".
+type SQLTable struct {
+	// Name is the name of the table optionally qualified by a schema
+	// ("directory.people").
+	Name string
+	// Base is the DN of the superior of the entries. It and its superiors
+	// exist as search bases but are not returned by searches.
+	Base string
+	// RDNAttribute is the naming attribute of the entries. It must be one
+	// of the attributes in Columns and its column should be unique.
+	RDNAttribute string
+	// ObjectClasses are the values of the objectClass attribute of every
+	// entry. Entries with other object classes can't be added.
+	ObjectClasses []string
+	// Columns maps attribute names to column names. Each attribute has
+	// at most one value, NULL if it's absent.
+	Columns map[string]string
+}
+
+type sqlTable struct {
+	*SQLTable
+	base    DN
+	rdnKey  string            // normalized RDNAttribute
+	keyCol  string            // column of RDNAttribute
+	attrs   []string          // attribute names in Columns sorted
+	columns map[string]string // by normalized attribute name
+	classes map[string]bool   // normalized object classes
+}
+
+// SQLBackend is a Backend that maps the rows of SQL tables to entries (see
+// SQLTable). Search filters are translated into parameterized WHERE
+// clauses and the rows are read as they're returned. Filter items that
+// can't be expressed in SQL select all rows and each row is matched
+// against the whole filter before it's returned. Unlike a MemoryBackend,
+// the database compares values of attributes with caseIgnore and
+// caseExact rules as they're stored, after LOWER for caseIgnore, without
+// removing insignificant spaces or normalizing Unicode. Values that only
+// differ in those ways, such as "John Doe" for (cn=john  doe), aren't
+// found.
+//
+// Updates are performed in a transaction. Entries can only be renamed
+// within their table. Simple binds are checked against the column mapped
+// to userPassword (see checkPassword) and PasswordModify stores the new
+// password hashed.
+type SQLBackend struct {
+	DB *sql.DB
+	// NumberedPlaceholders selects "$1" style placeholders, as used by
+	// PostgreSQL, instead of "?".
+	NumberedPlaceholders bool
+	// SizeLimit is the maximum number of entries returned by a search. If 0
+	// only the limit of the request applies.
+	SizeLimit int
+
+	schema *Schema
+	tables []*sqlTable
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// NewSQLBackend returns a backend for the tables of the database. The
+// schema is used to match attribute names and to choose how values are
+// compared. Table and column names must be plain identifiers since they
+// are written into the queries without quoting.
+func NewSQLBackend(db *sql.DB, schema *Schema, tables ...SQLTable) (*SQLBackend, error) {
+	b := &SQLBackend{DB: db, schema: schema}
+	d := b.editor().differ()
+	for i := range tables {
+		cfg := tables[i]
+		if !sqlIdentifier.MatchString(cfg.Name) {
+			return nil, fmt.Errorf("ldap: invalid table name %q", cfg.Name)
+		}
+		base, err := ParseDN(cfg.Base)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: base of table %s: %w", cfg.Name, err)
+		}
+		t := &sqlTable{
+			SQLTable: &cfg,
+			base:     base,
+			rdnKey:   d.key(cfg.RDNAttribute),
+			columns:  make(map[string]string, len(cfg.Columns)),
+			classes:  make(map[string]bool, len(cfg.ObjectClasses)),
+		}
+		for attr, col := range cfg.Columns {
+			desc, err := ParseAttributeDescription(attr)
+			if err != nil {
+				return nil, fmt.Errorf("ldap: table %s: invalid attribute description %q", cfg.Name, attr)
+			}
+			if len(desc.Options) != 0 {
+				return nil, fmt.Errorf("ldap: table %s: attribute %q has options", cfg.Name, attr)
+			}
+			if !sqlIdentifier.MatchString(col) {
+				return nil, fmt.Errorf("ldap: table %s: invalid column name %q", cfg.Name, col)
+			}
+			key := d.key(attr)
+			if key == d.key("objectClass") {
+				return nil, fmt.Errorf("ldap: table %s: objectClass can't be mapped to a column", cfg.Name)
+			}
+			if _, ok := t.columns[key]; ok {
+				return nil, fmt.Errorf("ldap: table %s: attribute %q mapped twice", cfg.Name, attr)
+			}
+			t.columns[key] = col
+			t.attrs = append(t.attrs, attr)
+		}
+		sort.Strings(t.attrs)
+		if t.keyCol = t.columns[t.rdnKey]; t.keyCol == "" {
+			return nil, fmt.Errorf("ldap: table %s: RDN attribute %q has no column", cfg.Name, cfg.RDNAttribute)
+		}
+		for _, oc := range cfg.ObjectClasses {
+			t.classes[b.objectClass(oc)] = true
+		}
+		for _, o := range b.tables {
+			if o.base.Equal(t.base) && o.rdnKey == t.rdnKey {
+				return nil, fmt.Errorf("ldap: tables %s and %s have the same entries", o.Name, cfg.Name)
+			}
+		}
+		b.tables = append(b.tables, t)
+	}
+	return b, nil
+}
+
+// NamingContexts returns the bases of the tables that aren't below the
+// base of another table.
+func (b *SQLBackend) NamingContexts() []string {
+	var names []string
+	for _, t := range b.tables {
+		top := true
+		for _, o := range b.tables {
+			top = top && !t.base.IsDescendantOf(o.base)
+		}
+		if name := t.base.String(); top && !containsDN(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func containsDN(s []string, v string) bool {
+	for _, x := range s {
+		if EqualDN(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *SQLBackend) editor() entryEditor {
+	return entryEditor{schema: b.schema}
+}
+
+// objectClass returns the normalized name of an object class.
+func (b *SQLBackend) objectClass(name string) string {
+	if b.schema != nil {
+		if oc := b.schema.ObjectClass(name); oc != nil {
+			return oc.OID
+		}
+	}
+	return strings.ToLower(name)
+}
+
+// sqlQuery builds a statement and its arguments.
+type sqlQuery struct {
+	strings.Builder
+	args     []any
+	numbered bool
+}
+
+func (b *SQLBackend) query() *sqlQuery {
+	return &sqlQuery{numbered: b.NumberedPlaceholders}
+}
+
+// arg adds an argument and returns its placeholder.
+func (q *sqlQuery) arg(v any) string {
+	q.args = append(q.args, v)
+	if q.numbered {
+		return "$" + strconv.Itoa(len(q.args))
+	}
+	return "?"
+}
+
+// where translates the filter into a condition that is true for at least
+// the rows of the table whose entries match. It returns false if the
+// filter can't be translated, in which case all rows must be read and no
+// arguments are added. The condition is exact if it's true for only the
+// matching rows and false for all others, which a negation requires.
+func (b *SQLBackend) where(q *sqlQuery, t *sqlTable, f Filter) (cond string, exact, ok bool) {
+	switch f := f.(type) {
+	case *AND:
+		var conds []string
+		exact = true
+		for _, c := range f.Filters {
+			cond, e, ok := b.where(q, t, c)
+			if ok {
+				conds = append(conds, cond)
+			}
+			exact = exact && ok && e
+		}
+		if len(conds) == 0 {
+			return "", false, false
+		}
+		return "(" + strings.Join(conds, " AND ") + ")", exact, true
+	case *OR:
+		if len(f.Filters) == 0 {
+			return "1=0", true, true
+		}
+		n := len(q.args)
+		conds := make([]string, len(f.Filters))
+		exact = true
+		for i, c := range f.Filters {
+			cond, e, ok := b.where(q, t, c)
+			if !ok {
+				q.args = q.args[:n]
+				return "", false, false
+			}
+			conds[i] = cond
+			exact = exact && e
+		}
+		return "(" + strings.Join(conds, " OR ") + ")", exact, true
+	case *NOT:
+		// The negation of a condition that's true for more rows than
+		// match would leave out matching rows.
+		n := len(q.args)
+		cond, exact, ok := b.where(q, t, f.Filter)
+		if !ok || !exact {
+			q.args = q.args[:n]
+			return "", false, false
+		}
+		return "NOT " + cond, true, true
+	case *EqualityMatch:
+		return b.item(q, t, f, f.Attribute, func(col string) (string, bool, bool) {
+			return b.compare(q, col, filterRule(b.schema, f.Attribute, EqualityMatchingRule), "=", f.Value)
+		})
+	case *ApproxMatch:
+		// CompileFilter uses equality for approximate matches.
+		return b.item(q, t, f, f.Attribute, func(col string) (string, bool, bool) {
+			return b.compare(q, col, filterRule(b.schema, f.Attribute, EqualityMatchingRule), "=", f.Value)
+		})
+	case *GreaterOrEqual:
+		return b.item(q, t, f, f.Attribute, func(col string) (string, bool, bool) {
+			return b.compare(q, col, filterRule(b.schema, f.Attribute, OrderingMatchingRule), ">=", f.Value)
+		})
+	case *LessOrEqual:
+		return b.item(q, t, f, f.Attribute, func(col string) (string, bool, bool) {
+			return b.compare(q, col, filterRule(b.schema, f.Attribute, OrderingMatchingRule), "<=", f.Value)
+		})
+	case *Present:
+		return b.item(q, t, f, f.Attribute, func(col string) (string, bool, bool) {
+			return col + " IS NOT NULL", true, true
+		})
+	case *Substrings:
+		return b.item(q, t, f, f.Attribute, func(col string) (string, bool, bool) {
+			return b.like(q, col, filterRule(b.schema, f.Attribute, SubstringsMatchingRule), f)
+		})
+	}
+	return "", false, false
+}
+
+// item translates a filter item for the attribute using cond for a mapped
+// column.
+func (b *SQLBackend) item(q *sqlQuery, t *sqlTable, f Filter, attr string, cond func(col string) (string, bool, bool)) (string, bool, bool) {
+	d := b.editor().differ()
+	key := d.key(attr)
+	if col := t.columns[key]; col != "" {
+		return cond(col)
+	}
+	if key == d.key("objectClass") {
+		// The object classes are the same for all rows.
+		e := &Entry{Attributes: []*Attribute{{Name: "objectClass"}}}
+		for _, oc := range t.ObjectClasses {
+			e.Attributes[0].Values = append(e.Attributes[0].Values, []byte(oc))
+		}
+		switch CompileFilter(f, b.schema)(e) {
+		case FilterTrue:
+			return "1=1", true, true
+		case FilterFalse:
+			return "1=0", true, true
+		}
+		return "1=0", false, true
+	}
+	sel, ok := newAttributeSelector(b.schema, attr)
+	if !ok {
+		// The item is undefined, which its negation is too.
+		return "1=0", false, true
+	}
+	for _, a := range t.attrs {
+		if sel.matches(a) {
+			// A subtype of the attribute type is mapped.
+			return "", false, false
+		}
+	}
+	// The entries have no values of the attribute.
+	return "1=0", true, true
+}
+
+// compare returns a comparison of the column with the assertion value or
+// false if the database can't compare values like the matching rule. The
+// database doesn't prepare strings as the rules for caseIgnore and
+// caseExact do, so their comparisons aren't exact.
+func (b *SQLBackend) compare(q *sqlQuery, col string, rule *MatchingRule, op string, v []byte) (string, bool, bool) {
+	switch rule {
+	case nil:
+		return "1=0", false, true
+	case caseIgnoreMatch, caseIgnoreOrderingMatch, caseIgnoreIA5Match:
+		return fmt.Sprintf("(%s IS NOT NULL AND LOWER(%s) %s %s)", col, col, op, q.arg(strings.ToLower(string(v)))), false, true
+	case caseExactMatch, caseExactOrderingMatch, caseExactIA5Match:
+		return fmt.Sprintf("(%s IS NOT NULL AND %s %s %s)", col, col, op, q.arg(string(v))), false, true
+	case octetStringMatch, octetStringOrderingMatch:
+		return fmt.Sprintf("(%s IS NOT NULL AND %s %s %s)", col, col, op, q.arg(string(v))), true, true
+	case integerMatch, integerOrderingMatch:
+		n, err := strconv.ParseInt(strings.TrimSpace(string(v)), 10, 64)
+		if err != nil {
+			return "1=0", false, true
+		}
+		return fmt.Sprintf("(%s IS NOT NULL AND %s %s %s)", col, col, op, q.arg(n)), true, true
+	}
+	return "", false, false
+}
+
+// like returns a LIKE condition for a substrings filter. Like compare it's
+// never exact.
+func (b *SQLBackend) like(q *sqlQuery, col string, rule *MatchingRule, f *Substrings) (string, bool, bool) {
+	var fold bool
+	switch rule {
+	case caseIgnoreSubstringsMatch, caseIgnoreIA5SubstringsMatch:
+		fold = true
+	case caseExactSubstringsMatch, caseExactIA5SubstringsMatch:
+	default:
+		return "", false, false
+	}
+	esc := func(s string) string {
+		if fold {
+			s = strings.ToLower(s)
+		}
+		return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+	}
+	pattern := esc(f.Initial) + "%"
+	for _, a := range f.Any {
+		if a != "" {
+			pattern += esc(a) + "%"
+		}
+	}
+	pattern += esc(f.Final)
+	expr := col
+	if fold {
+		expr = "LOWER(" + col + ")"
+	}
+	// The escape character is one without a meaning in string literals,
+	// unlike a backslash in MySQL.
+	return fmt.Sprintf(`(%s IS NOT NULL AND %s LIKE %s ESCAPE '!')`, col, expr, q.arg(pattern)), false, true
+}
+
+// keyCond returns the condition for the row with the RDN value.
+func (b *SQLBackend) keyCond(q *sqlQuery, t *sqlTable, value string) string {
+	if cond, _, ok := b.compare(q, t.keyCol, filterRule(b.schema, t.RDNAttribute, EqualityMatchingRule), "=", []byte(value)); ok {
+		return cond
+	}
+	return t.keyCol + " = " + q.arg(value)
+}
+
+// locate returns the table and RDN value of the entry with the DN.
+func (b *SQLBackend) locate(dn DN) (*sqlTable, string) {
+	if len(dn) == 0 || len(dn[0]) != 1 {
+		return nil, ""
+	}
+	d := b.editor().differ()
+	parent := dn.Parent()
+	for _, t := range b.tables {
+		if d.key(dn[0][0].Type) == t.rdnKey && parent.Equal(t.base) {
+			return t, dn[0][0].Value
+		}
+	}
+	return nil, ""
+}
+
+// isContainer returns true if the DN is the base of a table or one of
+// its superiors.
+func (b *SQLBackend) isContainer(dn DN) bool {
+	for _, t := range b.tables {
+		if t.base.Equal(dn) || t.base.IsDescendantOf(dn) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchedDN returns the closest container above the DN.
+func (b *SQLBackend) matchedDN(dn DN) string {
+	for dn = dn.Parent(); len(dn) != 0; dn = dn.Parent() {
+		if b.isContainer(dn) {
+			return dn.String()
+		}
+	}
+	return ""
+}
+
+// selectRows returns a query for the columns of the table.
+func (b *SQLBackend) selectRows(q *sqlQuery, t *sqlTable) {
+	cols := make([]string, len(t.attrs))
+	for i, a := range t.attrs {
+		cols[i] = t.Columns[a]
+	}
+	fmt.Fprintf(q, "SELECT %s FROM %s", strings.Join(cols, ", "), t.Name)
+}
+
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// scan returns the entry for the current row of a query from selectRows.
+func (b *SQLBackend) scan(t *sqlTable, rows *sql.Rows) (*Entry, error) {
+	vals := make([]sql.NullString, len(t.attrs))
+	dest := make([]any, len(vals))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	e := &Entry{}
+	if len(t.ObjectClasses) != 0 {
+		a := &Attribute{Name: "objectClass"}
+		for _, oc := range t.ObjectClasses {
+			a.Values = append(a.Values, []byte(oc))
+		}
+		e.Attributes = append(e.Attributes, a)
+	}
+	var rdn string
+	for i, attr := range t.attrs {
+		if !vals[i].Valid {
+			continue
+		}
+		if t.Columns[attr] == t.keyCol {
+			rdn = vals[i].String
+		}
+		e.Attributes = append(e.Attributes, &Attribute{Name: attr, Values: [][]byte{[]byte(vals[i].String)}})
+	}
+	dn := append(DN{{{Type: t.RDNAttribute, Value: rdn}}}, t.base...)
+	e.DN = dn.String()
+	return e, nil
+}
+
+// entry reads the entry with the RDN value. It returns nil if there's no
+// such row.
+func (b *SQLBackend) entry(ctx context.Context, db sqlQueryer, t *sqlTable, value string) (*Entry, error) {
+	q := b.query()
+	b.selectRows(q, t)
+	q.WriteString(" WHERE " + b.keyCond(q, t, value))
+	rows, err := db.QueryContext(ctx, q.String(), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return b.scan(t, rows)
+}
+
+// columnValues returns the values of the columns of the table for the
+// entry. Integer values are converted to int64.
+func (b *SQLBackend) columnValues(t *sqlTable, e *Entry) (map[string]any, BaseResponse) {
+	d := b.editor().differ()
+	vals := make(map[string]any, len(t.attrs))
+	for _, a := range e.Attributes {
+		if len(a.Values) == 0 {
+			continue
+		}
+		key := d.key(a.Name)
+		if key == d.key("objectClass") {
+			for _, v := range a.Values {
+				if !t.classes[b.objectClass(string(v))] {
+					return nil, BaseResponse{Code: ResultObjectClassViolation, Message: fmt.Sprintf("object class %s not allowed", v)}
+				}
+			}
+			continue
+		}
+		col := t.columns[key]
+		if col == "" {
+			return nil, BaseResponse{Code: ResultObjectClassViolation, Message: fmt.Sprintf("attribute %s not allowed", a.Name)}
+		}
+		if len(a.Values) > 1 {
+			return nil, BaseResponse{Code: ResultConstraintViolation, Message: fmt.Sprintf("attribute %s has more than one value", a.Name)}
+		}
+		switch filterRule(b.schema, a.Name, EqualityMatchingRule) {
+		case integerMatch:
+			n, err := strconv.ParseInt(strings.TrimSpace(string(a.Values[0])), 10, 64)
+			if err != nil {
+				return nil, BaseResponse{Code: ResultInvalidAttributeSyntax, Message: fmt.Sprintf("invalid integer %q for attribute %s", a.Values[0], a.Name)}
+			}
+			vals[col] = n
+		default:
+			vals[col] = string(a.Values[0])
+		}
+	}
+	return vals, BaseResponse{}
+}
+
+// update runs fn in a transaction that is committed if it succeeds.
+func (b *SQLBackend) update(ctx context.Context, fn func(tx *sql.Tx) (BaseResponse, error)) (BaseResponse, error) {
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return BaseResponse{}, err
+	}
+	res, err := fn(tx)
+	if err != nil || res.Code != ResultSuccess {
+		tx.Rollback()
+		return res, err
+	}
+	return res, tx.Commit()
+}
+
+func (b *SQLBackend) Connect(remoteAddr net.Addr) (State, error) {
+	return &bindState{}, nil
+}
+
+func (b *SQLBackend) Disconnect(state State) {
+}
+
+func (b *SQLBackend) Add(ctx context.Context, state State, req *AddRequest) (*AddResponse, error) {
+	res, err := b.add(ctx, req.Entry())
+	if err != nil {
+		return nil, err
+	}
+	return &AddResponse{BaseResponse: res}, nil
+}
+
+func (b *SQLBackend) add(ctx context.Context, e *Entry) (BaseResponse, error) {
+	dn, err := ParseDN(e.DN)
+	if err != nil {
+		return BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}, nil
+	}
+	if len(dn) == 0 || b.isContainer(dn) {
+		return BaseResponse{Code: ResultEntryAlreadyExists}, nil
+	}
+	t, value := b.locate(dn)
+	if t == nil {
+		if b.isContainer(dn.Parent()) {
+			return BaseResponse{Code: ResultUnwillingToPerform, Message: "no table for the entry"}, nil
+		}
+		return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn), Message: "superior entry does not exist"}, nil
+	}
+	if res := b.editor().checkRDN(e, dn[0]); res.Code != ResultSuccess {
+		res.Code = ResultNamingViolation
+		return res, nil
+	}
+	vals, res := b.columnValues(t, e)
+	if res.Code != ResultSuccess {
+		return res, nil
+	}
+	return b.update(ctx, func(tx *sql.Tx) (BaseResponse, error) {
+		old, err := b.entry(ctx, tx, t, value)
+		if err != nil {
+			return BaseResponse{}, err
+		} else if old != nil {
+			return BaseResponse{Code: ResultEntryAlreadyExists}, nil
+		}
+		q := b.query()
+		var cols, params []string
+		for _, attr := range t.attrs {
+			col := t.Columns[attr]
+			if v, ok := vals[col]; ok {
+				cols = append(cols, col)
+				params = append(params, q.arg(v))
+			}
+		}
+		fmt.Fprintf(q, "INSERT INTO %s (%s) VALUES (%s)", t.Name, strings.Join(cols, ", "), strings.Join(params, ", "))
+		_, err = tx.ExecContext(ctx, q.String(), q.args...)
+		return BaseResponse{}, err
+	})
+}
+
+func (b *SQLBackend) Delete(ctx context.Context, state State, req *DeleteRequest) (*DeleteResponse, error) {
+	dn, err := ParseDN(req.DN)
+	if err != nil {
+		return &DeleteResponse{BaseResponse: BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}}, nil
+	}
+	t, value := b.locate(dn)
+	if t == nil {
+		return &DeleteResponse{BaseResponse: b.missing(dn)}, nil
+	}
+	res, err := b.update(ctx, func(tx *sql.Tx) (BaseResponse, error) {
+		q := b.query()
+		fmt.Fprintf(q, "DELETE FROM %s WHERE ", t.Name)
+		q.WriteString(b.keyCond(q, t, value))
+		r, err := tx.ExecContext(ctx, q.String(), q.args...)
+		if err != nil {
+			return BaseResponse{}, err
+		}
+		if n, err := r.RowsAffected(); err != nil {
+			return BaseResponse{}, err
+		} else if n == 0 {
+			return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn)}, nil
+		} else if n > 1 {
+			return BaseResponse{}, fmt.Errorf("ldap: %d rows for %s in table %s", n, dn, t.Name)
+		}
+		return BaseResponse{}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResponse{BaseResponse: res}, nil
+}
+
+// missing returns the result for an update of an entry that isn't a row.
+func (b *SQLBackend) missing(dn DN) BaseResponse {
+	if len(dn) == 0 || b.isContainer(dn) {
+		return BaseResponse{Code: ResultUnwillingToPerform, Message: "the entry is not stored in a table"}
+	}
+	return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn)}
+}
+
+func (b *SQLBackend) Modify(ctx context.Context, state State, req *ModifyRequest) (*ModifyResponse, error) {
+	dn, err := ParseDN(req.DN)
+	if err != nil {
+		return &ModifyResponse{BaseResponse: BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}}, nil
+	}
+	t, value := b.locate(dn)
+	if t == nil {
+		return &ModifyResponse{BaseResponse: b.missing(dn)}, nil
+	}
+	res, err := b.update(ctx, func(tx *sql.Tx) (BaseResponse, error) {
+		e, err := b.entry(ctx, tx, t, value)
+		if err != nil {
+			return BaseResponse{}, err
+		} else if e == nil {
+			return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn)}, nil
+		}
+		ed := b.editor()
+		for _, m := range req.Mods {
+			if res := ed.applyMod(e, m); res.Code != ResultSuccess {
+				return res, nil
+			}
+		}
+		if res := ed.checkRDN(e, dn[0]); res.Code != ResultSuccess {
+			return res, nil
+		}
+		vals, res := b.columnValues(t, e)
+		if res.Code != ResultSuccess {
+			return res, nil
+		}
+		q := b.query()
+		sets := make([]string, len(t.attrs))
+		for i, attr := range t.attrs {
+			col := t.Columns[attr]
+			sets[i] = col + " = " + q.arg(vals[col])
+		}
+		fmt.Fprintf(q, "UPDATE %s SET %s WHERE ", t.Name, strings.Join(sets, ", "))
+		q.WriteString(b.keyCond(q, t, value))
+		_, err = tx.ExecContext(ctx, q.String(), q.args...)
+		return BaseResponse{}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ModifyResponse{BaseResponse: res}, nil
+}
+
+// ModifyDN renames an entry within its table. The old RDN value must be
+// deleted since the RDN attribute has a single value.
+func (b *SQLBackend) ModifyDN(ctx context.Context, state State, req *ModifyDNRequest) (*ModifyDNResponse, error) {
+	res, err := b.modifyDN(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &ModifyDNResponse{BaseResponse: res}, nil
+}
+
+func (b *SQLBackend) modifyDN(ctx context.Context, req *ModifyDNRequest) (BaseResponse, error) {
+	dn, err := ParseDN(req.DN)
+	if err != nil {
+		return BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}, nil
+	}
+	newRDN, err := ParseDN(req.NewRDN)
+	if err != nil || len(newRDN) != 1 {
+		return BaseResponse{Code: ResultInvalidDNSyntax, Message: fmt.Sprintf("invalid new RDN %q", req.NewRDN)}, nil
+	}
+	t, value := b.locate(dn)
+	if t == nil {
+		return b.missing(dn), nil
+	}
+	if req.NewSuperior != "" {
+		sup, err := ParseDN(req.NewSuperior)
+		if err != nil {
+			return BaseResponse{Code: ResultInvalidDNSyntax, Message: err.Error()}, nil
+		}
+		if !sup.Equal(t.base) {
+			return BaseResponse{Code: ResultUnwillingToPerform, Message: "entries can only be renamed within their table"}, nil
+		}
+	}
+	newDN := append(DN{newRDN[0]}, t.base...)
+	if nt, _ := b.locate(newDN); nt != t {
+		return BaseResponse{Code: ResultUnwillingToPerform, Message: "the RDN must be a single " + t.RDNAttribute + " value"}, nil
+	}
+	newValue := newRDN[0][0].Value
+	ed := b.editor()
+	same := ed.rdnHas(dn[0], t.RDNAttribute, []byte(newValue))
+	if !same && !req.DeleteOldRDN {
+		return BaseResponse{Code: ResultUnwillingToPerform, Message: "the old RDN value must be deleted"}, nil
+	}
+	return b.update(ctx, func(tx *sql.Tx) (BaseResponse, error) {
+		e, err := b.entry(ctx, tx, t, value)
+		if err != nil {
+			return BaseResponse{}, err
+		} else if e == nil {
+			return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn)}, nil
+		}
+		if !same {
+			if other, err := b.entry(ctx, tx, t, newValue); err != nil {
+				return BaseResponse{}, err
+			} else if other != nil {
+				return BaseResponse{Code: ResultEntryAlreadyExists}, nil
+			}
+		}
+		q := b.query()
+		fmt.Fprintf(q, "UPDATE %s SET %s = %s WHERE ", t.Name, t.keyCol, q.arg(newValue))
+		q.WriteString(b.keyCond(q, t, value))
+		_, err = tx.ExecContext(ctx, q.String(), q.args...)
+		return BaseResponse{}, err
+	})
+}
+
+func (b *SQLBackend) ExtendedRequest(ctx context.Context, state State, req *ExtendedRequest) (*ExtendedResponse, error) {
+	return unsupportedExtended(req), nil
+}
+
+// Bind performs a simple bind. A failed bind leaves the connection
+// anonymous.
+func (b *SQLBackend) Bind(ctx context.Context, state State, req *BindRequest) (*BindResponse, error) {
+	return simpleBind(state, req, func(dn DN, password []byte) (string, error) {
+		t, value := b.locate(dn)
+		if t == nil {
+			return "", nil
+		}
+		e, err := b.entry(ctx, b.DB, t, value)
+		if err != nil || e == nil {
+			return "", err
+		}
+		for _, v := range b.editor().values(e, "userPassword") {
+			if checkPassword(v, password) {
+				return e.DN, nil
+			}
+		}
+		return "", nil
+	})
+}
+
+// Whoami returns the authorization identity of the bound DN (RFC 4532).
+func (b *SQLBackend) Whoami(ctx context.Context, state State) (string, error) {
+	return authzID(state), nil
+}
+
+// PasswordModify changes the password of the bound user. The old password
+// must match if given.
+func (b *SQLBackend) PasswordModify(ctx context.Context, state State, req *PasswordModifyRequest) ([]byte, error) {
+	bound := boundDN(state)
+	if bound == "" {
+		return nil, &BaseResponse{Code: ResultUnwillingToPerform, Message: "must be bound to change the password"}
+	}
+	if target := strings.TrimPrefix(req.UserIdentity, "dn:"); target != "" && !EqualDN(target, bound) {
+		return nil, &BaseResponse{Code: ResultInsufficientAccessRights, Message: "only the password of the bound user can be changed"}
+	}
+	dn, err := ParseDN(bound)
+	if err != nil {
+		return nil, err
+	}
+	t, value := b.locate(dn)
+	if t == nil {
+		return nil, &BaseResponse{Code: ResultNoSuchObject}
+	}
+	col := t.columns[b.editor().differ().key("userPassword")]
+	if col == "" {
+		return nil, &BaseResponse{Code: ResultUnwillingToPerform, Message: "passwords are not stored"}
+	}
+	var gen []byte
+	password := req.NewPassword
+	if len(password) == 0 {
+		if gen, err = generatePassword(); err != nil {
+			return nil, err
+		}
+		password = gen
+	}
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.update(ctx, func(tx *sql.Tx) (BaseResponse, error) {
+		e, err := b.entry(ctx, tx, t, value)
+		if err != nil {
+			return BaseResponse{}, err
+		} else if e == nil {
+			return BaseResponse{Code: ResultNoSuchObject, MatchedDN: b.matchedDN(dn)}, nil
+		}
+		if req.OldPassword != nil {
+			ok := false
+			for _, v := range b.editor().values(e, "userPassword") {
+				ok = ok || checkPassword(v, req.OldPassword)
+			}
+			if !ok {
+				return BaseResponse{Code: ResultInvalidCredentials, Message: "old password does not match"}, nil
+			}
+		}
+		q := b.query()
+		fmt.Fprintf(q, "UPDATE %s SET %s = %s WHERE ", t.Name, col, q.arg(string(hashed)))
+		q.WriteString(b.keyCond(q, t, value))
+		_, err = tx.ExecContext(ctx, q.String(), q.args...)
+		return BaseResponse{}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Code != ResultSuccess {
+		return nil, &res
+	}
+	return gen, nil
+}
+
+// Search returns the entries of the rows in the scope of the search that
+// match the filter. The bases of the tables and their superiors exist but
+// aren't returned.
+func (b *SQLBackend) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	var results []*SearchResult
+	res, err := b.SearchFunc(ctx, state, req, func(r *SearchResult) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Results = results
+	return res, nil
+}
+
+// SearchFunc performs a search like Search but passes each entry to send
+// as its row is read.
+func (b *SQLBackend) SearchFunc(ctx context.Context, state State, req *SearchRequest, send func(*SearchResult) error) (*SearchResponse, error) {
+	res := &SearchResponse{}
+	switch req.Scope {
+	case ScopeBaseObject, ScopeSingleLevel, ScopeWholeSubtree, ScopeChildren:
+	default:
+		res.Code = ResultProtocolError
+		res.Message = fmt.Sprintf("unknown scope %d", req.Scope)
+		return res, nil
+	}
+	base, err := ParseDN(req.BaseDN)
+	if err != nil {
+		res.Code = ResultInvalidDNSyntax
+		res.Message = err.Error()
+		return res, nil
+	}
+	var match FilterPredicate
+	if req.Filter != nil {
+		match = CompileFilter(req.Filter, b.schema)
+	}
+	limit := req.SizeLimit
+	if b.SizeLimit > 0 && (limit <= 0 || b.SizeLimit < limit) {
+		limit = b.SizeLimit
+	}
+	if req.TimeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeLimit)*time.Second)
+		defer cancel()
+	}
+
+	if len(base) != 0 && !b.isContainer(base) {
+		t, value := b.locate(base)
+		var e *Entry
+		if t != nil {
+			if e, err = b.entry(ctx, b.DB, t, value); err != nil {
+				return nil, err
+			}
+		}
+		if e == nil {
+			res.Code = ResultNoSuchObject
+			res.MatchedDN = b.matchedDN(base)
+			return res, nil
+		}
+	}
+	sent := 0
+	for _, t := range b.tables {
+		q := b.query()
+		b.selectRows(q, t)
+		var conds []string
+		switch {
+		case t.base.Equal(base):
+			if req.Scope == ScopeBaseObject {
+				continue
+			}
+		case t.base.IsDescendantOf(base):
+			if req.Scope == ScopeBaseObject || req.Scope == ScopeSingleLevel {
+				continue
+			}
+		default:
+			nt, value := b.locate(base)
+			if nt != t || req.Scope == ScopeSingleLevel || req.Scope == ScopeChildren {
+				continue
+			}
+			conds = append(conds, b.keyCond(q, t, value))
+		}
+		if req.Filter != nil {
+			if cond, _, ok := b.where(q, t, req.Filter); ok {
+				conds = append(conds, cond)
+			}
+		}
+		if len(conds) != 0 {
+			q.WriteString(" WHERE " + strings.Join(conds, " AND "))
+		}
+		if done, err := b.search(ctx, t, q, match, limit, &sent, send, res); err != nil {
+			return nil, err
+		} else if done {
+			return res, nil
+		}
+	}
+	return res, nil
+}
+
+// search adds the matching entries of the query to the response. It
+// returns true if the search ended early.
+func (b *SQLBackend) search(ctx context.Context, t *sqlTable, q *sqlQuery, match FilterPredicate, limit int, sent *int, send func(*SearchResult) error, res *SearchResponse) (bool, error) {
+	rows, err := b.DB.QueryContext(ctx, q.String(), q.args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			res.Code = ResultTimeLimitExceeded
+			return true, nil
+		}
+		return true, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := b.scan(t, rows)
+		if err != nil {
+			return true, err
+		}
+		if match != nil && match(e) != FilterTrue {
+			continue
+		}
+		if limit > 0 && *sent == limit {
+			res.Code = ResultSizeLimitExceeded
+			return true, nil
+		}
+		if err := send(e.SearchResult()); err != nil {
+			return true, err
+		}
+		*sent++
+	}
+	if err := rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			res.Code = ResultTimeLimitExceeded
+			return true, nil
+		}
+		return true, err
+	}
+	return false, nil
+}
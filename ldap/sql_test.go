@@ -0,0 +1,890 @@
+package ldap
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unicode"
+)
+
+// fakeSQL is an in-memory database that supports the statements generated
+// by SQLBackend. Transactions hold a lock on the whole database.
+type fakeSQL struct {
+	mu     sync.Mutex
+	tables map[string]*fakeTable
+	read   atomic.Int64 // rows read from query results
+}
+
+type fakeTable struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func newFakeSQL(tables map[string][]string) *fakeSQL {
+	db := &fakeSQL{tables: make(map[string]*fakeTable)}
+	for name, cols := range tables {
+		db.tables[name] = &fakeTable{cols: cols}
+	}
+	return db
+}
+
+func (db *fakeSQL) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{db})
+}
+
+func (db *fakeSQL) clone() map[string]*fakeTable {
+	tables := make(map[string]*fakeTable, len(db.tables))
+	for name, t := range db.tables {
+		c := &fakeTable{cols: t.cols}
+		for _, r := range t.rows {
+			c.rows = append(c.rows, append([]driver.Value(nil), r...))
+		}
+		tables[name] = c
+	}
+	return tables
+}
+
+type fakeConnector struct{ db *fakeSQL }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakesql: use the connector")
+}
+
+type fakeConn struct {
+	db    *fakeSQL
+	saved map[string]*fakeTable // tables at the start of the transaction
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	c.saved = c.db.clone()
+	return fakeTx{c}, nil
+}
+
+type fakeTx struct{ c *fakeConn }
+
+func (tx fakeTx) Commit() error {
+	tx.c.saved = nil
+	tx.c.db.mu.Unlock()
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.c.db.tables = tx.c.saved
+	tx.c.saved = nil
+	tx.c.db.mu.Unlock()
+	return nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, _, n, err := s.run(args)
+	return driver.RowsAffected(n), err
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	cols, rows, _, err := s.run(args)
+	return &fakeRows{db: s.c.db, cols: cols, rows: rows}, err
+}
+
+func (s *fakeStmt) run(args []driver.Value) (cols []string, rows [][]driver.Value, n int64, err error) {
+	if s.c.saved == nil {
+		s.c.db.mu.Lock()
+		defer s.c.db.mu.Unlock()
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("fakesql: %v in %q", e, s.query)
+		}
+	}()
+	p := &fakeParser{toks: fakeTokens(s.query), args: args}
+	cols, rows, n = p.statement(s.c.db.tables)
+	if p.pos != len(p.toks) {
+		panic("unexpected " + p.peek())
+	}
+	return cols, rows, n, nil
+}
+
+type fakeRows struct {
+	db   *fakeSQL
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	r.db.read.Add(1)
+	return nil
+}
+
+var fakeToken = regexp.MustCompile(`\s*([A-Za-z_][A-Za-z0-9_.]*|-?[0-9]+|'(?:[^']|'')*'|\$[0-9]+|>=|<=|[(),=?])`)
+
+func fakeTokens(query string) []string {
+	var toks []string
+	for _, m := range fakeToken.FindAllStringSubmatch(query, -1) {
+		toks = append(toks, m[1])
+	}
+	if strings.TrimSpace(fakeToken.ReplaceAllString(query, "")) != "" {
+		panic("invalid characters in " + query)
+	}
+	return toks
+}
+
+// fakeExpr evaluates an expression for a row. Conditions are bool or nil
+// if unknown.
+type fakeExpr func(row map[string]driver.Value) driver.Value
+
+type fakeParser struct {
+	toks []string
+	pos  int
+	args []driver.Value
+	narg int
+}
+
+func (p *fakeParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *fakeParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *fakeParser) accept(kw string) bool {
+	if strings.EqualFold(p.peek(), kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *fakeParser) expect(kw string) {
+	if !p.accept(kw) {
+		panic(fmt.Sprintf("expected %s got %q", kw, p.peek()))
+	}
+}
+
+func (p *fakeParser) list(item func()) {
+	item()
+	for p.accept(",") {
+		item()
+	}
+}
+
+func (p *fakeParser) table(tables map[string]*fakeTable) *fakeTable {
+	name := p.next()
+	t := tables[name]
+	if t == nil {
+		panic("no table " + name)
+	}
+	return t
+}
+
+func (p *fakeParser) where() fakeExpr {
+	if p.accept("WHERE") {
+		return p.or()
+	}
+	return func(map[string]driver.Value) driver.Value { return true }
+}
+
+func (p *fakeParser) statement(tables map[string]*fakeTable) ([]string, [][]driver.Value, int64) {
+	switch strings.ToUpper(p.next()) {
+	case "SELECT":
+		var cols []string
+		p.list(func() { cols = append(cols, p.next()) })
+		p.expect("FROM")
+		t := p.table(tables)
+		cond := p.where()
+		var rows [][]driver.Value
+		for _, r := range t.rows {
+			m := t.row(r)
+			if cond(m) == true {
+				out := make([]driver.Value, len(cols))
+				for i, c := range cols {
+					out[i] = t.value(m, c)
+				}
+				rows = append(rows, out)
+			}
+		}
+		return cols, rows, 0
+	case "INSERT":
+		p.expect("INTO")
+		t := p.table(tables)
+		var cols []string
+		p.expect("(")
+		p.list(func() { cols = append(cols, p.next()) })
+		p.expect(")")
+		p.expect("VALUES")
+		p.expect("(")
+		m := make(map[string]driver.Value)
+		i := 0
+		p.list(func() {
+			m[cols[i]] = p.operand()(nil)
+			i++
+		})
+		p.expect(")")
+		r := make([]driver.Value, len(t.cols))
+		for i, c := range t.cols {
+			r[i] = m[c]
+			delete(m, c)
+		}
+		if len(m) != 0 {
+			panic(fmt.Sprintf("unknown columns %v", m))
+		}
+		t.rows = append(t.rows, r)
+		return nil, nil, 1
+	case "UPDATE":
+		t := p.table(tables)
+		p.expect("SET")
+		set := make(map[string]fakeExpr)
+		p.list(func() {
+			col := p.next()
+			p.expect("=")
+			set[col] = p.operand()
+		})
+		cond := p.where()
+		var n int64
+		for _, r := range t.rows {
+			m := t.row(r)
+			if cond(m) != true {
+				continue
+			}
+			for col, v := range set {
+				t.value(m, col)
+				r[t.index(col)] = v(m)
+			}
+			n++
+		}
+		return nil, nil, n
+	case "DELETE":
+		p.expect("FROM")
+		t := p.table(tables)
+		cond := p.where()
+		var rows [][]driver.Value
+		for _, r := range t.rows {
+			if cond(t.row(r)) != true {
+				rows = append(rows, r)
+			}
+		}
+		n := int64(len(t.rows) - len(rows))
+		t.rows = rows
+		return nil, nil, n
+	}
+	panic("unsupported statement")
+}
+
+func (t *fakeTable) row(r []driver.Value) map[string]driver.Value {
+	m := make(map[string]driver.Value, len(t.cols))
+	for i, c := range t.cols {
+		m[c] = r[i]
+	}
+	return m
+}
+
+func (t *fakeTable) index(col string) int {
+	for i, c := range t.cols {
+		if c == col {
+			return i
+		}
+	}
+	panic("no column " + col)
+}
+
+func (t *fakeTable) value(m map[string]driver.Value, col string) driver.Value {
+	v, ok := m[col]
+	if !ok {
+		panic("no column " + col)
+	}
+	return v
+}
+
+func (p *fakeParser) or() fakeExpr {
+	l := p.and()
+	for p.accept("OR") {
+		a, b := l, p.and()
+		l = func(m map[string]driver.Value) driver.Value {
+			x, y := a(m), b(m)
+			if x == true || y == true {
+				return true
+			}
+			if x == nil || y == nil {
+				return nil
+			}
+			return false
+		}
+	}
+	return l
+}
+
+func (p *fakeParser) and() fakeExpr {
+	l := p.not()
+	for p.accept("AND") {
+		a, b := l, p.not()
+		l = func(m map[string]driver.Value) driver.Value {
+			x, y := a(m), b(m)
+			if x == false || y == false {
+				return false
+			}
+			if x == nil || y == nil {
+				return nil
+			}
+			return true
+		}
+	}
+	return l
+}
+
+func (p *fakeParser) not() fakeExpr {
+	if p.accept("NOT") {
+		e := p.not()
+		return func(m map[string]driver.Value) driver.Value {
+			if x := e(m); x != nil {
+				return !x.(bool)
+			}
+			return nil
+		}
+	}
+	if p.accept("(") {
+		e := p.or()
+		p.expect(")")
+		return e
+	}
+	l := p.operand()
+	switch op := strings.ToUpper(p.next()); op {
+	case "IS":
+		not := p.accept("NOT")
+		p.expect("NULL")
+		return func(m map[string]driver.Value) driver.Value {
+			return (l(m) == nil) != not
+		}
+	case "LIKE":
+		r := p.operand()
+		p.expect("ESCAPE")
+		esc := p.operand()(nil).(string)
+		return func(m map[string]driver.Value) driver.Value {
+			x, y := l(m), r(m)
+			if x == nil || y == nil {
+				return nil
+			}
+			return fakeLike(y.(string), esc).MatchString(fmt.Sprint(x))
+		}
+	case "=", ">=", "<=":
+		r := p.operand()
+		return func(m map[string]driver.Value) driver.Value {
+			x, y := l(m), r(m)
+			if x == nil || y == nil {
+				return nil
+			}
+			c := fakeCompare(x, y)
+			return op == "=" && c == 0 || op == ">=" && c >= 0 || op == "<=" && c <= 0
+		}
+	default:
+		panic("unexpected " + op)
+	}
+}
+
+func (p *fakeParser) operand() fakeExpr {
+	tok := p.next()
+	switch {
+	case tok == "?":
+		v := p.args[p.narg]
+		p.narg++
+		return func(map[string]driver.Value) driver.Value { return v }
+	case tok[0] == '$':
+		n, _ := strconv.Atoi(tok[1:])
+		v := p.args[n-1]
+		return func(map[string]driver.Value) driver.Value { return v }
+	case tok[0] == '\'':
+		v := strings.ReplaceAll(tok[1:len(tok)-1], "''", "'")
+		return func(map[string]driver.Value) driver.Value { return v }
+	case tok[0] == '-' || unicode.IsDigit(rune(tok[0])):
+		n, _ := strconv.ParseInt(tok, 10, 64)
+		return func(map[string]driver.Value) driver.Value { return n }
+	case strings.EqualFold(tok, "LOWER"):
+		p.expect("(")
+		e := p.operand()
+		p.expect(")")
+		return func(m map[string]driver.Value) driver.Value {
+			if v := e(m); v != nil {
+				return strings.ToLower(fmt.Sprint(v))
+			}
+			return nil
+		}
+	}
+	return func(m map[string]driver.Value) driver.Value {
+		v, ok := m[tok]
+		if !ok {
+			panic("no column " + tok)
+		}
+		return v
+	}
+}
+
+// fakeCompare compares numerically if both values are integers.
+func fakeCompare(x, y driver.Value) int {
+	toInt := func(v driver.Value) (int64, bool) {
+		switch v := v.(type) {
+		case int64:
+			return v, true
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			return n, err == nil
+		}
+		return 0, false
+	}
+	if a, ok := toInt(x); ok {
+		if b, ok := toInt(y); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(x), fmt.Sprint(y))
+}
+
+func fakeLike(pattern, esc string) *regexp.Regexp {
+	var re strings.Builder
+	re.WriteString("(?s)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case string(r) == esc:
+			escaped = true
+		case r == '%':
+			re.WriteString(".*")
+		case r == '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String())
+}
+
+var testSQLColumns = map[string]string{
+	"uid":          "uid",
+	"cn":           "cn",
+	"sn":           "sn",
+	"mail":         "mail",
+	"uidNumber":    "uid_number",
+	"description":  "description",
+	"userPassword": "user_password",
+}
+
+func newTestFakeSQL() *fakeSQL {
+	cols := []string{"uid", "cn", "sn", "mail", "uid_number", "description", "user_password"}
+	return newFakeSQL(map[string][]string{"people": cols, "staff": cols})
+}
+
+func newTestSQLBackend(t *testing.T, db *fakeSQL, numbered bool) *SQLBackend {
+	t.Helper()
+	classes := []string{"top", "person", "inetOrgPerson"}
+	be, err := NewSQLBackend(db.open(), DefaultSchema,
+		SQLTable{Name: "people", Base: "ou=People,dc=example,dc=com", RDNAttribute: "uid", ObjectClasses: classes, Columns: testSQLColumns},
+		SQLTable{Name: "staff", Base: "ou=Staff,dc=example,dc=com", RDNAttribute: "uid", ObjectClasses: classes, Columns: testSQLColumns},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	be.NumberedPlaceholders = numbered
+	return be
+}
+
+// newTestSQLBackends returns a backend with the people of
+// newTestIndexBackend, which is also returned.
+func newTestSQLBackends(t *testing.T, numbered bool) (*SQLBackend, *MemoryBackend) {
+	t.Helper()
+	mem := newTestIndexBackend(t, 60, nil)
+	be := newTestSQLBackend(t, newTestFakeSQL(), numbered)
+	f, _ := ParseFilter("(uid=*)")
+	res, err := mem.Search(context.Background(), nil, &SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree, Filter: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res.Results {
+		if res, err := be.add(context.Background(), r.Entry()); err != nil {
+			t.Fatal(err)
+		} else if res.Code != ResultSuccess {
+			t.Fatalf("%s: %s", r.DN, res.Err())
+		}
+	}
+	return be, mem
+}
+
+func TestSQLSearch(t *testing.T) {
+	t.Parallel()
+	for _, numbered := range []bool{false, true} {
+		be, mem := newTestSQLBackends(t, numbered)
+		_, c := newTestServer(t, be)
+		_, memClient := newTestServer(t, mem)
+		// Only the people are in the tables. The server answers for the
+		// root DSE itself.
+		people := func(dns []string) []string {
+			var people []string
+			for _, dn := range dns {
+				if strings.HasPrefix(dn, "uid=") {
+					people = append(people, dn)
+				}
+			}
+			return people
+		}
+		for _, filter := range testIndexFilters {
+			for _, base := range []string{"", "dc=example,dc=com", "ou=People,dc=example,dc=com", "uid=user42,ou=People,dc=example,dc=com", "uid=nobody,ou=Staff,dc=example,dc=com", "ou=Nowhere,dc=example,dc=com"} {
+				for _, scope := range []Scope{ScopeBaseObject, ScopeSingleLevel, ScopeWholeSubtree, ScopeChildren} {
+					want, wantErr := searchDNs(t, memClient, base, scope, filter)
+					want = people(want)
+					got, err := searchDNs(t, c, base, scope, filter)
+					got = people(got)
+					if resultCode(err) != resultCode(wantErr) || !reflect.DeepEqual(got, want) {
+						t.Errorf("%s %q %s: expected %s with %d entries got %s with %d", filter, base, scope, resultCode(wantErr), len(want), resultCode(err), len(got))
+					}
+				}
+			}
+		}
+	}
+
+	be, mem := newTestSQLBackends(t, false)
+	dn := "uid=user42,ou=People,dc=example,dc=com"
+	res, err := be.Search(context.Background(), nil, &SearchRequest{BaseDN: dn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Results) != 1 {
+		t.Fatalf("expected 1 entry got %d", len(res.Results))
+	}
+	if mods, _ := DiffEntries(mem.Entry(dn), res.Results[0].Entry(), &DiffOptions{Schema: DefaultSchema}); len(mods) != 0 {
+		t.Errorf("entry differs: %v", mods)
+	}
+
+	res, err = be.Search(context.Background(), nil, &SearchRequest{BaseDN: "dc=example,dc=com", Scope: ScopeWholeSubtree, SizeLimit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != ResultSizeLimitExceeded || len(res.Results) != 5 {
+		t.Errorf("expected size limit exceeded with 5 entries got %s with %d", res.Code, len(res.Results))
+	}
+}
+
+func TestSQLSearchFunc(t *testing.T) {
+	t.Parallel()
+	db := newTestFakeSQL()
+	be := newTestSQLBackend(t, db, false)
+	for i := 0; i < 10; i++ {
+		uid := fmt.Sprintf("user%d", i)
+		e := NewEntry("uid="+uid+",ou=People,dc=example,dc=com", map[string][]string{
+			"objectClass": {"top", "person", "inetOrgPerson"},
+			"uid":         {uid},
+			"cn":          {uid},
+			"sn":          {uid},
+		})
+		if res, err := be.add(context.Background(), e); err != nil {
+			t.Fatal(err)
+		} else if res.Code != ResultSuccess {
+			t.Fatalf("%s: %s", e.DN, res.Err())
+		}
+	}
+
+	start := db.read.Load()
+	var n int
+	var readAtFirst int64
+	res, err := be.SearchFunc(context.Background(), nil, &SearchRequest{BaseDN: "ou=People,dc=example,dc=com", Scope: ScopeSingleLevel}, func(r *SearchResult) error {
+		if n == 0 {
+			readAtFirst = db.read.Load() - start
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != ResultSuccess || len(res.Results) != 0 || n != 10 {
+		t.Fatalf("expected 10 entries sent got %d and %d results with %s", n, len(res.Results), res.Code)
+	}
+	if readAtFirst != 1 {
+		t.Errorf("expected the first entry after reading one row got %d rows", readAtFirst)
+	}
+}
+
+func TestSQLWhere(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		filter   string
+		numbered bool
+		where    string
+		exact    bool
+		args     []any
+	}{
+		{"(uid=JDoe)", false, "(uid IS NOT NULL AND LOWER(uid) = ?)", false, []any{"jdoe"}},
+		{"(UID=JDoe)", true, "(uid IS NOT NULL AND LOWER(uid) = $1)", false, []any{"jdoe"}},
+		{"(uidNumber<=10)", false, "(uid_number IS NOT NULL AND uid_number <= ?)", true, []any{int64(10)}},
+		{"(uidNumber>=ten)", false, "1=0", false, nil},
+		{"(mail=*)", false, "mail IS NOT NULL", true, nil},
+		{`(cn=a*50%_\5c*z)`, false, `(cn IS NOT NULL AND LOWER(cn) LIKE ? ESCAPE '!')`, false, []any{`a%50!%!_\%z`}},
+		{"(cn=*x)", false, `(cn IS NOT NULL AND LOWER(cn) LIKE ? ESCAPE '!')`, false, []any{"%x"}},
+		{"(cn=*x!*)", false, `(cn IS NOT NULL AND LOWER(cn) LIKE ? ESCAPE '!')`, false, []any{"%x!!%"}},
+		{"(&(uid=a)(|(mail=b)(sn=c)))", true, "((uid IS NOT NULL AND LOWER(uid) = $1) AND ((mail IS NOT NULL AND LOWER(mail) = $2) OR (sn IS NOT NULL AND LOWER(sn) = $3)))", false, []any{"a", "b", "c"}},
+		{"(&(uid=a)(|(mail=b)(name=c))(sn=d))", true, "((uid IS NOT NULL AND LOWER(uid) = $1) AND (sn IS NOT NULL AND LOWER(sn) = $2))", false, []any{"a", "d"}},
+		{"(|(uid=a)(name=c))", false, "", false, nil},
+		{"(!(objectClass=person))", false, "NOT 1=1", true, nil},
+		{"(!(uid=a))", false, "", false, nil},
+		{"(!(&(uidNumber>=1)(mail=*)))", false, "NOT ((uid_number IS NOT NULL AND uid_number >= ?) AND mail IS NOT NULL)", true, []any{int64(1)}},
+		{"(!(&(uidNumber>=1)(uid:caseExactMatch:=a)))", false, "", false, nil},
+		{"(!(uidNumber>=ten))", false, "", false, nil},
+		{"(objectClass=groupOfNames)", false, "1=0", true, nil},
+		{"(telephoneNumber=1)", false, "1=0", true, nil},
+		{"(cn;lang-de=x)", false, "1=0", true, nil},
+		{"(|)", false, "1=0", true, nil},
+		{"(&)", false, "", false, nil},
+	}
+	db := newTestFakeSQL()
+	for _, tc := range cases {
+		be := newTestSQLBackend(t, db, tc.numbered)
+		f, err := ParseFilter(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := be.query()
+		where, exact, ok := be.where(q, be.tables[0], f)
+		if where != tc.where || exact != tc.exact || ok != (tc.where != "") || len(q.args)+len(tc.args) != 0 && !reflect.DeepEqual(q.args, tc.args) {
+			t.Errorf("%s: expected %q %v %v got %q %v %v", tc.filter, tc.where, tc.exact, tc.args, where, exact, q.args)
+		}
+	}
+}
+
+func TestSQLUpdate(t *testing.T) {
+	t.Parallel()
+	be, _ := newTestSQLBackends(t, false)
+	ctx := context.Background()
+	dn := "uid=user1,ou=People,dc=example,dc=com"
+	person := func(dn, uid string, attrs map[string][][]byte) *AddRequest {
+		a := map[string][][]byte{
+			"objectClass": {[]byte("top"), []byte("person")},
+			"uid":         {[]byte(uid)},
+			"cn":          {[]byte("New Person")},
+			"sn":          {[]byte("Person")},
+		}
+		for k, v := range attrs {
+			a[k] = v
+		}
+		return &AddRequest{DN: dn, Attributes: a}
+	}
+	newDN := "uid=new,ou=Staff,dc=example,dc=com"
+	cases := []struct {
+		req  Request
+		code ResultCode
+	}{
+		{person(newDN, "new", nil), ResultSuccess},
+		{person(newDN, "new", nil), ResultEntryAlreadyExists},
+		{person("uid=x,ou=Staff,dc=example,dc=com", "y", nil), ResultNamingViolation},
+		{person("uid=x,ou=Staff,dc=example,dc=com", "x", map[string][][]byte{"cn": {[]byte("a"), []byte("b")}}), ResultConstraintViolation},
+		{person("uid=x,ou=Staff,dc=example,dc=com", "x", map[string][][]byte{"telephoneNumber": {[]byte("1")}}), ResultObjectClassViolation},
+		{person("uid=x,ou=Staff,dc=example,dc=com", "x", map[string][][]byte{"objectClass": {[]byte("device")}}), ResultObjectClassViolation},
+		{person("uid=x,ou=Staff,dc=example,dc=com", "x", map[string][][]byte{"uidNumber": {[]byte("many")}}), ResultInvalidAttributeSyntax},
+		{person("cn=x,ou=Staff,dc=example,dc=com", "x", nil), ResultUnwillingToPerform},
+		{person("uid=x,ou=Nowhere,dc=example,dc=com", "x", nil), ResultNoSuchObject},
+		{person("ou=People,dc=example,dc=com", "x", nil), ResultEntryAlreadyExists},
+		{&ModifyRequest{DN: dn, Mods: []*Mod{{Type: Replace, Name: "mail", Values: [][]byte{[]byte("One@Example.com")}}, {Type: Delete, Name: "description"}}}, ResultSuccess},
+		{&ModifyRequest{DN: dn, Mods: []*Mod{{Type: Increment, Name: "uidNumber", Values: [][]byte{[]byte("1000")}}}}, ResultSuccess},
+		{&ModifyRequest{DN: dn, Mods: []*Mod{{Type: Add, Name: "cn", Values: [][]byte{[]byte("Second")}}}}, ResultConstraintViolation},
+		{&ModifyRequest{DN: dn, Mods: []*Mod{{Type: Delete, Name: "uid"}}}, ResultNotAllowedOnRDN},
+		{&ModifyRequest{DN: "uid=nobody,ou=People,dc=example,dc=com", Mods: []*Mod{{Type: Delete, Name: "mail"}}}, ResultNoSuchObject},
+		{&ModifyRequest{DN: "ou=People,dc=example,dc=com", Mods: []*Mod{{Type: Delete, Name: "mail"}}}, ResultUnwillingToPerform},
+		{&ModifyDNRequest{DN: dn, NewRDN: "uid=user2", DeleteOldRDN: true}, ResultEntryAlreadyExists},
+		{&ModifyDNRequest{DN: dn, NewRDN: "uid=renamed"}, ResultUnwillingToPerform},
+		{&ModifyDNRequest{DN: dn, NewRDN: "uid=renamed", DeleteOldRDN: true, NewSuperior: "ou=Staff,dc=example,dc=com"}, ResultUnwillingToPerform},
+		{&ModifyDNRequest{DN: dn, NewRDN: "cn=renamed", DeleteOldRDN: true}, ResultUnwillingToPerform},
+		{&ModifyDNRequest{DN: dn, NewRDN: "uid=renamed", DeleteOldRDN: true}, ResultSuccess},
+		{&DeleteRequest{DN: "uid=user2,ou=People,dc=example,dc=com"}, ResultSuccess},
+		{&DeleteRequest{DN: "uid=user2,ou=People,dc=example,dc=com"}, ResultNoSuchObject},
+		{&DeleteRequest{DN: "ou=People,dc=example,dc=com"}, ResultUnwillingToPerform},
+	}
+	for _, tc := range cases {
+		var res BaseResponse
+		var err error
+		switch req := tc.req.(type) {
+		case *AddRequest:
+			var r *AddResponse
+			if r, err = be.Add(ctx, nil, req); r != nil {
+				res = r.BaseResponse
+			}
+		case *ModifyRequest:
+			var r *ModifyResponse
+			if r, err = be.Modify(ctx, nil, req); r != nil {
+				res = r.BaseResponse
+			}
+		case *ModifyDNRequest:
+			var r *ModifyDNResponse
+			if r, err = be.ModifyDN(ctx, nil, req); r != nil {
+				res = r.BaseResponse
+			}
+		case *DeleteRequest:
+			var r *DeleteResponse
+			if r, err = be.Delete(ctx, nil, req); r != nil {
+				res = r.BaseResponse
+			}
+		}
+		if err != nil {
+			t.Fatalf("%T: %s", tc.req, err)
+		}
+		if res.Code != tc.code {
+			t.Errorf("%T %+v: expected %s got %s: %s", tc.req, tc.req, tc.code, res.Code, res.Message)
+		}
+	}
+
+	_, c := newTestServer(t, be)
+	for _, tc := range []struct {
+		filter string
+		dns    []string
+	}{
+		{"(mail=one@example.com)", []string{"uid=renamed,ou=People,dc=example,dc=com"}},
+		{"(uidNumber>=2001)", []string{"uid=renamed,ou=People,dc=example,dc=com"}},
+		{"(uid=user1)", nil},
+		{"(uid=user2)", nil},
+		{"(cn=new person)", []string{newDN}},
+	} {
+		if dns, err := searchDNs(t, c, "dc=example,dc=com", ScopeWholeSubtree, tc.filter); err != nil || !reflect.DeepEqual(dns, tc.dns) {
+			t.Errorf("%s: expected %v got %v %v", tc.filter, tc.dns, dns, err)
+		}
+	}
+}
+
+func TestSQLBind(t *testing.T) {
+	t.Parallel()
+	be, _ := newTestSQLBackends(t, true)
+	ctx := context.Background()
+	dn := "uid=user3,ou=People,dc=example,dc=com"
+	if res, err := be.Modify(ctx, nil, &ModifyRequest{DN: dn, Mods: []*Mod{{Type: Replace, Name: "userPassword", Values: [][]byte{[]byte("secret")}}}}); err != nil || res.Code != ResultSuccess {
+		t.Fatal(res, err)
+	}
+	state, _ := be.Connect(nil)
+	bind := func(dn, password string) ResultCode {
+		t.Helper()
+		res, err := be.Bind(ctx, state, &BindRequest{DN: dn, Password: []byte(password)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Code
+	}
+	if code := bind(dn, "wrong"); code != ResultInvalidCredentials {
+		t.Errorf("expected invalid credentials got %s", code)
+	}
+	if code := bind("uid=user4,ou=People,dc=example,dc=com", "secret"); code != ResultInvalidCredentials {
+		t.Errorf("expected invalid credentials got %s", code)
+	}
+	if _, err := be.PasswordModify(ctx, state, &PasswordModifyRequest{NewPassword: []byte("x")}); resultCode(err) != ResultUnwillingToPerform {
+		t.Errorf("expected unwilling to perform got %v", err)
+	}
+	if code := bind("UID=User3, ou=people,dc=example,dc=com", "secret"); code != ResultSuccess {
+		t.Fatalf("expected success got %s", code)
+	}
+	if id, _ := be.Whoami(ctx, state); id != "dn:"+dn {
+		t.Errorf("expected dn:%s got %s", dn, id)
+	}
+	if _, err := be.PasswordModify(ctx, state, &PasswordModifyRequest{OldPassword: []byte("wrong"), NewPassword: []byte("x")}); resultCode(err) != ResultInvalidCredentials {
+		t.Errorf("expected invalid credentials got %v", err)
+	}
+	if _, err := be.PasswordModify(ctx, state, &PasswordModifyRequest{UserIdentity: "dn:uid=user4,ou=People,dc=example,dc=com"}); resultCode(err) != ResultInsufficientAccessRights {
+		t.Errorf("expected insufficient access rights got %v", err)
+	}
+	gen, err := be.PasswordModify(ctx, state, &PasswordModifyRequest{OldPassword: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := bind(dn, "secret"); code != ResultInvalidCredentials {
+		t.Errorf("expected invalid credentials got %s", code)
+	}
+	if code := bind(dn, string(gen)); code != ResultSuccess {
+		t.Errorf("expected success got %s", code)
+	}
+	res, err := be.Search(ctx, nil, &SearchRequest{BaseDN: dn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw := res.Results[0].Entry().Get("userPassword"); !strings.HasPrefix(pw, "{SSHA}") {
+		t.Errorf("expected hashed password got %q", pw)
+	}
+}
+
+func TestNewSQLBackend(t *testing.T) {
+	t.Parallel()
+	cols := map[string]string{"uid": "uid"}
+	cases := []struct {
+		tables []SQLTable
+		err    string
+	}{
+		{[]SQLTable{{Name: "people", Base: "ou=People", RDNAttribute: "uid", Columns: cols}}, ""},
+		{[]SQLTable{{Name: "people; DROP TABLE x", Base: "ou=People", RDNAttribute: "uid", Columns: cols}}, "invalid table name"},
+		{[]SQLTable{{Name: "people", Base: "ou=People", RDNAttribute: "uid", Columns: map[string]string{"uid": "uid", "cn": "cn)"}}}, "invalid column name"},
+		{[]SQLTable{{Name: "people", Base: "ou=People", RDNAttribute: "cn", Columns: cols}}, `RDN attribute "cn" has no column`},
+		{[]SQLTable{{Name: "people", Base: "ou=People", RDNAttribute: "uid", Columns: map[string]string{"uid": "uid", "cn;lang-en": "cn"}}}, "has options"},
+		{[]SQLTable{{Name: "people", Base: "ou=People", RDNAttribute: "uid", Columns: map[string]string{"uid": "uid", "userid": "uid2"}}}, "mapped twice"},
+		{[]SQLTable{{Name: "people", Base: "ou=People", RDNAttribute: "uid", Columns: map[string]string{"uid": "uid", "objectClass": "oc"}}}, "objectClass"},
+		{[]SQLTable{{Name: "people", Base: "ou=People,,", RDNAttribute: "uid", Columns: cols}}, "base of table people"},
+		{[]SQLTable{
+			{Name: "people", Base: "ou=People", RDNAttribute: "uid", Columns: cols},
+			{Name: "people2", Base: "OU=people", RDNAttribute: "userid", Columns: map[string]string{"userid": "uid"}},
+		}, "have the same entries"},
+	}
+	for _, tc := range cases {
+		_, err := NewSQLBackend(nil, DefaultSchema, tc.tables...)
+		if tc.err == "" && err != nil {
+			t.Errorf("%v: unexpected error %s", tc.tables, err)
+		} else if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%v: expected error %q got %v", tc.tables, tc.err, err)
+		}
+	}
+}
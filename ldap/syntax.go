@@ -0,0 +1,227 @@
+package ldap
+
+// https://tools.ietf.org/html/rfc4517#section-3.3
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseGeneralizedTime parses a value of the Generalized Time syntax
+// (RFC 4517 section 3.3.13) such as "20240102150405Z" or "2024010215-0700".
+func ParseGeneralizedTime(s string) (time.Time, error) {
+	bad := func(msg string) (time.Time, error) {
+		return time.Time{}, fmt.Errorf("ldap: invalid generalized time %q: %s", s, msg)
+	}
+	digits := func(v string) (int, bool) {
+		n := 0
+		for i := 0; i < len(v); i++ {
+			if v[i] < '0' || v[i] > '9' {
+				return 0, false
+			}
+			n = n*10 + int(v[i]-'0')
+		}
+		return n, true
+	}
+	if len(s) < 11 {
+		return bad("too short")
+	}
+	var f [6]int // year, month, day, hour, minute, second
+	var ok bool
+	if f[0], ok = digits(s[:4]); !ok {
+		return bad("invalid year")
+	}
+	i := 4
+	n := 3
+	for j := 1; j < 6; j++ {
+		if i+2 > len(s) || s[i] < '0' || s[i] > '9' {
+			break
+		}
+		if f[j], ok = digits(s[i : i+2]); !ok {
+			return bad("invalid digits")
+		}
+		i += 2
+		n = j
+	}
+	if n < 3 {
+		return bad("missing hour")
+	}
+	if f[1] < 1 || f[1] > 12 || f[2] < 1 || f[2] > 31 || f[3] > 23 || f[4] > 59 || f[5] > 60 {
+		return bad("field out of range")
+	}
+	// A fraction applies to the last unit present (hour, minute or second).
+	var frac time.Duration
+	if i < len(s) && (s[i] == '.' || s[i] == ',') {
+		i++
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return bad("empty fraction")
+		}
+		r, ok := new(big.Rat).SetString("0." + s[start:i])
+		if !ok {
+			return bad("invalid fraction")
+		}
+		unit := []time.Duration{3: time.Hour, 4: time.Minute, 5: time.Second}[n]
+		r.Mul(r, new(big.Rat).SetInt64(int64(unit)))
+		fl, _ := r.Float64()
+		frac = time.Duration(fl)
+	}
+	if i == len(s) {
+		return bad("missing time zone")
+	}
+	loc := time.UTC
+	switch s[i] {
+	case 'Z':
+		i++
+	case '+', '-':
+		sign := 1
+		if s[i] == '-' {
+			sign = -1
+		}
+		i++
+		tz := s[i:]
+		if len(tz) != 2 && len(tz) != 4 {
+			return bad("invalid time zone")
+		}
+		hh, ok1 := digits(tz[:2])
+		mm, ok2 := 0, true
+		if len(tz) == 4 {
+			mm, ok2 = digits(tz[2:])
+		}
+		if !ok1 || !ok2 || hh > 23 || mm > 59 {
+			return bad("invalid time zone")
+		}
+		loc = time.FixedZone("", sign*(hh*3600+mm*60))
+		i = len(s)
+	default:
+		return bad("invalid time zone")
+	}
+	if i != len(s) {
+		return bad("trailing characters")
+	}
+	t := time.Date(f[0], time.Month(f[1]), f[2], f[3], f[4], f[5], 0, loc).Add(frac)
+	return t, nil
+}
+
+// FormatGeneralizedTime formats a time in UTC using the Generalized Time
+// syntax. Fractional seconds are only included when non-zero.
+func FormatGeneralizedTime(t time.Time) string {
+	t = t.UTC()
+	s := t.Format("20060102150405")
+	if ns := t.Nanosecond(); ns != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%09d", ns), "0")
+	}
+	return s + "Z"
+}
+
+var errInvalidInteger = errors.New("ldap: invalid integer")
+
+// parseInteger parses a value of the INTEGER syntax (RFC 4517 section 3.3.16).
+func parseInteger(v []byte) (*big.Int, error) {
+	s := string(v)
+	d := strings.TrimPrefix(s, "-")
+	if d == "" || (d[0] == '0' && (len(d) > 1 || len(s) != len(d))) {
+		return nil, errInvalidInteger
+	}
+	for i := 0; i < len(d); i++ {
+		if d[i] < '0' || d[i] > '9' {
+			return nil, errInvalidInteger
+		}
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, errInvalidInteger
+	}
+	return n, nil
+}
+
+// parseBoolean parses a value of the Boolean syntax (RFC 4517 section 3.3.3).
+func parseBoolean(v []byte) (bool, error) {
+	switch string(v) {
+	case "TRUE":
+		return true, nil
+	case "FALSE":
+		return false, nil
+	}
+	return false, fmt.Errorf("ldap: invalid boolean %q", v)
+}
+
+// isNumericOID returns true if s is a dotted-decimal object identifier.
+func isNumericOID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, p := range strings.Split(s, ".") {
+		if p == "" || (len(p) > 1 && p[0] == '0') {
+			return false
+		}
+		if _, err := strconv.ParseUint(p, 10, 64); err != nil {
+			return false
+		}
+	}
+	return strings.Contains(s, ".")
+}
+
+// isKeyString returns true if s is a descr (RFC 4512 section 1.4).
+func isKeyString(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case (c >= '0' && c <= '9' || c == '-') && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseSubstringAssertion parses a value of the Substring Assertion syntax
+// (RFC 4517 section 3.3.30) such as "a*b*c" where "*" and "\" in the
+// components are escaped as "\2A" and "\5C".
+func parseSubstringAssertion(v []byte) (initial string, any []string, final string, err error) {
+	var parts []string
+	var cur []byte
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '*':
+			parts = append(parts, string(cur))
+			cur = cur[:0]
+		case '\\':
+			if i+2 >= len(v) {
+				return "", nil, "", fmt.Errorf("ldap: invalid substring assertion %q", v)
+			}
+			switch strings.ToUpper(string(v[i+1 : i+3])) {
+			case "2A":
+				cur = append(cur, '*')
+			case "5C":
+				cur = append(cur, '\\')
+			default:
+				return "", nil, "", fmt.Errorf("ldap: invalid escape in substring assertion %q", v)
+			}
+			i += 2
+		default:
+			cur = append(cur, c)
+		}
+	}
+	parts = append(parts, string(cur))
+	if len(parts) < 2 {
+		return "", nil, "", fmt.Errorf("ldap: substring assertion %q has no *", v)
+	}
+	for _, p := range parts[1 : len(parts)-1] {
+		if p == "" {
+			return "", nil, "", fmt.Errorf("ldap: empty any component in substring assertion %q", v)
+		}
+	}
+	return parts[0], parts[1 : len(parts)-1], parts[len(parts)-1], nil
+}
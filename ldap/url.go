@@ -0,0 +1,311 @@
+package ldap
+
+// https://tools.ietf.org/html/rfc4516
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Names of well known LDAP URL extensions.
+const (
+	URLExtensionBindName = "bindname"
+	URLExtensionStartTLS = "StartTLS" // also identified by OIDStartTLS
+)
+
+// LDAPURL is an LDAP URL (RFC 4516) of the form
+//
+//	ldap://host:port/dn?attributes?scope?filter?extensions
+//
+// which identifies a server and optionally a search to perform on it. The
+// schemes "ldaps" (LDAP over TLS) and "ldapi" (LDAP over a Unix domain
+// socket with the path percent-encoded as the host) are accepted as well.
+type LDAPURL struct {
+	Scheme     string
+	Host       string // host and optional port, or socket path for ldapi
+	DN         string
+	Attributes []string
+	Scope      Scope
+	Filter     Filter // nil is "(objectClass=*)"
+	Extensions []*URLExtension
+}
+
+// URLExtension is an extension of an LDAP URL. A client must not use the
+// URL if it doesn't support a critical extension.
+type URLExtension struct {
+	Critical bool
+	Type     string // descriptor or OID
+	Value    string // empty if none
+}
+
+// urlScopes maps scopes to their names in URLs. "subordinate" is used by
+// OpenLDAP for the children scope.
+var urlScopes = map[Scope]string{
+	ScopeBaseObject:   "base",
+	ScopeSingleLevel:  "one",
+	ScopeWholeSubtree: "sub",
+	ScopeChildren:     "subordinate",
+}
+
+// ParseLDAPURL parses an LDAP URL. Components are percent-decoded and the
+// filter is parsed.
+func ParseLDAPURL(rawURL string) (*LDAPURL, error) {
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("ldap: invalid URL %q: %s", rawURL, fmt.Sprintf(format, args...))
+	}
+	scheme, rest, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return nil, errorf("missing scheme")
+	}
+	u := &LDAPURL{Scheme: strings.ToLower(scheme)}
+	switch u.Scheme {
+	case "ldap", "ldaps", "ldapi":
+	default:
+		return nil, errorf("unsupported scheme %q", scheme)
+	}
+	host, rest, hasPath := strings.Cut(rest, "/")
+	if !hasPath && strings.IndexByte(host, '?') >= 0 {
+		return nil, errorf("'?' without '/'")
+	}
+	var err error
+	if u.Host, err = urlUnescape(host); err != nil {
+		return nil, errorf("%s", err)
+	}
+	parts := strings.Split(rest, "?")
+	if len(parts) > 5 {
+		return nil, errorf("too many '?' separated components")
+	}
+	for len(parts) < 5 {
+		parts = append(parts, "")
+	}
+	if u.DN, err = urlUnescape(parts[0]); err != nil {
+		return nil, errorf("dn: %s", err)
+	}
+	if u.DN != "" {
+		if _, err := ParseDN(u.DN); err != nil {
+			return nil, errorf("dn: %s", err)
+		}
+	}
+	if parts[1] != "" {
+		for _, a := range strings.Split(parts[1], ",") {
+			a, err := urlUnescape(a)
+			if err != nil {
+				return nil, errorf("attributes: %s", err)
+			}
+			u.Attributes = append(u.Attributes, a)
+		}
+	}
+	if parts[2] != "" {
+		scope, err := urlUnescape(parts[2])
+		if err != nil {
+			return nil, errorf("scope: %s", err)
+		}
+		found := false
+		for sc, name := range urlScopes {
+			if strings.EqualFold(scope, name) {
+				u.Scope = sc
+				found = true
+			}
+		}
+		if !found {
+			return nil, errorf("unknown scope %q", scope)
+		}
+	}
+	if parts[3] != "" {
+		filter, err := urlUnescape(parts[3])
+		if err != nil {
+			return nil, errorf("filter: %s", err)
+		}
+		if u.Filter, err = ParseFilter(filter); err != nil {
+			return nil, errorf("filter: %s", err)
+		}
+	}
+	if parts[4] != "" {
+		for _, e := range strings.Split(parts[4], ",") {
+			ext := &URLExtension{}
+			if strings.HasPrefix(e, "!") {
+				ext.Critical = true
+				e = e[1:]
+			}
+			typ, value, _ := strings.Cut(e, "=")
+			if ext.Type, err = urlUnescape(typ); err != nil {
+				return nil, errorf("extension: %s", err)
+			}
+			if ext.Type == "" {
+				return nil, errorf("empty extension type")
+			}
+			if ext.Value, err = urlUnescape(value); err != nil {
+				return nil, errorf("extension %s: %s", ext.Type, err)
+			}
+			u.Extensions = append(u.Extensions, ext)
+		}
+	}
+	return u, nil
+}
+
+// String returns the URL with components percent-encoded as required.
+// Trailing empty components are omitted.
+func (u *LDAPURL) String() string {
+	var parts [4]string
+	parts[0] = urlEscape(strings.Join(u.Attributes, ","), ",")
+	if u.Scope != ScopeBaseObject {
+		parts[1] = urlScopes[u.Scope]
+	}
+	if u.Filter != nil {
+		parts[2] = urlEscape(u.Filter.String(), "")
+	}
+	exts := make([]string, len(u.Extensions))
+	for i, e := range u.Extensions {
+		s := urlEscape(e.Type, "")
+		if e.Critical {
+			s = "!" + s
+		}
+		if e.Value != "" {
+			s += "=" + urlEscape(e.Value, "")
+		}
+		exts[i] = s
+	}
+	parts[3] = strings.Join(exts, ",")
+	n := len(parts)
+	for n > 0 && parts[n-1] == "" {
+		n--
+	}
+	host := u.Host
+	if u.Scheme == "ldapi" {
+		host = urlEscape(host, "")
+	}
+	s := u.Scheme + "://" + host
+	if u.DN != "" || n != 0 {
+		s += "/" + urlEscape(u.DN, ",")
+	}
+	for _, p := range parts[:n] {
+		s += "?" + p
+	}
+	return s
+}
+
+// Addr returns the address to connect to which is the host with the
+// default port for the scheme if none is given, or the socket path for
+// ldapi. The host defaults to localhost.
+func (u *LDAPURL) Addr() string {
+	if u.Scheme == "ldapi" {
+		return u.Host
+	}
+	host := u.Host
+	if host == "" {
+		host = "localhost"
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "389"
+	if u.Scheme == "ldaps" {
+		port = "636"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// Extension returns the extension with the type (compared
+// case-insensitively) or nil if the URL doesn't have it. OIDStartTLS is
+// treated as an alias of URLExtensionStartTLS.
+func (u *LDAPURL) Extension(typ string) *URLExtension {
+	for _, e := range u.Extensions {
+		if strings.EqualFold(e.Type, typ) || isStartTLSExtension(typ) && isStartTLSExtension(e.Type) {
+			return e
+		}
+	}
+	return nil
+}
+
+func isStartTLSExtension(typ string) bool {
+	return strings.EqualFold(typ, URLExtensionStartTLS) || typ == OIDStartTLS
+}
+
+// CheckCritical returns an error if the URL has a critical extension that
+// isn't in supported.
+func (u *LDAPURL) CheckCritical(supported ...string) error {
+	for _, e := range u.Extensions {
+		if !e.Critical {
+			continue
+		}
+		ok := false
+		for _, s := range supported {
+			ok = ok || strings.EqualFold(e.Type, s) || isStartTLSExtension(e.Type) && isStartTLSExtension(s)
+		}
+		if !ok {
+			return fmt.Errorf("ldap: unsupported critical URL extension %s", e.Type)
+		}
+	}
+	return nil
+}
+
+// SearchRequest returns the search described by the URL. With no
+// attributes all user attributes are requested.
+func (u *LDAPURL) SearchRequest() *SearchRequest {
+	req := &SearchRequest{
+		BaseDN: u.DN,
+		Scope:  u.Scope,
+		Filter: u.Filter,
+	}
+	if len(u.Attributes) != 0 {
+		req.Attributes = make(map[string]bool, len(u.Attributes))
+		for _, a := range u.Attributes {
+			req.Attributes[a] = true
+		}
+	}
+	return req
+}
+
+// urlEscape percent-encodes characters that aren't allowed in a component
+// of an LDAP URL. Characters in keep are not encoded.
+func urlEscape(s, keep string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if shouldEscapeURL(c) && strings.IndexByte(keep, c) < 0 {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// shouldEscapeURL returns true for characters that are not unreserved or
+// sub-delimiters allowed in a path segment (RFC 3986) as well as '?' and
+// ',' which separate components and extensions.
+func shouldEscapeURL(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return false
+	}
+	return !strings.ContainsRune("-._~!$&'()*+;=:@", rune(c))
+}
+
+var errInvalidPercentEncoding = errors.New("invalid percent-encoding")
+
+func urlUnescape(s string) (string, error) {
+	if strings.IndexByte(s, '%') < 0 {
+		return s, nil
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2]) {
+			return "", errInvalidPercentEncoding
+		}
+		v, _ := hex.DecodeString(s[i+1 : i+3])
+		b = append(b, v...)
+		i += 2
+	}
+	return string(b), nil
+}
@@ -0,0 +1,201 @@
+package ldap
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseLDAPURL(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		url string
+		u   *LDAPURL
+		str string // if different from url
+	}{
+		{
+			url: "ldap://",
+			u:   &LDAPURL{Scheme: "ldap"},
+		},
+		{
+			url: "ldap:///o=University%20of%20Michigan,c=US",
+			u:   &LDAPURL{Scheme: "ldap", DN: "o=University of Michigan,c=US"},
+		},
+		{
+			url: "LDAP://ldap1.example.net:6666/o=University%20of%20Michigan,c=US??sub?(cn=Babs%20Jensen)",
+			u: &LDAPURL{
+				Scheme: "ldap", Host: "ldap1.example.net:6666", DN: "o=University of Michigan,c=US",
+				Scope: ScopeWholeSubtree, Filter: &EqualityMatch{Attribute: "cn", Value: []byte("Babs Jensen")},
+			},
+			str: "ldap://ldap1.example.net:6666/o=University%20of%20Michigan,c=US??sub?(cn=Babs%20Jensen)",
+		},
+		{
+			url: "ldap://ldap1.example.com/c=GB?objectClass?ONE",
+			u:   &LDAPURL{Scheme: "ldap", Host: "ldap1.example.com", DN: "c=GB", Attributes: []string{"objectClass"}, Scope: ScopeSingleLevel},
+			str: "ldap://ldap1.example.com/c=GB?objectClass?one",
+		},
+		{
+			url: "ldap://ldap2.example.com/o=Question%3f,c=US?mail",
+			u:   &LDAPURL{Scheme: "ldap", Host: "ldap2.example.com", DN: "o=Question?,c=US", Attributes: []string{"mail"}},
+			str: "ldap://ldap2.example.com/o=Question%3F,c=US?mail",
+		},
+		{
+			url: "ldap:///??sub??e-bindname=cn=Manager%2cdc=example%2cdc=com",
+			u: &LDAPURL{Scheme: "ldap", Scope: ScopeWholeSubtree, Extensions: []*URLExtension{
+				{Type: "e-bindname", Value: "cn=Manager,dc=example,dc=com"},
+			}},
+			str: "ldap:///??sub??e-bindname=cn=Manager%2Cdc=example%2Cdc=com",
+		},
+		{
+			url: "ldaps://[::1]:1636/dc=example?cn,mail?subordinate?(&(uid=a*)(!(cn=\\2a)))?!bindname=uid=x%2cdc=example,!StartTLS",
+			u: &LDAPURL{
+				Scheme: "ldaps", Host: "[::1]:1636", DN: "dc=example", Attributes: []string{"cn", "mail"}, Scope: ScopeChildren,
+				Filter: &AND{Filters: []Filter{
+					&Substrings{Attribute: "uid", Initial: "a"},
+					&NOT{Filter: &EqualityMatch{Attribute: "cn", Value: []byte("*")}},
+				}},
+				Extensions: []*URLExtension{
+					{Critical: true, Type: "bindname", Value: "uid=x,dc=example"},
+					{Critical: true, Type: "StartTLS"},
+				},
+			},
+			str: "ldaps://[::1]:1636/dc=example?cn,mail?subordinate?(&(uid=a*)(!(cn=%5C2a)))?!bindname=uid=x%2Cdc=example,!StartTLS",
+		},
+		{
+			url: "ldapi://%2Fvar%2Frun%2Fslapd.sock/",
+			u:   &LDAPURL{Scheme: "ldapi", Host: "/var/run/slapd.sock"},
+			str: "ldapi://%2Fvar%2Frun%2Fslapd.sock",
+		},
+	}
+	for _, c := range cases {
+		u, err := ParseLDAPURL(c.url)
+		if err != nil {
+			t.Errorf("ParseLDAPURL(%q) failed: %s", c.url, err)
+			continue
+		}
+		if !reflect.DeepEqual(u, c.u) {
+			t.Errorf("ParseLDAPURL(%q) = %+v, want %+v", c.url, u, c.u)
+		}
+		str := c.str
+		if str == "" {
+			str = c.url
+		}
+		if s := u.String(); s != str {
+			t.Errorf("String() = %q, want %q", s, str)
+		}
+		if u2, err := ParseLDAPURL(u.String()); err != nil || !reflect.DeepEqual(u, u2) {
+			t.Errorf("ParseLDAPURL(%q) = %+v, %v, want %+v", u.String(), u2, err, u)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"http://example.com",
+		"ldap:/dc=example",
+		"ldap://host?cn",
+		"ldap:///dc=example?cn?bogus",
+		"ldap:///dc=example???(cn=",
+		"ldap:///dc=example????,",
+		"ldap:///dc=example?????",
+		"ldap:///dc=example%2",
+		"ldap:///not a dn",
+	} {
+		if _, err := ParseLDAPURL(s); err == nil {
+			t.Errorf("Expected ParseLDAPURL(%q) to fail", s)
+		}
+	}
+}
+
+func TestLDAPURLAddr(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		url  string
+		addr string
+	}{
+		{"ldap://", "localhost:389"},
+		{"ldaps://example.com", "example.com:636"},
+		{"ldap://example.com:1389", "example.com:1389"},
+		{"ldap://[::1]", "[::1]:389"},
+		{"ldapi://%2Ftmp%2Fldap.sock", "/tmp/ldap.sock"},
+	}
+	for _, c := range cases {
+		u, err := ParseLDAPURL(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr := u.Addr(); addr != c.addr {
+			t.Errorf("Addr(%s) = %s, want %s", c.url, addr, c.addr)
+		}
+	}
+}
+
+func TestLDAPURLExtensions(t *testing.T) {
+	t.Parallel()
+	u, err := ParseLDAPURL("ldap:///????!1.3.6.1.4.1.1466.20037,bindname=cn=x,!x-unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := u.Extension("starttls"); e == nil || !e.Critical {
+		t.Errorf("Extension(starttls) = %+v", e)
+	}
+	if e := u.Extension("BindName"); e == nil || e.Value != "cn=x" {
+		t.Errorf("Extension(BindName) = %+v", e)
+	}
+	if e := u.Extension("missing"); e != nil {
+		t.Errorf("Extension(missing) = %+v", e)
+	}
+	if err := u.CheckCritical(URLExtensionStartTLS); err == nil {
+		t.Error("Expected unsupported critical extension")
+	}
+	if err := u.CheckCritical(URLExtensionStartTLS, "x-unknown"); err != nil {
+		t.Error(err)
+	}
+	if err := u.CheckCritical("x-unknown"); err == nil {
+		t.Error("Expected unsupported critical StartTLS extension")
+	}
+}
+
+func TestLDAPURLSearchRequest(t *testing.T) {
+	t.Parallel()
+	u, err := ParseLDAPURL("ldap:///dc=example,dc=com?cn,mail?sub?(uid=jdoe)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &SearchRequest{
+		BaseDN:     "dc=example,dc=com",
+		Scope:      ScopeWholeSubtree,
+		Filter:     &EqualityMatch{Attribute: "uid", Value: []byte("jdoe")},
+		Attributes: map[string]bool{"cn": true, "mail": true},
+	}
+	if req := u.SearchRequest(); !reflect.DeepEqual(req, want) {
+		t.Errorf("SearchRequest() = %+v, want %+v", req, want)
+	}
+}
+
+type urlBackend struct {
+	Backend
+	req *SearchRequest
+}
+
+func (be *urlBackend) Search(ctx context.Context, state State, req *SearchRequest) (*SearchResponse, error) {
+	be.req = req
+	return &SearchResponse{}, nil
+}
+
+func TestClientSearchURL(t *testing.T) {
+	t.Parallel()
+	be := &urlBackend{Backend: DebugBackend}
+	_, c := newTestServer(t, be)
+	if _, err := c.SearchURL("ldap://ignored/dc=example?cn?one?(uid=a%20b)"); err != nil {
+		t.Fatal(err)
+	}
+	if be.req.BaseDN != "dc=example" || be.req.Scope != ScopeSingleLevel || !be.req.Attributes["cn"] || be.req.Filter.String() != "(uid=a b)" {
+		t.Errorf("Unexpected request %+v", be.req)
+	}
+	if _, err := c.SearchURL("ldap:///dc=example????!StartTLS"); err == nil {
+		t.Error("Expected error for critical StartTLS extension without TLS")
+	}
+	if _, err := c.SearchURL("ldap:///dc=example?cn?bogus"); err == nil {
+		t.Error("Expected error for invalid URL")
+	}
+}